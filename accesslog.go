@@ -0,0 +1,446 @@
+package fasthttp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAccessLogBufferSize is the default number of access log entries,
+// which may wait for writing. See AccessLogger.BufferSize.
+const DefaultAccessLogBufferSize = 4096
+
+// AccessLogFormat is the format of access log entries.
+type AccessLogFormat int
+
+const (
+	// AccessLogFormatCommon is Common Log Format:
+	//
+	//	127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326
+	AccessLogFormatCommon AccessLogFormat = iota
+
+	// AccessLogFormatCombined is Combined Log Format, i.e. Common Log Format
+	// with Referer and User-Agent request headers:
+	//
+	//	127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /index.html HTTP/1.1" 200 2326 "http://example.com/" "curl/8.0"
+	AccessLogFormatCombined
+
+	// AccessLogFormatJSON writes an entry per line encoded as JSON object:
+	//
+	//	{"time":"2000-10-10T13:55:36.123-07:00","id":1,"remote_ip":"127.0.0.1",
+	//	"method":"GET","uri":"/index.html","protocol":"HTTP/1.1","status":200,
+	//	"bytes":2326,"duration":0.0012,"user_agent":"curl/8.0"}
+	//
+	// duration is in seconds.
+	AccessLogFormatJSON
+
+	// AccessLogFormatSlog passes entries to AccessLogger.Slog with the request
+	// start time and attributes with the same names as in AccessLogFormatJSON.
+	AccessLogFormatSlog
+)
+
+// AccessLogger writes access log entries for requests served by handlers
+// wrapped by AccessLog.
+//
+// Entries are written by a background goroutine, so slow output doesn't
+// block request handlers. Entries are dropped if more than BufferSize entries
+// wait for writing. See DroppedEntries.
+//
+// Call Close for writing the pending entries before exiting the program.
+//
+// It is safe calling AccessLogger methods from concurrently running goroutines.
+type AccessLogger struct {
+	// Output is the destination for entries.
+	//
+	// os.Stdout is used if not set. Output isn't used for AccessLogFormatSlog.
+	Output io.Writer
+
+	// Slog is the logger for AccessLogFormatSlog.
+	//
+	// slog.Default() is used if not set.
+	Slog *slog.Logger
+
+	// Logger is used for logging errors on writing to Output.
+	//
+	// The default logger is used if not set.
+	Logger Logger
+
+	entries chan *accessLogEntry
+
+	// done is closed by Close.
+	done chan struct{}
+
+	// stopped is closed after the pending entries are written.
+	stopped chan struct{}
+
+	// SampleRate is the fraction of requests to log, e.g. 0.1 for logging
+	// every tenth request on average.
+	//
+	// All the requests are logged if SampleRate is zero.
+	SampleRate float64
+
+	// Format is the format of entries.
+	//
+	// AccessLogFormatCommon is used by default.
+	Format AccessLogFormat
+
+	// BufferSize is the maximum number of entries waiting for writing.
+	//
+	// DefaultAccessLogBufferSize is used if not set.
+	BufferSize int
+
+	dropped atomic.Uint64
+	closed  atomic.Bool
+
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// AccessLog returns the handler, which logs requests served by h via l.
+//
+// The entry is written after the response is written by Server. It contains
+// request method, URI, protocol, response status code, the number of bytes
+// written for the response, the duration since the request has been read,
+// client IP, User-Agent and Referer request headers and RequestCtx.ID().
+//
+// The number of bytes written includes response headers for HTTP/1.x
+// and only the response body for HTTP/2.
+// The client IP is obtained via RequestCtx.ClientIP, so it is the address
+// forwarded by Server.TrustedProxies.
+//
+// Requests aren't logged if h is called outside Server,
+// e.g. via fasthttpadaptor.
+func AccessLog(h RequestHandler, l *AccessLogger) RequestHandler {
+	return func(ctx *RequestCtx) {
+		if l.SampleRate > 0 && rand.Float64() >= l.SampleRate { // #nosec G404
+			h(ctx)
+			return
+		}
+
+		// The request is recorded before calling h,
+		// since h may modify it.
+		e := acquireAccessLogEntry()
+		e.l = l
+		e.init(ctx)
+		h(ctx)
+		// Entries of nested AccessLog handlers are written in the order
+		// the handlers are called.
+		e.next = ctx.accessLog
+		ctx.accessLog = e
+	}
+}
+
+// DroppedEntries returns the number of entries dropped because of
+// the full buffer or because the logger has been closed.
+func (l *AccessLogger) DroppedEntries() uint64 {
+	return l.dropped.Load()
+}
+
+// Close writes the pending entries and stops the background goroutine.
+//
+// Subsequent entries are dropped.
+func (l *AccessLogger) Close() error {
+	l.startOnce.Do(l.start)
+	l.closeOnce.Do(func() {
+		l.closed.Store(true)
+		close(l.done)
+	})
+	<-l.stopped
+	return nil
+}
+
+func (l *AccessLogger) start() {
+	n := l.BufferSize
+	if n <= 0 {
+		n = DefaultAccessLogBufferSize
+	}
+	l.entries = make(chan *accessLogEntry, n)
+	l.done = make(chan struct{})
+	l.stopped = make(chan struct{})
+	go l.run()
+}
+
+func (l *AccessLogger) enqueue(e *accessLogEntry) {
+	l.startOnce.Do(l.start)
+	if l.closed.Load() {
+		l.dropped.Add(1)
+		releaseAccessLogEntry(e)
+		return
+	}
+	select {
+	case l.entries <- e:
+	default:
+		l.dropped.Add(1)
+		releaseAccessLogEntry(e)
+	}
+}
+
+func (l *AccessLogger) run() {
+	defer close(l.stopped)
+
+	output := l.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	w := &accessLogWriter{
+		l:  l,
+		w:  output,
+		bw: bufio.NewWriterSize(output, 64*1024),
+	}
+	for {
+		select {
+		case e := <-l.entries:
+			w.write(e)
+			// Entries are flushed when there are no more pending entries,
+			// so they are written in batches under high load.
+			if len(l.entries) == 0 {
+				w.flush()
+			}
+		case <-l.done:
+			for {
+				select {
+				case e := <-l.entries:
+					w.write(e)
+				default:
+					w.flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+type accessLogWriter struct {
+	l   *AccessLogger
+	w   io.Writer
+	bw  *bufio.Writer
+	buf []byte
+}
+
+func (w *accessLogWriter) write(e *accessLogEntry) {
+	defer releaseAccessLogEntry(e)
+
+	l := w.l
+	switch l.Format {
+	case AccessLogFormatJSON:
+		w.buf = e.appendJSON(w.buf[:0])
+	case AccessLogFormatSlog:
+		e.log(l.slog())
+		return
+	default:
+		w.buf = e.appendCommon(w.buf[:0], l.Format == AccessLogFormatCombined)
+	}
+	w.buf = append(w.buf, '\n')
+	if _, err := w.bw.Write(w.buf); err != nil {
+		w.handleError(err)
+	}
+}
+
+func (w *accessLogWriter) flush() {
+	if err := w.bw.Flush(); err != nil {
+		w.handleError(err)
+	}
+}
+
+func (w *accessLogWriter) handleError(err error) {
+	w.l.logger().Printf("cannot write access log: %v", err)
+	// bufio.Writer returns the same error for all the subsequent writes.
+	w.bw.Reset(w.w)
+}
+
+func (l *AccessLogger) slog() *slog.Logger {
+	if l.Slog != nil {
+		return l.Slog
+	}
+	return slog.Default()
+}
+
+func (l *AccessLogger) logger() Logger {
+	if l.Logger != nil {
+		return l.Logger
+	}
+	return defaultLogger
+}
+
+// finishAccessLog passes the served request to the loggers set by AccessLog.
+//
+// bytesWritten is the number of bytes written for the response.
+func (ctx *RequestCtx) finishAccessLog(bytesWritten int64) {
+	e := ctx.accessLog
+	ctx.accessLog = nil
+	for e != nil {
+		next := e.next
+		e.next = nil
+		e.statusCode = ctx.Response.StatusCode()
+		e.bytesWritten = bytesWritten
+		e.duration = time.Since(e.start)
+		e.l.enqueue(e)
+		e = next
+	}
+}
+
+type accessLogEntry struct {
+	start time.Time
+
+	l    *AccessLogger
+	next *accessLogEntry
+
+	method    []byte
+	uri       []byte
+	protocol  []byte
+	userAgent []byte
+	referer   []byte
+
+	remoteIP netip.Addr
+
+	id           uint64
+	bytesWritten int64
+	duration     time.Duration
+	statusCode   int
+}
+
+var accessLogEntryPool sync.Pool
+
+func acquireAccessLogEntry() *accessLogEntry {
+	v := accessLogEntryPool.Get()
+	if v == nil {
+		return &accessLogEntry{}
+	}
+	return v.(*accessLogEntry) //nolint:forcetypeassert
+}
+
+func releaseAccessLogEntry(e *accessLogEntry) {
+	e.l = nil
+	e.next = nil
+	accessLogEntryPool.Put(e)
+}
+
+func (e *accessLogEntry) init(ctx *RequestCtx) {
+	h := &ctx.Request.Header
+	e.start = ctx.Time()
+	e.method = append(e.method[:0], h.Method()...)
+	e.uri = append(e.uri[:0], h.RequestURI()...)
+	e.protocol = append(e.protocol[:0], h.Protocol()...)
+	e.userAgent = append(e.userAgent[:0], h.UserAgent()...)
+	e.referer = append(e.referer[:0], h.Referer()...)
+	ip, _ := netip.AddrFromSlice(ctx.ClientIP())
+	e.remoteIP = ip.Unmap()
+	e.id = ctx.ID()
+}
+
+// appendCommon appends the entry in Common or Combined Log Format to dst.
+func (e *accessLogEntry) appendCommon(dst []byte, combined bool) []byte {
+	dst = e.remoteIP.AppendTo(dst)
+	dst = append(dst, " - - ["...)
+	dst = e.start.AppendFormat(dst, "02/Jan/2006:15:04:05 -0700")
+	dst = append(dst, "] \""...)
+	dst = appendAccessLogEscaped(dst, e.method)
+	dst = append(dst, ' ')
+	dst = appendAccessLogEscaped(dst, e.uri)
+	dst = append(dst, ' ')
+	dst = appendAccessLogEscaped(dst, e.protocol)
+	dst = append(dst, "\" "...)
+	dst = strconv.AppendInt(dst, int64(e.statusCode), 10)
+	dst = append(dst, ' ')
+	if e.bytesWritten > 0 {
+		dst = strconv.AppendInt(dst, e.bytesWritten, 10)
+	} else {
+		dst = append(dst, '-')
+	}
+	if combined {
+		dst = append(dst, " \""...)
+		dst = appendAccessLogEscaped(dst, e.referer)
+		dst = append(dst, "\" \""...)
+		dst = appendAccessLogEscaped(dst, e.userAgent)
+		dst = append(dst, '"')
+	}
+	return dst
+}
+
+// appendAccessLogEscaped appends s to dst escaping quotes, backslashes
+// and non-printable characters, so clients cannot forge log entries.
+func appendAccessLogEscaped(dst, s []byte) []byte {
+	for _, c := range s {
+		switch {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c < 0x20 || c >= 0x7f:
+			dst = append(dst, '\\', 'x', lowerhex[c>>4], lowerhex[c&0xf])
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
+
+type accessLogJSONEntry struct {
+	Time      time.Time `json:"time"`
+	ID        uint64    `json:"id"`
+	RemoteIP  string    `json:"remote_ip"`
+	Method    string    `json:"method"`
+	URI       string    `json:"uri"`
+	Protocol  string    `json:"protocol"`
+	Status    int       `json:"status"`
+	Bytes     int64     `json:"bytes"`
+	Duration  float64   `json:"duration"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Referer   string    `json:"referer,omitempty"`
+}
+
+// appendJSON appends the entry encoded as JSON object to dst.
+func (e *accessLogEntry) appendJSON(dst []byte) []byte {
+	b, err := json.Marshal(&accessLogJSONEntry{
+		Time:      e.start,
+		ID:        e.id,
+		RemoteIP:  e.remoteIP.String(),
+		Method:    string(e.method),
+		URI:       string(e.uri),
+		Protocol:  string(e.protocol),
+		Status:    e.statusCode,
+		Bytes:     e.bytesWritten,
+		Duration:  e.duration.Seconds(),
+		UserAgent: string(e.userAgent),
+		Referer:   string(e.referer),
+	})
+	if err != nil {
+		// This shouldn't happen, since all the fields may be encoded.
+		panic(err)
+	}
+	return append(dst, b...)
+}
+
+// log passes the entry to logger with the request start time.
+func (e *accessLogEntry) log(logger *slog.Logger) {
+	ctx := context.Background()
+	h := logger.Handler()
+	if !h.Enabled(ctx, slog.LevelInfo) {
+		return
+	}
+	r := slog.NewRecord(e.start, slog.LevelInfo, "access", 0)
+	r.AddAttrs(
+		slog.Uint64("id", e.id),
+		slog.String("remote_ip", e.remoteIP.String()),
+		slog.String("method", string(e.method)),
+		slog.String("uri", string(e.uri)),
+		slog.String("protocol", string(e.protocol)),
+		slog.Int("status", e.statusCode),
+		slog.Int64("bytes", e.bytesWritten),
+		slog.Duration("duration", e.duration),
+	)
+	if len(e.userAgent) > 0 {
+		r.AddAttrs(slog.String("user_agent", string(e.userAgent)))
+	}
+	if len(e.referer) > 0 {
+		r.AddAttrs(slog.String("referer", string(e.referer)))
+	}
+	_ = h.Handle(ctx, r)
+}
@@ -0,0 +1,276 @@
+package fasthttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// serveAccessLogRequests sends requests to the server with the handler
+// wrapped by AccessLog and returns the responses.
+func serveAccessLogRequests(t *testing.T, l *AccessLogger, requests string) []byte {
+	t.Helper()
+
+	s := &Server{
+		Handler: AccessLog(func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/missing" {
+				ctx.NotFound()
+				return
+			}
+			// The original request is logged.
+			ctx.URI().SetPath("/rewritten")
+			ctx.WriteString("hello") //nolint:errcheck
+		}, l),
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	c, err := ln.DialWithLocalAddr(&net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte(requests)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	responses, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return responses
+}
+
+func TestAccessLogCommon(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []AccessLogFormat{AccessLogFormatCommon, AccessLogFormatCombined} {
+		var out bytes.Buffer
+		l := &AccessLogger{
+			Output: &out,
+			Format: format,
+		}
+		serveAccessLogRequests(t, l, "GET /foo?bar=baz HTTP/1.1\r\nHost: example.com\r\nUser-Agent: agent \"007\"\r\n\r\n"+
+			"POST /missing HTTP/1.0\r\nReferer: http://example.com/\r\n\r\n")
+
+		timeRE := `\[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\]`
+		expected := []string{
+			`^1\.2\.3\.4 - - ` + timeRE + ` "GET /foo\?bar=baz HTTP/1\.1" 200 \d+$`,
+			`^1\.2\.3\.4 - - ` + timeRE + ` "POST /missing HTTP/1\.0" 404 \d+$`,
+		}
+		if format == AccessLogFormatCombined {
+			expected[0] = strings.TrimSuffix(expected[0], "$") + ` "" "agent \\"007\\""$`
+			expected[1] = strings.TrimSuffix(expected[1], "$") + ` "http://example\.com/" ""$`
+		}
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if len(lines) != len(expected) {
+			t.Fatalf("unexpected number of entries %d. Expecting %d. Output:\n%s", len(lines), len(expected), out.String())
+		}
+		for i, line := range lines {
+			if !regexp.MustCompile(expected[i]).MatchString(line) {
+				t.Fatalf("unexpected entry %q. Expecting it to match %q", line, expected[i])
+			}
+		}
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	l := &AccessLogger{
+		Output: &out,
+		Format: AccessLogFormatJSON,
+	}
+	responses := serveAccessLogRequests(t, l,
+		"GET /foo HTTP/1.1\r\nHost: example.com\r\nUser-Agent: agent\r\nConnection: close\r\n\r\n")
+
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("unexpected error: %v. Output: %q", err, out.String())
+	}
+	for k, v := range map[string]any{
+		"remote_ip":  "1.2.3.4",
+		"method":     "GET",
+		"uri":        "/foo",
+		"protocol":   "HTTP/1.1",
+		"status":     float64(StatusOK),
+		"bytes":      float64(len(responses)),
+		"user_agent": "agent",
+	} {
+		if entry[k] != v {
+			t.Fatalf("unexpected %s=%v. Expecting %v", k, entry[k], v)
+		}
+	}
+	if _, ok := entry["referer"]; ok {
+		t.Fatalf("unexpected referer in %v", entry)
+	}
+	if id, _ := entry["id"].(float64); id <= 0 {
+		t.Fatalf("unexpected id %v", entry["id"])
+	}
+	if d, _ := entry["duration"].(float64); d <= 0 || d > 10 {
+		t.Fatalf("unexpected duration %v", entry["duration"])
+	}
+	tm, err := time.Parse(time.RFC3339Nano, entry["time"].(string)) //nolint:forcetypeassert
+	if err != nil || time.Since(tm) > time.Minute {
+		t.Fatalf("unexpected time %v, error: %v", entry["time"], err)
+	}
+}
+
+func TestAccessLogSlog(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	l := &AccessLogger{
+		Slog:   slog.New(slog.NewTextHandler(&out, nil)),
+		Format: AccessLogFormatSlog,
+	}
+	serveAccessLogRequests(t, l, "GET /foo HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+
+	entry := out.String()
+	for _, attr := range []string{
+		"level=INFO msg=access ",
+		" remote_ip=1.2.3.4 method=GET uri=/foo protocol=HTTP/1.1 status=200 bytes=",
+		" duration=",
+	} {
+		if !strings.Contains(entry, attr) {
+			t.Fatalf("missing %q in entry %q", attr, entry)
+		}
+	}
+	if strings.Contains(entry, "user_agent=") {
+		t.Fatalf("unexpected empty user agent in entry %q", entry)
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	l := &AccessLogger{
+		Output:     &out,
+		SampleRate: 1e-12,
+	}
+	serveAccessLogRequests(t, l, "GET /foo HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	if out.Len() > 0 {
+		t.Fatalf("unexpected entries for not sampled request: %q", out.String())
+	}
+}
+
+type blockingWriter struct {
+	w       bytes.Buffer
+	release chan struct{}
+	mu      sync.Mutex
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func TestAccessLogDroppedEntries(t *testing.T) {
+	t.Parallel()
+
+	out := &blockingWriter{
+		release: make(chan struct{}),
+	}
+	l := &AccessLogger{
+		Output:     out,
+		BufferSize: 1,
+	}
+	s := &Server{
+		Handler: AccessLog(func(ctx *RequestCtx) {}, l),
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	c := &HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+	}
+	for range 3 {
+		if _, _, err := c.Get(nil, "http://example.com/"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// Entries are passed to the logger after responses are sent.
+	deadline := time.Now().Add(5 * time.Second)
+	for s.InFlightRequests() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	// Workers aren't blocked by the output, so at most two entries
+	// are pending: the entry being written and the buffered one.
+	if n := l.DroppedEntries(); n == 0 {
+		t.Fatal("expecting dropped entries")
+	}
+
+	close(out.release)
+	if err := l.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out.mu.Lock()
+	written := strings.Count(out.w.String(), "\n")
+	out.mu.Unlock()
+	if n := uint64(written) + l.DroppedEntries(); n != 3 {
+		t.Fatalf("unexpected number of written and dropped entries %d. Expecting 3", n)
+	}
+}
+
+func TestAccessLogHTTP2(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	l := &AccessLogger{
+		Output: &out,
+	}
+	s := &Server{
+		Handler: AccessLog(func(ctx *RequestCtx) {
+			ctx.WriteString("hello") //nolint:errcheck
+		}, l),
+	}
+	c, ln := newHTTP2TestClient(t, s, nil)
+	defer ln.Close()
+
+	resp, err := c.Get("https://localhost/foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	resp.Body.Close()
+	c.CloseIdleConnections()
+
+	// The entry is written after the response is sent to the client.
+	deadline := time.Now().Add(5 * time.Second)
+	for s.InFlightRequests() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), `"GET /foo HTTP/2.0" 200 5`+"\n") {
+		t.Fatalf("unexpected entry %q", out.String())
+	}
+}
+
+func TestAppendAccessLogEscaped(t *testing.T) {
+	t.Parallel()
+
+	s := appendAccessLogEscaped(nil, []byte("a\"b\\c\n\x7f\xffd"))
+	if expected := `a\"b\\c\x0a\x7f\xffd`; string(s) != expected {
+		t.Fatalf("unexpected escaped value %q. Expecting %q", s, expected)
+	}
+}
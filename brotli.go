@@ -8,6 +8,7 @@ import (
 
 	"github.com/andybalholm/brotli"
 	"github.com/valyala/bytebufferpool"
+	"github.com/valyala/fasthttp/internal/compresspool"
 	"github.com/valyala/fasthttp/stackless"
 )
 
@@ -83,8 +84,8 @@ func releaseRealBrotliWriter(zw *brotli.Writer, level int) {
 }
 
 var (
-	stacklessBrotliWriterPoolMap = newCompressWriterPoolMap()
-	realBrotliWriterPoolMap      = newCompressWriterPoolMap()
+	stacklessBrotliWriterPoolMap = compresspool.NewPoolMap()
+	realBrotliWriterPoolMap      = compresspool.NewPoolMap()
 )
 
 // AppendBrotliBytesLevel appends brotlied src to dst using the given
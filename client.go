@@ -247,6 +247,20 @@ type Client struct {
 	// ConfigureClient configures the fasthttp.HostClient.
 	ConfigureClient func(hc *HostClient) error
 
+	// CookieJar stores cookies from responses and adds them to requests,
+	// including requests sent while following redirects.
+	//
+	// Cookies aren't stored if not set. See InMemoryCookieJar.
+	CookieJar CookieJar
+
+	// Middlewares wrap every request sent by the HostClient instances
+	// created by the Client. See HostClient.Middlewares for details.
+	Middlewares []Middleware
+
+	// Tracer is notified about every request sent by the HostClient
+	// instances created by the Client. See HostClient.Tracer for details.
+	Tracer ClientTracer
+
 	m  map[string]*HostClient
 	ms map[string]*HostClient
 
@@ -602,6 +616,9 @@ func (c *Client) hostClient(host []byte, isTLS bool) (*HostClient, error) {
 		RetryIf:                       c.RetryIf,
 		RetryIfErr:                    c.RetryIfErr,
 		RetryIfErrUpstream:            c.RetryIfErrUpstream,
+		CookieJar:                     c.CookieJar,
+		Middlewares:                   c.Middlewares,
+		Tracer:                        c.Tracer,
 		ConnPoolStrategy:              c.ConnPoolStrategy,
 		StreamResponseBody:            c.StreamResponseBody,
 		clientReaderPool:              &c.readerPool,
@@ -771,6 +788,40 @@ type RoundTripper interface {
 	RoundTrip(hc *HostClient, req *Request, resp *Response) (retry bool, err error)
 }
 
+// Doer performs the given http request and fills the given http response.
+//
+// Client, HostClient and LBClient implement Doer.
+type Doer interface {
+	Do(req *Request, resp *Response) error
+}
+
+// DoerFunc is an adapter allowing using ordinary functions as Doer.
+type DoerFunc func(req *Request, resp *Response) error
+
+// Do calls f(req, resp).
+func (f DoerFunc) Do(req *Request, resp *Response) error {
+	return f(req, resp)
+}
+
+// Middleware wraps the next Doer in the chain.
+//
+// Middleware may modify the request before calling next.Do, inspect
+// or modify the response after next.Do returns, call next.Do multiple times
+// (e.g. for retrying) or return without calling next.Do at all.
+//
+// Middleware is used for auth signing, logging, metrics, retries,
+// header injection, etc. See HostClient.Middlewares for details.
+type Middleware func(next Doer) Doer
+
+// chainMiddlewares wraps d into middlewares, so middlewares[0]
+// is called first.
+func chainMiddlewares(middlewares []Middleware, d Doer) Doer {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		d = middlewares[i](d)
+	}
+	return d
+}
+
 // ConnPoolStrategyType define strategy of connection pool enqueue/dequeue.
 type ConnPoolStrategyType int
 
@@ -831,6 +882,33 @@ type HostClient struct {
 	// Upstream information is a <host>:<port> format.
 	RetryIfErrUpstream RetryIfErrUpstreamFunc
 
+	// CookieJar stores cookies from responses and adds them to requests.
+	//
+	// Cookies aren't stored if not set. See InMemoryCookieJar.
+	CookieJar CookieJar
+
+	// Middlewares wrap every request sent by the HostClient,
+	// including every redirect hop.
+	//
+	// Middlewares[0] is called first and the last middleware calls
+	// the HostClient, which sends the request, retrying it if needed.
+	// Cookies from CookieJar are already added to the request
+	// when middlewares are called.
+	//
+	// Middlewares mustn't be changed after the first request.
+	Middlewares []Middleware
+
+	// Tracer is notified about every request sent by the HostClient,
+	// including every redirect hop, with the request timings.
+	//
+	// The span is started before adding cookies from CookieJar
+	// and calling Middlewares.
+	Tracer ClientTracer
+
+	middlewareDoer Doer
+
+	middlewareOnce sync.Once
+
 	connsWait *wantConnQueue
 
 	tlsConfigMap map[string]*tls.Config
@@ -1007,6 +1085,9 @@ type clientConn struct {
 
 	createdTime time.Time
 	lastUseTime time.Time
+
+	dialDuration         time.Duration
+	tlsHandshakeDuration time.Duration
 }
 
 // Conn returns the underlying net.Conn associated with the client connection.
@@ -1080,11 +1161,7 @@ func (c *HostClient) Post(dst []byte, url string, postArgs *Args) (statusCode in
 	return clientPostURL(dst, url, postArgs, c)
 }
 
-type clientDoer interface {
-	Do(req *Request, resp *Response) error
-}
-
-func clientGetURL(dst []byte, url string, c clientDoer) (statusCode int, body []byte, err error) {
+func clientGetURL(dst []byte, url string, c Doer) (statusCode int, body []byte, err error) {
 	req := AcquireRequest()
 
 	statusCode, body, err = doRequestFollowRedirectsBuffer(req, dst, url, c)
@@ -1093,7 +1170,7 @@ func clientGetURL(dst []byte, url string, c clientDoer) (statusCode int, body []
 	return statusCode, body, err
 }
 
-func clientGetURLTimeout(dst []byte, url string, timeout time.Duration, c clientDoer) (statusCode int, body []byte, err error) {
+func clientGetURLTimeout(dst []byte, url string, timeout time.Duration, c Doer) (statusCode int, body []byte, err error) {
 	deadline := time.Now().Add(timeout)
 	return clientGetURLDeadline(dst, url, deadline, c)
 }
@@ -1104,7 +1181,7 @@ type clientURLResponse struct {
 	statusCode int
 }
 
-func clientGetURLDeadline(dst []byte, url string, deadline time.Time, c clientDoer) (statusCode int, body []byte, err error) {
+func clientGetURLDeadline(dst []byte, url string, deadline time.Time, c Doer) (statusCode int, body []byte, err error) {
 	timeout := time.Until(deadline)
 	if timeout <= 0 {
 		return 0, dst, ErrTimeout
@@ -1176,7 +1253,7 @@ func clientGetURLDeadline(dst []byte, url string, deadline time.Time, c clientDo
 
 var clientURLResponseChPool sync.Pool
 
-func clientPostURL(dst []byte, url string, postArgs *Args, c clientDoer) (statusCode int, body []byte, err error) {
+func clientPostURL(dst []byte, url string, postArgs *Args, c Doer) (statusCode int, body []byte, err error) {
 	req := AcquireRequest()
 	defer ReleaseRequest(req)
 
@@ -1208,7 +1285,7 @@ var (
 
 const defaultMaxRedirectsCount = 16
 
-func doRequestFollowRedirectsBuffer(req *Request, dst []byte, url string, c clientDoer) (statusCode int, body []byte, err error) {
+func doRequestFollowRedirectsBuffer(req *Request, dst []byte, url string, c Doer) (statusCode int, body []byte, err error) {
 	resp := AcquireResponse()
 	bodyBuf := resp.bodyBuffer()
 	resp.keepBodyBuffer = true
@@ -1226,7 +1303,7 @@ func doRequestFollowRedirectsBuffer(req *Request, dst []byte, url string, c clie
 }
 
 func doRequestFollowRedirects(
-	req *Request, resp *Response, url string, maxRedirectsCount int, c clientDoer,
+	req *Request, resp *Response, url string, maxRedirectsCount int, c Doer,
 ) (statusCode int, body []byte, err error) {
 	redirectsCount := 0
 	initialHost := hostnameFromURLString(url)
@@ -1531,6 +1608,63 @@ func (c *HostClient) DoRedirects(req *Request, resp *Response, maxRedirectsCount
 // It is recommended obtaining req and resp via AcquireRequest
 // and AcquireResponse in performance-critical code.
 func (c *HostClient) Do(req *Request, resp *Response) error {
+	jar := c.CookieJar
+	if resp == nil && (jar != nil || len(c.Middlewares) > 0 || c.Tracer != nil) {
+		// The response is needed for storing cookies and passing it
+		// to middlewares and tracer.
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	if c.Tracer != nil {
+		return c.doTraced(req, resp)
+	}
+	return c.doWithCookies(req, resp)
+}
+
+func (c *HostClient) doTraced(req *Request, resp *Response) error {
+	trace := &ClientTrace{
+		Start: time.Now(),
+	}
+	span := c.Tracer.StartClientSpan(req)
+	req.clientTrace = trace
+	err := c.doWithCookies(req, resp)
+	req.clientTrace = nil
+	span.End(req, resp, trace, err)
+	return err
+}
+
+func (c *HostClient) doWithCookies(req *Request, resp *Response) error {
+	jar := c.CookieJar
+	n := -1
+	if jar != nil {
+		n = addCookieJarCookies(jar, req)
+	}
+
+	var err error
+	if len(c.Middlewares) > 0 {
+		err = c.middlewares().Do(req, resp)
+	} else {
+		err = c.doWithRetries(req, resp)
+	}
+
+	if jar != nil {
+		removeCookieJarCookies(req, n)
+		if err == nil {
+			storeCookieJarCookies(jar, req.URI(), resp)
+		}
+	}
+	return err
+}
+
+func (c *HostClient) middlewares() Doer {
+	c.middlewareOnce.Do(func() {
+		c.middlewareDoer = chainMiddlewares(c.Middlewares, DoerFunc(c.doWithRetries))
+	})
+	return c.middlewareDoer
+}
+
+// doWithRetries performs the given request, retrying it on errors if needed.
+func (c *HostClient) doWithRetries(req *Request, resp *Response) error {
 	var (
 		err          error
 		retry        bool
@@ -1654,6 +1788,10 @@ func (c *HostClient) doNonNilReqResp(req *Request, resp *Response) (bool, error)
 		return false, ErrHostClientRedirectToDifferentScheme
 	}
 
+	if trace := req.clientTrace; trace != nil {
+		trace.Attempts++
+	}
+
 	atomic.StoreUint32(&c.lastUseTime, uint32(time.Now().Unix()-startTimeUnix)) // #nosec G115
 
 	// Free up resources occupied by response before sending the request,
@@ -1827,12 +1965,11 @@ func (c *HostClient) AcquireConn(reqTimeout time.Duration, connectionClose bool)
 		go c.connsCleaner()
 	}
 
-	conn, err := c.dialHostHard(reqTimeout)
+	cc, err = c.dialHostHard(reqTimeout)
 	if err != nil {
 		c.decConnsCount()
 		return nil, err
 	}
-	cc = acquireClientConn(conn)
 
 	return cc, nil
 }
@@ -1848,14 +1985,13 @@ func (c *HostClient) queueForIdle(w *wantConn) {
 }
 
 func (c *HostClient) dialConnFor(w *wantConn) {
-	conn, err := c.dialHostHard(0)
+	cc, err := c.dialHostHard(0)
 	if err != nil {
 		w.tryDeliver(nil, err)
 		c.decConnsCount()
 		return
 	}
 
-	cc := acquireClientConn(conn)
 	if !w.tryDeliver(cc, nil) {
 		// not delivered, return idle connection
 		c.ReleaseConn(cc)
@@ -2141,7 +2277,7 @@ func (c *HostClient) nextAddr() string {
 	return addr
 }
 
-func (c *HostClient) dialHostHard(dialTimeout time.Duration) (conn net.Conn, err error) {
+func (c *HostClient) dialHostHard(dialTimeout time.Duration) (*clientConn, error) {
 	// use dialTimeout to control the timeout of each dial. It does not work if dialTimeout is 0 or if
 	// c.DialTimeout has not been set and c.Dial has been set.
 	// attempt to dial all the available hosts before giving up.
@@ -2160,6 +2296,7 @@ func (c *HostClient) dialHostHard(dialTimeout time.Duration) (conn net.Conn, err
 		timeout = DefaultDialTimeout
 	}
 	deadline := time.Now().Add(timeout)
+	var err error
 	for n > 0 {
 		addr := c.nextAddr()
 		var tlsConfig *tls.Config
@@ -2170,9 +2307,15 @@ func (c *HostClient) dialHostHard(dialTimeout time.Duration) (conn net.Conn, err
 				continue
 			}
 		}
-		conn, err = dialAddr(addr, c.Dial, c.DialTimeout, c.DialDualStack, c.IsTLS, tlsConfig, dialTimeout, c.WriteTimeout)
+		dialStart := time.Now()
+		var conn net.Conn
+		var tlsHandshakeDuration time.Duration
+		conn, tlsHandshakeDuration, err = dialAddr(addr, c.Dial, c.DialTimeout, c.DialDualStack, c.IsTLS, tlsConfig, dialTimeout, c.WriteTimeout)
 		if err == nil {
-			return conn, nil
+			cc := acquireClientConn(conn)
+			cc.dialDuration = time.Since(dialStart) - tlsHandshakeDuration
+			cc.tlsHandshakeDuration = tlsHandshakeDuration
+			return cc, nil
 		}
 		if time.Since(deadline) >= 0 {
 			break
@@ -2230,17 +2373,19 @@ func tlsClientHandshake(rawConn net.Conn, tlsConfig *tls.Config, deadline time.T
 	return conn, nil
 }
 
+// dialAddr returns the connection to addr and the duration of TLS handshake
+// if it has been performed.
 func dialAddr(
 	addr string, dial DialFunc, dialWithTimeout DialFuncWithTimeout, dialDualStack, isTLS bool,
 	tlsConfig *tls.Config, dialTimeout, writeTimeout time.Duration,
-) (net.Conn, error) {
+) (net.Conn, time.Duration, error) {
 	deadline := time.Now().Add(writeTimeout)
 	conn, err := callDialFunc(addr, dial, dialWithTimeout, dialDualStack, isTLS, dialTimeout)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if conn == nil {
-		return nil, errors.New("dialling unsuccessful: please report this bug")
+		return nil, 0, errors.New("dialling unsuccessful: please report this bug")
 	}
 
 	// We assume that any conn that has the Handshake() method is a TLS conn already.
@@ -2249,11 +2394,14 @@ func dialAddr(
 
 	if isTLS && !isTLSAlready {
 		if writeTimeout == 0 {
-			return tls.Client(conn, tlsConfig), nil
+			// The handshake is performed on the first write.
+			return tls.Client(conn, tlsConfig), 0, nil
 		}
-		return tlsClientHandshake(conn, tlsConfig, deadline)
+		handshakeStart := time.Now()
+		conn, err = tlsClientHandshake(conn, tlsConfig, deadline)
+		return conn, time.Since(handshakeStart), err
 	}
-	return conn, nil
+	return conn, 0, nil
 }
 
 func callDialFunc(
@@ -2964,7 +3112,7 @@ func (c *pipelineConnClient) worker(chs *pipelineConnChannels) error {
 			return err
 		}
 	}
-	conn, err := dialAddr(c.Addr, c.Dial, nil, c.DialDualStack, c.IsTLS, tlsConfig, 0, c.WriteTimeout)
+	conn, _, err := dialAddr(c.Addr, c.Dial, nil, c.DialDualStack, c.IsTLS, tlsConfig, 0, c.WriteTimeout)
 	if err != nil {
 		return err
 	}
@@ -3242,12 +3390,30 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 		deadline = time.Now().Add(req.timeout)
 	}
 
+	trace := req.clientTrace
+	var acquireStart time.Time
+	if trace != nil {
+		acquireStart = time.Now()
+	}
+
 	cc, err := hc.AcquireConn(req.timeout, req.ConnectionClose())
 	if err != nil {
 		return false, err
 	}
 	conn := cc.c
 
+	if trace != nil {
+		trace.ConnReused = !cc.lastUseTime.IsZero()
+		trace.Dial, trace.TLSHandshake = 0, 0
+		trace.ConnWait = time.Since(acquireStart)
+		if !trace.ConnReused {
+			trace.Dial = cc.dialDuration
+			trace.TLSHandshake = cc.tlsHandshakeDuration
+			// The connection may be dialed after waiting for a free connection.
+			trace.ConnWait = max(trace.ConnWait-trace.Dial-trace.TLSHandshake, 0)
+		}
+	}
+
 	resp.ParseNetConn(conn)
 
 	writeDeadline := deadline
@@ -3263,6 +3429,23 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 		return true, err
 	}
 
+	if trace != nil {
+		// Perform the delayed TLS handshake before writing the request,
+		// so its duration isn't included into the request write.
+		if tlsConn, ok := conn.(*tls.Conn); ok && !tlsConn.ConnectionState().HandshakeComplete {
+			handshakeStart := time.Now()
+			err = conn.SetReadDeadline(writeDeadline)
+			if err == nil {
+				err = tlsConn.Handshake()
+			}
+			trace.TLSHandshake = time.Since(handshakeStart)
+			if err != nil {
+				hc.CloseConn(cc)
+				return true, err
+			}
+		}
+	}
+
 	resetConnection := false
 	if hc.MaxConnDuration > 0 && time.Since(cc.createdTime) > hc.MaxConnDuration && !req.ConnectionClose() {
 		req.SetConnectionClose()
@@ -3312,7 +3495,18 @@ func (t *transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (ret
 	}
 
 	br := hc.AcquireReader(conn)
+	var readStart time.Time
+	if trace != nil {
+		writeEnd := time.Now()
+		// Errors are returned by ReadLimitBody below.
+		br.Peek(1) //nolint:errcheck
+		readStart = time.Now()
+		trace.FirstByte = readStart.Sub(writeEnd)
+	}
 	err = resp.ReadLimitBody(br, hc.MaxResponseBodySize)
+	if trace != nil {
+		trace.BodyRead = time.Since(readStart)
+	}
 	if err != nil {
 		hc.ReleaseReader(br)
 		hc.CloseConn(cc)
@@ -4412,3 +4412,134 @@ func TestClientRetryIfErrUpstream(t *testing.T) {
 		}
 	})
 }
+
+func TestHostClientMiddlewares(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if requests.Add(1) == 1 {
+				ctx.Error("try again", StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(ctx, "%s %s", ctx.Request.Header.Peek("X-Inject"), ctx.Request.Header.Peek("X-Signature"))
+		},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	var calls []string
+	injectHeader := func(next Doer) Doer {
+		return DoerFunc(func(req *Request, resp *Response) error {
+			calls = append(calls, "inject")
+			req.Header.Set("X-Inject", "foo")
+			return next.Do(req, resp)
+		})
+	}
+	sign := func(next Doer) Doer {
+		return DoerFunc(func(req *Request, resp *Response) error {
+			calls = append(calls, "sign")
+			req.Header.Set("X-Signature", "signed-"+string(req.Header.Peek("X-Inject")))
+			return next.Do(req, resp)
+		})
+	}
+	retryUnavailable := func(next Doer) Doer {
+		return DoerFunc(func(req *Request, resp *Response) error {
+			for {
+				calls = append(calls, "retry")
+				if err := next.Do(req, resp); err != nil || resp.StatusCode() != StatusServiceUnavailable {
+					return err
+				}
+			}
+		})
+	}
+	c := &HostClient{
+		Addr:        "example.com",
+		Dial:        func(string) (net.Conn, error) { return ln.Dial() },
+		Middlewares: []Middleware{injectHeader, retryUnavailable, sign},
+	}
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://example.com/")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	expectedBody := "foo signed-foo"
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	expectedCalls := "inject retry sign retry sign"
+	if s := strings.Join(calls, " "); s != expectedCalls {
+		t.Fatalf("unexpected middleware calls %q. Expecting %q", s, expectedCalls)
+	}
+
+	// Middlewares see the response even if the caller ignores it.
+	var statusCode int
+	c2 := &HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+		Middlewares: []Middleware{func(next Doer) Doer {
+			return DoerFunc(func(req *Request, resp *Response) error {
+				err := next.Do(req, resp)
+				statusCode = resp.StatusCode()
+				return err
+			})
+		}},
+	}
+	if err := c2.Do(req, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusOK)
+	}
+}
+
+func TestClientMiddlewares(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/redirect" {
+				ctx.Redirect("http://other.com/", StatusFound)
+				return
+			}
+			fmt.Fprintf(ctx, "%s %s", ctx.Host(), ctx.Request.Header.Peek("X-Token"))
+		},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	var urls []string
+	c := &Client{
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+		Middlewares: []Middleware{func(next Doer) Doer {
+			return DoerFunc(func(req *Request, resp *Response) error {
+				urls = append(urls, req.URI().String())
+				req.Header.Set("X-Token", "secret")
+				return next.Do(req, resp)
+			})
+		}},
+	}
+
+	// Middlewares are called for every redirect hop.
+	statusCode, body, err := c.Get(nil, "http://example.com/redirect")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != StatusOK || string(body) != "other.com secret" {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, "other.com secret")
+	}
+	expectedURLs := "http://example.com/redirect http://other.com/"
+	if s := strings.Join(urls, " "); s != expectedURLs {
+		t.Fatalf("unexpected urls %q. Expecting %q", s, expectedURLs)
+	}
+}
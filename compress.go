@@ -11,6 +11,7 @@ import (
 	"github.com/klauspost/compress/gzip"
 	"github.com/klauspost/compress/zlib"
 	"github.com/valyala/bytebufferpool"
+	"github.com/valyala/fasthttp/internal/compresspool"
 	"github.com/valyala/fasthttp/stackless"
 )
 
@@ -75,7 +76,7 @@ func resetFlateReader(zr io.ReadCloser, r io.Reader) error {
 var flateReaderPool sync.Pool
 
 func acquireStacklessGzipWriter(w io.Writer, level int) stackless.Writer {
-	nLevel := normalizeCompressLevel(level)
+	nLevel := compresspool.NormalizeLevel(level)
 	p := stacklessGzipWriterPoolMap[nLevel]
 	v := p.Get()
 	if v == nil {
@@ -90,13 +91,13 @@ func acquireStacklessGzipWriter(w io.Writer, level int) stackless.Writer {
 
 func releaseStacklessGzipWriter(sw stackless.Writer, level int) {
 	sw.Close()
-	nLevel := normalizeCompressLevel(level)
+	nLevel := compresspool.NormalizeLevel(level)
 	p := stacklessGzipWriterPoolMap[nLevel]
 	p.Put(sw)
 }
 
 func acquireRealGzipWriter(w io.Writer, level int) *gzip.Writer {
-	nLevel := normalizeCompressLevel(level)
+	nLevel := compresspool.NormalizeLevel(level)
 	p := realGzipWriterPoolMap[nLevel]
 	v := p.Get()
 	if v == nil {
@@ -120,14 +121,14 @@ func acquireRealGzipWriter(w io.Writer, level int) *gzip.Writer {
 
 func releaseRealGzipWriter(zw *gzip.Writer, level int) {
 	zw.Close()
-	nLevel := normalizeCompressLevel(level)
+	nLevel := compresspool.NormalizeLevel(level)
 	p := realGzipWriterPoolMap[nLevel]
 	p.Put(zw)
 }
 
 var (
-	stacklessGzipWriterPoolMap = newCompressWriterPoolMap()
-	realGzipWriterPoolMap      = newCompressWriterPoolMap()
+	stacklessGzipWriterPoolMap = compresspool.NewPoolMap()
+	realGzipWriterPoolMap      = compresspool.NewPoolMap()
 )
 
 // AppendGzipBytesLevel appends gzipped src to dst using the given
@@ -387,7 +388,7 @@ func (r *byteSliceReader) ReadByte() (byte, error) {
 }
 
 func acquireStacklessDeflateWriter(w io.Writer, level int) stackless.Writer {
-	nLevel := normalizeCompressLevel(level)
+	nLevel := compresspool.NormalizeLevel(level)
 	p := stacklessDeflateWriterPoolMap[nLevel]
 	v := p.Get()
 	if v == nil {
@@ -402,13 +403,13 @@ func acquireStacklessDeflateWriter(w io.Writer, level int) stackless.Writer {
 
 func releaseStacklessDeflateWriter(sw stackless.Writer, level int) {
 	sw.Close()
-	nLevel := normalizeCompressLevel(level)
+	nLevel := compresspool.NormalizeLevel(level)
 	p := stacklessDeflateWriterPoolMap[nLevel]
 	p.Put(sw)
 }
 
 func acquireRealDeflateWriter(w io.Writer, level int) *zlib.Writer {
-	nLevel := normalizeCompressLevel(level)
+	nLevel := compresspool.NormalizeLevel(level)
 	p := realDeflateWriterPoolMap[nLevel]
 	v := p.Get()
 	if v == nil {
@@ -432,28 +433,16 @@ func acquireRealDeflateWriter(w io.Writer, level int) *zlib.Writer {
 
 func releaseRealDeflateWriter(zw *zlib.Writer, level int) {
 	zw.Close()
-	nLevel := normalizeCompressLevel(level)
+	nLevel := compresspool.NormalizeLevel(level)
 	p := realDeflateWriterPoolMap[nLevel]
 	p.Put(zw)
 }
 
 var (
-	stacklessDeflateWriterPoolMap = newCompressWriterPoolMap()
-	realDeflateWriterPoolMap      = newCompressWriterPoolMap()
+	stacklessDeflateWriterPoolMap = compresspool.NewPoolMap()
+	realDeflateWriterPoolMap      = compresspool.NewPoolMap()
 )
 
-func newCompressWriterPoolMap() []*sync.Pool {
-	// Initialize pools for all the compression levels defined
-	// in https://pkg.go.dev/compress/flate#pkg-constants .
-	// Compression levels are normalized with normalizeCompressLevel,
-	// so the fit [0..11].
-	m := make([]*sync.Pool, 0, 12)
-	for range 12 {
-		m = append(m, &sync.Pool{})
-	}
-	return m
-}
-
 func isFileCompressible(f fs.File, minCompressRatio float64) bool {
 	// Try compressing the first 4kb of the file
 	// and see if it can be compressed by more than
@@ -480,14 +469,3 @@ func isFileCompressible(f fs.File, minCompressRatio float64) bool {
 	bytebufferpool.Put(b)
 	return float64(zn) < float64(n)*minCompressRatio
 }
-
-// normalizes compression level into [0..11], so it could be used as an index
-// in *PoolMap.
-func normalizeCompressLevel(level int) int {
-	// -2 is the lowest compression level - CompressHuffmanOnly
-	// 9 is the highest compression level - CompressBestCompression
-	if level < -2 || level > 9 {
-		level = CompressDefaultCompression
-	}
-	return level + 2
-}
@@ -414,6 +414,10 @@ func (c *Cookie) ParseBytes(src []byte) error {
 					if err != nil {
 						return err
 					}
+					if maxAge == 0 {
+						// 'max-age=0' means delete cookie now.
+						maxAge = -1
+					}
 					c.maxAge = maxAge
 				}
 
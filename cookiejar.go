@@ -0,0 +1,354 @@
+package fasthttp
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CookieJar manages storage and use of cookies in HTTP requests.
+//
+// Client and HostClient store cookies from Set-Cookie response headers
+// into CookieJar and add matching cookies from CookieJar to every request,
+// including requests sent while following redirects.
+//
+// Implementations of CookieJar must be safe for concurrent use
+// by multiple goroutines.
+type CookieJar interface {
+	// SetCookies handles the receipt of the cookies in a reply for the given uri.
+	//
+	// cookies and uri mustn't be retained after returning from SetCookies.
+	// Copy them if they must be stored.
+	SetCookies(uri *URI, cookies []*Cookie)
+
+	// Cookies returns the cookies to send in a request for the given uri.
+	//
+	// Only Key and Value of the returned cookies are sent.
+	// The returned cookies mustn't be modified by the caller.
+	Cookies(uri *URI) []*Cookie
+}
+
+// InMemoryCookieJar is an in-memory CookieJar implementation
+// following RFC 6265.
+//
+// The following cookie attributes are honoured:
+//
+//   - Domain: domain cookies are sent to the domain and its subdomains,
+//     while cookies without Domain are sent only to the host they were
+//     received from. Domain attributes not matching the host are rejected.
+//   - Path: cookies are sent only to paths matching the cookie path.
+//     The path of the request uri is used if Path is missing.
+//   - Expire and MaxAge: expired cookies are deleted. MaxAge takes precedence
+//     over Expire. Cookies without both attributes live until the jar is dropped.
+//   - Secure: secure cookies are accepted from and sent to https uris only.
+//   - SameSite: cookies with SameSite=None must be secure. All the requests
+//     sent by the client are treated as same-site, so Lax and Strict cookies
+//     are always sent.
+//   - Partitioned: partitioned cookies must be secure.
+//
+// Cookie names prefixed with __Secure- and __Host- are handled
+// according to https://datatracker.ietf.org/doc/html/draft-ietf-httpbis-rfc6265bis#section-4.1.3 .
+//
+// The public suffix list isn't consulted, so only Domain attributes
+// without dots (e.g. 'com') are rejected.
+//
+// The zero value is ready for use.
+//
+// It is safe calling InMemoryCookieJar methods from concurrently running goroutines.
+type InMemoryCookieJar struct {
+	// entries maps domains to cookies set for them.
+	entries map[string][]*cookieJarEntry
+
+	mu sync.Mutex
+
+	// seq orders cookies with the same path length by creation time.
+	seq uint64
+}
+
+type cookieJarEntry struct {
+	// expire is zero for cookies without expiration.
+	expire time.Time
+
+	cookie Cookie
+
+	seq uint64
+
+	hostOnly bool
+}
+
+const (
+	cookiePrefixSecure = "__Secure-"
+	cookiePrefixHost   = "__Host-"
+)
+
+// SetCookies stores cookies received in a reply for the given uri.
+//
+// Cookies violating RFC 6265 restrictions are ignored, while expired cookies
+// delete the stored cookies with the same name, domain and path.
+func (j *InMemoryCookieJar) SetCookies(uri *URI, cookies []*Cookie) {
+	host, isIP := cookieJarHost(uri)
+	if host == "" {
+		return
+	}
+	isHTTPS := uri.isHTTPS()
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, c := range cookies {
+		j.setCookie(c, uri, host, isIP, isHTTPS, now)
+	}
+}
+
+func (j *InMemoryCookieJar) setCookie(c *Cookie, uri *URI, host string, isIP, isHTTPS bool, now time.Time) {
+	if len(c.key) == 0 {
+		return
+	}
+	if c.secure && !isHTTPS {
+		return
+	}
+	if (c.sameSite == CookieSameSiteNoneMode || c.partitioned) && !c.secure {
+		return
+	}
+
+	domain := strings.ToLower(strings.TrimPrefix(string(c.domain), "."))
+	hostOnly := domain == ""
+	switch {
+	case hostOnly:
+		domain = host
+	case domain == host:
+	case isIP, strings.IndexByte(domain, '.') < 0, !strings.HasSuffix(host, "."+domain):
+		// IP addresses can't have domain cookies, while top-level domains
+		// and domains not matching the host can't set cookies.
+		return
+	}
+
+	path := string(c.path)
+	if path == "" || path[0] != '/' {
+		path = defaultCookiePath(uri.Path())
+	}
+
+	key := b2s(c.key)
+	if strings.HasPrefix(key, cookiePrefixSecure) && !c.secure {
+		return
+	}
+	if strings.HasPrefix(key, cookiePrefixHost) && (!c.secure || !hostOnly || path != "/") {
+		return
+	}
+
+	var expire time.Time
+	switch {
+	case c.maxAge < 0:
+		expire = CookieExpireDelete
+	case c.maxAge > 0:
+		expire = now.Add(time.Duration(c.maxAge) * time.Second)
+	case !c.expire.IsZero():
+		expire = c.expire
+	}
+	expired := !expire.IsZero() && !expire.After(now)
+
+	entries := j.entries[domain]
+	for i, e := range entries {
+		if string(e.cookie.key) != key || string(e.cookie.path) != path || e.hostOnly != hostOnly {
+			continue
+		}
+		if expired {
+			entries = append(entries[:i], entries[i+1:]...)
+			if len(entries) == 0 {
+				delete(j.entries, domain)
+			} else {
+				j.entries[domain] = entries
+			}
+			return
+		}
+		// The creation time of the replaced cookie is retained.
+		e.cookie.CopyTo(c)
+		e.cookie.SetDomain(domain)
+		e.cookie.SetPath(path)
+		e.expire = expire
+		return
+	}
+	if expired {
+		return
+	}
+
+	j.seq++
+	e := &cookieJarEntry{
+		expire:   expire,
+		seq:      j.seq,
+		hostOnly: hostOnly,
+	}
+	e.cookie.CopyTo(c)
+	e.cookie.SetDomain(domain)
+	e.cookie.SetPath(path)
+	if j.entries == nil {
+		j.entries = make(map[string][]*cookieJarEntry)
+	}
+	j.entries[domain] = append(entries, e)
+}
+
+// Cookies returns copies of cookies to send in a request for the given uri.
+//
+// Cookies with longer paths are listed first. Cookies with equal
+// path lengths are listed in the order they were created.
+func (j *InMemoryCookieJar) Cookies(uri *URI) []*Cookie {
+	host, isIP := cookieJarHost(uri)
+	if host == "" {
+		return nil
+	}
+	isHTTPS := uri.isHTTPS()
+	path := string(uri.Path())
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var matched []*cookieJarEntry
+	domain := host
+	for {
+		entries := j.entries[domain]
+		n := 0
+		for _, e := range entries {
+			if !e.expire.IsZero() && !e.expire.After(now) {
+				// Purge the expired cookie.
+				continue
+			}
+			entries[n] = e
+			n++
+			if e.hostOnly && domain != host {
+				continue
+			}
+			if e.cookie.secure && !isHTTPS {
+				continue
+			}
+			if !cookiePathMatch(path, string(e.cookie.path)) {
+				continue
+			}
+			matched = append(matched, e)
+		}
+		switch {
+		case n == 0 && len(entries) > 0:
+			delete(j.entries, domain)
+		case n < len(entries):
+			clear(entries[n:])
+			j.entries[domain] = entries[:n]
+		}
+
+		if isIP {
+			break
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+
+	sort.Slice(matched, func(a, b int) bool {
+		pa, pb := len(matched[a].cookie.path), len(matched[b].cookie.path)
+		if pa != pb {
+			return pa > pb
+		}
+		return matched[a].seq < matched[b].seq
+	})
+
+	cookies := make([]*Cookie, len(matched))
+	for i, e := range matched {
+		c := &Cookie{}
+		c.CopyTo(&e.cookie)
+		cookies[i] = c
+	}
+	return cookies
+}
+
+// cookieJarHost returns lowercase host of the uri without port
+// and whether the host is an IP address.
+func cookieJarHost(uri *URI) (string, bool) {
+	host := strings.ToLower(string(hostnameFromHostPortBytes(uri.Host())))
+	host = strings.TrimSuffix(host, ".")
+	return host, net.ParseIP(host) != nil
+}
+
+// defaultCookiePath returns the default cookie path for the given request path.
+//
+// See https://www.rfc-editor.org/rfc/rfc6265#section-5.1.4 .
+func defaultCookiePath(path []byte) string {
+	n := bytes.LastIndexByte(path, '/')
+	if n <= 0 {
+		return "/"
+	}
+	return string(path[:n])
+}
+
+// cookiePathMatch returns true if the request path matches the cookie path.
+//
+// See https://www.rfc-editor.org/rfc/rfc6265#section-5.1.4 .
+func cookiePathMatch(path, cookiePath string) bool {
+	if !strings.HasPrefix(path, cookiePath) {
+		return false
+	}
+	return len(path) == len(cookiePath) ||
+		cookiePath[len(cookiePath)-1] == '/' ||
+		path[len(cookiePath)] == '/'
+}
+
+// addCookieJarCookies adds cookies from jar matching the request uri
+// to req unless req already contains cookies with the same names.
+//
+// It returns the number of request cookies before adding jar cookies
+// or -1 if the cookies cannot be added.
+func addCookieJarCookies(jar CookieJar, req *Request) int {
+	h := &req.Header
+	if h.disableSpecialHeader {
+		return -1
+	}
+	h.collectCookies()
+	n := len(h.cookies)
+	for _, c := range jar.Cookies(req.URI()) {
+		if !hasCookieKey(h.cookies[:n], c.key) {
+			h.cookies = appendArgBytes(h.cookies, c.key, c.value, argsHasValue)
+		}
+	}
+	return n
+}
+
+// removeCookieJarCookies removes cookies added by addCookieJarCookies,
+// so they don't leak to requests sent to other hosts on redirects.
+func removeCookieJarCookies(req *Request, n int) {
+	if n >= 0 && n <= len(req.Header.cookies) {
+		req.Header.cookies = req.Header.cookies[:n]
+	}
+}
+
+func hasCookieKey(cookies []argsKV, key []byte) bool {
+	for i := range cookies {
+		if bytes.Equal(cookies[i].key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// storeCookieJarCookies stores cookies from Set-Cookie response headers into jar.
+func storeCookieJarCookies(jar CookieJar, uri *URI, resp *Response) {
+	var cookies []*Cookie
+	for _, v := range resp.Header.Cookies() {
+		c := AcquireCookie()
+		if err := c.ParseBytes(v); err != nil {
+			ReleaseCookie(c)
+			continue
+		}
+		cookies = append(cookies, c)
+	}
+	if len(cookies) == 0 {
+		return
+	}
+	jar.SetCookies(uri, cookies)
+	for _, c := range cookies {
+		ReleaseCookie(c)
+	}
+}
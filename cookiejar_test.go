@@ -0,0 +1,228 @@
+package fasthttp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func setTestJarCookies(t *testing.T, jar CookieJar, uri string, setCookies ...string) {
+	t.Helper()
+
+	var u URI
+	if err := u.Parse(nil, []byte(uri)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cookies := make([]*Cookie, len(setCookies))
+	for i, s := range setCookies {
+		cookies[i] = &Cookie{}
+		if err := cookies[i].Parse(s); err != nil {
+			t.Fatalf("unexpected error when parsing %q: %v", s, err)
+		}
+	}
+	jar.SetCookies(&u, cookies)
+}
+
+func testJarCookies(t *testing.T, jar CookieJar, uri, expected string) {
+	t.Helper()
+
+	var u URI
+	if err := u.Parse(nil, []byte(uri)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var kvs []string
+	for _, c := range jar.Cookies(&u) {
+		kvs = append(kvs, fmt.Sprintf("%s=%s", c.Key(), c.Value()))
+	}
+	if s := strings.Join(kvs, " "); s != expected {
+		t.Fatalf("unexpected cookies for %q: %q. Expecting %q", uri, s, expected)
+	}
+}
+
+func TestInMemoryCookieJarDomain(t *testing.T) {
+	t.Parallel()
+
+	var jar InMemoryCookieJar
+	setTestJarCookies(t, &jar, "http://www.example.com/",
+		"host=1",
+		"domain=2; domain=example.com",
+		"dotdomain=3; domain=.Example.COM",
+		"self=4; domain=www.example.com",
+		"sub=5; domain=sub.www.example.com",
+		"other=6; domain=other.com",
+		"tld=7; domain=com",
+	)
+	testJarCookies(t, &jar, "http://www.example.com/", "host=1 domain=2 dotdomain=3 self=4")
+	testJarCookies(t, &jar, "http://WWW.example.com:8080/", "host=1 domain=2 dotdomain=3 self=4")
+	testJarCookies(t, &jar, "http://sub.www.example.com/", "domain=2 dotdomain=3 self=4")
+	testJarCookies(t, &jar, "http://example.com/", "domain=2 dotdomain=3")
+	testJarCookies(t, &jar, "http://other.com/", "")
+	testJarCookies(t, &jar, "http://badexample.com/", "")
+
+	setTestJarCookies(t, &jar, "http://127.0.0.1/",
+		"ip=1",
+		"ipdomain=2; domain=0.0.1",
+	)
+	testJarCookies(t, &jar, "http://127.0.0.1:8080/", "ip=1")
+}
+
+func TestInMemoryCookieJarPath(t *testing.T) {
+	t.Parallel()
+
+	var jar InMemoryCookieJar
+	setTestJarCookies(t, &jar, "http://example.com/foo/bar/baz",
+		"default=1",
+		"root=2; path=/",
+		"foo=3; path=/foo",
+		"foobar=4; path=/foo/bar/",
+	)
+	testJarCookies(t, &jar, "http://example.com/", "root=2")
+	testJarCookies(t, &jar, "http://example.com/foo", "foo=3 root=2")
+	testJarCookies(t, &jar, "http://example.com/foobar", "root=2")
+	testJarCookies(t, &jar, "http://example.com/foo/bar", "default=1 foo=3 root=2")
+	testJarCookies(t, &jar, "http://example.com/foo/bar/baz", "foobar=4 default=1 foo=3 root=2")
+
+	// Cookies with equal path lengths are ordered by creation time,
+	// which is retained on replacing.
+	setTestJarCookies(t, &jar, "http://example.com/", "a=1", "b=2", "a=3")
+	testJarCookies(t, &jar, "http://example.com/", "root=2 a=3 b=2")
+}
+
+func TestInMemoryCookieJarExpire(t *testing.T) {
+	t.Parallel()
+
+	var jar InMemoryCookieJar
+	setTestJarCookies(t, &jar, "http://example.com/",
+		"session=1",
+		"maxage=2; max-age=100",
+		"expire=3; expires="+string(AppendHTTPDate(nil, time.Now().Add(time.Hour))),
+		"expired=4; expires=Tue, 10 Nov 2009 23:00:00 GMT",
+		"precedence=5; max-age=100; expires=Tue, 10 Nov 2009 23:00:00 GMT",
+	)
+	testJarCookies(t, &jar, "http://example.com/", "session=1 maxage=2 expire=3 precedence=5")
+
+	setTestJarCookies(t, &jar, "http://example.com/",
+		"session=; max-age=0",
+		"expire=; expires=Tue, 10 Nov 2009 23:00:00 GMT",
+	)
+	testJarCookies(t, &jar, "http://example.com/", "maxage=2 precedence=5")
+
+	// Cookies are purged after expiration.
+	jar.mu.Lock()
+	for _, e := range jar.entries["example.com"] {
+		e.expire = time.Now().Add(-time.Second)
+	}
+	jar.mu.Unlock()
+	testJarCookies(t, &jar, "http://example.com/", "")
+	if len(jar.entries) != 0 {
+		t.Fatalf("unexpected entries %v. Expecting expired cookies to be purged", jar.entries)
+	}
+}
+
+func TestInMemoryCookieJarSecure(t *testing.T) {
+	t.Parallel()
+
+	var jar InMemoryCookieJar
+	setTestJarCookies(t, &jar, "http://example.com/",
+		"insecure=1",
+		"secure=2; secure",
+		"none=3; samesite=none",
+		"__Secure-a=4; secure",
+	)
+	setTestJarCookies(t, &jar, "https://example.com/",
+		"secure=5; secure",
+		"lax=6; samesite=lax",
+		"none=7; samesite=none",
+		"nonesecure=8; samesite=none; secure",
+		"partitioned=9; partitioned",
+		"partitionedsecure=10; partitioned; secure",
+		"__Secure-a=11",
+		"__Secure-b=12; secure",
+		"__Host-a=13; secure; path=/",
+		"__Host-b=14; secure; path=/; domain=example.com",
+		"__Host-c=15; secure; path=/foo",
+		"__Host-d=16; path=/",
+	)
+	testJarCookies(t, &jar, "http://example.com/", "insecure=1 lax=6")
+	testJarCookies(t, &jar, "https://example.com/",
+		"insecure=1 secure=5 lax=6 nonesecure=8 partitionedsecure=10 __Secure-b=12 __Host-a=13")
+}
+
+func TestClientCookieJar(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			host := string(ctx.Host())
+			switch string(ctx.Path()) {
+			case "/login":
+				ctx.Response.Header.Add(HeaderSetCookie, "session=123; path=/")
+				ctx.Response.Header.Add(HeaderSetCookie, "shared=456; domain=example.com; path=/")
+				ctx.Redirect("/profile", StatusFound)
+			case "/other":
+				ctx.Redirect("http://other.com/profile", StatusFound)
+			case "/logout":
+				ctx.Response.Header.Add(HeaderSetCookie, "session=; max-age=0; path=/")
+			default:
+				fmt.Fprintf(ctx, "%s session=%s shared=%s explicit=%s",
+					host, ctx.Request.Header.Cookie("session"), ctx.Request.Header.Cookie("shared"),
+					ctx.Request.Header.Cookie("explicit"))
+			}
+		},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	c := &Client{
+		Dial:      func(string) (net.Conn, error) { return ln.Dial() },
+		CookieJar: &InMemoryCookieJar{},
+	}
+
+	testCases := []struct {
+		uri      string
+		expected string
+	}{
+		// Cookies are stored and sent on the redirect hop.
+		{"http://www.example.com/login", "www.example.com session=123 shared=456 explicit="},
+		{"http://www.example.com/profile", "www.example.com session=123 shared=456 explicit="},
+		{"http://api.example.com/profile", "api.example.com session= shared=456 explicit="},
+		// Cookies don't leak to other hosts on redirects.
+		{"http://www.example.com/other", "other.com session= shared= explicit="},
+		{"http://www.example.com/logout", ""},
+		{"http://www.example.com/profile", "www.example.com session= shared=456 explicit="},
+	}
+	for _, tc := range testCases {
+		statusCode, body, err := c.Get(nil, tc.uri)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if statusCode != StatusOK || string(body) != tc.expected {
+			t.Fatalf("unexpected response for %q: %d %q. Expecting %d %q", tc.uri, statusCode, body, StatusOK, tc.expected)
+		}
+	}
+
+	// Cookies set by the caller take precedence over the jar cookies
+	// and jar cookies aren't left in the request.
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI("http://www.example.com/profile")
+	req.Header.SetCookie("shared", "789")
+	req.Header.SetCookie("explicit", "1")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "www.example.com session= shared=789 explicit=1"
+	if string(resp.Body()) != expected {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expected)
+	}
+	if v := string(req.Header.Peek(HeaderCookie)); v != "shared=789; explicit=1" {
+		t.Fatalf("unexpected Cookie header %q. Expecting %q", v, "shared=789; explicit=1")
+	}
+}
@@ -0,0 +1,176 @@
+package fasthttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// listenerFDsEnv contains comma-separated file descriptors of the listeners
+// handed off by Server.HandoffListeners.
+const listenerFDsEnv = "FASTHTTP_LISTENER_FDS"
+
+var errNoListeners = errors.New("the server doesn't serve any listeners")
+
+// InFlightRequests returns the number of requests currently being served,
+// i.e. requests, which have been started to be read, but whose responses
+// haven't been written yet.
+//
+// This function is intended be used by monitoring systems.
+func (s *Server) InFlightRequests() int32 {
+	return s.inFlight.Load()
+}
+
+// Drain gracefully shuts down the server telling keep-alive clients to leave.
+//
+// Drain calls OnDrainStart and then shuts down the server like ShutdownWithContext,
+// while 'Connection: close' header is set on all the subsequent responses
+// regardless of CloseOnShutdown. So clients don't send new requests
+// to connections, which are about to be closed. HTTP/2 clients are sent
+// GOAWAY frame. OnDrainEnd is called after all the connections are closed
+// or ctx is done.
+//
+// Hand off the listeners to a new process via HandoffListeners before
+// calling Drain for restarting the server without refusing connections.
+func (s *Server) Drain(ctx context.Context) error {
+	s.draining.Store(true)
+	defer s.draining.Store(false)
+
+	if s.OnDrainStart != nil {
+		s.OnDrainStart()
+	}
+	err := s.ShutdownWithContext(ctx)
+	if s.OnDrainEnd != nil {
+		s.OnDrainEnd(err)
+	}
+	return err
+}
+
+// HandoffListeners passes the listeners served by the server to the process
+// started by cmd, so the process accepts connections on the same sockets.
+//
+// Duplicates of the listener files are appended to cmd.ExtraFiles.
+// The process obtains the listeners via InheritedListeners.
+// The returned files must be closed after cmd is started.
+//
+// Call Drain after the process is ready to accept connections. The sockets
+// stay open during the restart, so connections aren't refused.
+//
+// Only listeners with File method, such as *net.TCPListener and *net.UnixListener,
+// may be handed off. This includes listeners wrapped by ServeTLS
+// and ProxyProtocolListener.
+//
+// Handing off listeners isn't supported on Windows.
+func (s *Server) HandoffListeners(cmd *exec.Cmd) ([]*os.File, error) {
+	s.mu.Lock()
+	lns := append([]net.Listener(nil), s.ln...)
+	s.mu.Unlock()
+	if len(lns) == 0 {
+		return nil, errNoListeners
+	}
+
+	files := make([]*os.File, 0, len(lns))
+	fds := make([]string, 0, len(lns))
+	for _, ln := range lns {
+		f, err := listenerFile(ln)
+		if err != nil {
+			for _, f := range files {
+				_ = f.Close()
+			}
+			return nil, err
+		}
+		// File descriptors of cmd.ExtraFiles start at 3 in the started process.
+		fds = append(fds, strconv.Itoa(3+len(cmd.ExtraFiles)+len(files)))
+		files = append(files, f)
+	}
+
+	cmd.ExtraFiles = append(cmd.ExtraFiles, files...)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, listenerFDsEnv+"="+strings.Join(fds, ","))
+	return files, nil
+}
+
+// InheritedListeners returns the listeners handed off by the parent process
+// via Server.HandoffListeners in the same order as they were served.
+//
+// nil is returned if the process hasn't been started via HandoffListeners.
+// Subsequent calls return nil, since the listeners are owned by the caller.
+//
+// Usage:
+//
+//	lns, err := fasthttp.InheritedListeners()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if len(lns) == 0 {
+//		// The process hasn't been started by the previous process.
+//		ln, err := net.Listen("tcp", addr)
+//		...
+//	}
+func InheritedListeners() ([]net.Listener, error) {
+	v, ok := os.LookupEnv(listenerFDsEnv)
+	if !ok {
+		return nil, nil
+	}
+	// The listeners mustn't be inherited by child processes.
+	_ = os.Unsetenv(listenerFDsEnv)
+
+	var lns []net.Listener
+	for fdStr := range strings.SplitSeq(v, ",") {
+		fd, err := strconv.ParseUint(fdStr, 10, 0)
+		if err != nil {
+			err = fmt.Errorf("cannot parse %s=%q: %w", listenerFDsEnv, v, err)
+			return nil, closeListeners(lns, err)
+		}
+		f := os.NewFile(uintptr(fd), "fasthttp-inherited-listener")
+		// net.FileListener dups the file descriptor, so f must be closed.
+		ln, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			err = fmt.Errorf("cannot open inherited listener fd=%d: %w", fd, err)
+			return nil, closeListeners(lns, err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+func closeListeners(lns []net.Listener, err error) error {
+	for _, ln := range lns {
+		_ = ln.Close()
+	}
+	return err
+}
+
+// listenerFile returns a duplicate of the file of the given listener
+// or the listener it wraps.
+func listenerFile(ln net.Listener) (*os.File, error) {
+	for {
+		switch l := ln.(type) {
+		case interface{ File() (*os.File, error) }:
+			return l.File()
+		case *tlsListener:
+			ln = l.ln
+		case *ProxyProtocolListener:
+			ln = l.Listener
+		default:
+			return nil, fmt.Errorf("cannot hand off listener %T: it has no File method", ln)
+		}
+	}
+}
+
+// tlsListener is TLS listener created by Server.ServeTLS and Server.ServeTLSEmbed.
+//
+// It keeps the wrapped listener for HandoffListeners.
+type tlsListener struct {
+	net.Listener
+
+	ln net.Listener
+}
@@ -0,0 +1,210 @@
+package fasthttp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestServerDrain(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	drainStarted := make(chan struct{})
+	drainEnded := make(chan error, 1)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			close(started)
+			<-release
+			ctx.WriteString("ok") //nolint:errcheck
+		},
+		OnDrainStart: func() {
+			close(drainStarted)
+		},
+		OnDrainEnd: func(err error) {
+			drainEnded <- err
+		},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	go s.Serve(ln) //nolint:errcheck
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	if _, err = c.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	<-started
+	if n := s.InFlightRequests(); n != 1 {
+		t.Fatalf("unexpected number of in-flight requests %d. Expecting 1", n)
+	}
+
+	drainErr := make(chan error, 1)
+	go func() {
+		drainErr <- s.Drain(context.Background())
+	}()
+	<-drainStarted
+	close(release)
+
+	// The keep-alive client is told to close the connection.
+	var resp Response
+	if err = resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected response body %q. Expecting %q", resp.Body(), "ok")
+	}
+	if !resp.ConnectionClose() {
+		t.Fatal("expecting 'Connection: close' response header")
+	}
+
+	select {
+	case err = <-drainErr:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if err = <-drainEnded; err != nil {
+		t.Fatalf("unexpected error passed to OnDrainEnd: %v", err)
+	}
+	if n := s.InFlightRequests(); n != 0 {
+		t.Fatalf("unexpected number of in-flight requests %d. Expecting 0", n)
+	}
+}
+
+func TestServerHandoffListeners(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("handing off listeners isn't supported on Windows")
+	}
+
+	if os.Getenv(listenerFDsEnv) != "" {
+		// The process has been started by the test below. It serves requests
+		// until it is killed.
+		lns, err := InheritedListeners()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(lns) != 1 {
+			t.Fatalf("unexpected number of inherited listeners %d. Expecting 1", len(lns))
+		}
+		s := &Server{
+			Handler: func(ctx *RequestCtx) {
+				ctx.WriteString("child") //nolint:errcheck
+			},
+		}
+		s.Serve(lns[0]) //nolint:errcheck
+		return
+	}
+
+	t.Parallel()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	addr := "http://" + ln.Addr().String() + "/"
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("parent") //nolint:errcheck
+		},
+	}
+	go s.Serve(ln) //nolint:errcheck
+
+	get := func() string {
+		t.Helper()
+
+		c := &Client{}
+		statusCode, body, err := c.GetTimeout(nil, addr, 10*time.Second)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusOK)
+		}
+		return string(body)
+	}
+	if body := get(); body != "parent" {
+		t.Fatalf("unexpected response %q. Expecting %q", body, "parent")
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestServerHandoffListeners$")
+	files, err := s.HandoffListeners(cmd)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 1 || len(cmd.ExtraFiles) != 1 {
+		t.Fatalf("unexpected number of files %d. Expecting 1", len(files))
+	}
+	if env := cmd.Env[len(cmd.Env)-1]; env != listenerFDsEnv+"=3" {
+		t.Fatalf("unexpected environment variable %q. Expecting %q", env, listenerFDsEnv+"=3")
+	}
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		cmd.Process.Kill() //nolint:errcheck
+		cmd.Wait()         //nolint:errcheck
+	}()
+	for _, f := range files {
+		f.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = s.Drain(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The listening socket is still open in the child process.
+	if body := get(); body != "child" {
+		t.Fatalf("unexpected response %q. Expecting %q", body, "child")
+	}
+
+	// The listeners have been closed by Drain.
+	if _, err = s.HandoffListeners(exec.Command("true")); err != errNoListeners {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errNoListeners)
+	}
+	if lns, err := InheritedListeners(); err != nil || len(lns) != 0 {
+		t.Fatalf("unexpected inherited listeners %v, error: %v", lns, err)
+	}
+}
+
+func TestListenerFile(t *testing.T) {
+	t.Parallel()
+
+	_, err := listenerFile(fasthttputil.NewInmemoryListener())
+	if err == nil || !strings.Contains(err.Error(), "InmemoryListener") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+	wrapped := &ProxyProtocolListener{
+		Listener: &tlsListener{Listener: ln, ln: ln},
+	}
+	f, err := listenerFile(wrapped)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f.Close()
+}
@@ -0,0 +1,401 @@
+// Package fasthttpmetrics collects metrics of fasthttp.Server
+// and fasthttp.HostClient and exposes them in Prometheus text format.
+//
+// The Prometheus client library isn't required.
+//
+// Both HTTP/1.x and HTTP/2 requests are counted. The number of response
+// bytes written for HTTP/2 requests includes only response bodies.
+//
+// Usage:
+//
+//	m := fasthttpmetrics.NewMetrics(nil)
+//	s := &fasthttp.Server{
+//		Handler: handler,
+//	}
+//	m.RegisterServer(s)
+//	c := &fasthttp.Client{}
+//	m.RegisterClient(c)
+//
+//	// Serve metrics on a separate port.
+//	go fasthttp.ListenAndServe(":9100", m.RequestHandler)
+package fasthttpmetrics
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultBuckets are the default upper bounds of request duration
+// histogram buckets in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// methods contains the values of method label.
+//
+// Unknown methods are counted as OTHER, so malicious clients cannot
+// produce unlimited number of time series.
+var methods = []string{
+	fasthttp.MethodGet,
+	fasthttp.MethodHead,
+	fasthttp.MethodPost,
+	fasthttp.MethodPut,
+	fasthttp.MethodPatch,
+	fasthttp.MethodDelete,
+	fasthttp.MethodConnect,
+	fasthttp.MethodOptions,
+	fasthttp.MethodTrace,
+	"OTHER",
+}
+
+func methodIndex(method []byte) int {
+	switch string(method) {
+	case fasthttp.MethodGet:
+		return 0
+	case fasthttp.MethodHead:
+		return 1
+	case fasthttp.MethodPost:
+		return 2
+	case fasthttp.MethodPut:
+		return 3
+	case fasthttp.MethodPatch:
+		return 4
+	case fasthttp.MethodDelete:
+		return 5
+	case fasthttp.MethodConnect:
+		return 6
+	case fasthttp.MethodOptions:
+		return 7
+	case fasthttp.MethodTrace:
+		return 8
+	default:
+		return len(methods) - 1
+	}
+}
+
+// codes contains the values of code label.
+//
+// error is used for client requests failed without a response.
+var codes = []string{"1xx", "2xx", "3xx", "4xx", "5xx", "unknown", "error"}
+
+const (
+	codeUnknown = 5
+	codeError   = 6
+)
+
+func codeIndex(statusCode int) int {
+	if statusCode < 100 || statusCode > 599 {
+		return codeUnknown
+	}
+	return statusCode/100 - 1
+}
+
+// Metrics collects metrics of the registered servers and clients.
+//
+// It is safe calling Metrics methods from concurrently running goroutines.
+type Metrics struct {
+	serverSpan serverSpan
+	clientSpan clientSpan
+
+	// serverRequests and clientRequests are indexed by method and code.
+	serverRequests [][]*histogram
+	clientRequests [][]*histogram
+
+	clientConnWait *histogram
+
+	servers     []*fasthttp.Server
+	clients     []*fasthttp.Client
+	hostClients []*fasthttp.HostClient
+
+	buckets []float64
+
+	serverBytesRead    atomic.Uint64
+	serverBytesWritten atomic.Uint64
+
+	connStateTransitions [len(stateNames)]atomic.Uint64
+
+	mu sync.Mutex
+}
+
+// stateNames contains the values of state label.
+var stateNames = [...]string{
+	fasthttp.StateNew:      "new",
+	fasthttp.StateActive:   "active",
+	fasthttp.StateIdle:     "idle",
+	fasthttp.StateHijacked: "hijacked",
+	fasthttp.StateClosed:   "closed",
+}
+
+// NewMetrics returns new metrics with the given upper bounds of request
+// duration histogram buckets in seconds.
+//
+// DefaultBuckets are used if buckets is empty. buckets must be sorted
+// in ascending order.
+func NewMetrics(buckets []float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	m := &Metrics{
+		buckets: append([]float64(nil), buckets...),
+	}
+	m.serverSpan.m = m
+	m.clientSpan.m = m
+	m.serverRequests = m.newHistograms()
+	m.clientRequests = m.newHistograms()
+	m.clientConnWait = newHistogram(m.buckets)
+	return m
+}
+
+func (m *Metrics) newHistograms() [][]*histogram {
+	hs := make([][]*histogram, len(methods))
+	for i := range hs {
+		hs[i] = make([]*histogram, len(codes))
+		for j := range hs[i] {
+			hs[i][j] = newHistogram(m.buckets)
+		}
+	}
+	return hs
+}
+
+// RegisterServer makes m collecting metrics of s.
+//
+// The following metrics are collected:
+//
+//   - request duration histograms by method and status code class,
+//     whose _count series are request counters;
+//   - the number of request bytes read and response bytes written,
+//     see fasthttp.ServerTrace.BytesWritten;
+//   - the number of connection state transitions by the new state;
+//   - the number of open connections, in-flight requests
+//     and rejected connections;
+//   - the number of busy workers and the maximum number of workers,
+//     i.e. worker pool utilisation.
+//
+// RegisterServer sets s.Tracer and s.ConnState, so it must be called
+// before serving. The previously set s.Tracer and s.ConnState
+// are called as usual.
+func (m *Metrics) RegisterServer(s *fasthttp.Server) {
+	s.Tracer = &serverTracer{
+		m:    m,
+		next: s.Tracer,
+	}
+	connState := s.ConnState
+	s.ConnState = func(c net.Conn, state fasthttp.ConnState) {
+		m.ConnState(c, state)
+		if connState != nil {
+			connState(c, state)
+		}
+	}
+
+	m.mu.Lock()
+	m.servers = append(m.servers, s)
+	m.mu.Unlock()
+}
+
+// ConnState counts the connection state transition.
+//
+// It may be used as fasthttp.Server.ConnState for servers,
+// which aren't registered via RegisterServer.
+func (m *Metrics) ConnState(_ net.Conn, state fasthttp.ConnState) {
+	if int(state) >= 0 && int(state) < len(m.connStateTransitions) {
+		m.connStateTransitions[state].Add(1)
+	}
+}
+
+// RegisterClient makes m collecting metrics of c.
+//
+// The following metrics are collected:
+//
+//   - request duration histograms by method and status code class,
+//     whose _count series are request counters;
+//   - connection pool wait duration histogram;
+//   - the number of connections.
+//
+// RegisterClient sets c.Tracer, so it must be called before sending
+// requests. The previously set c.Tracer is called as usual.
+func (m *Metrics) RegisterClient(c *fasthttp.Client) {
+	c.Tracer = &clientTracer{
+		m:    m,
+		next: c.Tracer,
+	}
+
+	m.mu.Lock()
+	m.clients = append(m.clients, c)
+	m.mu.Unlock()
+}
+
+// RegisterHostClient makes m collecting metrics of c.
+//
+// The same metrics as for RegisterClient are collected
+// plus the number of pending requests.
+//
+// RegisterHostClient sets c.Tracer, so it must be called before sending
+// requests. The previously set c.Tracer is called as usual.
+func (m *Metrics) RegisterHostClient(c *fasthttp.HostClient) {
+	c.Tracer = &clientTracer{
+		m:    m,
+		next: c.Tracer,
+	}
+
+	m.mu.Lock()
+	m.hostClients = append(m.hostClients, c)
+	m.mu.Unlock()
+}
+
+func (m *Metrics) observeServerRequest(ctx *fasthttp.RequestCtx, trace *fasthttp.ServerTrace) {
+	h := m.serverRequests[methodIndex(ctx.Method())][codeIndex(ctx.Response.StatusCode())]
+	h.observe(time.Since(trace.Start))
+
+	m.serverBytesRead.Add(uint64(requestSize(&ctx.Request))) // #nosec G115
+	if trace.BytesWritten > 0 {
+		m.serverBytesWritten.Add(uint64(trace.BytesWritten))
+	}
+}
+
+// requestSize returns the approximate size of the request read by the server.
+//
+// The request line and headers are counted as they were received.
+// Chunked request bodies are counted without chunk headers. Streamed
+// request bodies without Content-Length aren't counted.
+func requestSize(req *fasthttp.Request) int {
+	h := &req.Header
+	// Raw headers include the empty line after headers.
+	n := len(h.Method()) + 1 + len(h.RequestURI()) + 1 + len(h.Protocol()) + 2
+	n += len(h.RawHeaders())
+	if cl := h.ContentLength(); cl > 0 {
+		n += cl
+	} else if !req.IsBodyStream() {
+		n += len(req.Body())
+	}
+	return n
+}
+
+func (m *Metrics) observeClientRequest(req *fasthttp.Request, resp *fasthttp.Response,
+	trace *fasthttp.ClientTrace, err error,
+) {
+	code := codeError
+	if err == nil {
+		code = codeIndex(resp.StatusCode())
+		m.clientConnWait.observe(trace.ConnWait)
+	}
+	h := m.clientRequests[methodIndex(req.Header.Method())][code]
+	h.observe(time.Since(trace.Start))
+}
+
+// serverTracer passes requests to Metrics and the next tracer if set.
+type serverTracer struct {
+	m    *Metrics
+	next fasthttp.ServerTracer
+}
+
+var serverSpanPool sync.Pool
+
+func (t *serverTracer) StartServerSpan(ctx *fasthttp.RequestCtx) fasthttp.ServerSpan {
+	if t.next == nil {
+		// The shared span is stateless, so it doesn't allocate memory.
+		return &t.m.serverSpan
+	}
+	next := t.next.StartServerSpan(ctx)
+	if next == nil {
+		return &t.m.serverSpan
+	}
+	v := serverSpanPool.Get()
+	if v == nil {
+		v = &serverSpan{}
+	}
+	s := v.(*serverSpan) //nolint:forcetypeassert
+	s.m = t.m
+	s.next = next
+	return s
+}
+
+type serverSpan struct {
+	m    *Metrics
+	next fasthttp.ServerSpan
+}
+
+func (s *serverSpan) End(ctx *fasthttp.RequestCtx, trace *fasthttp.ServerTrace) {
+	s.m.observeServerRequest(ctx, trace)
+	if s.next != nil {
+		s.next.End(ctx, trace)
+		s.m = nil
+		s.next = nil
+		serverSpanPool.Put(s)
+	}
+}
+
+// clientTracer passes requests to Metrics and the next tracer if set.
+type clientTracer struct {
+	m    *Metrics
+	next fasthttp.ClientTracer
+}
+
+var clientSpanPool sync.Pool
+
+func (t *clientTracer) StartClientSpan(req *fasthttp.Request) fasthttp.ClientSpan {
+	if t.next == nil {
+		return &t.m.clientSpan
+	}
+	next := t.next.StartClientSpan(req)
+	if next == nil {
+		return &t.m.clientSpan
+	}
+	v := clientSpanPool.Get()
+	if v == nil {
+		v = &clientSpan{}
+	}
+	s := v.(*clientSpan) //nolint:forcetypeassert
+	s.m = t.m
+	s.next = next
+	return s
+}
+
+type clientSpan struct {
+	m    *Metrics
+	next fasthttp.ClientSpan
+}
+
+func (s *clientSpan) End(req *fasthttp.Request, resp *fasthttp.Response, trace *fasthttp.ClientTrace, err error) {
+	s.m.observeClientRequest(req, resp, trace, err)
+	if s.next != nil {
+		s.next.End(req, resp, trace, err)
+		s.m = nil
+		s.next = nil
+		clientSpanPool.Put(s)
+	}
+}
+
+// histogram is a cumulative histogram of durations.
+type histogram struct {
+	// bounds are upper bounds of buckets.
+	bounds []time.Duration
+
+	// counts contains the number of observations per bucket
+	// with the last bucket for observations exceeding all the bounds.
+	counts []atomic.Uint64
+
+	// sum is the sum of observations in nanoseconds.
+	sum atomic.Int64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	h := &histogram{
+		bounds: make([]time.Duration, len(buckets)),
+		counts: make([]atomic.Uint64, len(buckets)+1),
+	}
+	for i, b := range buckets {
+		h.bounds[i] = time.Duration(b * float64(time.Second))
+	}
+	return h
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
@@ -0,0 +1,266 @@
+package fasthttpmetrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"golang.org/x/net/http2"
+)
+
+func TestMetricsServer(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics(nil)
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			if string(ctx.Path()) == "/missing" {
+				ctx.SetStatusCode(fasthttp.StatusNotFound)
+			}
+			ctx.WriteString("ok") //nolint:errcheck
+		},
+	}
+	var connStates atomic.Int32
+	s.ConnState = func(net.Conn, fasthttp.ConnState) {
+		connStates.Add(1)
+	}
+	m.RegisterServer(s)
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	requests := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+		"FOO /missing HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+		"POST / HTTP/1.1\r\nHost: example.com\r\nContent-Length: 3\r\nConnection: close\r\n\r\nabc"
+	if _, err = c.Write([]byte(requests)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	responses, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Wait until the connection is closed by the server:
+	// new, 3 x (active, idle) with the last idle replaced by closed.
+	for connStates.Load() < 7 {
+		time.Sleep(time.Millisecond)
+	}
+
+	body := string(m.appendMetrics(nil, false))
+	for _, line := range []string{
+		`# TYPE fasthttp_server_request_duration_seconds histogram`,
+		`fasthttp_server_request_duration_seconds_count{method="GET",code="2xx"} 1`,
+		`fasthttp_server_request_duration_seconds_count{method="POST",code="2xx"} 1`,
+		`fasthttp_server_request_duration_seconds_count{method="OTHER",code="4xx"} 1`,
+		`fasthttp_server_request_duration_seconds_bucket{method="GET",code="2xx",le="+Inf"} 1`,
+		`fasthttp_server_read_bytes_total ` + strconv.Itoa(len(requests)),
+		`fasthttp_server_written_bytes_total ` + strconv.Itoa(len(responses)),
+		`fasthttp_server_conn_state_transitions_total{state="new"} 1`,
+		`fasthttp_server_conn_state_transitions_total{state="active"} 3`,
+		`fasthttp_server_conn_state_transitions_total{state="idle"} 2`,
+		`fasthttp_server_conn_state_transitions_total{state="closed"} 1`,
+		`fasthttp_server_open_connections 0`,
+		`fasthttp_server_in_flight_requests 0`,
+		`fasthttp_server_max_workers ` + strconv.Itoa(fasthttp.DefaultConcurrency),
+		`fasthttp_server_rejected_connections_total 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in metrics:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `method="HEAD"`) {
+		t.Fatalf("unexpected histogram without observations in metrics:\n%s", body)
+	}
+}
+
+func TestMetricsServerHTTP2(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics(nil)
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString("ok") //nolint:errcheck
+		},
+	}
+	if err := fasthttp.ConfigureHTTP2Server(s, &fasthttp.HTTP2Config{H2C: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.RegisterServer(s)
+
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	tr := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(context.Context, string, string, *tls.Config) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	c := &http.Client{Transport: tr}
+	resp, err := c.Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	resp.Body.Close()
+	tr.CloseIdleConnections()
+
+	// The request is counted after the response is sent to the client.
+	deadline := time.Now().Add(5 * time.Second)
+	for s.InFlightRequests() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	body := string(m.appendMetrics(nil, false))
+	for _, line := range []string{
+		`fasthttp_server_request_duration_seconds_count{method="GET",code="2xx"} 1`,
+		`fasthttp_server_written_bytes_total 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in metrics:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsClient(t *testing.T) {
+	t.Parallel()
+
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.WriteString("ok") //nolint:errcheck
+		},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	m := NewMetrics([]float64{0.5, 1})
+	var traced atomic.Int32
+	hc := &fasthttp.HostClient{
+		Addr:   "example.com",
+		Dial:   func(string) (net.Conn, error) { return ln.Dial() },
+		Tracer: testClientTracer{traced: &traced},
+	}
+	m.RegisterHostClient(hc)
+	c := &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) { return nil, io.ErrUnexpectedEOF },
+	}
+	m.RegisterClient(c)
+
+	for range 2 {
+		if _, _, err := hc.Get(nil, "http://example.com/"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, _, err := c.Get(nil, "http://example.com/"); err == nil {
+		t.Fatal("expecting error")
+	}
+	if n := traced.Load(); n != 2 {
+		t.Fatalf("unexpected number of requests passed to the previously set tracer %d. Expecting 2", n)
+	}
+
+	body := string(m.appendMetrics(nil, false))
+	for _, line := range []string{
+		`fasthttp_client_request_duration_seconds_bucket{method="GET",code="2xx",le="0.5"} 2`,
+		`fasthttp_client_request_duration_seconds_bucket{method="GET",code="2xx",le="1"} 2`,
+		`fasthttp_client_request_duration_seconds_bucket{method="GET",code="2xx",le="+Inf"} 2`,
+		`fasthttp_client_request_duration_seconds_count{method="GET",code="error"} 1`,
+		`fasthttp_client_conn_wait_duration_seconds_count 2`,
+		`fasthttp_client_connections 1`,
+		`fasthttp_client_pending_requests 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in metrics:\n%s", line, body)
+		}
+	}
+}
+
+type testClientTracer struct {
+	traced *atomic.Int32
+}
+
+func (t testClientTracer) StartClientSpan(*fasthttp.Request) fasthttp.ClientSpan {
+	return t
+}
+
+func (t testClientTracer) End(*fasthttp.Request, *fasthttp.Response, *fasthttp.ClientTrace, error) {
+	t.traced.Add(1)
+}
+
+func TestMetricsRequestHandler(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics(nil)
+	m.ConnState(nil, fasthttp.StateNew)
+
+	var ctx fasthttp.RequestCtx
+	m.RequestHandler(&ctx)
+	if ct := string(ctx.Response.Header.ContentType()); ct != contentTypePrometheus {
+		t.Fatalf("unexpected content type %q. Expecting %q", ct, contentTypePrometheus)
+	}
+	body := string(ctx.Response.Body())
+	if !strings.Contains(body, "# TYPE fasthttp_server_read_bytes_total counter\n") {
+		t.Fatalf("missing counter type in metrics:\n%s", body)
+	}
+	if !strings.Contains(body, `fasthttp_server_conn_state_transitions_total{state="new"} 1`+"\n") {
+		t.Fatalf("missing connection state transitions in metrics:\n%s", body)
+	}
+	var buf bytes.Buffer
+	if err := m.WritePrometheus(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != body {
+		t.Fatalf("unexpected metrics written:\n%s\nExpecting:\n%s", buf.String(), body)
+	}
+
+	ctx.Request.Reset()
+	ctx.Response.Reset()
+	ctx.Request.Header.Set(fasthttp.HeaderAccept, "application/openmetrics-text;version=1.0.0,text/plain;q=0.5")
+	m.RequestHandler(&ctx)
+	if ct := string(ctx.Response.Header.ContentType()); ct != contentTypeOpenMetrics {
+		t.Fatalf("unexpected content type %q. Expecting %q", ct, contentTypeOpenMetrics)
+	}
+	body = string(ctx.Response.Body())
+	if !strings.Contains(body, "# TYPE fasthttp_server_read_bytes counter\nfasthttp_server_read_bytes_total 0\n") {
+		t.Fatalf("unexpected counter in metrics:\n%s", body)
+	}
+	if !strings.HasSuffix(body, "\n# EOF\n") {
+		t.Fatalf("missing EOF in metrics:\n%s", body)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+
+	h := newHistogram([]float64{0.1, 1})
+	for _, d := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second} {
+		h.observe(d)
+	}
+	w := &metricsWriter{}
+	w.histogramSamples("foo", `a="b"`, h, false)
+	expected := `foo_bucket{a="b",le="0.1"} 2
+foo_bucket{a="b",le="1"} 3
+foo_bucket{a="b",le="+Inf"} 4
+foo_sum{a="b"} 2.65
+foo_count{a="b"} 4
+`
+	if string(w.b) != expected {
+		t.Fatalf("unexpected histogram:\n%s\nExpecting:\n%s", w.b, expected)
+	}
+}
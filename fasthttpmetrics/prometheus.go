@@ -0,0 +1,205 @@
+package fasthttpmetrics
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	contentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	contentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+var strOpenMetrics = []byte("application/openmetrics-text")
+
+// RequestHandler serves the collected metrics.
+//
+// Metrics are rendered in OpenMetrics text format if the client accepts it
+// according to Accept request header, otherwise Prometheus text format is used.
+func (m *Metrics) RequestHandler(ctx *fasthttp.RequestCtx) {
+	openMetrics := bytes.Contains(ctx.Request.Header.Peek(fasthttp.HeaderAccept), strOpenMetrics)
+	if openMetrics {
+		ctx.SetContentType(contentTypeOpenMetrics)
+	} else {
+		ctx.SetContentType(contentTypePrometheus)
+	}
+	ctx.SetBody(m.appendMetrics(nil, openMetrics))
+}
+
+// WritePrometheus writes the collected metrics to w in Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	_, err := w.Write(m.appendMetrics(nil, false))
+	return err
+}
+
+func (m *Metrics) appendMetrics(dst []byte, openMetrics bool) []byte {
+	w := &metricsWriter{
+		b:           dst,
+		openMetrics: openMetrics,
+	}
+
+	w.histograms("fasthttp_server_request_duration_seconds",
+		"Duration of requests served by the server.", m.serverRequests)
+	w.counter("fasthttp_server_read_bytes_total",
+		"Approximate number of request bytes read by the server.", m.serverBytesRead.Load())
+	w.counter("fasthttp_server_written_bytes_total",
+		"Number of response bytes written by the server, only bodies for HTTP/2.", m.serverBytesWritten.Load())
+
+	w.family("fasthttp_server_conn_state_transitions_total", "counter",
+		"Number of connection state transitions by the new state.")
+	for i, name := range stateNames {
+		w.sample("fasthttp_server_conn_state_transitions_total", `state="`+name+`"`,
+			float64(m.connStateTransitions[i].Load()))
+	}
+
+	m.mu.Lock()
+	var open, inFlight, busy, maxWorkers, rejected float64
+	for _, s := range m.servers {
+		open += float64(max(s.GetOpenConnectionsCount(), 0))
+		inFlight += float64(s.InFlightRequests())
+		busy += float64(s.GetCurrentConcurrency())
+		if s.Concurrency > 0 {
+			maxWorkers += float64(s.Concurrency)
+		} else {
+			maxWorkers += fasthttp.DefaultConcurrency
+		}
+		rejected += float64(s.GetRejectedConnectionsCount())
+	}
+	var conns, pending float64
+	for _, c := range m.clients {
+		conns += float64(c.ConnsCount())
+	}
+	for _, c := range m.hostClients {
+		conns += float64(c.ConnsCount())
+		pending += float64(c.PendingRequests())
+	}
+	m.mu.Unlock()
+
+	w.gauge("fasthttp_server_open_connections", "Number of open connections.", open)
+	w.gauge("fasthttp_server_in_flight_requests", "Number of requests being served.", inFlight)
+	w.gauge("fasthttp_server_busy_workers", "Number of workers serving connections.", busy)
+	w.gauge("fasthttp_server_max_workers", "Maximum number of workers, see Server.Concurrency.", maxWorkers)
+	w.counter("fasthttp_server_rejected_connections_total",
+		"Number of connections rejected because of the concurrency limits.", uint64(rejected))
+
+	w.histograms("fasthttp_client_request_duration_seconds",
+		"Duration of requests sent by the client including retries.", m.clientRequests)
+	w.histogram("fasthttp_client_conn_wait_duration_seconds",
+		"Duration of waiting for a free connection in the client connection pool.", "", m.clientConnWait)
+	w.gauge("fasthttp_client_connections", "Number of client connections.", conns)
+	w.gauge("fasthttp_client_pending_requests", "Number of requests being sent by host clients.", pending)
+
+	if openMetrics {
+		w.b = append(w.b, "# EOF\n"...)
+	}
+	return w.b
+}
+
+// metricsWriter appends metrics in Prometheus or OpenMetrics text format.
+type metricsWriter struct {
+	b           []byte
+	openMetrics bool
+}
+
+// family appends HELP and TYPE lines for the given metric family.
+func (w *metricsWriter) family(name, typ, help string) {
+	if w.openMetrics && typ == "counter" {
+		// OpenMetrics counter family names have no _total suffix.
+		name = name[:len(name)-len("_total")]
+	}
+	w.b = append(w.b, "# HELP "...)
+	w.b = append(w.b, name...)
+	w.b = append(w.b, ' ')
+	w.b = append(w.b, help...)
+	w.b = append(w.b, "\n# TYPE "...)
+	w.b = append(w.b, name...)
+	w.b = append(w.b, ' ')
+	w.b = append(w.b, typ...)
+	w.b = append(w.b, '\n')
+}
+
+func (w *metricsWriter) sample(name, labels string, v float64) {
+	w.b = append(w.b, name...)
+	if labels != "" {
+		w.b = append(w.b, '{')
+		w.b = append(w.b, labels...)
+		w.b = append(w.b, '}')
+	}
+	w.b = append(w.b, ' ')
+	w.b = appendFloat(w.b, v)
+	w.b = append(w.b, '\n')
+}
+
+func (w *metricsWriter) counter(name, help string, v uint64) {
+	w.family(name, "counter", help)
+	w.sample(name, "", float64(v))
+}
+
+func (w *metricsWriter) gauge(name, help string, v float64) {
+	w.family(name, "gauge", help)
+	w.sample(name, "", v)
+}
+
+// histograms appends histograms indexed by method and code.
+//
+// Histograms without observations are skipped.
+func (w *metricsWriter) histograms(name, help string, hs [][]*histogram) {
+	w.family(name, "histogram", help)
+	for i, method := range methods {
+		for j, code := range codes {
+			w.histogramSamples(name, `method="`+method+`",code="`+code+`"`, hs[i][j], true)
+		}
+	}
+}
+
+func (w *metricsWriter) histogram(name, help, labels string, h *histogram) {
+	w.family(name, "histogram", help)
+	w.histogramSamples(name, labels, h, false)
+}
+
+func (w *metricsWriter) histogramSamples(name, labels string, h *histogram, skipEmpty bool) {
+	// Load counts before sum, so the sum corresponds to at least
+	// the loaded number of observations.
+	counts := make([]uint64, len(h.counts))
+	var count uint64
+	for i := range h.counts {
+		counts[i] = h.counts[i].Load()
+		count += counts[i]
+	}
+	if skipEmpty && count == 0 {
+		return
+	}
+	sum := time.Duration(h.sum.Load()).Seconds()
+
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, n := range counts {
+		cumulative += n
+		le := math.Inf(1)
+		if i < len(h.bounds) {
+			le = h.bounds[i].Seconds()
+		}
+		w.sample(name+"_bucket", labels+sep+`le="`+string(appendFloat(nil, le))+`"`, float64(cumulative))
+	}
+	w.sample(name+"_sum", labels, sum)
+	w.sample(name+"_count", labels, float64(count))
+}
+
+func appendFloat(dst []byte, v float64) []byte {
+	switch {
+	case math.IsInf(v, 1):
+		return append(dst, "+Inf"...)
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		return strconv.AppendInt(dst, int64(v), 10)
+	default:
+		return strconv.AppendFloat(dst, v, 'g', -1, 64)
+	}
+}
@@ -1,2 +1,3 @@
-// Package fasthttpproxy provides SOCKS5 and HTTP proxy support for fasthttp.
+// Package fasthttpproxy provides SOCKS5 and HTTP proxy support for fasthttp
+// clients and a reverse proxy handler for fasthttp servers.
 package fasthttpproxy
@@ -0,0 +1,286 @@
+package fasthttpproxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// UpstreamClient sends requests to upstream servers.
+//
+// fasthttp.HostClient, fasthttp.LBClient and fasthttp.Client implement it.
+type UpstreamClient interface {
+	Do(req *fasthttp.Request, resp *fasthttp.Response) error
+	DoTimeout(req *fasthttp.Request, resp *fasthttp.Response, timeout time.Duration) error
+}
+
+// ReverseProxy is a request handler forwarding incoming requests
+// to upstream servers via Client.
+//
+// The upstream request is a copy of the incoming request with hop-by-hop
+// headers removed and X-Forwarded-For, X-Forwarded-Host, X-Forwarded-Proto
+// and Forwarded headers set. The upstream response is sent back to the client
+// with hop-by-hop headers removed.
+//
+// Request bodies are streamed to upstream servers if Server.StreamRequestBody
+// is set, while response bodies are streamed to clients if
+// HostClient.StreamResponseBody (or Client.StreamResponseBody) is set.
+//
+// Upstream errors are converted to 502 Bad Gateway responses,
+// while upstream timeouts are converted to 504 Gateway Timeout responses.
+//
+// Usage:
+//
+//	p := &fasthttpproxy.ReverseProxy{
+//		Client: &fasthttp.HostClient{
+//			Addr: "upstream:8080",
+//		},
+//	}
+//	fasthttp.ListenAndServe(":80", p.NewRequestHandler())
+//
+// It is safe calling ReverseProxy methods from concurrently running goroutines.
+type ReverseProxy struct {
+	// Client sends requests to upstream servers.
+	//
+	// The upstream request has the same Host header and request uri
+	// as the incoming request, so fasthttp.HostClient or fasthttp.LBClient
+	// must be used unless Rewrite sets the upstream uri.
+	Client UpstreamClient
+
+	// Rewrite is called before sending the upstream request.
+	//
+	// It may modify req, e.g. change the request uri via req.URI()
+	// or add authorization headers. req mustn't be retained after returning
+	// from Rewrite.
+	//
+	// The upstream request uri has https scheme if Client is
+	// fasthttp.HostClient with IsTLS set and http scheme otherwise.
+	Rewrite func(ctx *fasthttp.RequestCtx, req *fasthttp.Request)
+
+	// ModifyResponse is called before sending the upstream response
+	// to the client.
+	//
+	// ErrorHandler is called if ModifyResponse returns non-nil error.
+	ModifyResponse func(ctx *fasthttp.RequestCtx, resp *fasthttp.Response) error
+
+	// ErrorHandler is called on errors returned by Client or ModifyResponse.
+	//
+	// By default 504 Gateway Timeout is returned on timeouts
+	// and 502 Bad Gateway on other errors.
+	ErrorHandler func(ctx *fasthttp.RequestCtx, err error)
+
+	// Timeout is the maximum duration for waiting the upstream response.
+	//
+	// Client timeouts are used by default.
+	Timeout time.Duration
+}
+
+// NewReverseProxyHandler returns a request handler forwarding
+// incoming requests to upstream servers via the given client.
+//
+// See ReverseProxy for details.
+func NewReverseProxyHandler(client UpstreamClient) fasthttp.RequestHandler {
+	p := &ReverseProxy{
+		Client: client,
+	}
+	return p.NewRequestHandler()
+}
+
+// NewRequestHandler returns a request handler forwarding
+// incoming requests to upstream servers.
+func (p *ReverseProxy) NewRequestHandler() fasthttp.RequestHandler {
+	if p.Client == nil {
+		panic("BUG: ReverseProxy.Client must be set")
+	}
+	return p.handleRequest
+}
+
+// hopHeaders are removed from requests and responses passing the proxy.
+//
+// See https://www.rfc-editor.org/rfc/rfc9110#section-7.6.1 .
+var hopHeaders = []string{
+	fasthttp.HeaderConnection,
+	fasthttp.HeaderProxyConnection,
+	fasthttp.HeaderKeepAlive,
+	fasthttp.HeaderProxyAuthenticate,
+	fasthttp.HeaderProxyAuthorization,
+	fasthttp.HeaderTE,
+	fasthttp.HeaderTrailer,
+	fasthttp.HeaderTransferEncoding,
+	fasthttp.HeaderUpgrade,
+}
+
+func (p *ReverseProxy) handleRequest(ctx *fasthttp.RequestCtx) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	p.prepareRequest(ctx, req)
+	if p.Rewrite != nil {
+		p.Rewrite(ctx, req)
+	}
+
+	// The upstream response is read directly into ctx.Response,
+	// so its body stream is closed by the server after sending it.
+	resp := &ctx.Response
+	var err error
+	if p.Timeout > 0 {
+		err = p.Client.DoTimeout(req, resp, p.Timeout)
+	} else {
+		err = p.Client.Do(req, resp)
+	}
+	if err == nil {
+		removeHopHeaders(&resp.Header)
+		if p.ModifyResponse != nil {
+			err = p.ModifyResponse(ctx, resp)
+		}
+	}
+	if err != nil {
+		resp.CloseBodyStream() //nolint:errcheck
+		resp.Reset()
+		p.errorHandler()(ctx, err)
+	}
+}
+
+func (p *ReverseProxy) errorHandler() func(ctx *fasthttp.RequestCtx, err error) {
+	if p.ErrorHandler == nil {
+		return defaultProxyErrorHandler
+	}
+	return p.ErrorHandler
+}
+
+func defaultProxyErrorHandler(ctx *fasthttp.RequestCtx, err error) {
+	statusCode := fasthttp.StatusBadGateway
+	if isTimeoutError(err) {
+		statusCode = fasthttp.StatusGatewayTimeout
+	}
+	ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+}
+
+func isTimeoutError(err error) bool {
+	if errors.Is(err, fasthttp.ErrTimeout) ||
+		errors.Is(err, fasthttp.ErrDialTimeout) ||
+		errors.Is(err, fasthttp.ErrTLSHandshakeTimeout) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// prepareRequest fills req from the incoming request.
+func (p *ReverseProxy) prepareRequest(ctx *fasthttp.RequestCtx, req *fasthttp.Request) {
+	ctx.Request.Header.CopyTo(&req.Header)
+	removeHopHeaders(&req.Header)
+
+	scheme := "http"
+	if hc, ok := p.Client.(*fasthttp.HostClient); ok && hc.IsTLS {
+		scheme = "https"
+	}
+	req.URI().SetScheme(scheme)
+
+	if bodyStream := ctx.RequestBodyStream(); bodyStream != nil {
+		// The stream is owned by the incoming request, so it is wrapped
+		// for preventing its release by req.
+		req.SetBodyStream(struct{ io.Reader }{bodyStream}, ctx.Request.Header.ContentLength())
+	} else {
+		req.SetBodyRaw(ctx.Request.Body())
+	}
+
+	setForwardedHeaders(ctx, &req.Header)
+}
+
+// hopHeaderer is implemented by fasthttp.RequestHeader and fasthttp.ResponseHeader.
+type hopHeaderer interface {
+	PeekAll(key string) [][]byte
+	Del(key string)
+}
+
+func removeHopHeaders(h hopHeaderer) {
+	// Headers listed in the Connection header are hop-by-hop too.
+	var keys []string
+	for _, v := range h.PeekAll(fasthttp.HeaderConnection) {
+		for _, key := range strings.Split(string(v), ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+	}
+	for _, key := range keys {
+		h.Del(key)
+	}
+	for _, key := range hopHeaders {
+		h.Del(key)
+	}
+}
+
+// setForwardedHeaders appends the client address to X-Forwarded-For
+// and Forwarded headers and sets X-Forwarded-Host and X-Forwarded-Proto headers.
+//
+// See https://www.rfc-editor.org/rfc/rfc7239 .
+func setForwardedHeaders(ctx *fasthttp.RequestCtx, h *fasthttp.RequestHeader) {
+	ip := ctx.RemoteIP()
+	proto := "http"
+	if ctx.IsTLS() {
+		proto = "https"
+	}
+	host := ctx.Host()
+
+	xff := bytes.Join(h.PeekAll(fasthttp.HeaderXForwardedFor), []byte(", "))
+	if len(xff) > 0 {
+		xff = append(xff, ", "...)
+	}
+	xff = append(xff, ip.String()...)
+	h.SetBytesV(fasthttp.HeaderXForwardedFor, xff)
+	h.SetBytesV(fasthttp.HeaderXForwardedHost, host)
+	h.Set(fasthttp.HeaderXForwardedProto, proto)
+
+	forwarded := bytes.Join(h.PeekAll(fasthttp.HeaderForwarded), []byte(", "))
+	if len(forwarded) > 0 {
+		forwarded = append(forwarded, ", "...)
+	}
+	forwarded = append(forwarded, "for="...)
+	if ip.To4() == nil {
+		// IPv6 addresses must be quoted and enclosed in brackets.
+		forwarded = append(forwarded, `"[`...)
+		forwarded = append(forwarded, ip.String()...)
+		forwarded = append(forwarded, `]"`...)
+	} else {
+		forwarded = append(forwarded, ip.String()...)
+	}
+	if len(host) > 0 {
+		forwarded = append(forwarded, ";host="...)
+		forwarded = appendForwardedValue(forwarded, host)
+	}
+	forwarded = append(forwarded, ";proto="...)
+	forwarded = append(forwarded, proto...)
+	h.SetBytesV(fasthttp.HeaderForwarded, forwarded)
+}
+
+// appendForwardedValue appends v to dst, quoting it if it isn't a token.
+func appendForwardedValue(dst, v []byte) []byte {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			dst = append(dst, '"')
+			for _, c := range v {
+				if c == '"' || c == '\\' {
+					dst = append(dst, '\\')
+				}
+				dst = append(dst, c)
+			}
+			return append(dst, '"')
+		}
+	}
+	return append(dst, v...)
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,222 @@
+package fasthttpproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// startReverseProxy starts the proxy forwarding requests to p.Client.
+// If p.Client isn't set, then the upstream server is started and p.Client
+// is set to the client sending requests to it.
+//
+// The returned client sends requests to the proxy from 10.0.0.1.
+func startReverseProxy(t *testing.T, upstream *fasthttp.Server, p *ReverseProxy, proxy *fasthttp.Server) *fasthttp.HostClient {
+	t.Helper()
+
+	if p.Client == nil {
+		upstreamLn := fasthttputil.NewInmemoryListener()
+		t.Cleanup(func() { upstreamLn.Close() })
+		go upstream.Serve(upstreamLn) //nolint:errcheck
+
+		p.Client = &fasthttp.HostClient{
+			Addr: "upstream",
+			Dial: func(string) (net.Conn, error) { return upstreamLn.Dial() },
+		}
+	}
+	proxy.Handler = p.NewRequestHandler()
+	proxyLn := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() { proxyLn.Close() })
+	go proxy.Serve(proxyLn) //nolint:errcheck
+
+	return &fasthttp.HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) {
+			return proxyLn.DialWithLocalAddr(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234})
+		},
+	}
+}
+
+func TestReverseProxy(t *testing.T) {
+	t.Parallel()
+
+	upstream := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			h := &ctx.Request.Header
+			for _, key := range []string{
+				"X-Hop", fasthttp.HeaderKeepAlive, fasthttp.HeaderProxyAuthorization, "X-Foo", "X-Rewrite",
+				fasthttp.HeaderXForwardedFor, fasthttp.HeaderXForwardedHost, fasthttp.HeaderXForwardedProto,
+				fasthttp.HeaderForwarded,
+			} {
+				fmt.Fprintf(ctx, "%s=%s\n", key, h.Peek(key))
+			}
+			fmt.Fprintf(ctx, "uri=%s host=%s body=%s", h.RequestURI(), h.Host(), ctx.PostBody())
+			ctx.Response.Header.Set(fasthttp.HeaderConnection, "X-Secret")
+			ctx.Response.Header.Set("X-Secret", "1")
+			ctx.Response.Header.Set("X-Bar", "baz")
+		},
+	}
+	p := &ReverseProxy{
+		Rewrite: func(_ *fasthttp.RequestCtx, req *fasthttp.Request) {
+			req.Header.Set("X-Rewrite", "yes")
+		},
+		ModifyResponse: func(_ *fasthttp.RequestCtx, resp *fasthttp.Response) error {
+			resp.Header.Set("X-Modified", "yes")
+			return nil
+		},
+	}
+	c := startReverseProxy(t, upstream, p, &fasthttp.Server{})
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI("http://example.com/foo?bar=baz")
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.Header.Set(fasthttp.HeaderConnection, "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set(fasthttp.HeaderKeepAlive, "timeout=5")
+	req.Header.Set(fasthttp.HeaderProxyAuthorization, "Basic Zm9vOmJhcg==")
+	req.Header.Set("X-Foo", "foo")
+	req.Header.Set(fasthttp.HeaderXForwardedFor, "1.2.3.4")
+	req.Header.Set(fasthttp.HeaderForwarded, "for=1.2.3.4")
+	req.SetBodyString("hello")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), fasthttp.StatusOK)
+	}
+
+	expectedBody := "X-Hop=\n" +
+		"Keep-Alive=\n" +
+		"Proxy-Authorization=\n" +
+		"X-Foo=foo\n" +
+		"X-Rewrite=yes\n" +
+		"X-Forwarded-For=1.2.3.4, 10.0.0.1\n" +
+		"X-Forwarded-Host=example.com\n" +
+		"X-Forwarded-Proto=http\n" +
+		"Forwarded=for=1.2.3.4, for=10.0.0.1;host=example.com;proto=http\n" +
+		"uri=/foo?bar=baz host=example.com body=hello"
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	for key, expected := range map[string]string{
+		"X-Secret":   "",
+		"X-Bar":      "baz",
+		"X-Modified": "yes",
+	} {
+		if v := string(resp.Header.Peek(key)); v != expected {
+			t.Fatalf("unexpected %s header %q. Expecting %q", key, v, expected)
+		}
+	}
+	if resp.ConnectionClose() {
+		t.Fatal("the client connection mustn't be closed")
+	}
+}
+
+func TestReverseProxyErrors(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	defer close(release)
+	upstream := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			if string(ctx.Path()) == "/slow" {
+				<-release
+			}
+		},
+	}
+	p := &ReverseProxy{
+		Timeout: 50 * time.Millisecond,
+		ModifyResponse: func(ctx *fasthttp.RequestCtx, _ *fasthttp.Response) error {
+			if string(ctx.Path()) == "/modify-error" {
+				return errors.New("modify error")
+			}
+			return nil
+		},
+	}
+	c := startReverseProxy(t, upstream, p, &fasthttp.Server{})
+
+	testCases := []struct {
+		uri        string
+		statusCode int
+	}{
+		{"http://example.com/", fasthttp.StatusOK},
+		{"http://example.com/slow", fasthttp.StatusGatewayTimeout},
+		{"http://example.com/modify-error", fasthttp.StatusBadGateway},
+	}
+	for _, tc := range testCases {
+		statusCode, _, err := c.Get(nil, tc.uri)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if statusCode != tc.statusCode {
+			t.Fatalf("unexpected status code %d for %q. Expecting %d", statusCode, tc.uri, tc.statusCode)
+		}
+	}
+
+	// Unreachable upstream.
+	p = &ReverseProxy{
+		Client: &fasthttp.HostClient{
+			Addr: "upstream",
+			Dial: func(string) (net.Conn, error) { return nil, errors.New("connection refused") },
+		},
+	}
+	c = startReverseProxy(t, nil, p, &fasthttp.Server{})
+	statusCode, body, err := c.Get(nil, "http://example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if statusCode != fasthttp.StatusBadGateway || string(body) != "Bad Gateway" {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, fasthttp.StatusBadGateway, "Bad Gateway")
+	}
+}
+
+func TestReverseProxyStreaming(t *testing.T) {
+	t.Parallel()
+
+	body := bytes.Repeat([]byte("0123456789"), 300000)
+	upstream := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.Write(ctx.PostBody()) //nolint:errcheck
+		},
+	}
+	p := &ReverseProxy{}
+	// The request body exceeds MaxRequestBodySize, so it must be streamed.
+	proxy := &fasthttp.Server{
+		StreamRequestBody:  true,
+		MaxRequestBodySize: 1 << 20,
+	}
+	c := startReverseProxy(t, upstream, p, proxy)
+	p.Client.(*fasthttp.HostClient).StreamResponseBody = true
+
+	for _, contentLength := range []int{len(body), -1} {
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		req.SetRequestURI("http://example.com/")
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetBodyStream(bytes.NewReader(body), contentLength)
+		resp.StreamBody = true
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := io.ReadAll(resp.BodyStream())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, body) {
+			t.Fatalf("unexpected body length %d. Expecting %d", len(got), len(body))
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+	}
+}
@@ -0,0 +1,337 @@
+// Package fasthttptrace provides fasthttp.ServerTracer and fasthttp.ClientTracer
+// implementation recording OpenTelemetry-compatible spans.
+//
+// Spans are passed to Exporter, which may convert them to spans
+// of the OpenTelemetry SDK or any other tracing library.
+// Span attributes follow OpenTelemetry semantic conventions for HTTP.
+//
+// Usage:
+//
+//	exporter := &fasthttptrace.InMemoryExporter{}
+//	tracer := fasthttptrace.NewTracer(exporter)
+//	s := &fasthttp.Server{
+//		Handler: handler,
+//		Tracer:  tracer,
+//	}
+//	c := &fasthttp.Client{
+//		Tracer: tracer,
+//	}
+package fasthttptrace
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// SpanKind is the span kind.
+type SpanKind int
+
+const (
+	// SpanKindServer is the kind of spans started by Server.
+	SpanKindServer SpanKind = iota + 1
+
+	// SpanKindClient is the kind of spans started by HostClient.
+	SpanKindClient
+)
+
+// String returns the span kind name.
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "SpanKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// Attribute names set on spans in addition to OpenTelemetry
+// semantic conventions.
+//
+// Phase durations are stored as time.Duration values.
+const (
+	AttrServerHeaderRead = "fasthttp.server.header_read.duration"
+	AttrServerBodyRead   = "fasthttp.server.body_read.duration"
+	AttrServerHandler    = "fasthttp.server.handler.duration"
+	AttrServerWrite      = "fasthttp.server.write.duration"
+
+	AttrClientDial         = "fasthttp.client.dial.duration"
+	AttrClientTLSHandshake = "fasthttp.client.tls_handshake.duration"
+	AttrClientFirstByte    = "fasthttp.client.first_byte.duration"
+	AttrClientBodyRead     = "fasthttp.client.body_read.duration"
+	AttrClientConnReused   = "fasthttp.client.conn_reused"
+)
+
+// Attribute is a span attribute.
+type Attribute struct {
+	// Value is string, int, bool or time.Duration.
+	Value any
+
+	Key string
+}
+
+// Span is a finished span.
+type Span struct {
+	StartTime time.Time
+	EndTime   time.Time
+
+	// Err is the error the span finished with.
+	Err error
+
+	// Name is the span name, which is the request method.
+	Name string
+
+	Attributes []Attribute
+
+	// Context is the trace context of the span.
+	Context fasthttp.TraceContext
+
+	// Parent is the trace context of the parent span.
+	//
+	// It is invalid for root spans.
+	Parent fasthttp.TraceContext
+
+	Kind SpanKind
+}
+
+// Attribute returns the value of the span attribute with the given key.
+func (s *Span) Attribute(key string) (any, bool) {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Span) setAttr(key string, value any) {
+	s.Attributes = append(s.Attributes, Attribute{Key: key, Value: value})
+}
+
+// Exporter exports finished spans.
+//
+// ExportSpan may be called from concurrently running goroutines.
+type Exporter interface {
+	// ExportSpan is called for each finished sampled span.
+	//
+	// The exporter owns the span after the call.
+	ExportSpan(span *Span)
+}
+
+// InMemoryExporter stores finished spans in memory.
+//
+// It is useful for testing.
+//
+// It is safe calling InMemoryExporter methods from concurrently running goroutines.
+type InMemoryExporter struct {
+	spans []*Span
+	mu    sync.Mutex
+}
+
+// ExportSpan stores the span.
+func (e *InMemoryExporter) ExportSpan(span *Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, span)
+	e.mu.Unlock()
+}
+
+// Spans returns the stored spans in the order they were finished.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	spans := append([]*Span(nil), e.spans...)
+	e.mu.Unlock()
+	return spans
+}
+
+// Reset deletes the stored spans.
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+// Tracer implements fasthttp.ServerTracer and fasthttp.ClientTracer.
+//
+// Tracer continues traces received in traceparent and tracestate request
+// headers and propagates the trace context in requests sent by clients.
+// Requests without the trace context start new sampled traces.
+// Spans of unsampled traces aren't exported.
+//
+// It is safe calling Tracer methods from concurrently running goroutines.
+type Tracer struct {
+	// Exporter exports finished spans.
+	Exporter Exporter
+}
+
+// NewTracer returns a tracer exporting spans to the given exporter.
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{
+		Exporter: exporter,
+	}
+}
+
+var (
+	_ fasthttp.ServerTracer = (*Tracer)(nil)
+	_ fasthttp.ClientTracer = (*Tracer)(nil)
+)
+
+// traceContextKey is the user value key for the server span trace context.
+type traceContextKey struct{}
+
+// TraceContextFromRequestCtx returns the trace context
+// of the server span for the given request.
+//
+// The returned trace context may be injected into requests sent by
+// the request handler for propagating the trace:
+//
+//	tc, _ := fasthttptrace.TraceContextFromRequestCtx(ctx)
+//	tc.Inject(&req.Header)
+func TraceContextFromRequestCtx(ctx *fasthttp.RequestCtx) (fasthttp.TraceContext, bool) {
+	tc, ok := ctx.UserValue(traceContextKey{}).(fasthttp.TraceContext)
+	return tc, ok
+}
+
+// StartServerSpan implements fasthttp.ServerTracer.
+func (t *Tracer) StartServerSpan(ctx *fasthttp.RequestCtx) fasthttp.ServerSpan {
+	span := t.startSpan(SpanKindServer, &ctx.Request)
+	ctx.SetUserValue(traceContextKey{}, span.span.Context)
+
+	h := &ctx.Request.Header
+	scheme := "http"
+	if ctx.IsTLS() {
+		scheme = "https"
+	}
+	s := &span.span
+	s.setAttr("http.request.method", string(h.Method()))
+	s.setAttr("url.scheme", scheme)
+	s.setAttr("url.path", string(ctx.Path()))
+	s.setAttr("server.address", string(ctx.Host()))
+	s.setAttr("client.address", ctx.RemoteIP().String())
+	s.setAttr("network.protocol.version", protocolVersion(h.Protocol()))
+	if ua := h.UserAgent(); len(ua) > 0 {
+		s.setAttr("user_agent.original", string(ua))
+	}
+	return span
+}
+
+// StartClientSpan implements fasthttp.ClientTracer.
+func (t *Tracer) StartClientSpan(req *fasthttp.Request) fasthttp.ClientSpan {
+	span := t.startSpan(SpanKindClient, req)
+	span.span.Context.Inject(&req.Header)
+
+	s := &span.span
+	s.setAttr("http.request.method", string(req.Header.Method()))
+	s.setAttr("url.full", req.URI().String())
+	s.setAttr("server.address", string(req.URI().Host()))
+	return (*clientSpan)(span)
+}
+
+func (t *Tracer) startSpan(kind SpanKind, req *fasthttp.Request) *span {
+	sp := &span{
+		exporter: t.Exporter,
+	}
+	s := &sp.span
+	s.Kind = kind
+	s.Name = string(req.Header.Method())
+	s.StartTime = time.Now()
+
+	if s.Parent.Extract(&req.Header) {
+		s.Context.TraceID = s.Parent.TraceID
+		s.Context.Flags = s.Parent.Flags
+		s.Context.TraceState = s.Parent.TraceState
+	} else {
+		newTraceID(&s.Context.TraceID)
+		s.Context.Flags = fasthttp.TraceFlagsSampled
+	}
+	newSpanID(&s.Context.SpanID)
+	return sp
+}
+
+func newTraceID(id *[16]byte) {
+	for *id == [16]byte{} {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64()) // #nosec G404
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64()) // #nosec G404
+	}
+}
+
+func newSpanID(id *[8]byte) {
+	for *id == [8]byte{} {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64()) // #nosec G404
+	}
+}
+
+func protocolVersion(protocol []byte) string {
+	switch string(protocol) {
+	case "HTTP/1.0":
+		return "1.0"
+	case "HTTP/2.0":
+		return "2"
+	default:
+		return "1.1"
+	}
+}
+
+// span implements fasthttp.ServerSpan and fasthttp.ClientSpan.
+type span struct {
+	exporter Exporter
+	span     Span
+}
+
+// End implements fasthttp.ServerSpan.
+func (sp *span) End(ctx *fasthttp.RequestCtx, trace *fasthttp.ServerTrace) {
+	s := &sp.span
+	s.StartTime = trace.Start
+	s.Err = trace.Err
+	s.setAttr("http.response.status_code", ctx.Response.StatusCode())
+	s.setAttr(AttrServerHeaderRead, trace.HeaderRead)
+	s.setAttr(AttrServerBodyRead, trace.BodyRead)
+	s.setAttr(AttrServerHandler, trace.Handler)
+	s.setAttr(AttrServerWrite, trace.Write)
+	sp.export()
+}
+
+// clientSpan adapts span to fasthttp.ClientSpan, since End methods
+// of fasthttp.ServerSpan and fasthttp.ClientSpan have different signatures.
+type clientSpan span
+
+// End implements fasthttp.ClientSpan.
+func (sp *clientSpan) End(req *fasthttp.Request, resp *fasthttp.Response, trace *fasthttp.ClientTrace, err error) {
+	s := &sp.span
+
+	// Restore the original trace context, so spans of redirect hops
+	// and of the repeated requests aren't children of this span.
+	if s.Parent.IsValid() {
+		s.Parent.Inject(&req.Header)
+	} else {
+		req.Header.Del(fasthttp.HeaderTraceParent)
+		req.Header.Del(fasthttp.HeaderTraceState)
+	}
+
+	s.Err = err
+	if err == nil {
+		s.setAttr("http.response.status_code", resp.StatusCode())
+	}
+	if trace.Attempts > 1 {
+		s.setAttr("http.request.resend_count", trace.Attempts-1)
+	}
+	s.setAttr(AttrClientConnReused, trace.ConnReused)
+	s.setAttr(AttrClientDial, trace.Dial)
+	s.setAttr(AttrClientTLSHandshake, trace.TLSHandshake)
+	s.setAttr(AttrClientFirstByte, trace.FirstByte)
+	s.setAttr(AttrClientBodyRead, trace.BodyRead)
+	(*span)(sp).export()
+}
+
+func (sp *span) export() {
+	s := &sp.span
+	s.EndTime = time.Now()
+	if s.Context.IsSampled() && sp.exporter != nil {
+		sp.exporter.ExportSpan(s)
+	}
+}
@@ -0,0 +1,135 @@
+package fasthttptrace
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	var handlerCtx fasthttp.TraceContext
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			handlerCtx, _ = TraceContextFromRequestCtx(ctx)
+			ctx.WriteString("ok") //nolint:errcheck
+		},
+		Tracer: tracer,
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	c := &fasthttp.Client{
+		Dial:   func(string) (net.Conn, error) { return ln.Dial() },
+		Tracer: tracer,
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.SetRequestURI("http://example.com/foo")
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(req.Header.Peek(fasthttp.HeaderTraceParent)) > 0 {
+		t.Fatalf("unexpected traceparent header %q left in the request", req.Header.Peek(fasthttp.HeaderTraceParent))
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("unexpected number of spans %d. Expecting 2", len(spans))
+	}
+	serverSpan, clientSpan := spans[0], spans[1]
+	if serverSpan.Kind != SpanKindServer || clientSpan.Kind != SpanKindClient {
+		t.Fatalf("unexpected span kinds %s, %s. Expecting %s, %s", serverSpan.Kind, clientSpan.Kind, SpanKindServer, SpanKindClient)
+	}
+	if clientSpan.Parent.IsValid() {
+		t.Fatalf("unexpected parent %+v of the root span", clientSpan.Parent)
+	}
+	if serverSpan.Parent.TraceID != clientSpan.Context.TraceID || serverSpan.Parent.SpanID != clientSpan.Context.SpanID {
+		t.Fatalf("unexpected server span parent %+v. Expecting %+v", serverSpan.Parent, clientSpan.Context)
+	}
+	if serverSpan.Context.TraceID != clientSpan.Context.TraceID || serverSpan.Context.SpanID == clientSpan.Context.SpanID {
+		t.Fatalf("unexpected server span context %+v", serverSpan.Context)
+	}
+	if handlerCtx != serverSpan.Context {
+		t.Fatalf("unexpected trace context in the handler %+v. Expecting %+v", handlerCtx, serverSpan.Context)
+	}
+
+	for _, span := range spans {
+		if span.Name != fasthttp.MethodGet || span.Err != nil || span.EndTime.Before(span.StartTime) {
+			t.Fatalf("unexpected span %+v", span)
+		}
+		if v, _ := span.Attribute("http.response.status_code"); v != fasthttp.StatusOK {
+			t.Fatalf("unexpected status code attribute %v. Expecting %d", v, fasthttp.StatusOK)
+		}
+	}
+	if v, _ := serverSpan.Attribute("url.path"); v != "/foo" {
+		t.Fatalf("unexpected url.path attribute %v. Expecting %q", v, "/foo")
+	}
+	if v, _ := serverSpan.Attribute(AttrServerHandler); v == nil {
+		t.Fatalf("missing %s attribute", AttrServerHandler)
+	}
+	if v, _ := clientSpan.Attribute("url.full"); v != "http://example.com/foo" {
+		t.Fatalf("unexpected url.full attribute %v. Expecting %q", v, "http://example.com/foo")
+	}
+	v, _ := clientSpan.Attribute(AttrClientDial)
+	if d, ok := v.(time.Duration); !ok || d <= 0 {
+		t.Fatalf("unexpected %s attribute %v", AttrClientDial, v)
+	}
+	if v, _ := clientSpan.Attribute(AttrClientConnReused); v != false {
+		t.Fatalf("unexpected %s attribute %v. Expecting false", AttrClientConnReused, v)
+	}
+}
+
+func TestTracerContinueTrace(t *testing.T) {
+	t.Parallel()
+
+	exporter := &InMemoryExporter{}
+	tracer := NewTracer(exporter)
+
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var req fasthttp.Request
+	req.SetRequestURI("http://example.com/")
+	req.Header.Set(fasthttp.HeaderTraceParent, traceParent)
+	req.Header.Set(fasthttp.HeaderTraceState, "foo=bar")
+
+	span := tracer.StartClientSpan(&req)
+	var tc fasthttp.TraceContext
+	if !tc.Extract(&req.Header) {
+		t.Fatal("cannot extract injected trace context")
+	}
+	if string(tc.AppendTraceParent(nil)) == traceParent || tc.TraceState != "foo=bar" {
+		t.Fatalf("unexpected injected trace context %+v", tc)
+	}
+	span.End(&req, nil, &fasthttp.ClientTrace{}, fasthttp.ErrTimeout)
+
+	if v := string(req.Header.Peek(fasthttp.HeaderTraceParent)); v != traceParent {
+		t.Fatalf("unexpected traceparent header %q. Expecting %q", v, traceParent)
+	}
+	spans := exporter.Spans()
+	if len(spans) != 1 || spans[0].Err != fasthttp.ErrTimeout {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+	if string(spans[0].Parent.AppendTraceParent(nil)) != traceParent {
+		t.Fatalf("unexpected parent %+v", spans[0].Parent)
+	}
+
+	// Unsampled spans aren't exported.
+	exporter.Reset()
+	req.Header.Set(fasthttp.HeaderTraceParent, traceParent[:len(traceParent)-1]+"0")
+	tracer.StartClientSpan(&req).End(&req, nil, &fasthttp.ClientTrace{}, fasthttp.ErrTimeout)
+	if len(exporter.Spans()) != 0 {
+		t.Fatalf("unexpected number of spans %d. Expecting 0", len(exporter.Spans()))
+	}
+}
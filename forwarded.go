@@ -0,0 +1,312 @@
+package fasthttp
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+)
+
+// ClientIP returns the ip of the client the request came from.
+//
+// The ip is obtained from Forwarded or X-Forwarded-For request headers
+// if the request came from one of Server.TrustedProxies. The headers are
+// walked from right to left, i.e. from the nearest proxy, until the first
+// untrusted address, which is the client ip. Forwarded takes precedence
+// over X-Forwarded-For. The address of the farthest trusted proxy
+// is returned if it forwarded an unknown or obfuscated client address.
+//
+// RemoteIP is returned if Server.TrustedProxies is empty
+// or if the request came from an untrusted address.
+//
+// Always returns non-nil result.
+func (ctx *RequestCtx) ClientIP() net.IP {
+	ip, _, _ := ctx.forwardedClient()
+	return ip
+}
+
+// ClientProto returns the protocol the client used for sending the request,
+// i.e. "http" or "https".
+//
+// The protocol is obtained from Forwarded or X-Forwarded-Proto request
+// headers the same way as ClientIP. The protocol of the connection
+// to the server is returned if the proxy didn't forward the protocol.
+//
+// X-Forwarded-Proto is used only if it contains the same number of values
+// as X-Forwarded-For, i.e. the trusted proxies must append the protocol
+// like the client address, and the value for the client ip is returned.
+// Otherwise the value may be sent by the client, so it is ignored.
+// Proxies overwriting X-Forwarded-Proto, e.g. nginx with $scheme, require
+// Server.TrustedProxyOverwritesXForwarded, since the client may send its
+// own X-Forwarded-For.
+//
+// The returned value is valid until the request is released.
+func (ctx *RequestCtx) ClientProto() []byte {
+	_, proto, _ := ctx.forwardedClient()
+	if proto != nil {
+		return proto
+	}
+	if ctx.IsTLS() {
+		return strHTTPS
+	}
+	return strHTTP
+}
+
+// ClientHost returns the host the client sent the request to.
+//
+// The host is obtained from Forwarded or X-Forwarded-Host request headers
+// the same way as ClientIP and ClientProto. Host is returned if the proxy
+// didn't forward the host.
+//
+// The returned value is valid until the request is released.
+func (ctx *RequestCtx) ClientHost() []byte {
+	_, _, host := ctx.forwardedClient()
+	if len(host) > 0 {
+		return host
+	}
+	return ctx.Host()
+}
+
+// forwardedClient returns the client ip, protocol and host forwarded
+// by the trusted proxies.
+//
+// nil protocol and host are returned if they aren't forwarded.
+func (ctx *RequestCtx) forwardedClient() (ip net.IP, proto, host []byte) {
+	ip = ctx.RemoteIP()
+	if ctx.s == nil || !ipInPrefixes(ip, ctx.s.TrustedProxies) {
+		return ip, nil, nil
+	}
+	trustedProxies := ctx.s.TrustedProxies
+	h := &ctx.Request.Header
+
+	if forwarded := h.PeekAll(HeaderForwarded); len(forwarded) > 0 {
+		elements := parseForwarded(nil, forwarded)
+		for i := len(elements) - 1; i >= 0; i-- {
+			e := &elements[i]
+			if p := forwardedProto(e.proto); p != nil {
+				proto = p
+			}
+			if len(e.host) > 0 {
+				host = e.host
+			}
+			forIP := parseForwardedIP(e.forIP)
+			if forIP == nil {
+				break
+			}
+			ip = forIP
+			if !ipInPrefixes(ip, trustedProxies) {
+				break
+			}
+		}
+		return ip, proto, host
+	}
+
+	ips := splitForwardedList(nil, h.PeekAll(HeaderXForwardedFor))
+	i := len(ips) - 1
+	for ; i >= 0; i-- {
+		forIP := parseForwardedIP(ips[i])
+		if forIP == nil {
+			break
+		}
+		ip = forIP
+		if !ipInPrefixes(ip, trustedProxies) {
+			break
+		}
+	}
+	i = max(i, 0)
+
+	protos := splitForwardedList(nil, h.PeekAll(HeaderXForwardedProto))
+	hosts := splitForwardedList(nil, h.PeekAll(HeaderXForwardedHost))
+	if ctx.s.TrustedProxyOverwritesXForwarded {
+		// The last values are set by the nearest trusted proxy.
+		if len(protos) > 0 {
+			proto = forwardedProto(protos[len(protos)-1])
+		}
+		if len(hosts) > 0 {
+			host = hosts[len(hosts)-1]
+		}
+		return ip, proto, host
+	}
+
+	// X-Forwarded-Proto and X-Forwarded-Host values are used only if every
+	// proxy appended them like X-Forwarded-For, so the value at the index
+	// of the client ip has been set by the trusted proxy. Otherwise
+	// the values may be sent by the client, so they are ignored.
+	if len(protos) > 0 && len(protos) == len(ips) {
+		proto = forwardedProto(protos[i])
+	}
+	if len(hosts) > 0 && len(hosts) == len(ips) {
+		host = hosts[i]
+	}
+	return ip, proto, host
+}
+
+func forwardedProto(proto []byte) []byte {
+	switch {
+	case caseInsensitiveCompare(proto, strHTTPS):
+		return strHTTPS
+	case caseInsensitiveCompare(proto, strHTTP):
+		return strHTTP
+	default:
+		return nil
+	}
+}
+
+// ipInPrefixes returns true if ip belongs to one of the given networks.
+func ipInPrefixes(ip net.IP, prefixes []netip.Prefix) bool {
+	if len(prefixes) == 0 {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedIP parses the node of Forwarded 'for' parameter
+// or X-Forwarded-For value.
+//
+// nil is returned for unknown and obfuscated nodes.
+// See https://www.rfc-editor.org/rfc/rfc7239#section-6 .
+func parseForwardedIP(node []byte) net.IP {
+	if len(node) > 0 && node[0] == '[' {
+		// IPv6 address with optional port.
+		n := bytes.IndexByte(node, ']')
+		if n < 0 {
+			return nil
+		}
+		node = node[1:n]
+	}
+	addr, err := netip.ParseAddr(b2s(node))
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(b2s(node))
+		if err != nil {
+			return nil
+		}
+		addr = addrPort.Addr()
+	}
+	if addr.Zone() != "" {
+		return nil
+	}
+	return addr.Unmap().AsSlice()
+}
+
+// splitForwardedList appends comma-separated values of the given
+// header values to dst.
+func splitForwardedList(dst [][]byte, values [][]byte) [][]byte {
+	for _, v := range values {
+		for len(v) > 0 {
+			var item []byte
+			if n := bytes.IndexByte(v, ','); n >= 0 {
+				item, v = v[:n], v[n+1:]
+			} else {
+				item, v = v, nil
+			}
+			if item = bytes.TrimSpace(item); len(item) > 0 {
+				dst = append(dst, item)
+			}
+		}
+	}
+	return dst
+}
+
+// forwardedElement contains the parameters of Forwarded header element
+// used for resolving the client.
+type forwardedElement struct {
+	forIP []byte
+	proto []byte
+	host  []byte
+}
+
+// parseForwarded appends the elements of the given Forwarded header values to dst.
+//
+// See https://www.rfc-editor.org/rfc/rfc7239#section-4 .
+func parseForwarded(dst []forwardedElement, values [][]byte) []forwardedElement {
+	for _, v := range values {
+		var e forwardedElement
+		for len(v) > 0 {
+			v = bytes.TrimLeft(v, " \t")
+			n := bytes.IndexAny(v, "=;,")
+			if n < 0 {
+				// Parameter without value at the end.
+				break
+			}
+			if v[n] == '=' {
+				key := bytes.TrimSpace(v[:n])
+				var value []byte
+				value, v = parseForwardedValue(v[n+1:])
+
+				switch {
+				case caseInsensitiveCompare(key, strForwardedFor):
+					e.forIP = value
+				case caseInsensitiveCompare(key, strForwardedProto):
+					e.proto = value
+				case caseInsensitiveCompare(key, strForwardedHost):
+					e.host = value
+				}
+
+				v = bytes.TrimLeft(v, " \t")
+				if len(v) == 0 {
+					break
+				}
+				n = 0
+			}
+			if v[n] == ',' {
+				dst = appendForwardedElement(dst, &e)
+				e = forwardedElement{}
+			}
+			v = v[n+1:]
+		}
+		dst = appendForwardedElement(dst, &e)
+	}
+	return dst
+}
+
+// appendForwardedElement appends e to dst unless e is empty.
+func appendForwardedElement(dst []forwardedElement, e *forwardedElement) []forwardedElement {
+	if e.forIP == nil && e.proto == nil && e.host == nil {
+		return dst
+	}
+	return append(dst, *e)
+}
+
+// parseForwardedValue parses the token or the quoted string at the beginning of b.
+//
+// It returns the value and the rest of b.
+func parseForwardedValue(b []byte) (value, tail []byte) {
+	if len(b) == 0 || b[0] != '"' {
+		n := bytes.IndexAny(b, ";,")
+		if n < 0 {
+			return bytes.TrimSpace(b), nil
+		}
+		return bytes.TrimSpace(b[:n]), b[n:]
+	}
+
+	b = b[1:]
+	n := bytes.IndexAny(b, "\"\\")
+	if n >= 0 && b[n] == '"' {
+		// Fast path: the quoted string doesn't contain escaped chars.
+		return b[:n], b[n+1:]
+	}
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+			if i < len(b) {
+				value = append(value, b[i])
+			}
+		case '"':
+			return value, b[i+1:]
+		default:
+			value = append(value, b[i])
+		}
+	}
+	// Unterminated quoted string.
+	return value, nil
+}
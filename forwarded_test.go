@@ -0,0 +1,245 @@
+package fasthttp
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestRequestCtxClient(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		TrustedProxies: []netip.Prefix{
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("2001:db8::/32"),
+		},
+	}
+	for _, tc := range []struct {
+		name          string
+		remoteIP      string
+		headers       [][2]string
+		expectedIP    string
+		expectedProto string
+		expectedHost  string
+	}{
+		{
+			name:          "untrusted remote address",
+			remoteIP:      "1.1.1.1",
+			headers:       [][2]string{{HeaderXForwardedFor, "2.2.2.2"}, {HeaderXForwardedProto, "https"}},
+			expectedIP:    "1.1.1.1",
+			expectedProto: "http",
+			expectedHost:  "example.com",
+		},
+		{
+			name:          "no headers",
+			remoteIP:      "10.0.0.1",
+			expectedIP:    "10.0.0.1",
+			expectedProto: "http",
+			expectedHost:  "example.com",
+		},
+		{
+			name:     "x-forwarded-for",
+			remoteIP: "10.0.0.1",
+			headers: [][2]string{
+				{HeaderXForwardedFor, "3.3.3.3, 2.2.2.2"},
+				{HeaderXForwardedFor, "10.0.0.2"},
+			},
+			expectedIP:    "2.2.2.2",
+			expectedProto: "http",
+			expectedHost:  "example.com",
+		},
+		{
+			name:     "x-forwarded-proto not appended by proxy",
+			remoteIP: "10.0.0.1",
+			headers: [][2]string{
+				{HeaderXForwardedFor, "3.3.3.3, 2.2.2.2"},
+				{HeaderXForwardedProto, "HTTPS"},
+				{HeaderXForwardedHost, "foo.com"},
+			},
+			expectedIP:    "2.2.2.2",
+			expectedProto: "http",
+			expectedHost:  "example.com",
+		},
+		{
+			name:     "x-forwarded-proto sent by client",
+			remoteIP: "10.0.0.1",
+			headers: [][2]string{
+				{HeaderXForwardedFor, "9.9.9.9, 2.2.2.2"},
+				{HeaderXForwardedProto, "https"},
+			},
+			expectedIP:    "2.2.2.2",
+			expectedProto: "http",
+			expectedHost:  "example.com",
+		},
+		{
+			name:     "x-forwarded-* appended by each proxy",
+			remoteIP: "10.0.0.1",
+			headers: [][2]string{
+				{HeaderXForwardedFor, "2.2.2.2, 10.0.0.2"},
+				{HeaderXForwardedProto, "https, http"},
+				{HeaderXForwardedHost, "foo.com, internal"},
+			},
+			expectedIP:    "2.2.2.2",
+			expectedProto: "https",
+			expectedHost:  "foo.com",
+		},
+		{
+			name:     "x-forwarded-* appended by each proxy with untrusted hops",
+			remoteIP: "10.0.0.1",
+			headers: [][2]string{
+				{HeaderXForwardedFor, "9.9.9.9, 2.2.2.2, 10.0.0.2"},
+				{HeaderXForwardedProto, "http, https, http"},
+				{HeaderXForwardedHost, "evil.com, foo.com, internal"},
+			},
+			expectedIP:    "2.2.2.2",
+			expectedProto: "https",
+			expectedHost:  "foo.com",
+		},
+		{
+			name:          "x-forwarded-for ipv6 with port",
+			remoteIP:      "10.0.0.1",
+			headers:       [][2]string{{HeaderXForwardedFor, "[2001:db9::1]:1234"}},
+			expectedIP:    "2001:db9::1",
+			expectedProto: "http",
+			expectedHost:  "example.com",
+		},
+		{
+			name:          "x-forwarded-for all trusted",
+			remoteIP:      "10.0.0.1",
+			headers:       [][2]string{{HeaderXForwardedFor, "10.0.0.3, 10.0.0.2"}},
+			expectedIP:    "10.0.0.3",
+			expectedProto: "http",
+			expectedHost:  "example.com",
+		},
+		{
+			name:          "x-forwarded-for invalid address",
+			remoteIP:      "10.0.0.1",
+			headers:       [][2]string{{HeaderXForwardedFor, "2.2.2.2, foobar, 10.0.0.2"}},
+			expectedIP:    "10.0.0.2",
+			expectedProto: "http",
+			expectedHost:  "example.com",
+		},
+		{
+			name:     "forwarded",
+			remoteIP: "10.0.0.1",
+			headers: [][2]string{
+				{HeaderForwarded, `for=3.3.3.3, for="2.2.2.2:1234";proto=https;host="foo.com"`},
+				{HeaderForwarded, `For="[2001:db8::1]:4711";Proto=http;by=10.0.0.1`},
+				{HeaderXForwardedFor, "4.4.4.4"},
+			},
+			expectedIP:    "2.2.2.2",
+			expectedProto: "https",
+			expectedHost:  "foo.com",
+		},
+		{
+			name:          "forwarded ipv6",
+			remoteIP:      "2001:db8::2",
+			headers:       [][2]string{{HeaderForwarded, `for="[2001:db9::1]";proto=https`}},
+			expectedIP:    "2001:db9::1",
+			expectedProto: "https",
+			expectedHost:  "example.com",
+		},
+		{
+			name:          "forwarded obfuscated",
+			remoteIP:      "10.0.0.1",
+			headers:       [][2]string{{HeaderForwarded, `for=_hidden;proto=https, for=10.0.0.2`}},
+			expectedIP:    "10.0.0.2",
+			expectedProto: "https",
+			expectedHost:  "example.com",
+		},
+		{
+			name:          "forwarded invalid proto",
+			remoteIP:      "10.0.0.1",
+			headers:       [][2]string{{HeaderForwarded, `for=2.2.2.2;proto="ftp\"s"`}},
+			expectedIP:    "2.2.2.2",
+			expectedProto: "http",
+			expectedHost:  "example.com",
+		},
+	} {
+		var req Request
+		req.SetRequestURI("http://example.com/")
+		for _, kv := range tc.headers {
+			req.Header.Add(kv[0], kv[1])
+		}
+		var ctx RequestCtx
+		ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(tc.remoteIP), Port: 1234}, nil)
+		ctx.s = s
+
+		if ip := ctx.ClientIP().String(); ip != tc.expectedIP {
+			t.Fatalf("%s: unexpected client ip %q. Expecting %q", tc.name, ip, tc.expectedIP)
+		}
+		if proto := string(ctx.ClientProto()); proto != tc.expectedProto {
+			t.Fatalf("%s: unexpected client proto %q. Expecting %q", tc.name, proto, tc.expectedProto)
+		}
+		if host := string(ctx.ClientHost()); host != tc.expectedHost {
+			t.Fatalf("%s: unexpected client host %q. Expecting %q", tc.name, host, tc.expectedHost)
+		}
+	}
+}
+
+func TestRequestCtxClientOverwrittenXForwarded(t *testing.T) {
+	t.Parallel()
+
+	// The client sends its own X-Forwarded-For, while the proxy appends
+	// the client address to it and overwrites X-Forwarded-Proto
+	// and X-Forwarded-Host.
+	var req Request
+	req.SetRequestURI("http://example.com/")
+	req.Header.Add(HeaderXForwardedFor, "9.9.9.9, 2.2.2.2")
+	req.Header.Add(HeaderXForwardedProto, "https")
+	req.Header.Add(HeaderXForwardedHost, "foo.com")
+
+	for _, tc := range []struct {
+		expectedProto string
+		expectedHost  string
+		overwrites    bool
+	}{
+		// The values cannot be matched with the client address by default.
+		{expectedProto: "http", expectedHost: "example.com"},
+		{expectedProto: "https", expectedHost: "foo.com", overwrites: true},
+	} {
+		s := &Server{
+			TrustedProxies:                   []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			TrustedProxyOverwritesXForwarded: tc.overwrites,
+		}
+		var ctx RequestCtx
+		ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}, nil)
+		ctx.s = s
+
+		if ip := ctx.ClientIP().String(); ip != "2.2.2.2" {
+			t.Fatalf("unexpected client ip %q. Expecting %q", ip, "2.2.2.2")
+		}
+		if proto := string(ctx.ClientProto()); proto != tc.expectedProto {
+			t.Fatalf("unexpected client proto %q. Expecting %q", proto, tc.expectedProto)
+		}
+		if host := string(ctx.ClientHost()); host != tc.expectedHost {
+			t.Fatalf("unexpected client host %q. Expecting %q", host, tc.expectedHost)
+		}
+	}
+}
+
+func TestParseForwarded(t *testing.T) {
+	t.Parallel()
+
+	elements := parseForwarded(nil, [][]byte{
+		[]byte(` for=1.1.1.1 ; proto=http,, for="[::1]";host="a\"b,c";secret, `),
+		[]byte(`by=foo`),
+		[]byte(`host=foo.com;for="unterminated`),
+	})
+	expected := []forwardedElement{
+		{forIP: []byte("1.1.1.1"), proto: []byte("http")},
+		{forIP: []byte("[::1]"), host: []byte(`a"b,c`)},
+		{forIP: []byte("unterminated"), host: []byte("foo.com")},
+	}
+	if len(elements) != len(expected) {
+		t.Fatalf("unexpected number of elements %d. Expecting %d", len(elements), len(expected))
+	}
+	for i, e := range elements {
+		ee := expected[i]
+		if string(e.forIP) != string(ee.forIP) || string(e.proto) != string(ee.proto) || string(e.host) != string(ee.host) {
+			t.Fatalf("unexpected element #%d %q. Expecting %q",
+				i, [][]byte{e.forIP, e.proto, e.host}, [][]byte{ee.forIP, ee.proto, ee.host})
+		}
+	}
+}
@@ -2,21 +2,31 @@ package fasthttp
 
 import (
 	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
+	"html/template"
 	"io"
 	"io/fs"
 	"maps"
+	"math/rand/v2"
 	"mime"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/andybalholm/brotli"
@@ -351,6 +361,18 @@ type FS struct {
 	// "Cannot open requested path"
 	PathNotFound RequestHandler
 
+	// ErrorHandler sends error responses instead of the default
+	// plain text responses.
+	//
+	// statusCode is the response status code, which is already set, such as
+	// StatusNotFound, StatusForbidden or StatusRequestedRangeNotSatisfiable.
+	// err describes the error.
+	//
+	// PathNotFound takes precedence over ErrorHandler for not found paths.
+	//
+	// By default plain text error messages are sent.
+	ErrorHandler func(ctx *RequestCtx, statusCode int, err error)
+
 	// Suffixes list to add to compressedFileSuffix depending on encoding
 	//
 	// This value has sense only if Compress is set.
@@ -358,11 +380,44 @@ type FS struct {
 	// FSCompressedFileSuffixes is used by default.
 	CompressedFileSuffixes map[string]string
 
+	// Suffixes of precompressed files depending on encoding.
+	//
+	// Precompressed files are served as is instead of the original files
+	// to clients accepting the corresponding encoding. For example,
+	// main.js.br is served instead of main.js to clients accepting brotli
+	// if the suffix for "br" encoding is ".br".
+	//
+	// Precompressed files are never re-created, so they must be updated
+	// together with the original files. The original files are compressed
+	// on the fly only if Compress is set and precompressed files are missing.
+	//
+	// FSPrecompressedFileSuffixes may be used for common suffixes.
+	//
+	// By default precompressed files aren't served.
+	PrecompressedFileSuffixes map[string]string
+
 	// If CleanStop is set, the channel can be closed to stop the cleanup handlers
 	// for the FS RequestHandlers created with NewRequestHandler.
 	// NEVER close this channel while the handler is still being used!
 	CleanStop chan struct{}
 
+	// Cache stores opened file handlers.
+	//
+	// The cache is closed when CleanStop is closed or when the request
+	// handler isn't used anymore.
+	//
+	// By default file handlers are cached for CacheDuration.
+	Cache FSCache
+
+	// Template for generated index pages.
+	//
+	// The template is executed with *FSDirIndex data.
+	//
+	// This value has sense only if GenerateIndexPages is set.
+	//
+	// The built-in index page is generated by default.
+	IndexTemplate *template.Template
+
 	h RequestHandler
 
 	// Path to the root directory to serve files from.
@@ -379,6 +434,19 @@ type FS struct {
 	// FSCompressedFileSuffix is used by default.
 	CompressedFileSuffix string
 
+	// Path to the file relative to Root, which is served instead of
+	// not found files, such as "/index.html".
+	//
+	// This allows serving single-page applications, which route
+	// unknown paths on the client side.
+	//
+	// Request paths matching SPAFallbackExcludePrefixes or
+	// SPAFallbackExcludeExtensions aren't served by the fallback,
+	// so missing assets and API endpoints are still reported as not found.
+	//
+	// By default not found files aren't served by the fallback.
+	SPAFallback string
+
 	// List of index file names to try opening during directory access.
 	//
 	// For example:
@@ -390,13 +458,44 @@ type FS struct {
 	// By default the list is empty.
 	IndexNames []string
 
+	// Request path prefixes, which aren't served by SPAFallback, such as "/api/".
+	SPAFallbackExcludePrefixes []string
+
+	// File extensions, which aren't served by SPAFallback, such as ".js".
+	//
+	// Extensions are compared case-insensitively.
+	SPAFallbackExcludeExtensions []string
+
+	// Rules for setting Cache-Control, Expires and Vary response headers.
+	//
+	// The first rule matching the path of the served file is applied.
+	// FSFingerprintCacheControlRule may be used for fingerprinted files.
+	//
+	// By default Cache-Control header isn't set.
+	CacheControlRules []FSCacheControlRule
+
+	// Interval for checking cached files for changes.
+	//
+	// This value has sense only if InvalidateOnChange is set
+	// and file changes cannot be detected via inotify.
+	//
+	// FSInvalidatePollInterval is used by default.
+	InvalidatePollInterval time.Duration
+
 	// Expiration duration for inactive file handlers.
 	//
+	// This value has sense only if Cache isn't set.
+	//
 	// FSHandlerCacheDuration is used by default.
 	CacheDuration time.Duration
 
 	once sync.Once
 
+	// Sort order of entries on generated index pages.
+	//
+	// Entries are sorted by name by default.
+	IndexSort FSIndexSort
+
 	// AllowEmptyRoot controls what happens when Root is empty. When false (default) it will default to the
 	// current working directory. An empty root is mostly useful when you want to use absolute paths
 	// on windows that are on different filesystems. On linux setting your Root to "/" already allows you to use
@@ -427,6 +526,26 @@ type FS struct {
 	// By default index pages aren't generated.
 	GenerateIndexPages bool
 
+	// Sorts entries on generated index pages in descending order if set to true.
+	IndexSortDesc bool
+
+	// Hides files and directories with names starting with a dot
+	// from generated index pages if set to true.
+	//
+	// Hidden files are still served if requested directly.
+	IndexHideDotFiles bool
+
+	// Serves generated index pages as JSON to clients preferring
+	// application/json over text/html in Accept request header if set to true.
+	//
+	// The JSON index page is FSDirIndex encoded with encoding/json.
+	// JSON index pages aren't compressed.
+	//
+	// This value has sense only if GenerateIndexPages is set.
+	//
+	// JSON index pages are disabled by default.
+	JSONIndexPages bool
+
 	// Transparently compresses responses if set to true.
 	//
 	// The server tries minimizing CPU usage by caching compressed files.
@@ -441,9 +560,50 @@ type FS struct {
 
 	// Enables byte range requests if set to true.
 	//
+	// Byte ranges of compressed responses refer to the compressed file
+	// contents, since Content-Encoding is a property of the representation.
+	//
 	// Byte range requests are disabled by default.
 	AcceptByteRange bool
 
+	// Sends ETag response header and responds with '304 Not Modified'
+	// to requests with matching 'If-None-Match' header if set to true.
+	//
+	// By default weak ETags are generated from the file size
+	// and modification time. Compressed responses get distinct ETags.
+	//
+	// ETag generation is disabled by default.
+	GenerateETag bool
+
+	// Uses strong ETags generated from the hash of the file contents
+	// if set to true.
+	//
+	// The hash is calculated on the first request for the file
+	// and is cached together with the file handler.
+	//
+	// This value has sense only if GenerateETag is set.
+	//
+	// Strong ETags are disabled by default.
+	StrongETag bool
+
+	// Evicts cached files as soon as they are changed if set to true.
+	//
+	// Changes of files served from Root are detected via inotify on Linux.
+	// Otherwise the cached files are checked for changes
+	// every InvalidatePollInterval. The cached files are checked
+	// for changes the same way if inotify fails.
+	//
+	// Stale compressed files stored under CompressRoot are removed
+	// together with the changed files.
+	//
+	// Files should be replaced atomically via rename, since files modified
+	// in place may be served inconsistently until the change is detected.
+	//
+	// This value has sense only if SkipCache isn't set.
+	//
+	// Cached files aren't checked for changes by default.
+	InvalidateOnChange bool
+
 	// SkipCache if true, will cache no file handler.
 	//
 	// By default is false.
@@ -469,10 +629,209 @@ var FSCompressedFileSuffixes = map[string]string{
 	"zstd": ".fasthttp.zst",
 }
 
+// FSPrecompressedFileSuffixes contains common suffixes of precompressed files
+// depending on encoding. See FS.PrecompressedFileSuffixes for details.
+//
+// It is not safe for concurrent modification. Do not modify the map.
+var FSPrecompressedFileSuffixes = map[string]string{
+	"gzip": ".gz",
+	"br":   ".br",
+	"zstd": ".zst",
+}
+
+// FSCacheControlRule sets Cache-Control, Expires and Vary response headers
+// for files with matching paths. See FS.CacheControlRules for details.
+//
+// The rule matches all the files if both Pattern and Regexp are empty.
+type FSCacheControlRule struct {
+	// Regexp is matched against the file path relative to Root,
+	// such as "/assets/app.js".
+	Regexp *regexp.Regexp
+
+	// Pattern is path.Match pattern for the file path relative to Root,
+	// such as "/assets/*.js". Patterns without slashes are matched against
+	// the file name, so "*.js" matches JavaScript files in all directories.
+	//
+	// Invalid patterns don't match any file.
+	Pattern string
+
+	// Vary contains header names added to Vary response header.
+	Vary []string
+
+	// MaxAge is max-age directive value. It is rounded down to seconds.
+	//
+	// max-age directive isn't sent if MaxAge isn't positive.
+	MaxAge time.Duration
+
+	// Immutable adds immutable directive.
+	Immutable bool
+
+	// NoCache adds no-cache directive.
+	NoCache bool
+
+	// NoStore adds no-store directive.
+	NoStore bool
+
+	// Private adds private directive.
+	Private bool
+
+	// Expires sets Expires response header to the current time plus MaxAge
+	// for HTTP/1.0 caches.
+	Expires bool
+
+	// match is used instead of Pattern and Regexp if set.
+	match func(filePath string) bool
+}
+
+// FSFingerprintCacheControlRule caches fingerprinted files, such as
+// app.3f9a1c.js, for a year, since their contents never change.
+//
+// A file is considered fingerprinted if its name contains a hex hash
+// of at least 6 characters with at least one digit before the extension.
+var FSFingerprintCacheControlRule = FSCacheControlRule{
+	MaxAge:    365 * 24 * time.Hour,
+	Immutable: true,
+	match:     isFingerprintedFilePath,
+}
+
+func isFingerprintedFilePath(filePath string) bool {
+	name := path.Base(filePath)
+	n := strings.LastIndexByte(name, '.')
+	if n <= 0 {
+		return false
+	}
+	name = name[:n]
+	n = strings.LastIndexByte(name, '.')
+	if n <= 0 {
+		return false
+	}
+	hash := name[n+1:]
+	if len(hash) < 6 {
+		return false
+	}
+	hasDigit := false
+	for i := range len(hash) {
+		c := hash[i]
+		switch {
+		case c >= '0' && c <= '9':
+			hasDigit = true
+		case (c < 'a' || c > 'f') && (c < 'A' || c > 'F'):
+			return false
+		}
+	}
+	return hasDigit
+}
+
+func (r *FSCacheControlRule) matches(filePath string) bool {
+	if r.match != nil {
+		return r.match(filePath)
+	}
+	if r.Regexp != nil && !r.Regexp.MatchString(filePath) {
+		return false
+	}
+	if r.Pattern != "" {
+		name := filePath
+		if !strings.Contains(r.Pattern, "/") {
+			name = path.Base(filePath)
+		}
+		if ok, err := path.Match(r.Pattern, name); !ok || err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// appendCacheControl appends Cache-Control header value for the rule to dst.
+func (r *FSCacheControlRule) appendCacheControl(dst []byte) []byte {
+	appendDirective := func(directive string) {
+		if len(dst) > 0 {
+			dst = append(dst, ", "...)
+		}
+		dst = append(dst, directive...)
+	}
+	if r.NoCache {
+		appendDirective("no-cache")
+	}
+	if r.NoStore {
+		appendDirective("no-store")
+	}
+	if r.Private {
+		appendDirective("private")
+	}
+	if maxAge := int64(r.MaxAge / time.Second); maxAge > 0 {
+		appendDirective("max-age=")
+		dst = strconv.AppendInt(dst, maxAge, 10)
+	}
+	if r.Immutable {
+		appendDirective("immutable")
+	}
+	return dst
+}
+
+// fsCacheControlRule is FSCacheControlRule with the pre-computed headers.
+type fsCacheControlRule struct {
+	rule         FSCacheControlRule
+	cacheControl []byte
+}
+
+// FSIndexSort is the sort order of entries on generated index pages.
+//
+// See FS.IndexSort for details.
+type FSIndexSort uint8
+
+const (
+	// FSIndexSortByName sorts entries by name.
+	FSIndexSortByName FSIndexSort = iota
+
+	// FSIndexSortByModTime sorts entries by modification time.
+	FSIndexSortByModTime
+
+	// FSIndexSortBySize sorts entries by size.
+	FSIndexSortBySize
+)
+
+// FSDirIndex is a generated directory index page.
+//
+// See FS.IndexTemplate and FS.JSONIndexPages for details.
+type FSDirIndex struct {
+	// Path is the request path of the directory.
+	Path string `json:"path"`
+
+	// ParentPath is the request path of the parent directory.
+	//
+	// It is empty for the root directory.
+	ParentPath string `json:"parent,omitempty"`
+
+	// Entries contains the directory entries in FS.IndexSort order.
+	Entries []FSDirEntry `json:"entries"`
+}
+
+// FSDirEntry is an entry on a generated directory index page.
+type FSDirEntry struct {
+	// ModTime is the last modification time of the entry.
+	ModTime time.Time `json:"mtime"`
+
+	// Name is the entry name.
+	Name string `json:"name"`
+
+	// Path is the request path of the entry.
+	Path string `json:"path"`
+
+	// Size is the file size in bytes. It is zero for directories.
+	Size int64 `json:"size"`
+
+	// IsDir is set for directories.
+	IsDir bool `json:"is_dir"`
+}
+
 // FSHandlerCacheDuration is the default expiration duration for inactive
 // file handlers opened by FS.
 const FSHandlerCacheDuration = 10 * time.Second
 
+// FSInvalidatePollInterval is the default interval for checking cached files
+// for changes. See FS.InvalidateOnChange for details.
+const FSInvalidatePollInterval = time.Second
+
 // FSHandler returns request handler serving static files from
 // the given root folder.
 //
@@ -593,31 +952,61 @@ func (fs *FS) initRequestHandler() {
 		indexNames:             fs.IndexNames,
 		pathRewrite:            fs.PathRewrite,
 		generateIndexPages:     fs.GenerateIndexPages,
+		indexTemplate:          fs.IndexTemplate,
+		indexSort:              fs.IndexSort,
+		indexSortDesc:          fs.IndexSortDesc,
+		indexHideDotFiles:      fs.IndexHideDotFiles,
+		jsonIndexPages:         fs.JSONIndexPages,
 		compress:               fs.Compress,
 		compressBrotli:         fs.CompressBrotli,
 		compressZstd:           fs.CompressZstd,
 		compressRoot:           compressRoot,
 		pathNotFound:           fs.PathNotFound,
+		errorHandler:           fs.ErrorHandler,
 		acceptByteRange:        fs.AcceptByteRange,
+		generateETag:           fs.GenerateETag,
+		strongETag:             fs.StrongETag,
 		compressedFileSuffixes: compressedFileSuffixes,
 	}
+	if len(fs.PrecompressedFileSuffixes) > 0 {
+		h.precompressedFileSuffixes = maps.Clone(fs.PrecompressedFileSuffixes)
+	}
+	for i := range fs.CacheControlRules {
+		rule := fs.CacheControlRules[i]
+		h.cacheControlRules = append(h.cacheControlRules, fsCacheControlRule{
+			rule:         rule,
+			cacheControl: rule.appendCacheControl(nil),
+		})
+	}
+	if fs.SPAFallback != "" {
+		h.spaFallback = []byte(fs.SPAFallback)
+		if h.spaFallback[0] != '/' {
+			h.spaFallback = append([]byte{'/'}, h.spaFallback...)
+		}
+		h.spaFallbackExcludePrefixes = fs.SPAFallbackExcludePrefixes
+		h.spaFallbackExcludeExtensions = fs.SPAFallbackExcludeExtensions
+	}
 
-	h.cacheManager = newCacheManager(fs)
+	h.cache = newCacheManager(fs)
 
 	if h.filesystem == nil {
 		h.filesystem = &osFS{} // It provides os.Open and os.Stat
 	}
 
 	if fs.SkipCache {
-		// noopCacheManager.Close is a no-op, so there is nothing to stop.
+		// There is no cache to stop.
 		fs.h = h.handleRequest
 		return
 	}
 
+	if fs.InvalidateOnChange {
+		h.invalidator = newFSCacheInvalidator(h, fs.InvalidatePollInterval, fs.CleanStop)
+	}
+
 	// Use a >16-byte backing array so the cleanup owner doesn't fall under
 	// runtime's tiny pointer-free allocation batching.
 	cacheCleaner := make([]byte, 32)
-	runtime.AddCleanup(&cacheCleaner[0], cacheManager.Close, h.cacheManager)
+	runtime.AddCleanup(&cacheCleaner[0], (*fsHandler).closeCache, h)
 	fs.h = func(ctx *RequestCtx) {
 		h.handleRequest(ctx)
 		// Keep the cleanup owner captured by fs.h and alive until
@@ -626,24 +1015,55 @@ func (fs *FS) initRequestHandler() {
 	}
 }
 
+// closeCache closes the cache and stops the cache invalidation.
+func (h *fsHandler) closeCache() {
+	if h.invalidator != nil {
+		h.invalidator.Close()
+	}
+	h.cache.Close()
+}
+
 type fsHandler struct {
 	smallFileReaderPool sync.Pool
 	filesystem          fs.FS
 
-	cacheManager cacheManager
+	// cache is nil if the cache is disabled.
+	cache FSCache
+
+	// invalidator is nil if FS.InvalidateOnChange isn't set.
+	invalidator *fsCacheInvalidator
 
 	pathRewrite            PathRewriteFunc
 	pathNotFound           RequestHandler
 	compressedFileSuffixes map[string]string
+	indexTemplate          *template.Template
+
+	// precompressedFileSuffixes is nil if precompressed files aren't served.
+	precompressedFileSuffixes map[string]string
+
+	errorHandler func(ctx *RequestCtx, statusCode int, err error)
+
+	cacheControlRules []fsCacheControlRule
+
+	// spaFallback is nil if FS.SPAFallback isn't set.
+	spaFallback                  []byte
+	spaFallbackExcludePrefixes   []string
+	spaFallbackExcludeExtensions []string
 
 	root               string
 	compressRoot       string
 	indexNames         []string
+	indexSort          FSIndexSort
 	generateIndexPages bool
+	indexSortDesc      bool
+	indexHideDotFiles  bool
+	jsonIndexPages     bool
 	compress           bool
 	compressBrotli     bool
 	compressZstd       bool
 	acceptByteRange    bool
+	generateETag       bool
+	strongETag         bool
 }
 
 type fsFile struct {
@@ -653,15 +1073,25 @@ type fsFile struct {
 	f               fs.File
 	h               *fsHandler
 	filename        string // fs.FileInfo.Name() return filename, isn't filepath.
+	srcPath         string // path of the original file or directory. Used for cache invalidation.
 	contentType     string
 	dirIndex        []byte
 	lastModifiedStr []byte
+	etag            []byte
 
 	bigFiles      []*bigFileReader
 	contentLength int
-	readersCount  int
+
+	// srcStat contains the stats of srcPath obtained when ff has been opened.
+	// Used for cache invalidation.
+	srcStat fsFileStat
+
+	// refs is the number of references to ff held by readers
+	// and by the cache. ff is released when refs drops to zero.
+	refs atomic.Int32
 
 	bigFilesLock sync.Mutex
+	etagLock     sync.Mutex
 	compressed   bool
 }
 
@@ -740,8 +1170,98 @@ func (ff *fsFile) Release() {
 	}
 }
 
+// ETag returns the ETag for ff served with the given content encoding.
+//
+// The ETag is cached in ff, since it is requested for every response.
+func (ff *fsFile) ETag(strong bool, fileEncoding string) ([]byte, error) {
+	ff.etagLock.Lock()
+	defer ff.etagLock.Unlock()
+
+	if ff.etag != nil {
+		return ff.etag, nil
+	}
+	if !ff.compressed {
+		fileEncoding = ""
+	}
+
+	var etag []byte
+	if strong {
+		// The hash of the served contents differs for every content encoding,
+		// so there is no need in adding the encoding to the ETag.
+		sum, err := ff.contentHash()
+		if err != nil {
+			return nil, err
+		}
+		etag = append(etag, '"')
+		etag = hex.AppendEncode(etag, sum[:16])
+	} else {
+		etag = append(etag, `W/"`...)
+		etag = strconv.AppendInt(etag, ff.lastModified.Unix(), 16)
+		etag = append(etag, '-')
+		etag = strconv.AppendInt(etag, int64(ff.contentLength), 16)
+		if fileEncoding != "" {
+			etag = append(etag, '-')
+			etag = append(etag, fileEncoding...)
+		}
+	}
+	etag = append(etag, '"')
+
+	ff.etag = etag
+	return etag, nil
+}
+
+func (ff *fsFile) contentHash() ([sha256.Size]byte, error) {
+	if ff.f == nil {
+		return sha256.Sum256(ff.dirIndex), nil
+	}
+
+	// ff.f is shared with readers, so the file is opened again.
+	f, err := ff.h.filesystem.Open(ff.filename)
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("cannot open file %q: %w", ff.filename, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := copyZeroAlloc(h, f); err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("cannot read file %q: %w", ff.filename, err)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// Size implements FSCacheEntry.
+func (ff *fsFile) Size() int {
+	return ff.contentLength
+}
+
+// Evict implements FSCacheEntry.
+func (ff *fsFile) Evict() {
+	ff.decReadersCount()
+}
+
+// tryAcquire acquires the reference to ff unless it has been released.
+func (ff *fsFile) tryAcquire() bool {
+	for {
+		n := ff.refs.Load()
+		if n <= 0 {
+			return false
+		}
+		if ff.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
 func (ff *fsFile) decReadersCount() {
-	ff.h.cacheManager.DecReadersCount(ff)
+	n := ff.refs.Add(-1)
+	if n < 0 {
+		panic("bug: fsFile.refs < 0")
+	}
+	if n == 0 {
+		ff.Release()
+	}
 }
 
 // bigFileReader attempts to trigger sendfile
@@ -900,20 +1420,66 @@ func (r *fsSmallFileReader) WriteTo(w io.Writer) (int64, error) {
 	return int64(curPos - r.startPos), err
 }
 
-type cacheManager interface {
-	Lock()
-	Unlock()
+// FSCache caches file handlers opened by FS.
+//
+// FS acquires a reference to the cached file for each request,
+// so the file remains open until the pending requests complete
+// even if it has been removed from the cache.
+//
+// FSCache must be safe for concurrent use. FSCache mustn't be shared
+// between FS instances, since different FS instances may serve
+// different files under the same path.
+//
+// See FSLRUCache for a cache bounded by the number and the size of files.
+type FSCache interface {
+	// Get returns the entry stored under the given key.
+	Get(kind CacheKind, path []byte) (FSCacheEntry, bool)
+
+	// Set stores the entry under the given key and returns it.
+	//
+	// Set may return the entry already stored under the given key
+	// instead of storing the new entry.
+	//
+	// Entry.Evict must be called when the stored entry is removed
+	// from the cache.
+	Set(kind CacheKind, path []byte, entry FSCacheEntry) FSCacheEntry
+
+	// Delete removes the entry stored under the given key from the cache.
+	//
+	// It is used for evicting changed files. See FS.InvalidateOnChange.
+	Delete(kind CacheKind, path []byte)
+
+	// Close removes all the entries from the cache.
+	//
+	// It is called when CleanStop is closed or when the request handler
+	// isn't used anymore. The entries passed to Set after Close
+	// must be evicted immediately.
 	Close()
-	DecReadersCount(ff *fsFile)
-	GetFileFromCache(cacheKind CacheKind, path []byte) (*fsFile, bool)
-	SetFileToCache(cacheKind CacheKind, path []byte, ff *fsFile) *fsFile
+}
+
+// FSCacheEntry is a file handler stored in FSCache.
+type FSCacheEntry interface {
+	// Size returns the size of the file contents in bytes.
+	Size() int
+
+	// Evict must be called exactly once when the entry
+	// is removed from the cache.
+	//
+	// The file is closed after the pending requests for the file complete.
+	Evict()
 }
 
 var (
-	_ cacheManager = (*inMemoryCacheManager)(nil)
-	_ cacheManager = (*noopCacheManager)(nil)
+	_ FSCache = (*inMemoryCacheManager)(nil)
+	_ FSCache = (*FSLRUCache)(nil)
+
+	_ FSCacheEntry = (*fsFile)(nil)
 )
 
+// CacheKind is the kind of the file stored in FSCache.
+//
+// Files with distinct content encodings are cached under distinct kinds.
+// JSON index pages are cached under a distinct kind too.
 type CacheKind uint8
 
 const (
@@ -921,11 +1487,27 @@ const (
 	brotliCacheKind
 	gzipCacheKind
 	zstdCacheKind
+	jsonIndexCacheKind
 )
 
-func newCacheManager(fs *FS) cacheManager {
+// newCacheManager returns the cache for the given fs.
+//
+// nil is returned if the cache is disabled.
+func newCacheManager(fs *FS) FSCache {
 	if fs.SkipCache {
-		return &noopCacheManager{}
+		return nil
+	}
+
+	if fs.Cache != nil {
+		if fs.CleanStop == nil {
+			return fs.Cache
+		}
+		cache := &stoppableFSCache{
+			FSCache: fs.Cache,
+			stop:    make(chan struct{}),
+		}
+		go cache.handleCleanStop(fs.CleanStop)
+		return cache
 	}
 
 	cacheDuration := fs.CacheDuration
@@ -939,6 +1521,7 @@ func newCacheManager(fs *FS) cacheManager {
 		cacheBrotli:   make(map[string]*fsFile),
 		cacheGzip:     make(map[string]*fsFile),
 		cacheZstd:     make(map[string]*fsFile),
+		cacheJSON:     make(map[string]*fsFile),
 		cleanStop:     make(chan struct{}),
 	}
 
@@ -947,75 +1530,54 @@ func newCacheManager(fs *FS) cacheManager {
 	return instance
 }
 
-type noopCacheManager struct {
-	cacheLock sync.Mutex
-}
+// stoppableFSCache closes FS.Cache when FS.CleanStop is closed.
+type stoppableFSCache struct {
+	FSCache
 
-func (n *noopCacheManager) Lock() {
-	n.cacheLock.Lock()
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
-func (n *noopCacheManager) Unlock() {
-	n.cacheLock.Unlock()
+func (c *stoppableFSCache) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		c.FSCache.Close()
+	})
 }
 
-func (*noopCacheManager) Close() {}
-
-func (n *noopCacheManager) DecReadersCount(ff *fsFile) {
-	release := false
-
-	n.cacheLock.Lock()
-	ff.readersCount--
-	if ff.readersCount < 0 {
-		n.cacheLock.Unlock()
-		panic("bug: fsFile.readersCount < 0")
-	}
-	if ff.readersCount == 0 {
-		release = true
-	}
-	n.cacheLock.Unlock()
-
-	if release {
-		ff.Release()
+func (c *stoppableFSCache) handleCleanStop(cleanStop chan struct{}) {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case _, stillOpen := <-cleanStop:
+			// Ignore values send on the channel, only stop when it is closed.
+			if !stillOpen {
+				c.Close()
+				return
+			}
+		}
 	}
 }
 
-func (*noopCacheManager) GetFileFromCache(cacheKind CacheKind, path []byte) (*fsFile, bool) {
-	return nil, false
-}
-
-func (n *noopCacheManager) SetFileToCache(cacheKind CacheKind, path []byte, ff *fsFile) *fsFile {
-	n.cacheLock.Lock()
-	ff.readersCount++
-	n.cacheLock.Unlock()
-	return ff
-}
-
+// inMemoryCacheManager evicts files after cacheDuration since they were opened.
 type inMemoryCacheManager struct {
 	cache         map[string]*fsFile
 	cacheBrotli   map[string]*fsFile
 	cacheGzip     map[string]*fsFile
 	cacheZstd     map[string]*fsFile
+	cacheJSON     map[string]*fsFile
 	cacheDuration time.Duration
 	cleanStop     chan struct{}
 	cleanStopOnce sync.Once
-	pendingFiles  []*fsFile
 	closed        bool
 	cacheLock     sync.Mutex
 }
 
-func (cm *inMemoryCacheManager) Lock() {
-	cm.cacheLock.Lock()
-}
-
-func (cm *inMemoryCacheManager) Unlock() {
-	cm.cacheLock.Unlock()
-}
-
 func (cm *inMemoryCacheManager) Close() {
-	filesToRelease := cm.close()
-	for _, ff := range filesToRelease {
-		ff.Release()
+	filesToEvict := cm.close()
+	for _, ff := range filesToEvict {
+		ff.Evict()
 	}
 }
 
@@ -1031,30 +1593,15 @@ func (cm *inMemoryCacheManager) close() []*fsFile {
 
 	cm.cacheLock.Lock()
 	cm.closed = true
-	filesToRelease := cm.collectAllFilesToReleaseNolock(nil)
-	cm.cacheLock.Unlock()
-
-	return filesToRelease
-}
-
-func (cm *inMemoryCacheManager) DecReadersCount(ff *fsFile) {
-	release := false
-
-	cm.cacheLock.Lock()
-	ff.readersCount--
-	if ff.readersCount < 0 {
-		cm.cacheLock.Unlock()
-		panic("bug: fsFile.readersCount < 0")
-	}
-	if cm.closed && ff.readersCount == 0 {
-		release = true
-		cm.removePendingFileNolock(ff)
-	}
+	var filesToEvict []*fsFile
+	filesToEvict = cm.collectCacheFilesNolock(cm.cache, filesToEvict)
+	filesToEvict = cm.collectCacheFilesNolock(cm.cacheBrotli, filesToEvict)
+	filesToEvict = cm.collectCacheFilesNolock(cm.cacheGzip, filesToEvict)
+	filesToEvict = cm.collectCacheFilesNolock(cm.cacheZstd, filesToEvict)
+	filesToEvict = cm.collectCacheFilesNolock(cm.cacheJSON, filesToEvict)
 	cm.cacheLock.Unlock()
 
-	if release {
-		ff.Release()
-	}
+	return filesToEvict
 }
 
 func (cm *inMemoryCacheManager) getFsCache(cacheKind CacheKind) map[string]*fsFile {
@@ -1066,12 +1613,14 @@ func (cm *inMemoryCacheManager) getFsCache(cacheKind CacheKind) map[string]*fsFi
 		fileCache = cm.cacheGzip
 	case zstdCacheKind:
 		fileCache = cm.cacheZstd
+	case jsonIndexCacheKind:
+		fileCache = cm.cacheJSON
 	}
 
 	return fileCache
 }
 
-func (cm *inMemoryCacheManager) GetFileFromCache(cacheKind CacheKind, path []byte) (*fsFile, bool) {
+func (cm *inMemoryCacheManager) Get(cacheKind CacheKind, path []byte) (FSCacheEntry, bool) {
 	cm.cacheLock.Lock()
 	var ff *fsFile
 	var ok bool
@@ -1079,19 +1628,26 @@ func (cm *inMemoryCacheManager) GetFileFromCache(cacheKind CacheKind, path []byt
 		fileCache := cm.getFsCache(cacheKind)
 		ff, ok = fileCache[string(path)]
 	}
-	if ok {
-		ff.readersCount++
-	}
 	cm.cacheLock.Unlock()
 
-	return ff, ok
+	if !ok {
+		return nil, false
+	}
+	return ff, true
 }
 
-func (cm *inMemoryCacheManager) SetFileToCache(cacheKind CacheKind, path []byte, ff *fsFile) *fsFile {
+func (cm *inMemoryCacheManager) Set(cacheKind CacheKind, path []byte, entry FSCacheEntry) FSCacheEntry {
+	ff, ok := entry.(*fsFile)
+	if !ok {
+		// Only files opened by FS are cached.
+		entry.Evict()
+		return entry
+	}
+
 	cm.cacheLock.Lock()
 	if cm.closed {
-		ff.readersCount++
 		cm.cacheLock.Unlock()
+		ff.Evict()
 		return ff
 	}
 
@@ -1099,28 +1655,35 @@ func (cm *inMemoryCacheManager) SetFileToCache(cacheKind CacheKind, path []byte,
 	ff1, ok := fileCache[string(path)]
 	if !ok {
 		fileCache[string(path)] = ff
-		ff.readersCount++
-	} else {
-		ff1.readersCount++
 	}
 	cm.cacheLock.Unlock()
 
 	if ok {
-		// The file has been already opened by another
-		// goroutine, so close the current file and use
-		// the file opened by another goroutine instead.
-		ff.Release()
-		ff = ff1
+		// The file has been already opened by another goroutine.
+		return ff1
 	}
-
 	return ff
 }
 
+func (cm *inMemoryCacheManager) Delete(cacheKind CacheKind, path []byte) {
+	cm.cacheLock.Lock()
+	fileCache := cm.getFsCache(cacheKind)
+	ff, ok := fileCache[string(path)]
+	if ok {
+		delete(fileCache, string(path))
+	}
+	cm.cacheLock.Unlock()
+
+	if ok {
+		ff.Evict()
+	}
+}
+
 func (cm *inMemoryCacheManager) handleCleanCache(cleanStop chan struct{}) {
 	clean := func() {
-		filesToRelease := cm.cleanCache()
-		for _, ff := range filesToRelease {
-			ff.Release()
+		filesToEvict := cm.cleanCache()
+		for _, ff := range filesToEvict {
+			ff.Evict()
 		}
 	}
 
@@ -1136,10 +1699,7 @@ func (cm *inMemoryCacheManager) handleCleanCache(cleanStop chan struct{}) {
 		case _, stillOpen := <-cleanStop:
 			// Ignore values send on the channel, only stop when it is closed.
 			if !stillOpen {
-				filesToRelease := cm.close()
-				for _, ff := range filesToRelease {
-					ff.Release()
-				}
+				cm.Close()
 				return
 			}
 		}
@@ -1147,7 +1707,7 @@ func (cm *inMemoryCacheManager) handleCleanCache(cleanStop chan struct{}) {
 }
 
 func (cm *inMemoryCacheManager) cleanCache() []*fsFile {
-	var filesToRelease []*fsFile
+	var filesToEvict []*fsFile
 
 	cm.cacheLock.Lock()
 	if cm.closed {
@@ -1155,80 +1715,285 @@ func (cm *inMemoryCacheManager) cleanCache() []*fsFile {
 		return nil
 	}
 
-	// Close files which couldn't be closed before due to non-zero
-	// readers count on the previous run.
-	var remainingFiles []*fsFile
-	for _, ff := range cm.pendingFiles {
-		if ff.readersCount > 0 {
-			remainingFiles = append(remainingFiles, ff)
-		} else {
-			filesToRelease = append(filesToRelease, ff)
-		}
-	}
-	cm.pendingFiles = remainingFiles
-
-	filesToRelease = cm.cleanCacheNolock(cm.cache, filesToRelease)
-	filesToRelease = cm.cleanCacheNolock(cm.cacheBrotli, filesToRelease)
-	filesToRelease = cm.cleanCacheNolock(cm.cacheGzip, filesToRelease)
-	filesToRelease = cm.cleanCacheNolock(cm.cacheZstd, filesToRelease)
+	filesToEvict = cm.cleanCacheNolock(cm.cache, filesToEvict)
+	filesToEvict = cm.cleanCacheNolock(cm.cacheBrotli, filesToEvict)
+	filesToEvict = cm.cleanCacheNolock(cm.cacheGzip, filesToEvict)
+	filesToEvict = cm.cleanCacheNolock(cm.cacheZstd, filesToEvict)
+	filesToEvict = cm.cleanCacheNolock(cm.cacheJSON, filesToEvict)
 
 	cm.cacheLock.Unlock()
 
-	return filesToRelease
+	return filesToEvict
 }
 
-func (cm *inMemoryCacheManager) cleanCacheNolock(cache map[string]*fsFile, filesToRelease []*fsFile) []*fsFile {
+func (cm *inMemoryCacheManager) cleanCacheNolock(cache map[string]*fsFile, filesToEvict []*fsFile) []*fsFile {
 	t := time.Now()
 	for k, ff := range cache {
 		if t.Sub(ff.t) > cm.cacheDuration {
-			filesToRelease = cm.addFileToReleaseNolock(filesToRelease, ff)
+			filesToEvict = append(filesToEvict, ff)
 			delete(cache, k)
 		}
 	}
-	return filesToRelease
+	return filesToEvict
+}
+
+func (cm *inMemoryCacheManager) collectCacheFilesNolock(cache map[string]*fsFile, filesToEvict []*fsFile) []*fsFile {
+	for k, ff := range cache {
+		filesToEvict = append(filesToEvict, ff)
+		delete(cache, k)
+	}
+	return filesToEvict
+}
+
+// FSLRUCache is FSCache evicting the least recently used files
+// when the number or the total size of the cached files exceeds the limits.
+//
+// Usage:
+//
+//	fs := &fasthttp.FS{
+//		Root: "/var/www",
+//		Cache: &fasthttp.FSLRUCache{
+//			MaxFiles: 10000,
+//			MaxBytes: 1 << 30,
+//		},
+//	}
+//
+// It is safe calling FSLRUCache methods from concurrently running goroutines.
+type FSLRUCache struct {
+	entries map[fsCacheKey]*list.Element
+	lru     list.List
+
+	// MaxBytes is the maximum total size of the cached files.
+	//
+	// The size of the cached files is unlimited by default.
+	MaxBytes int
+
+	// MaxFiles is the maximum number of the cached files.
+	//
+	// The number of the cached files is unlimited by default.
+	MaxFiles int
+
+	// CacheDuration is the expiration duration for the cached files.
+	//
+	// Expired files are evicted when they are requested.
+	//
+	// Cached files don't expire by default.
+	CacheDuration time.Duration
+
+	bytes     int
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// FSLRUCacheStats contains FSLRUCache statistics.
+type FSLRUCacheStats struct {
+	// Hits is the number of Get calls returning the cached file.
+	Hits uint64
+
+	// Misses is the number of Get calls without the cached file.
+	Misses uint64
+
+	// Evictions is the number of files evicted due to limits or expiration.
+	Evictions uint64
+
+	// Files is the number of the cached files.
+	Files int
+
+	// Bytes is the total size of the cached files.
+	Bytes int
+}
+
+// fsCacheKey is the key of the cached file.
+type fsCacheKey struct {
+	path string
+	kind CacheKind
 }
 
-func (cm *inMemoryCacheManager) collectAllFilesToReleaseNolock(filesToRelease []*fsFile) []*fsFile {
-	filesToRelease = cm.collectCacheFilesToReleaseNolock(cm.cache, filesToRelease)
-	filesToRelease = cm.collectCacheFilesToReleaseNolock(cm.cacheBrotli, filesToRelease)
-	filesToRelease = cm.collectCacheFilesToReleaseNolock(cm.cacheGzip, filesToRelease)
-	filesToRelease = cm.collectCacheFilesToReleaseNolock(cm.cacheZstd, filesToRelease)
+type fsLRUCacheEntry struct {
+	entry   FSCacheEntry
+	created time.Time
+	key     fsCacheKey
+	size    int
+}
 
-	pendingFiles := cm.pendingFiles
-	cm.pendingFiles = nil
-	for _, ff := range pendingFiles {
-		filesToRelease = cm.addFileToReleaseNolock(filesToRelease, ff)
+// Stats returns the cache statistics.
+func (c *FSLRUCache) Stats() FSLRUCacheStats {
+	c.mu.Lock()
+	stats := FSLRUCacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Files:     c.lru.Len(),
+		Bytes:     c.bytes,
 	}
-	return filesToRelease
+	c.mu.Unlock()
+	return stats
 }
 
-func (cm *inMemoryCacheManager) collectCacheFilesToReleaseNolock(cache map[string]*fsFile, filesToRelease []*fsFile) []*fsFile {
-	for k, ff := range cache {
-		filesToRelease = cm.addFileToReleaseNolock(filesToRelease, ff)
-		delete(cache, k)
+// Get implements FSCache.
+func (c *FSLRUCache) Get(kind CacheKind, path []byte) (FSCacheEntry, bool) {
+	var expired FSCacheEntry
+
+	c.mu.Lock()
+	el, ok := c.entries[fsCacheKey{kind: kind, path: string(path)}]
+	if ok {
+		e := el.Value.(*fsLRUCacheEntry) //nolint:forcetypeassert
+		if c.CacheDuration > 0 && time.Since(e.created) > c.CacheDuration {
+			c.removeNolock(el)
+			c.evictions++
+			expired = e.entry
+			ok = false
+		} else {
+			c.lru.MoveToFront(el)
+		}
+	}
+	if ok {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	c.mu.Unlock()
+
+	if expired != nil {
+		expired.Evict()
+	}
+	if !ok {
+		return nil, false
+	}
+	return el.Value.(*fsLRUCacheEntry).entry, true //nolint:forcetypeassert
+}
+
+// Set implements FSCache.
+func (c *FSLRUCache) Set(kind CacheKind, path []byte, entry FSCacheEntry) FSCacheEntry {
+	key := fsCacheKey{kind: kind, path: string(path)}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		entry.Evict()
+		return entry
+	}
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*fsLRUCacheEntry).entry //nolint:forcetypeassert
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[fsCacheKey]*list.Element)
+	}
+	e := &fsLRUCacheEntry{
+		entry:   entry,
+		created: time.Now(),
+		key:     key,
+		size:    entry.Size(),
 	}
-	return filesToRelease
+	c.entries[key] = c.lru.PushFront(e)
+	c.bytes += e.size
+
+	var entriesToEvict []FSCacheEntry
+	for c.lru.Len() > 0 && ((c.MaxFiles > 0 && c.lru.Len() > c.MaxFiles) || (c.MaxBytes > 0 && c.bytes > c.MaxBytes)) {
+		el := c.lru.Back()
+		c.removeNolock(el)
+		c.evictions++
+		entriesToEvict = append(entriesToEvict, el.Value.(*fsLRUCacheEntry).entry) //nolint:forcetypeassert
+	}
+	c.mu.Unlock()
+
+	for _, entry := range entriesToEvict {
+		entry.Evict()
+	}
+	return entry
 }
 
-func (cm *inMemoryCacheManager) addFileToReleaseNolock(filesToRelease []*fsFile, ff *fsFile) []*fsFile {
-	if ff.readersCount > 0 {
-		// There are pending readers on the file handle, so we cannot close it.
-		// Put it into pendingFiles so it will be closed later.
-		cm.pendingFiles = append(cm.pendingFiles, ff)
-		return filesToRelease
+// Delete implements FSCache.
+func (c *FSLRUCache) Delete(kind CacheKind, path []byte) {
+	c.mu.Lock()
+	el, ok := c.entries[fsCacheKey{kind: kind, path: string(path)}]
+	if ok {
+		c.removeNolock(el)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		el.Value.(*fsLRUCacheEntry).entry.Evict() //nolint:forcetypeassert
 	}
-	return append(filesToRelease, ff)
 }
 
-func (cm *inMemoryCacheManager) removePendingFileNolock(ff *fsFile) {
-	for i, pendingFile := range cm.pendingFiles {
-		if pendingFile == ff {
-			copy(cm.pendingFiles[i:], cm.pendingFiles[i+1:])
-			cm.pendingFiles[len(cm.pendingFiles)-1] = nil
-			cm.pendingFiles = cm.pendingFiles[:len(cm.pendingFiles)-1]
-			return
+// Close implements FSCache.
+//
+// The cache cannot be used after Close.
+func (c *FSLRUCache) Close() {
+	c.mu.Lock()
+	c.closed = true
+	entriesToEvict := make([]FSCacheEntry, 0, c.lru.Len())
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		entriesToEvict = append(entriesToEvict, el.Value.(*fsLRUCacheEntry).entry) //nolint:forcetypeassert
+	}
+	c.entries = nil
+	c.lru.Init()
+	c.bytes = 0
+	c.mu.Unlock()
+
+	for _, entry := range entriesToEvict {
+		entry.Evict()
+	}
+}
+
+func (c *FSLRUCache) removeNolock(el *list.Element) {
+	e := c.lru.Remove(el).(*fsLRUCacheEntry) //nolint:forcetypeassert
+	delete(c.entries, e.key)
+	c.bytes -= e.size
+}
+
+// getFileFromCache returns the cached file for the given path
+// with the acquired reference.
+func (h *fsHandler) getFileFromCache(cacheKind CacheKind, path []byte) (*fsFile, bool) {
+	if h.cache == nil {
+		return nil, false
+	}
+	entry, ok := h.cache.Get(cacheKind, path)
+	if !ok {
+		return nil, false
+	}
+	ff, ok := entry.(*fsFile)
+	if !ok || !ff.tryAcquire() {
+		// The file has been evicted concurrently.
+		return nil, false
+	}
+	return ff, true
+}
+
+// setFileToCache stores ff with the acquired reference in the cache
+// and returns the cached file with the acquired reference.
+func (h *fsHandler) setFileToCache(cacheKind CacheKind, path []byte, ff *fsFile) *fsFile {
+	if h.cache == nil {
+		return ff
+	}
+
+	// The reference owned by the cache. It is released by Evict.
+	ff.refs.Add(1)
+	entry := h.cache.Set(cacheKind, path, ff)
+	if entry == FSCacheEntry(ff) {
+		if h.invalidator != nil {
+			h.invalidator.Add(ff.srcPath, ff.srcStat, fsCacheKey{kind: cacheKind, path: string(path)})
 		}
+		return ff
 	}
+
+	// The file hasn't been stored, since the file has been already
+	// opened by another goroutine, so use the file opened by another
+	// goroutine instead.
+	ff.refs.Add(-1)
+	if ff1, ok := entry.(*fsFile); ok && ff1.tryAcquire() {
+		ff.decReadersCount()
+		return ff1
+	}
+	return ff
 }
 
 func (h *fsHandler) pathToFilePath(path []byte, hasTrailingSlash bool) string {
@@ -1305,18 +2070,17 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	} else {
 		path = ctx.Path()
 	}
-	hasTrailingSlash := len(path) > 0 && path[len(path)-1] == '/'
 
 	if n := bytes.IndexByte(path, 0); n >= 0 {
 		ctx.Logger().Printf("cannot serve path with nil byte at position %d: %q", n, path)
-		ctx.Error("Are you a hacker?", StatusBadRequest)
+		h.sendError(ctx, "Are you a hacker?", StatusBadRequest, errPathNilByte)
 		return
 	}
 	// Prevent request paths from reaching NTFS alternate data streams through
 	// the default osFS. Custom filesystems may define their own colon syntax.
 	if _, ok := h.filesystem.(*osFS); ok && hasWindowsReservedPathColon(path, h.root == "") {
 		ctx.Logger().Printf("cannot serve path with a Windows-reserved ':' character: %q", path)
-		ctx.Error("Forbidden", StatusForbidden)
+		h.sendError(ctx, "Forbidden", StatusForbidden, errPathColon)
 		return
 	}
 
@@ -1329,33 +2093,109 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	if h.pathRewrite != nil || filepath.Separator == '\\' {
 		if hasDotDotPathSegment(path) {
 			ctx.Logger().Printf("cannot serve path with '..' path segment due to security reasons: %q", path)
-			ctx.Error("Internal Server Error", StatusInternalServerError)
+			h.sendError(ctx, "Internal Server Error", StatusInternalServerError, errPathDotDot)
 			return
 		}
 	}
 
+	h.servePath(ctx, path, false)
+}
+
+// sendError sends the error response with the given status code.
+//
+// msg is sent if FS.ErrorHandler isn't set.
+func (h *fsHandler) sendError(ctx *RequestCtx, msg string, statusCode int, err error) {
+	if h.errorHandler == nil {
+		ctx.Error(msg, statusCode)
+		return
+	}
+	ctx.Response.Reset()
+	ctx.SetStatusCode(statusCode)
+	h.errorHandler(ctx, statusCode, err)
+}
+
+// mustServeSPAFallback returns true if FS.SPAFallback must be served
+// instead of the not found file requested by ctx.
+func (h *fsHandler) mustServeSPAFallback(ctx *RequestCtx) bool {
+	if h.spaFallback == nil {
+		return false
+	}
+	requestPath := ctx.Path()
+	for _, prefix := range h.spaFallbackExcludePrefixes {
+		if bytes.HasPrefix(requestPath, s2b(prefix)) {
+			return false
+		}
+	}
+	if ext := path.Ext(b2s(requestPath)); ext != "" {
+		for _, excludedExt := range h.spaFallbackExcludeExtensions {
+			if strings.EqualFold(ext, excludedExt) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// setCacheControl sets response headers according to the first
+// FS.CacheControlRules rule matching the given path.
+func (h *fsHandler) setCacheControl(ctx *RequestCtx, path []byte) {
+	if len(h.cacheControlRules) == 0 {
+		return
+	}
+	filePath := b2s(path)
+	for i := range h.cacheControlRules {
+		r := &h.cacheControlRules[i]
+		if !r.rule.matches(filePath) {
+			continue
+		}
+		hdr := &ctx.Response.Header
+		if len(r.cacheControl) > 0 {
+			hdr.setNonSpecial(strCacheControl, r.cacheControl)
+		}
+		if r.rule.Expires && r.rule.MaxAge > 0 {
+			hdr.setNonSpecial(strExpires, AppendHTTPDate(nil, time.Now().Add(r.rule.MaxAge)))
+		}
+		for _, v := range r.rule.Vary {
+			hdr.addVaryBytes(s2b(v))
+		}
+		return
+	}
+}
+
+// servePath serves the file with the given path.
+//
+// isFallback is set if the path is FS.SPAFallback.
+func (h *fsHandler) servePath(ctx *RequestCtx, path []byte, isFallback bool) {
+	hasTrailingSlash := len(path) > 0 && path[len(path)-1] == '/'
+
 	mustCompress := false
 	fileCacheKind := defaultCacheKind
 	fileEncoding := ""
 	byteRange := ctx.Request.Header.peek(strRange)
-	if len(byteRange) == 0 && h.compress {
-		switch {
-		case h.compressBrotli && ctx.Request.Header.HasAcceptEncodingBytes(strBr):
-			mustCompress = true
-			fileCacheKind = brotliCacheKind
-			fileEncoding = "br"
-		case h.compressZstd && ctx.Request.Header.HasAcceptEncodingBytes(strZstd):
-			mustCompress = true
-			fileCacheKind = zstdCacheKind
-			fileEncoding = "zstd"
-		case ctx.Request.Header.HasAcceptEncodingBytes(strGzip):
-			mustCompress = true
-			fileCacheKind = gzipCacheKind
-			fileEncoding = "gzip"
-		}
-	}
-
-	ff, ok := h.cacheManager.GetFileFromCache(fileCacheKind, path)
+	// Byte ranges of compressed responses are relative to the compressed file,
+	// so compressed files are served for range requests too.
+	switch {
+	case h.servesEncoding("br") && ctx.Request.Header.HasAcceptEncodingBytes(strBr):
+		mustCompress = true
+		fileCacheKind = brotliCacheKind
+		fileEncoding = "br"
+	case h.servesEncoding("zstd") && ctx.Request.Header.HasAcceptEncodingBytes(strZstd):
+		mustCompress = true
+		fileCacheKind = zstdCacheKind
+		fileEncoding = "zstd"
+	case h.servesEncoding("gzip") && ctx.Request.Header.HasAcceptEncodingBytes(strGzip):
+		mustCompress = true
+		fileCacheKind = gzipCacheKind
+		fileEncoding = "gzip"
+	}
+	jsonIndex := h.generateIndexPages && h.jsonIndexPages && hasTrailingSlash
+	if jsonIndex && acceptsJSON(ctx.Request.Header.Peek(HeaderAccept)) {
+		mustCompress = false
+		fileCacheKind = jsonIndexCacheKind
+		fileEncoding = ""
+	}
+
+	ff, ok := h.getFileFromCache(fileCacheKind, path)
 	if !ok {
 		filePath := h.pathToFilePath(path, hasTrailingSlash)
 
@@ -1376,13 +2216,19 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 			ff, err = h.openIndexFile(ctx, filePath, mustCompress, fileEncoding)
 			if err != nil {
 				ctx.Logger().Printf("cannot open dir index %q: %v", filePath, err)
-				ctx.Error("Directory index is forbidden", StatusForbidden)
+				h.sendError(ctx, "Directory index is forbidden", StatusForbidden, err)
 				return
 			}
 		} else if err != nil {
+			// Other errors such as permission errors are reported as is,
+			// so they aren't masked by the fallback.
+			if !isFallback && errors.Is(err, fs.ErrNotExist) && h.mustServeSPAFallback(ctx) {
+				h.servePath(ctx, h.spaFallback, true)
+				return
+			}
 			ctx.Logger().Printf("cannot open file %q: %v", filePath, err)
 			if h.pathNotFound == nil {
-				ctx.Error("Cannot open requested path", StatusNotFound)
+				h.sendError(ctx, "Cannot open requested path", StatusNotFound, err)
 			} else {
 				ctx.SetStatusCode(StatusNotFound)
 				h.pathNotFound(ctx)
@@ -1390,12 +2236,41 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 			return
 		}
 
-		ff = h.cacheManager.SetFileToCache(fileCacheKind, path, ff)
+		if ff.srcPath == "" {
+			ff.srcPath = filePath
+		}
+		// The reference owned by the current request.
+		ff.refs.Store(1)
+		ff = h.setFileToCache(fileCacheKind, path, ff)
+	}
+
+	var etag []byte
+	if h.generateETag {
+		var err error
+		etag, err = ff.ETag(h.strongETag, fileEncoding)
+		if err != nil {
+			ff.decReadersCount()
+			ctx.Logger().Printf("cannot generate ETag for path=%q: %v", path, err)
+			h.sendError(ctx, "Internal Server Error", StatusInternalServerError, err)
+			return
+		}
 	}
 
-	if !ctx.IfModifiedSince(ff.lastModified) {
+	// If-Modified-Since is ignored if If-None-Match is present.
+	// See https://www.rfc-editor.org/rfc/rfc9110#section-13.1.3 .
+	var notModified bool
+	if etag != nil && len(ctx.Request.Header.peek(strIfNoneMatch)) > 0 {
+		notModified = !ctx.IfNoneMatch(etag)
+	} else {
+		notModified = !ctx.IfModifiedSince(ff.lastModified)
+	}
+	if notModified {
 		ff.decReadersCount()
 		ctx.NotModified()
+		if etag != nil {
+			ctx.Response.Header.setNonSpecial(strETag, etag)
+		}
+		h.setCacheControl(ctx, path)
 		return
 	}
 
@@ -1403,11 +2278,15 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	if err != nil {
 		ff.decReadersCount()
 		ctx.Logger().Printf("cannot obtain file reader for path=%q: %v", path, err)
-		ctx.Error("Internal Server Error", StatusInternalServerError)
+		h.sendError(ctx, "Internal Server Error", StatusInternalServerError, err)
 		return
 	}
 
 	hdr := &ctx.Response.Header
+	if jsonIndex {
+		hdr.addVaryBytes(strAccept)
+	}
+	h.setCacheControl(ctx, path)
 	if ff.compressed {
 		switch fileEncoding {
 		case "br":
@@ -1426,29 +2305,49 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 	contentLength := ff.contentLength
 	if h.acceptByteRange {
 		hdr.setNonSpecial(strAcceptRanges, strBytes)
-		if len(byteRange) > 0 {
-			startPos, endPos, err := ParseByteRange(byteRange, contentLength)
+		// The whole file is sent if it has been changed since the client
+		// obtained the validator from 'If-Range' header.
+		if len(byteRange) > 0 && ctx.IfRange(etag, ff.lastModified) {
+			ranges, err := ParseByteRanges(nil, byteRange, contentLength)
 			if err != nil {
 				_ = r.(io.Closer).Close() //nolint:forcetypeassert
 				ctx.Logger().Printf("cannot parse byte range %q for path=%q: %v", byteRange, path, err)
-				ctx.Error("Range Not Satisfiable", StatusRequestedRangeNotSatisfiable)
+				h.sendError(ctx, "Range Not Satisfiable", StatusRequestedRangeNotSatisfiable, err)
 				return
 			}
 
-			if err = r.(byteRangeUpdater).UpdateByteRange(startPos, endPos); err != nil { //nolint:forcetypeassert
-				_ = r.(io.Closer).Close() //nolint:forcetypeassert
-				ctx.Logger().Printf("cannot seek byte range %q for path=%q: %v", byteRange, path, err)
-				ctx.Error("Internal Server Error", StatusInternalServerError)
-				return
-			}
+			ranges, ok := coalesceByteRanges(ranges, contentLength)
+			switch {
+			case !ok || len(ranges) > fsMaxByteRanges:
+				// Overlapping or too many ranges may be used for amplifying
+				// the response size, so the whole file is sent instead.
+				// See https://www.rfc-editor.org/rfc/rfc9110#section-14.2 .
+			case len(ranges) == 1:
+				startPos, endPos := ranges[0].Start, ranges[0].End
+				if err = r.(byteRangeUpdater).UpdateByteRange(startPos, endPos); err != nil { //nolint:forcetypeassert
+					_ = r.(io.Closer).Close() //nolint:forcetypeassert
+					ctx.Logger().Printf("cannot seek byte range %q for path=%q: %v", byteRange, path, err)
+					h.sendError(ctx, "Internal Server Error", StatusInternalServerError, err)
+					return
+				}
 
-			hdr.SetContentRange(startPos, endPos, contentLength)
-			contentLength = endPos - startPos + 1
-			statusCode = StatusPartialContent
+				hdr.SetContentRange(startPos, endPos, contentLength)
+				contentLength = endPos - startPos + 1
+				statusCode = StatusPartialContent
+			default:
+				mr := newFSMultiRangeReader(r, ranges, ff.contentType, contentLength)
+				hdr.SetContentType("multipart/byteranges; boundary=" + mr.boundary)
+				r = mr
+				contentLength = mr.contentLength
+				statusCode = StatusPartialContent
+			}
 		}
 	}
 
 	hdr.setNonSpecial(strLastModified, ff.lastModifiedStr)
+	if etag != nil {
+		hdr.setNonSpecial(strETag, etag)
+	}
 	if !ctx.IsHead() {
 		ctx.SetBodyStream(r, contentLength)
 	} else {
@@ -1458,7 +2357,7 @@ func (h *fsHandler) handleRequest(ctx *RequestCtx) {
 		if rc, ok := r.(io.Closer); ok {
 			if err := rc.Close(); err != nil {
 				ctx.Logger().Printf("cannot close file reader: %v", err)
-				ctx.Error("Internal Server Error", StatusInternalServerError)
+				h.sendError(ctx, "Internal Server Error", StatusInternalServerError, err)
 				return
 			}
 		}
@@ -1474,6 +2373,297 @@ type byteRangeUpdater interface {
 	UpdateByteRange(startPos, endPos int) error
 }
 
+// coalesceByteRanges sorts ranges by the start position and merges
+// overlapping and adjacent ranges.
+//
+// false is returned if the total size of ranges exceeds contentLength,
+// i.e. the ranges overlap heavily, so the whole content must be sent instead.
+func coalesceByteRanges(ranges []ByteRange, contentLength int) ([]ByteRange, bool) {
+	total := 0
+	for _, r := range ranges {
+		total += r.End - r.Start + 1
+		if total > contentLength {
+			return ranges, false
+		}
+	}
+	if len(ranges) < 2 {
+		return ranges, true
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End+1 {
+			last.End = max(last.End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged, true
+}
+
+// fsMaxByteRanges is the maximum number of byte ranges
+// sent in multipart/byteranges response.
+const fsMaxByteRanges = 32
+
+// fsMultiRangeReader streams multipart/byteranges response body
+// containing the given byte ranges of the file reader.
+type fsMultiRangeReader struct {
+	// r is bigFileReader or fsSmallFileReader.
+	r io.Reader
+
+	contentType string
+	boundary    string
+	ranges      []ByteRange
+
+	// buf contains the part header left to read.
+	buf []byte
+
+	contentLength int
+	totalLength   int
+
+	// next is the index of the next part.
+	next   int
+	inPart bool
+}
+
+func newFSMultiRangeReader(r io.Reader, ranges []ByteRange, contentType string, totalLength int) *fsMultiRangeReader {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], rand.Uint64()) // #nosec G404
+	mr := &fsMultiRangeReader{
+		r:           r,
+		contentType: contentType,
+		boundary:    hex.EncodeToString(b[:]),
+		ranges:      ranges,
+		totalLength: totalLength,
+	}
+
+	var buf []byte
+	for i, br := range ranges {
+		buf = mr.appendPartHeader(buf[:0], i)
+		mr.contentLength += len(buf) + br.End - br.Start + 1
+	}
+	buf = mr.appendClosingDelimiter(buf[:0])
+	mr.contentLength += len(buf)
+	return mr
+}
+
+func (mr *fsMultiRangeReader) appendPartHeader(dst []byte, i int) []byte {
+	if i > 0 {
+		dst = append(dst, "\r\n"...)
+	}
+	dst = append(dst, "--"...)
+	dst = append(dst, mr.boundary...)
+	dst = append(dst, "\r\n"...)
+	dst = append(dst, HeaderContentType...)
+	dst = append(dst, ": "...)
+	dst = append(dst, mr.contentType...)
+	dst = append(dst, "\r\n"...)
+	dst = append(dst, HeaderContentRange...)
+	dst = append(dst, ": bytes "...)
+	br := mr.ranges[i]
+	dst = AppendUint(dst, br.Start)
+	dst = append(dst, '-')
+	dst = AppendUint(dst, br.End)
+	dst = append(dst, '/')
+	dst = AppendUint(dst, mr.totalLength)
+	return append(dst, "\r\n\r\n"...)
+}
+
+func (mr *fsMultiRangeReader) appendClosingDelimiter(dst []byte) []byte {
+	dst = append(dst, "\r\n--"...)
+	dst = append(dst, mr.boundary...)
+	return append(dst, "--\r\n"...)
+}
+
+// nextPart prepares the header of the next part for reading.
+//
+// false is returned if there are no more parts.
+func (mr *fsMultiRangeReader) nextPart() (bool, error) {
+	i := mr.next
+	if i > len(mr.ranges) {
+		return false, nil
+	}
+	mr.next++
+	if i == len(mr.ranges) {
+		mr.buf = mr.appendClosingDelimiter(mr.buf[:0])
+		return true, nil
+	}
+
+	br := mr.ranges[i]
+	if err := mr.r.(byteRangeUpdater).UpdateByteRange(br.Start, br.End); err != nil { //nolint:forcetypeassert
+		return false, err
+	}
+	mr.buf = mr.appendPartHeader(mr.buf[:0], i)
+	mr.inPart = true
+	return true, nil
+}
+
+func (mr *fsMultiRangeReader) Read(p []byte) (int, error) {
+	for {
+		if len(mr.buf) > 0 {
+			n := copy(p, mr.buf)
+			mr.buf = mr.buf[n:]
+			return n, nil
+		}
+		if mr.inPart {
+			n, err := mr.r.Read(p)
+			if err == io.EOF {
+				mr.inPart = false
+				err = nil
+			}
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		ok, err := mr.nextPart()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, io.EOF
+		}
+	}
+}
+
+// WriteTo writes the remaining parts to w.
+//
+// Part contents are written via the file reader's WriteTo,
+// so sendfile may be used for big files.
+func (mr *fsMultiRangeReader) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for {
+		if len(mr.buf) > 0 {
+			n, err := w.Write(mr.buf)
+			written += int64(n)
+			mr.buf = mr.buf[n:]
+			if err != nil {
+				return written, err
+			}
+		}
+		if mr.inPart {
+			n, err := copyZeroAlloc(w, mr.r)
+			written += n
+			mr.inPart = false
+			if err != nil {
+				return written, err
+			}
+		}
+		ok, err := mr.nextPart()
+		if err != nil {
+			return written, err
+		}
+		if !ok {
+			return written, nil
+		}
+	}
+}
+
+func (mr *fsMultiRangeReader) Close() error {
+	return mr.r.(io.Closer).Close() //nolint:forcetypeassert
+}
+
+// ByteRange is a byte range from 'Range' request header.
+type ByteRange struct {
+	// Start is the position of the first byte in the range.
+	Start int
+
+	// End is the position of the last byte in the range.
+	End int
+}
+
+// ParseByteRanges parses 'Range: bytes=...' header value containing
+// one or more comma-separated byte ranges, appends the parsed ranges to dst
+// and returns the extended dst.
+//
+// Unsatisfiable ranges are skipped. An error is returned if none of the ranges
+// is satisfiable for the given contentLength.
+//
+// It follows https://www.rfc-editor.org/rfc/rfc9110#section-14.1.2 .
+func ParseByteRanges(dst []ByteRange, byteRange []byte, contentLength int) ([]ByteRange, error) {
+	b := byteRange
+	if !bytes.HasPrefix(b, strBytes) {
+		return dst, fmt.Errorf("unsupported range units: %q: expecting %q", byteRange, strBytes)
+	}
+
+	b = b[len(strBytes):]
+	if len(b) == 0 || b[0] != '=' {
+		return dst, fmt.Errorf("missing byte range in %q", byteRange)
+	}
+	b = b[1:]
+
+	n := len(dst)
+	for len(b) > 0 {
+		var spec []byte
+		if m := bytes.IndexByte(b, ','); m >= 0 {
+			spec, b = b[:m], b[m+1:]
+		} else {
+			spec, b = b, nil
+		}
+		spec = bytes.TrimSpace(spec)
+		if len(spec) == 0 {
+			// Empty list elements are allowed.
+			continue
+		}
+
+		br, ok, err := parseByteRangeSpec(spec, contentLength)
+		if err != nil {
+			return dst[:n], fmt.Errorf("cannot parse byte range %q: %w", byteRange, err)
+		}
+		if ok {
+			dst = append(dst, br)
+		}
+	}
+	if len(dst) == n {
+		return dst, fmt.Errorf("byte range %q is not satisfiable for content length %d", byteRange, contentLength)
+	}
+	return dst, nil
+}
+
+// parseByteRangeSpec parses a single byte range from range set.
+//
+// false is returned if the range is valid, but unsatisfiable.
+func parseByteRangeSpec(spec []byte, contentLength int) (ByteRange, bool, error) {
+	n := bytes.IndexByte(spec, '-')
+	if n < 0 {
+		return ByteRange{}, false, fmt.Errorf("missing the end position in %q", spec)
+	}
+
+	if n == 0 {
+		v, err := ParseUint(spec[1:])
+		if err != nil {
+			return ByteRange{}, false, err
+		}
+		if v == 0 || contentLength <= 0 {
+			return ByteRange{}, false, nil
+		}
+		return ByteRange{Start: max(contentLength-v, 0), End: contentLength - 1}, true, nil
+	}
+
+	startPos, err := ParseUint(spec[:n])
+	if err != nil {
+		return ByteRange{}, false, err
+	}
+	endPos := contentLength - 1
+	if len(spec) > n+1 {
+		if endPos, err = ParseUint(spec[n+1:]); err != nil {
+			return ByteRange{}, false, err
+		}
+		if endPos < startPos {
+			return ByteRange{}, false, fmt.Errorf("the start position cannot exceed the end position in %q", spec)
+		}
+		endPos = min(endPos, contentLength-1)
+	}
+	if startPos >= contentLength {
+		return ByteRange{}, false, nil
+	}
+	return ByteRange{Start: startPos, End: endPos}, true, nil
+}
+
 // ParseByteRange parses 'Range: bytes=...' header value.
 //
 // It follows https://www.w3.org/Protocols/rfc2616/rfc2616-sec14.html#sec14.35 .
@@ -1539,13 +2729,19 @@ func (h *fsHandler) openIndexFile(ctx *RequestCtx, dirPath string, mustCompress
 
 		ff, err := h.openFSFile(indexFilePath, mustCompress, fileEncoding)
 		if err == nil {
+			ff.srcPath = indexFilePath
 			return ff, nil
 		}
 		if mustCompress && err == errNoCreatePermission {
 			ctx.Logger().Printf("insufficient permissions for saving compressed file for %q. Serving uncompressed file. "+
 				"Allow write access to the directory with this file in order to improve fasthttp performance", indexFilePath)
 			mustCompress = false
-			return h.openFSFile(indexFilePath, mustCompress, fileEncoding)
+			ff, err = h.openFSFile(indexFilePath, mustCompress, fileEncoding)
+			if err != nil {
+				return nil, err
+			}
+			ff.srcPath = indexFilePath
+			return ff, nil
 		}
 		if !errors.Is(err, fs.ErrNotExist) {
 			return nil, fmt.Errorf("cannot open file %q: %w", indexFilePath, err)
@@ -1556,12 +2752,20 @@ func (h *fsHandler) openIndexFile(ctx *RequestCtx, dirPath string, mustCompress
 		return nil, fmt.Errorf("cannot access directory without index page: directory %q", dirPath)
 	}
 
+	if mustCompress && !h.compressesEncoding(fileEncoding) {
+		// Only precompressed files are served with the given encoding.
+		mustCompress = false
+	}
 	return h.createDirIndex(ctx, dirPath, mustCompress, fileEncoding)
 }
 
 var (
 	errDirIndexRequired   = errors.New("directory index required")
 	errNoCreatePermission = errors.New("no 'create file' permissions")
+
+	errPathNilByte = errors.New("path contains nil byte")
+	errPathColon   = errors.New("path contains Windows-reserved ':' character")
+	errPathDotDot  = errors.New("path contains '..' path segment")
 )
 
 func (h *fsHandler) createDirIndex(ctx *RequestCtx, dirPath string, mustCompress bool, fileEncoding string) (*fsFile, error) {
@@ -1574,26 +2778,32 @@ func (h *fsHandler) createDirIndex(ctx *RequestCtx, dirPath string, mustCompress
 		dirPath = "."
 	}
 
-	basePathEscaped := html.EscapeString(string(base.Path()))
-	_, _ = fmt.Fprintf(w, "<html><head><title>%s</title><style>.dir { font-weight: bold }</style></head><body>", basePathEscaped)
-	_, _ = fmt.Fprintf(w, "<h1>%s</h1>", basePathEscaped)
-	_, _ = fmt.Fprintf(w, "<ul>")
-
-	if len(basePathEscaped) > 1 {
+	index := &FSDirIndex{
+		Path: string(base.Path()),
+	}
+	if len(index.Path) > 1 {
 		var parentURI URI
 		base.CopyTo(&parentURI)
-		parentURI.Update(string(base.Path()) + "/..")
-		parentPathEscaped := html.EscapeString(string(parentURI.Path()))
-		_, _ = fmt.Fprintf(w, `<li><a href="%s" class="dir">..</a></li>`, parentPathEscaped)
+		parentURI.Update(index.Path + "/..")
+		index.ParentPath = string(parentURI.Path())
 	}
 
+	// The directory stats are obtained before reading the entries,
+	// so the entries changed after that are detected.
+	dirInfo, err := fs.Stat(h.filesystem, dirPath)
+	if err != nil {
+		return nil, err
+	}
 	dirEntries, err := fs.ReadDir(h.filesystem, dirPath)
 	if err != nil {
 		return nil, err
 	}
 
-	fm := make(map[string]fs.FileInfo, len(dirEntries))
-	filenames := make([]string, 0, len(dirEntries))
+	var u URI
+	base.CopyTo(&u)
+	u.Update(string(u.Path()) + "/")
+
+	index.Entries = make([]FSDirEntry, 0, len(dirEntries))
 nestedContinue:
 	for _, de := range dirEntries {
 		name := de.Name()
@@ -1603,6 +2813,9 @@ nestedContinue:
 				continue nestedContinue
 			}
 		}
+		if h.indexHideDotFiles && strings.HasPrefix(name, ".") {
+			continue
+		}
 		fi, err := de.Info()
 		if err != nil {
 			ctx.Logger().Printf("cannot fetch information from dir entry %q: %v, skip", name, err)
@@ -1610,31 +2823,37 @@ nestedContinue:
 			continue nestedContinue
 		}
 
-		fm[name] = fi
-		filenames = append(filenames, name)
-	}
-
-	var u URI
-	base.CopyTo(&u)
-	u.Update(string(u.Path()) + "/")
-
-	sort.Strings(filenames)
-	for _, name := range filenames {
 		u.Update(name)
-		pathEscaped := html.EscapeString(string(u.Path()))
-		fi := fm[name]
-		auxStr := "dir"
-		className := "dir"
-		if !fi.IsDir() {
-			auxStr = fmt.Sprintf("file, %d bytes", fi.Size())
-			className = "file"
+		e := FSDirEntry{
+			Name:    name,
+			Path:    string(u.Path()),
+			ModTime: fi.ModTime(),
+			IsDir:   fi.IsDir(),
+		}
+		if !e.IsDir {
+			e.Size = fi.Size()
+		}
+		index.Entries = append(index.Entries, e)
+	}
+	h.sortDirEntries(index.Entries)
+
+	// The cache kind of JSON index pages is selected by handleRequest
+	// using the same conditions.
+	contentType := "text/html; charset=utf-8"
+	switch {
+	case h.jsonIndexPages && acceptsJSON(ctx.Request.Header.Peek(HeaderAccept)):
+		contentType = "application/json"
+		if err := json.NewEncoder(w).Encode(index); err != nil {
+			return nil, err
 		}
-		_, _ = fmt.Fprintf(w, `<li><a href="%s" class="%s">%s</a>, %s, last modified %s</li>`,
-			pathEscaped, className, html.EscapeString(name), auxStr, fsModTime(fi.ModTime()))
+	case h.indexTemplate != nil:
+		if err := h.indexTemplate.Execute(w, index); err != nil {
+			return nil, fmt.Errorf("cannot execute index template: %w", err)
+		}
+	default:
+		writeDirIndexHTML(w, index)
 	}
 
-	_, _ = fmt.Fprintf(w, "</ul></body></html>")
-
 	if mustCompress {
 		var zbuf bytebufferpool.ByteBuffer
 		switch fileEncoding {
@@ -1652,8 +2871,10 @@ nestedContinue:
 	lastModified := time.Now()
 	ff := &fsFile{
 		h:               h,
+		srcPath:         dirPath,
+		srcStat:         newFSFileStat(dirInfo),
 		dirIndex:        dirIndex,
-		contentType:     "text/html; charset=utf-8",
+		contentType:     contentType,
 		contentLength:   len(dirIndex),
 		compressed:      mustCompress,
 		lastModified:    lastModified,
@@ -1664,6 +2885,118 @@ nestedContinue:
 	return ff, nil
 }
 
+// sortDirEntries sorts entries according to FS.IndexSort and FS.IndexSortDesc.
+func (h *fsHandler) sortDirEntries(entries []FSDirEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if h.indexSortDesc {
+			a, b = b, a
+		}
+		switch h.indexSort {
+		case FSIndexSortByModTime:
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		case FSIndexSortBySize:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		}
+		return a.Name < b.Name
+	})
+}
+
+func writeDirIndexHTML(w io.Writer, index *FSDirIndex) {
+	basePathEscaped := html.EscapeString(index.Path)
+	_, _ = fmt.Fprintf(w, "<html><head><title>%s</title><style>.dir { font-weight: bold }</style></head><body>", basePathEscaped)
+	_, _ = fmt.Fprintf(w, "<h1>%s</h1>", basePathEscaped)
+	_, _ = fmt.Fprintf(w, "<ul>")
+
+	if index.ParentPath != "" {
+		parentPathEscaped := html.EscapeString(index.ParentPath)
+		_, _ = fmt.Fprintf(w, `<li><a href="%s" class="dir">..</a></li>`, parentPathEscaped)
+	}
+
+	for i := range index.Entries {
+		e := &index.Entries[i]
+		pathEscaped := html.EscapeString(e.Path)
+		auxStr := "dir"
+		className := "dir"
+		if !e.IsDir {
+			auxStr = fmt.Sprintf("file, %d bytes", e.Size)
+			className = "file"
+		}
+		_, _ = fmt.Fprintf(w, `<li><a href="%s" class="%s">%s</a>, %s, last modified %s</li>`,
+			pathEscaped, className, html.EscapeString(e.Name), auxStr, fsModTime(e.ModTime))
+	}
+
+	_, _ = fmt.Fprintf(w, "</ul></body></html>")
+}
+
+// acceptsJSON returns true if the given Accept header value prefers
+// application/json over text/html.
+func acceptsJSON(accept []byte) bool {
+	if len(accept) == 0 {
+		return false
+	}
+	jsonQ, htmlQ := -1.0, -1.0
+	jsonSpecificity, htmlSpecificity := -1, -1
+	for len(accept) > 0 {
+		var mediaRange []byte
+		if n := bytes.IndexByte(accept, ','); n >= 0 {
+			mediaRange, accept = accept[:n], accept[n+1:]
+		} else {
+			mediaRange, accept = accept, nil
+		}
+
+		q := 1.0
+		if n := bytes.IndexByte(mediaRange, ';'); n >= 0 {
+			params := mediaRange[n+1:]
+			mediaRange = mediaRange[:n]
+			for len(params) > 0 {
+				var param []byte
+				if n := bytes.IndexByte(params, ';'); n >= 0 {
+					param, params = params[:n], params[n+1:]
+				} else {
+					param, params = params, nil
+				}
+				param = bytes.TrimSpace(param)
+				if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+					if v, err := ParseUfloat(param[2:]); err == nil {
+						q = v
+					}
+				}
+			}
+		}
+		mediaRange = bytes.TrimSpace(mediaRange)
+
+		// The most specific media range matching the type determines its quality.
+		switch {
+		case bytes.EqualFold(mediaRange, []byte("*/*")):
+			if jsonSpecificity < 0 {
+				jsonQ, jsonSpecificity = q, 0
+			}
+			if htmlSpecificity < 0 {
+				htmlQ, htmlSpecificity = q, 0
+			}
+		case bytes.EqualFold(mediaRange, []byte("application/*")):
+			if jsonSpecificity < 1 {
+				jsonQ, jsonSpecificity = q, 1
+			}
+		case bytes.EqualFold(mediaRange, []byte("application/json")):
+			jsonQ, jsonSpecificity = q, 2
+		case bytes.EqualFold(mediaRange, []byte("text/*")):
+			if htmlSpecificity < 1 {
+				htmlQ, htmlSpecificity = q, 1
+			}
+		case bytes.EqualFold(mediaRange, []byte("text/html")):
+			htmlQ, htmlSpecificity = q, 2
+		}
+	}
+	// JSON is served only if it is requested explicitly.
+	return jsonSpecificity > 0 && jsonQ > 0 && jsonQ > htmlQ
+}
+
 const (
 	fsMinCompressRatio        = 0.8
 	fsMaxCompressibleFileSize = 8 * 1024 * 1024
@@ -1729,7 +3062,7 @@ func (h *fsHandler) compressFileNolock(
 	// is guarded by file mutex - see getFileLock call.
 	if _, err := os.Stat(compressedFilePath); err == nil {
 		_ = f.Close()
-		return h.newCompressedFSFile(compressedFilePath, fileEncoding)
+		return h.newCompressedFSFile(compressedFilePath, fileEncoding, fileInfo)
 	}
 
 	// Create temporary file, so concurrent goroutines don't use
@@ -1786,7 +3119,7 @@ func (h *fsHandler) compressFileNolock(
 		_ = os.Remove(tmpFilePath)
 		return nil, fmt.Errorf("cannot move compressed file from %q to %q: %w", tmpFilePath, compressedFilePath, err)
 	}
-	return h.newCompressedFSFile(compressedFilePath, fileEncoding)
+	return h.newCompressedFSFile(compressedFilePath, fileEncoding, fileInfo)
 }
 
 // newCompressedFSFileCache use memory cache compressed files.
@@ -1853,6 +3186,7 @@ func (h *fsHandler) newCompressedFSFileCache(f fs.File, fileInfo fs.FileInfo, fi
 		compressed:      true,
 		lastModified:    lastModified,
 		lastModifiedStr: AppendHTTPDate(nil, lastModified),
+		srcStat:         newFSFileStat(fileInfo),
 
 		t: time.Now(),
 	}
@@ -1860,7 +3194,9 @@ func (h *fsHandler) newCompressedFSFileCache(f fs.File, fileInfo fs.FileInfo, fi
 	return ff, nil
 }
 
-func (h *fsHandler) newCompressedFSFile(filePath, fileEncoding string) (*fsFile, error) {
+// newCompressedFSFile opens the compressed file created for the original file
+// with the given srcInfo.
+func (h *fsHandler) newCompressedFSFile(filePath, fileEncoding string, srcInfo fs.FileInfo) (*fsFile, error) {
 	f, err := h.filesystem.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("cannot open compressed file %q: %w", filePath, err)
@@ -1870,10 +3206,47 @@ func (h *fsHandler) newCompressedFSFile(filePath, fileEncoding string) (*fsFile,
 		_ = f.Close()
 		return nil, fmt.Errorf("cannot obtain info for compressed file %q: %w", filePath, err)
 	}
-	return h.newFSFile(f, fileInfo, true, filePath, fileEncoding)
+	ff, err := h.newFSFile(f, fileInfo, true, filePath, fileEncoding)
+	if err != nil {
+		return nil, err
+	}
+	ff.srcStat = newFSFileStat(srcInfo)
+	return ff, nil
+}
+
+// servesEncoding returns true if files may be served with the given encoding.
+func (h *fsHandler) servesEncoding(fileEncoding string) bool {
+	return h.compressesEncoding(fileEncoding) || h.precompressedFileSuffixes[fileEncoding] != ""
+}
+
+// compressesEncoding returns true if files may be compressed on the fly
+// with the given encoding.
+func (h *fsHandler) compressesEncoding(fileEncoding string) bool {
+	if !h.compress {
+		return false
+	}
+	switch fileEncoding {
+	case "br":
+		return h.compressBrotli
+	case "zstd":
+		return h.compressZstd
+	default:
+		return true
+	}
 }
 
 func (h *fsHandler) openFSFile(filePath string, mustCompress bool, fileEncoding string) (*fsFile, error) {
+	if mustCompress && h.precompressedFileSuffixes[fileEncoding] != "" {
+		ff, err := h.openPrecompressedFSFile(filePath, fileEncoding)
+		if err == nil {
+			return ff, nil
+		}
+		if !h.compressesEncoding(fileEncoding) {
+			// Serve the original file, since there is no precompressed file.
+			return h.openFSFile(filePath, false, "")
+		}
+	}
+
 	filePathOriginal := filePath
 	if mustCompress {
 		filePath += h.compressedFileSuffixes[fileEncoding]
@@ -1907,25 +3280,69 @@ func (h *fsHandler) openFSFile(filePath string, mustCompress bool, fileEncoding
 		return nil, errDirIndexRequired
 	}
 
-	if mustCompress {
-		fileInfoOriginal, err := fs.Stat(h.filesystem, filePathOriginal)
-		if err != nil {
-			_ = f.Close()
-			return nil, fmt.Errorf("cannot obtain info for original file %q: %w", filePathOriginal, err)
-		}
+	if !mustCompress {
+		return h.newFSFile(f, fileInfo, false, filePath, fileEncoding)
+	}
 
-		// Only re-create the compressed file if there was more than a second between the mod times.
-		// On macOS the gzip seems to truncate the nanoseconds in the mod time causing the original file
-		// to look newer than the gzipped file.
-		if fileInfoOriginal.ModTime().Sub(fileInfo.ModTime()) >= time.Second {
-			// The compressed file became stale. Re-create it.
-			_ = f.Close()
-			_ = os.Remove(filePath)
-			return h.compressAndOpenFSFile(filePathOriginal, fileEncoding)
+	fileInfoOriginal, err := fs.Stat(h.filesystem, filePathOriginal)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("cannot obtain info for original file %q: %w", filePathOriginal, err)
+	}
+
+	// Only re-create the compressed file if there was more than a second between the mod times.
+	// On macOS the gzip seems to truncate the nanoseconds in the mod time causing the original file
+	// to look newer than the gzipped file.
+	if fileInfoOriginal.ModTime().Sub(fileInfo.ModTime()) >= time.Second {
+		// The compressed file became stale. Re-create it.
+		_ = f.Close()
+		_ = os.Remove(filePath)
+		return h.compressAndOpenFSFile(filePathOriginal, fileEncoding)
+	}
+
+	ff, err := h.newFSFile(f, fileInfo, true, filePath, fileEncoding)
+	if err != nil {
+		return nil, err
+	}
+	ff.srcStat = newFSFileStat(fileInfoOriginal)
+	return ff, nil
+}
+
+// openPrecompressedFSFile opens the precompressed file for the given file.
+//
+// See FS.PrecompressedFileSuffixes for details.
+func (h *fsHandler) openPrecompressedFSFile(filePath, fileEncoding string) (*fsFile, error) {
+	// The precompressed file is cached under the original file path,
+	// so the original file stats are used for cache invalidation.
+	var srcStat fsFileStat
+	if h.invalidator != nil {
+		if fi, err := fs.Stat(h.filesystem, filePath); err == nil {
+			srcStat = newFSFileStat(fi)
 		}
 	}
 
-	return h.newFSFile(f, fileInfo, mustCompress, filePath, fileEncoding)
+	filePath += h.precompressedFileSuffixes[fileEncoding]
+	f, err := h.filesystem.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("cannot obtain info for file %q: %w", filePath, err)
+	}
+	if fileInfo.IsDir() {
+		_ = f.Close()
+		return nil, fmt.Errorf("directory with precompressed file suffix found: %q", filePath)
+	}
+
+	ff, err := h.newFSFile(f, fileInfo, true, filePath, fileEncoding)
+	if err != nil {
+		return nil, err
+	}
+	ff.srcStat = srcStat
+	return ff, nil
 }
 
 func (h *fsHandler) newFSFile(f fs.File, fileInfo fs.FileInfo, compressed bool, filePath, fileEncoding string) (*fsFile, error) {
@@ -1937,7 +3354,11 @@ func (h *fsHandler) newFSFile(f fs.File, fileInfo fs.FileInfo, compressed bool,
 	}
 
 	// detect content-type
-	ext := fileExtension(fileInfo.Name(), compressed, h.compressedFileSuffixes[fileEncoding])
+	compressedFileSuffix := h.compressedFileSuffixes[fileEncoding]
+	if compressed && !strings.HasSuffix(fileInfo.Name(), compressedFileSuffix) {
+		compressedFileSuffix = h.precompressedFileSuffixes[fileEncoding]
+	}
+	ext := fileExtension(fileInfo.Name(), compressed, compressedFileSuffix)
 	contentType := mime.TypeByExtension(ext)
 	if contentType == "" {
 		data, err := readFileHeader(f, compressed, fileEncoding)
@@ -1958,6 +3379,9 @@ func (h *fsHandler) newFSFile(f fs.File, fileInfo fs.FileInfo, compressed bool,
 		lastModified:    lastModified,
 		lastModifiedStr: AppendHTTPDate(nil, lastModified),
 
+		// The stats of the original file are set by the caller for compressed files.
+		srcStat: newFSFileStat(fileInfo),
+
 		t: time.Now(),
 	}
 	return ff, nil
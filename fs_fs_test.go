@@ -4,10 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"embed"
+	"encoding/json"
+	"errors"
+	"html/template"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"testing/fstest"
@@ -1318,3 +1324,518 @@ func TestFSServeRejectsBackslashDotDotSegments(t *testing.T) {
 		})
 	}
 }
+
+func newDirIndexTestFS() fstest.MapFS {
+	now := time.Now()
+	return fstest.MapFS{
+		"dir/b.txt":       {Data: []byte("bb"), ModTime: now.Add(-time.Hour)},
+		"dir/a.txt":       {Data: []byte("aaa"), ModTime: now},
+		"dir/c.txt":       {Data: []byte("c"), ModTime: now.Add(-2 * time.Hour)},
+		"dir/.hidden":     {Data: []byte("hidden"), ModTime: now},
+		"dir/sub/foo.txt": {Data: []byte("foo"), ModTime: now},
+	}
+}
+
+func getDirIndex(t *testing.T, h RequestHandler, accept string) *Response {
+	t.Helper()
+
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("http://foobar.com/dir/")
+	req.Header.Set(HeaderAccept, accept)
+	ctx.Init(&req, nil, TestLogger{t})
+	h(&ctx)
+
+	resp := readResponseFromCtx(t, &ctx, false)
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d. Body %q", resp.StatusCode(), StatusOK, resp.Body())
+	}
+	return resp
+}
+
+func TestFSJSONIndexPages(t *testing.T) {
+	t.Parallel()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	fs := &FS{
+		FS:                 newDirIndexTestFS(),
+		GenerateIndexPages: true,
+		JSONIndexPages:     true,
+		IndexSort:          FSIndexSortBySize,
+		IndexSortDesc:      true,
+		IndexHideDotFiles:  true,
+		CleanStop:          stop,
+	}
+	h := fs.NewRequestHandler()
+
+	for range 2 {
+		resp := getDirIndex(t, h, "application/json")
+		if ct := string(resp.Header.ContentType()); ct != "application/json" {
+			t.Fatalf("unexpected content type %q. Expecting %q", ct, "application/json")
+		}
+		if v := string(resp.Header.Peek(HeaderVary)); v != HeaderAccept {
+			t.Fatalf("unexpected Vary header %q. Expecting %q", v, HeaderAccept)
+		}
+		var index FSDirIndex
+		if err := json.Unmarshal(resp.Body(), &index); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if index.Path != "/dir/" || index.ParentPath != "/" {
+			t.Fatalf("unexpected index paths %q, %q. Expecting %q, %q", index.Path, index.ParentPath, "/dir/", "/")
+		}
+		var names []string
+		for _, e := range index.Entries {
+			names = append(names, e.Name)
+		}
+		expectedNames := []string{"a.txt", "b.txt", "c.txt", "sub"}
+		if !slices.Equal(names, expectedNames) {
+			t.Fatalf("unexpected entries %q. Expecting %q", names, expectedNames)
+		}
+		if e := index.Entries[0]; e.Path != "/dir/a.txt" || e.Size != 3 || e.IsDir || e.ModTime.IsZero() {
+			t.Fatalf("unexpected entry %+v", e)
+		}
+		if e := index.Entries[3]; e.Path != "/dir/sub" || e.Size != 0 || !e.IsDir {
+			t.Fatalf("unexpected entry %+v", e)
+		}
+	}
+
+	// HTML index pages are served to browsers.
+	resp := getDirIndex(t, h, "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	if ct := string(resp.Header.ContentType()); ct != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected content type %q. Expecting %q", ct, "text/html; charset=utf-8")
+	}
+	body := string(resp.Body())
+	if strings.Contains(body, ".hidden") {
+		t.Fatalf("hidden file mustn't be listed on index page %q", body)
+	}
+	if !strings.Contains(body, `<a href="/dir/a.txt" class="file">a.txt</a>, file, 3 bytes`) {
+		t.Fatalf("unexpected index page %q", body)
+	}
+	if a, c := strings.Index(body, "a.txt"), strings.Index(body, "c.txt"); a > c {
+		t.Fatalf("unexpected index page order %q", body)
+	}
+
+	// Hidden files are still served.
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("http://foobar.com/dir/.hidden")
+	ctx.Init(&req, nil, TestLogger{t})
+	h(&ctx)
+	if ctx.Response.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code %d. Expecting %d", ctx.Response.StatusCode(), StatusOK)
+	}
+}
+
+func TestFSIndexTemplate(t *testing.T) {
+	t.Parallel()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	tmpl := template.Must(template.New("index").Parse(
+		`{{.Path}}|{{.ParentPath}}{{range .Entries}}|<a href="{{.Path}}">{{.Name}}</a>{{end}}`))
+	fs := &FS{
+		FS:                 newDirIndexTestFS(),
+		GenerateIndexPages: true,
+		IndexTemplate:      tmpl,
+		IndexSort:          FSIndexSortByModTime,
+		CleanStop:          stop,
+	}
+	h := fs.NewRequestHandler()
+
+	// JSON index pages are disabled, so Accept header is ignored.
+	resp := getDirIndex(t, h, "application/json")
+	// MapFS directories have zero modification time.
+	expectedBody := `/dir/|/|<a href="/dir/sub">sub</a>|<a href="/dir/c.txt">c.txt</a>|<a href="/dir/b.txt">b.txt</a>` +
+		`|<a href="/dir/.hidden">.hidden</a>|<a href="/dir/a.txt">a.txt</a>`
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+	if ct := string(resp.Header.ContentType()); ct != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected content type %q. Expecting %q", ct, "text/html; charset=utf-8")
+	}
+}
+
+func TestAcceptsJSON(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		accept   string
+		expected bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", true},
+		{"Application/JSON", true},
+		{"application/*", true},
+		{"text/html, application/json", false},
+		{"application/json, text/html;q=0.9", true},
+		{"application/json;q=0.5, */*;q=0.8", false},
+		{"application/json, */*;q=0.8", true},
+		{"application/json;q=0, */*", false},
+		{"text/*;q=0.1, application/json;q=0.2", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+	} {
+		if v := acceptsJSON([]byte(tc.accept)); v != tc.expected {
+			t.Fatalf("unexpected result for %q: %v. Expecting %v", tc.accept, v, tc.expected)
+		}
+	}
+}
+
+func TestFSPrecompressedFiles(t *testing.T) {
+	t.Parallel()
+
+	original := strings.Repeat("console.log('original');\n", 10)
+	testFS := fstest.MapFS{
+		"app.js":    {Data: []byte(original)},
+		"app.js.br": {Data: AppendBrotliBytes(nil, []byte("console.log('br');"))},
+		"app.js.gz": {Data: AppendGzipBytes(nil, []byte("console.log('gzip');"))},
+		"plain.txt": {Data: []byte(original)},
+	}
+
+	get := func(h RequestHandler, path, acceptEncoding string) (string, string) {
+		t.Helper()
+
+		var ctx RequestCtx
+		var req Request
+		req.SetRequestURI("http://foobar.com" + path)
+		req.Header.Set(HeaderAcceptEncoding, acceptEncoding)
+		ctx.Init(&req, nil, TestLogger{t})
+		h(&ctx)
+
+		resp := readResponseFromCtx(t, &ctx, false)
+		if resp.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+		}
+		if ct := string(resp.Header.ContentType()); !strings.HasPrefix(ct, "text/") {
+			t.Fatalf("unexpected content type %q for %q", ct, path)
+		}
+		body, err := resp.BodyUncompressed()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return string(resp.Header.ContentEncoding()), string(body)
+	}
+
+	for _, compress := range []bool{false, true} {
+		stop := make(chan struct{})
+		fs := &FS{
+			FS:                        testFS,
+			PrecompressedFileSuffixes: FSPrecompressedFileSuffixes,
+			Compress:                  compress,
+			CleanStop:                 stop,
+		}
+		h := fs.NewRequestHandler()
+
+		for _, tc := range []struct {
+			path             string
+			acceptEncoding   string
+			expectedEncoding string
+			expectedBody     string
+		}{
+			{"/app.js", "gzip, br", "br", "console.log('br');"},
+			{"/app.js", "gzip", "gzip", "console.log('gzip');"},
+			{"/app.js", "", "", original},
+			{"/app.js", "zstd", "", original},
+		} {
+			// Check the cached file too.
+			for range 2 {
+				encoding, body := get(h, tc.path, tc.acceptEncoding)
+				if encoding != tc.expectedEncoding || body != tc.expectedBody {
+					t.Fatalf("unexpected response for %q with Accept-Encoding %q, compress=%v: %q %q. Expecting %q %q",
+						tc.path, tc.acceptEncoding, compress, encoding, body, tc.expectedEncoding, tc.expectedBody)
+				}
+			}
+		}
+
+		// Files without precompressed files are compressed on the fly only if Compress is set.
+		expectedEncoding := ""
+		if compress {
+			expectedEncoding = "gzip"
+		}
+		if encoding, body := get(h, "/plain.txt", "gzip"); encoding != expectedEncoding || body != original {
+			t.Fatalf("unexpected response for %q, compress=%v: %q %q. Expecting %q %q",
+				"/plain.txt", compress, encoding, body, expectedEncoding, original)
+		}
+		close(stop)
+	}
+}
+
+func TestFSSPAFallback(t *testing.T) {
+	t.Parallel()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	fs := &FS{
+		FS: fstest.MapFS{
+			"index.html":    {Data: []byte("<html>app</html>")},
+			"assets/app.js": {Data: []byte("app.js")},
+		},
+		SPAFallback:                  "/index.html",
+		SPAFallbackExcludePrefixes:   []string{"/api/"},
+		SPAFallbackExcludeExtensions: []string{".js", ".css"},
+		CleanStop:                    stop,
+	}
+	h := fs.NewRequestHandler()
+
+	for _, tc := range []struct {
+		path               string
+		expectedBody       string
+		expectedStatusCode int
+	}{
+		{"/users/123", "<html>app</html>", StatusOK},
+		{"/users.v2/123", "<html>app</html>", StatusOK},
+		{"/report.pdf", "<html>app</html>", StatusOK},
+		{"/assets/app.js", "app.js", StatusOK},
+		{"/assets/missing.js", "Cannot open requested path", StatusNotFound},
+		{"/assets/missing.CSS", "Cannot open requested path", StatusNotFound},
+		{"/api/users", "Cannot open requested path", StatusNotFound},
+	} {
+		var ctx RequestCtx
+		var req Request
+		req.SetRequestURI("http://foobar.com" + tc.path)
+		ctx.Init(&req, nil, TestLogger{t})
+		h(&ctx)
+
+		if ctx.Response.StatusCode() != tc.expectedStatusCode {
+			t.Fatalf("unexpected status code %d for %q. Expecting %d", ctx.Response.StatusCode(), tc.path, tc.expectedStatusCode)
+		}
+		resp := readResponseFromCtx(t, &ctx, false)
+		if string(resp.Body()) != tc.expectedBody {
+			t.Fatalf("unexpected body %q for %q. Expecting %q", resp.Body(), tc.path, tc.expectedBody)
+		}
+	}
+}
+
+// permissionDeniedFS returns fs.ErrPermission when opening the given files.
+type permissionDeniedFS struct {
+	fs.FS
+	denied map[string]bool
+}
+
+func (f *permissionDeniedFS) Open(name string) (fs.File, error) {
+	if f.denied[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return f.FS.Open(name)
+}
+
+func TestFSSPAFallbackUnreadableFile(t *testing.T) {
+	t.Parallel()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	var errs []error
+	fsys := &FS{
+		FS: &permissionDeniedFS{
+			FS: fstest.MapFS{
+				"index.html":  {Data: []byte("<html>app</html>")},
+				"secret.html": {Data: []byte("secret")},
+			},
+			denied: map[string]bool{"secret.html": true},
+		},
+		SPAFallback: "/index.html",
+		ErrorHandler: func(ctx *RequestCtx, statusCode int, err error) {
+			errs = append(errs, err)
+			ctx.Error("error", statusCode)
+		},
+		CleanStop: stop,
+	}
+	h := fsys.NewRequestHandler()
+
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("http://foobar.com/secret.html")
+	ctx.Init(&req, nil, TestLogger{t})
+	h(&ctx)
+
+	// The error isn't masked by the fallback.
+	if ctx.Response.StatusCode() == StatusOK || string(ctx.Response.Body()) != "error" {
+		t.Fatalf("unexpected response %d %q. Expecting error", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], fs.ErrPermission) {
+		t.Fatalf("unexpected errors %v. Expecting %v", errs, fs.ErrPermission)
+	}
+}
+
+func TestFSErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	testFS := fstest.MapFS{
+		"dir/foo.txt": {Data: []byte("foo")},
+	}
+	var errs []error
+	errorHandler := func(ctx *RequestCtx, statusCode int, err error) {
+		errs = append(errs, err)
+		ctx.SetContentType("text/html; charset=utf-8")
+		ctx.SetBodyString("<h1>" + strconv.Itoa(statusCode) + "</h1>")
+	}
+	h := (&FS{
+		FS:              testFS,
+		AcceptByteRange: true,
+		ErrorHandler:    errorHandler,
+		CleanStop:       stop,
+	}).NewRequestHandler()
+
+	for _, tc := range []struct {
+		path               string
+		byteRange          string
+		expectedStatusCode int
+	}{
+		{"/missing.txt", "", StatusNotFound},
+		{"/dir/", "", StatusForbidden},
+		{"/dir/foo.txt", "bytes=10-20", StatusRequestedRangeNotSatisfiable},
+		{"/dir/foo%00.txt", "", StatusBadRequest},
+	} {
+		var ctx RequestCtx
+		var req Request
+		req.SetRequestURI("http://foobar.com" + tc.path)
+		if tc.byteRange != "" {
+			req.Header.Set(HeaderRange, tc.byteRange)
+		}
+		ctx.Init(&req, nil, TestLogger{t})
+		h(&ctx)
+
+		resp := readResponseFromCtx(t, &ctx, false)
+		expectedBody := "<h1>" + strconv.Itoa(tc.expectedStatusCode) + "</h1>"
+		if resp.StatusCode() != tc.expectedStatusCode || string(resp.Body()) != expectedBody {
+			t.Fatalf("unexpected response for %q: %d %q. Expecting %d %q",
+				tc.path, resp.StatusCode(), resp.Body(), tc.expectedStatusCode, expectedBody)
+		}
+		if ct := string(resp.Header.ContentType()); ct != "text/html; charset=utf-8" {
+			t.Fatalf("unexpected content type %q. Expecting %q", ct, "text/html; charset=utf-8")
+		}
+	}
+	if len(errs) != 4 || !os.IsNotExist(errs[0]) || errs[3] != errPathNilByte {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	// PathNotFound takes precedence over ErrorHandler.
+	h = (&FS{
+		FS: testFS,
+		PathNotFound: func(ctx *RequestCtx) {
+			ctx.SetBodyString("path not found")
+		},
+		ErrorHandler: errorHandler,
+		CleanStop:    stop,
+	}).NewRequestHandler()
+	var ctx RequestCtx
+	var req Request
+	req.SetRequestURI("http://foobar.com/missing.txt")
+	ctx.Init(&req, nil, TestLogger{t})
+	h(&ctx)
+	if ctx.Response.StatusCode() != StatusNotFound || string(ctx.Response.Body()) != "path not found" {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q",
+			ctx.Response.StatusCode(), ctx.Response.Body(), StatusNotFound, "path not found")
+	}
+}
+
+func TestFSCacheControlRules(t *testing.T) {
+	t.Parallel()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	fs := &FS{
+		FS: fstest.MapFS{
+			"index.html":               {Data: []byte("index")},
+			"assets/app.3f9a1c.js":     {Data: []byte("app")},
+			"assets/app.js":            {Data: []byte("app")},
+			"assets/style.css":         {Data: []byte("style")},
+			"private/report.json":      {Data: []byte("{}")},
+			"downloads/archive.tar.gz": {Data: []byte("archive")},
+		},
+		CacheControlRules: []FSCacheControlRule{
+			FSFingerprintCacheControlRule,
+			{Pattern: "/index.html", NoCache: true},
+			{Regexp: regexp.MustCompile(`^/private/`), Private: true, NoStore: true, Vary: []string{HeaderAuthorization}},
+			{Pattern: "*.js", MaxAge: time.Hour, Expires: true},
+			{Pattern: "/assets/*", MaxAge: 90 * time.Second},
+		},
+		CleanStop: stop,
+	}
+	h := fs.NewRequestHandler()
+
+	for _, tc := range []struct {
+		path                 string
+		expectedCacheControl string
+		expectedVary         string
+		expectedExpires      bool
+	}{
+		{"/assets/app.3f9a1c.js", "max-age=31536000, immutable", "", false},
+		{"/index.html", "no-cache", "", false},
+		{"/private/report.json", "no-store, private", HeaderAuthorization, false},
+		{"/assets/app.js", "max-age=3600", "", true},
+		{"/assets/style.css", "max-age=90", "", false},
+		{"/downloads/archive.tar.gz", "", "", false},
+	} {
+		var ctx RequestCtx
+		var req Request
+		req.SetRequestURI("http://foobar.com" + tc.path)
+		ctx.Init(&req, nil, TestLogger{t})
+		h(&ctx)
+
+		hdr := &ctx.Response.Header
+		if ctx.Response.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code %d for %q. Expecting %d", ctx.Response.StatusCode(), tc.path, StatusOK)
+		}
+		if v := string(hdr.Peek(HeaderCacheControl)); v != tc.expectedCacheControl {
+			t.Fatalf("unexpected Cache-Control %q for %q. Expecting %q", v, tc.path, tc.expectedCacheControl)
+		}
+		if v := string(hdr.Peek(HeaderVary)); v != tc.expectedVary {
+			t.Fatalf("unexpected Vary %q for %q. Expecting %q", v, tc.path, tc.expectedVary)
+		}
+		if expires := hdr.Peek(HeaderExpires); (len(expires) > 0) != tc.expectedExpires {
+			t.Fatalf("unexpected Expires %q for %q", expires, tc.path)
+		} else if len(expires) > 0 {
+			date, err := ParseHTTPDate(expires)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d := time.Until(date); d < 59*time.Minute || d > time.Hour {
+				t.Fatalf("unexpected Expires %q. Expecting an hour from now", expires)
+			}
+		}
+
+		// Cache-Control is sent with 304 responses too.
+		req.Header.Set(HeaderIfModifiedSince, string(hdr.Peek(HeaderLastModified)))
+		ctx.Init(&req, nil, TestLogger{t})
+		h(&ctx)
+		if ctx.Response.StatusCode() != StatusNotModified {
+			t.Fatalf("unexpected status code %d for %q. Expecting %d", ctx.Response.StatusCode(), tc.path, StatusNotModified)
+		}
+		if v := string(ctx.Response.Header.Peek(HeaderCacheControl)); v != tc.expectedCacheControl {
+			t.Fatalf("unexpected Cache-Control %q for %q. Expecting %q", v, tc.path, tc.expectedCacheControl)
+		}
+	}
+}
+
+func TestIsFingerprintedFilePath(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		filePath string
+		expected bool
+	}{
+		{"/app.3f9a1c.js", true},
+		{"/assets/main.0123456789ABCDEF.css", true},
+		{"/app.js", false},
+		{"/app.3f9a1.js", false},
+		{"/app.facade.js", false},
+		{"/app.3f9a1g.js", false},
+		{"/3f9a1c.js", false},
+		{"/.3f9a1c.js", false},
+		{"/3f9a1c.min.js/app", false},
+	} {
+		if v := isFingerprintedFilePath(tc.filePath); v != tc.expected {
+			t.Fatalf("unexpected result for %q: %v. Expecting %v", tc.filePath, v, tc.expected)
+		}
+	}
+}
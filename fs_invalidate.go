@@ -0,0 +1,292 @@
+package fasthttp
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fsWatcher notifies fsCacheInvalidator about changes in the watched directories.
+type fsWatcher interface {
+	// Watch starts watching the given directory.
+	Watch(dir string) error
+
+	// Close stops watching all the directories.
+	Close() error
+}
+
+// fsCacheInvalidator evicts cached files when the original files change.
+//
+// Changes are detected by fsWatcher if it is supported for the served
+// filesystem. Otherwise the cached files are polled for changes.
+type fsCacheInvalidator struct {
+	h *fsHandler
+
+	// watcher is nil if the cached files are polled for changes.
+	// It is reset to nil when the watcher fails.
+	watcher fsWatcher
+
+	// watcherFailed is closed by the watcher if it cannot detect changes
+	// anymore, so the cached files are polled for changes instead.
+	watcherFailed chan struct{}
+
+	// keys contains the cache keys for each original file path.
+	keys map[string][]fsCacheKey
+
+	// stats contains the original file stats obtained when the file
+	// has been opened for the first caching. It is used for polling.
+	stats map[string]fsFileStat
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu sync.Mutex
+}
+
+type fsFileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func newFSFileStat(fi fs.FileInfo) fsFileStat {
+	return fsFileStat{
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+	}
+}
+
+// changed returns true if fi differs from st.
+func (st fsFileStat) changed(fi fs.FileInfo) bool {
+	return !fi.ModTime().Equal(st.modTime) || fi.Size() != st.size
+}
+
+func newFSCacheInvalidator(h *fsHandler, pollInterval time.Duration, cleanStop chan struct{}) *fsCacheInvalidator {
+	inv := &fsCacheInvalidator{
+		h:     h,
+		keys:  make(map[string][]fsCacheKey),
+		stats: make(map[string]fsFileStat),
+		stop:  make(chan struct{}),
+	}
+
+	if _, ok := h.filesystem.(*osFS); ok {
+		// Fall back to polling if notifications aren't available,
+		// e.g. when inotify instances limit is reached.
+		inv.watcherFailed = make(chan struct{})
+		if w, err := newFSWatcher(inv); err == nil {
+			inv.watcher = w
+		}
+	}
+
+	if pollInterval <= 0 {
+		pollInterval = FSInvalidatePollInterval
+	}
+	go inv.run(pollInterval, cleanStop)
+
+	return inv
+}
+
+// Close stops the cache invalidation.
+func (inv *fsCacheInvalidator) Close() {
+	inv.stopOnce.Do(func() {
+		close(inv.stop)
+	})
+}
+
+func (inv *fsCacheInvalidator) run(pollInterval time.Duration, cleanStop chan struct{}) {
+	defer inv.stopWatching()
+
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+	watcherFailed := inv.watcherFailed
+	if inv.watcher != nil {
+		// The cached files are polled only after the watcher fails.
+		t.Stop()
+	} else {
+		watcherFailed = nil
+	}
+
+	for {
+		select {
+		case <-t.C:
+			inv.poll()
+		case <-watcherFailed:
+			watcherFailed = nil
+			inv.stopWatching()
+			t.Reset(pollInterval)
+			// The changes made before the failure may be missed.
+			inv.poll()
+		case <-inv.stop:
+			return
+		case _, stillOpen := <-cleanStop:
+			// Ignore values send on the channel, only stop when it is closed.
+			if !stillOpen {
+				inv.Close()
+				return
+			}
+		}
+	}
+}
+
+// Add registers the cache key of the file with the given original path.
+//
+// st must contain the original file stats obtained when the file has been
+// opened, so the changes made after the opening are detected.
+func (inv *fsCacheInvalidator) Add(srcPath string, st fsFileStat, key fsCacheKey) {
+	if _, ok := inv.h.filesystem.(*osFS); ok {
+		// The watched paths are cleaned.
+		srcPath = filepath.Clean(srcPath)
+	}
+
+	inv.mu.Lock()
+	w := inv.watcher
+	keys, known := inv.keys[srcPath]
+	if !slices.Contains(keys, key) {
+		inv.keys[srcPath] = append(keys, key)
+	}
+	if !known {
+		// The stats are stored even if the file is watched,
+		// so the file is polled if the watcher fails.
+		inv.stats[srcPath] = st
+	}
+	inv.mu.Unlock()
+
+	if known || w == nil {
+		return
+	}
+
+	fi, err := fs.Stat(inv.h.filesystem, srcPath)
+	if err != nil {
+		// The file has been removed after it was opened.
+		inv.Invalidate(srcPath)
+		return
+	}
+
+	// Directory indexes change when directory entries change,
+	// while files change when the parent directory entries change.
+	dir := srcPath
+	if !fi.IsDir() {
+		dir = filepath.Dir(srcPath)
+	}
+	if err := w.Watch(dir); err != nil {
+		// Do not cache files, which cannot be watched.
+		inv.Invalidate(srcPath)
+		return
+	}
+
+	// The file may have been changed after it was opened
+	// and before the watching has been started.
+	fi, err = fs.Stat(inv.h.filesystem, srcPath)
+	if err != nil || st.changed(fi) {
+		inv.Invalidate(srcPath)
+	}
+}
+
+// fallBackToPolling is called by the watcher if it cannot detect
+// changes anymore. It must be called at most once.
+func (inv *fsCacheInvalidator) fallBackToPolling() {
+	close(inv.watcherFailed)
+}
+
+// stopWatching closes the watcher, so the cached files aren't watched anymore.
+func (inv *fsCacheInvalidator) stopWatching() {
+	inv.mu.Lock()
+	w := inv.watcher
+	inv.watcher = nil
+	inv.mu.Unlock()
+
+	if w != nil {
+		_ = w.Close()
+	}
+}
+
+// Invalidate evicts the cached files with the given original path.
+func (inv *fsCacheInvalidator) Invalidate(srcPath string) {
+	inv.mu.Lock()
+	keys := inv.keys[srcPath]
+	delete(inv.keys, srcPath)
+	delete(inv.stats, srcPath)
+	inv.mu.Unlock()
+
+	for _, key := range keys {
+		inv.h.cache.Delete(key.kind, s2b(key.path))
+	}
+}
+
+// InvalidateAll evicts all the cached files registered via Add.
+func (inv *fsCacheInvalidator) InvalidateAll() {
+	inv.mu.Lock()
+	srcPaths := make([]string, 0, len(inv.keys))
+	for srcPath := range inv.keys {
+		srcPaths = append(srcPaths, srcPath)
+	}
+	inv.mu.Unlock()
+
+	for _, srcPath := range srcPaths {
+		inv.Invalidate(srcPath)
+	}
+}
+
+// handleChange is called by fsWatcher when the entry with the given name
+// changes in the watched dir. The name is empty if the dir itself changes.
+func (inv *fsCacheInvalidator) handleChange(dir, name string) {
+	if name != "" {
+		for _, suffix := range inv.h.compressedFileSuffixes {
+			if strings.Contains(name, suffix) {
+				// Compressed files are created by FS itself.
+				return
+			}
+		}
+
+		for _, suffix := range inv.h.precompressedFileSuffixes {
+			if strings.HasSuffix(name, suffix) {
+				// Precompressed files are cached under the original file path.
+				inv.Invalidate(filepath.Join(dir, name[:len(name)-len(suffix)]))
+			}
+		}
+
+		filePath := filepath.Join(dir, name)
+		inv.Invalidate(filePath)
+		inv.removeCompressedFiles(filePath)
+	}
+	inv.Invalidate(dir)
+}
+
+// removeCompressedFiles removes the compressed files created for the given
+// original file, so they are re-created from the changed file.
+//
+// The compressed files are re-created only if the original file is newer
+// by more than a second otherwise. See fsHandler.openFSFile.
+func (inv *fsCacheInvalidator) removeCompressedFiles(filePath string) {
+	// filePath is cleaned, so it is matched against the cleaned root.
+	compressedFilePath := filePath
+	if root := filepath.Clean(inv.h.root); inv.h.root != inv.h.compressRoot && strings.HasPrefix(filePath, root) {
+		compressedFilePath = filepath.Join(inv.h.compressRoot, filePath[len(root):])
+	}
+	for _, suffix := range inv.h.compressedFileSuffixes {
+		_ = os.Remove(compressedFilePath + suffix)
+	}
+}
+
+// poll evicts the cached files, which have been changed since they were cached.
+func (inv *fsCacheInvalidator) poll() {
+	inv.mu.Lock()
+	stats := make(map[string]fsFileStat, len(inv.stats))
+	for srcPath, st := range inv.stats {
+		stats[srcPath] = st
+	}
+	inv.mu.Unlock()
+
+	for srcPath, st := range stats {
+		fi, err := fs.Stat(inv.h.filesystem, srcPath)
+		if err != nil || st.changed(fi) {
+			inv.Invalidate(srcPath)
+			if _, ok := inv.h.filesystem.(*osFS); ok {
+				inv.removeCompressedFiles(srcPath)
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+//go:build linux
+
+package fasthttp
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyWatchMask = unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+var errFSWatcherClosed = errors.New("fs watcher is closed")
+
+// inotifyWatcher detects file changes via inotify.
+type inotifyWatcher struct {
+	inv *fsCacheInvalidator
+
+	// f is used for reading events, so the read is interrupted on Close.
+	f  *os.File
+	fd int
+
+	dirs map[string]int
+	wds  map[int]string
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newFSWatcher(inv *fsCacheInvalidator) (fsWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		inv:  inv,
+		f:    os.NewFile(uintptr(fd), "inotify"),
+		fd:   fd,
+		dirs: make(map[string]int),
+		wds:  make(map[int]string),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Watch(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errFSWatcherClosed
+	}
+	if _, ok := w.dirs[dir]; ok {
+		return nil
+	}
+	wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyWatchMask)
+	if err != nil {
+		return err
+	}
+	// inotify returns the same watch descriptor for the same inode.
+	if oldDir, ok := w.wds[wd]; ok {
+		delete(w.dirs, oldDir)
+	}
+	w.dirs[dir] = wd
+	w.wds[wd] = dir
+	return nil
+}
+
+func (w *inotifyWatcher) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	return w.f.Close()
+}
+
+func (w *inotifyWatcher) readEvents() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			w.mu.Lock()
+			closed := w.closed
+			w.mu.Unlock()
+			if !closed {
+				// Changes cannot be detected anymore because of the error.
+				w.inv.fallBackToPolling()
+			}
+			return
+		}
+		w.handleEvents(buf[:n])
+	}
+}
+
+func (w *inotifyWatcher) handleEvents(b []byte) {
+	for len(b) >= unix.SizeofInotifyEvent {
+		wd := int(int32(binary.NativeEndian.Uint32(b)))
+		mask := binary.NativeEndian.Uint32(b[4:])
+		nameLen := int(binary.NativeEndian.Uint32(b[12:]))
+		if len(b) < unix.SizeofInotifyEvent+nameLen {
+			return
+		}
+		name := b[unix.SizeofInotifyEvent : unix.SizeofInotifyEvent+nameLen]
+		b = b[unix.SizeofInotifyEvent+nameLen:]
+
+		if mask&unix.IN_Q_OVERFLOW != 0 {
+			// Events have been lost.
+			w.inv.InvalidateAll()
+			continue
+		}
+
+		w.mu.Lock()
+		dir, ok := w.wds[wd]
+		if ok && mask&unix.IN_IGNORED != 0 {
+			// The watch has been removed, since the dir has been deleted or unmounted.
+			delete(w.wds, wd)
+			delete(w.dirs, dir)
+		}
+		w.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		// The name is padded with zero bytes.
+		for len(name) > 0 && name[len(name)-1] == 0 {
+			name = name[:len(name)-1]
+		}
+		w.inv.handleChange(dir, string(name))
+	}
+}
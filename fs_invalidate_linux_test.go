@@ -0,0 +1,70 @@
+package fasthttp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFSCacheInvalidatorWatcherFailure(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(filePath, []byte("foo"), 0o600); err != nil {
+		t.Fatalf("cannot write test file: %v", err)
+	}
+
+	h := &fsHandler{
+		filesystem: &osFS{},
+		cache:      &FSLRUCache{MaxFiles: 10},
+	}
+	inv := newFSCacheInvalidator(h, 10*time.Millisecond, nil)
+	defer inv.Close()
+
+	w, ok := inv.watcher.(*inotifyWatcher)
+	if !ok {
+		t.Skip("inotify isn't available")
+	}
+	// Break the watcher without closing it via Close.
+	_ = w.f.Close()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		inv.mu.Lock()
+		stopped := inv.watcher == nil
+		inv.mu.Unlock()
+		if stopped {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the failed watcher hasn't been replaced by polling")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ff := &fsFile{h: h}
+	ff.refs.Store(1)
+	h.cache.Set(defaultCacheKind, []byte("/a.txt"), ff)
+	inv.Add(filePath, newFSFileStat(fi), fsCacheKey{kind: defaultCacheKind, path: "/a.txt"})
+
+	// The changes are detected by polling.
+	if err = os.WriteFile(filePath, []byte("foobar"), 0o600); err != nil {
+		t.Fatalf("cannot write test file: %v", err)
+	}
+	deadline = time.Now().Add(3 * time.Second)
+	for {
+		if _, ok := h.cache.Get(defaultCacheKind, []byte("/a.txt")); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the changed file hasn't been evicted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package fasthttp
+
+import "errors"
+
+// newFSWatcher returns an error on systems without inotify,
+// so the cached files are polled for changes.
+func newFSWatcher(*fsCacheInvalidator) (fsWatcher, error) {
+	return nil, errors.New("fs watcher isn't supported on this system")
+}
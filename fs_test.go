@@ -3,13 +3,18 @@ package fasthttp
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	iofs "io/fs"
 	"math/rand"
+	"mime"
+	"mime/multipart"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"sort"
 	"strings"
@@ -479,6 +484,21 @@ func TestFSByteRangeConcurrentSkipCache(t *testing.T) {
 	})
 }
 
+func TestFSByteRangeConcurrentLRUCache(t *testing.T) {
+	// This test can't run parallel as files in / might be changed by other tests.
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// Files are evicted while they are being read by concurrent requests.
+	runFSByteRangeConcurrent(t, &FS{
+		Root:            ".",
+		Cache:           &FSLRUCache{MaxFiles: 1},
+		AcceptByteRange: true,
+		CleanStop:       stop,
+	})
+}
+
 func runFSByteRangeConcurrent(t *testing.T, fs *FS) {
 	t.Helper()
 
@@ -577,6 +597,476 @@ func TestFSByteRangeZeroLengthSuffixRange(t *testing.T) {
 	}
 }
 
+func TestFSETag(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	body := bytes.Repeat([]byte("foobar\n"), 1000)
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), body, 0o600); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+
+	for _, strong := range []bool{false, true} {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		fs := &FS{
+			Root:         dir,
+			Compress:     true,
+			GenerateETag: true,
+			StrongETag:   strong,
+			CleanStop:    stop,
+		}
+		h := fs.NewRequestHandler()
+
+		serve := func(acceptEncoding, ifNoneMatch, ifModifiedSince string) *Response {
+			var ctx RequestCtx
+			ctx.Init(&Request{}, nil, nil)
+			ctx.Request.SetRequestURI("/foo.txt")
+			if acceptEncoding != "" {
+				ctx.Request.Header.Set(HeaderAcceptEncoding, acceptEncoding)
+			}
+			if ifNoneMatch != "" {
+				ctx.Request.Header.Set(HeaderIfNoneMatch, ifNoneMatch)
+			}
+			if ifModifiedSince != "" {
+				ctx.Request.Header.Set(HeaderIfModifiedSince, ifModifiedSince)
+			}
+			h(&ctx)
+			return readResponseFromCtx(t, &ctx, false)
+		}
+
+		resp := serve("", "", "")
+		etag := string(resp.Header.Peek(HeaderETag))
+		if resp.StatusCode() != StatusOK || !bytes.Equal(resp.Body(), body) {
+			t.Fatalf("unexpected response %d %q", resp.StatusCode(), resp.Body())
+		}
+		if strong {
+			sum := sha256.Sum256(body)
+			expectedETag := `"` + hex.EncodeToString(sum[:16]) + `"`
+			if etag != expectedETag {
+				t.Fatalf("unexpected ETag %q. Expecting %q", etag, expectedETag)
+			}
+		} else if !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("unexpected ETag %q. Expecting weak ETag", etag)
+		}
+
+		resp = serve("gzip", "", "")
+		gzipETag := string(resp.Header.Peek(HeaderETag))
+		if string(resp.Header.ContentEncoding()) != "gzip" {
+			t.Fatalf("unexpected Content-Encoding %q. Expecting %q", resp.Header.ContentEncoding(), "gzip")
+		}
+		if gzipETag == "" || gzipETag == etag {
+			t.Fatalf("unexpected ETag %q for compressed response. Expecting ETag distinct from %q", gzipETag, etag)
+		}
+
+		lastModified := string(resp.Header.Peek(HeaderLastModified))
+		for _, tc := range []struct {
+			acceptEncoding  string
+			ifNoneMatch     string
+			ifModifiedSince string
+			etag            string
+			statusCode      int
+		}{
+			{"", etag, "", etag, StatusNotModified},
+			{"", `"bar", ` + etag, "", etag, StatusNotModified},
+			{"", "*", "", etag, StatusNotModified},
+			{"", gzipETag, "", etag, StatusOK},
+			{"gzip", gzipETag, "", gzipETag, StatusNotModified},
+			{"gzip", etag, "", gzipETag, StatusOK},
+			// If-Modified-Since is ignored if If-None-Match is present.
+			{"", `"bar"`, lastModified, etag, StatusOK},
+			{"", "", lastModified, etag, StatusNotModified},
+		} {
+			resp = serve(tc.acceptEncoding, tc.ifNoneMatch, tc.ifModifiedSince)
+			if resp.StatusCode() != tc.statusCode {
+				t.Fatalf("unexpected status code %d for If-None-Match %q. Expecting %d",
+					resp.StatusCode(), tc.ifNoneMatch, tc.statusCode)
+			}
+			if v := string(resp.Header.Peek(HeaderETag)); v != tc.etag {
+				t.Fatalf("unexpected ETag %q for If-None-Match %q. Expecting %q", v, tc.ifNoneMatch, tc.etag)
+			}
+		}
+	}
+}
+
+func TestFSCompressedByteRange(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	compressDir := t.TempDir()
+	body := bytes.Repeat([]byte("compressible line\n"), 1000)
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), body, 0o600); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+
+	for _, fsys := range []iofs.FS{nil, os.DirFS(dir)} {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		fs := &FS{
+			FS:              fsys,
+			Root:            dir,
+			CompressRoot:    compressDir,
+			Compress:        true,
+			AcceptByteRange: true,
+			CleanStop:       stop,
+		}
+		if fsys != nil {
+			fs.Root = ""
+		}
+		h := fs.NewRequestHandler()
+
+		serve := func(byteRange string) *Response {
+			var ctx RequestCtx
+			ctx.Init(&Request{}, nil, nil)
+			ctx.Request.SetRequestURI("/foo.txt")
+			ctx.Request.Header.Set(HeaderAcceptEncoding, "gzip")
+			if byteRange != "" {
+				ctx.Request.Header.Set(HeaderRange, byteRange)
+			}
+			h(&ctx)
+			return readResponseFromCtx(t, &ctx, false)
+		}
+
+		resp := serve("")
+		if string(resp.Header.ContentEncoding()) != "gzip" {
+			t.Fatalf("unexpected Content-Encoding %q. Expecting %q", resp.Header.ContentEncoding(), "gzip")
+		}
+		compressed := append([]byte(nil), resp.Body()...)
+		if fsys == nil {
+			// The compressed file is stored under CompressRoot.
+			data, err := os.ReadFile(filepath.Join(compressDir, "foo.txt"+FSCompressedFileSuffixes["gzip"]))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(data, compressed) {
+				t.Fatal("unexpected compressed file contents")
+			}
+		}
+		uncompressed, err := AppendGunzipBytes(nil, compressed)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(uncompressed, body) {
+			t.Fatal("unexpected uncompressed body")
+		}
+
+		resp = serve("bytes=10-29")
+		if resp.StatusCode() != StatusPartialContent {
+			t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusPartialContent)
+		}
+		if string(resp.Header.ContentEncoding()) != "gzip" {
+			t.Fatalf("unexpected Content-Encoding %q. Expecting %q", resp.Header.ContentEncoding(), "gzip")
+		}
+		expectedCR := fmt.Sprintf("bytes 10-29/%d", len(compressed))
+		if v := string(resp.Header.Peek(HeaderContentRange)); v != expectedCR {
+			t.Fatalf("unexpected content-range %q. Expecting %q", v, expectedCR)
+		}
+		if !bytes.Equal(resp.Body(), compressed[10:30]) {
+			t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), compressed[10:30])
+		}
+	}
+}
+
+type testFSCacheEntry struct {
+	size    int
+	evicted int
+}
+
+func (e *testFSCacheEntry) Size() int { return e.size }
+func (e *testFSCacheEntry) Evict()    { e.evicted++ }
+
+func TestFSLRUCache(t *testing.T) {
+	t.Parallel()
+
+	c := &FSLRUCache{
+		MaxFiles: 3,
+		MaxBytes: 100,
+	}
+	entries := make([]*testFSCacheEntry, 5)
+	for i := range entries {
+		entries[i] = &testFSCacheEntry{size: 30}
+	}
+
+	for i := range 3 {
+		path := []byte(fmt.Sprintf("/%d", i))
+		if e := c.Set(defaultCacheKind, path, entries[i]); e != entries[i] {
+			t.Fatalf("unexpected entry %v. Expecting %v", e, entries[i])
+		}
+	}
+	// The same path with a different kind is a different file.
+	if _, ok := c.Get(gzipCacheKind, []byte("/0")); ok {
+		t.Fatal("unexpected cached gzip file")
+	}
+	if e, ok := c.Get(defaultCacheKind, []byte("/0")); !ok || e != entries[0] {
+		t.Fatalf("unexpected cached file %v. Expecting %v", e, entries[0])
+	}
+	// The existing entry is returned for the already cached path.
+	if e := c.Set(defaultCacheKind, []byte("/1"), entries[4]); e != entries[1] {
+		t.Fatalf("unexpected entry %v. Expecting %v", e, entries[1])
+	}
+
+	// The least recently used file is evicted due to MaxFiles.
+	c.Set(defaultCacheKind, []byte("/3"), entries[3])
+	if entries[2].evicted != 1 {
+		t.Fatalf("unexpected evictions count %d. Expecting 1", entries[2].evicted)
+	}
+	if _, ok := c.Get(defaultCacheKind, []byte("/2")); ok {
+		t.Fatal("evicted file mustn't be cached")
+	}
+
+	// Files are evicted due to MaxBytes.
+	big := &testFSCacheEntry{size: 70}
+	c.Set(defaultCacheKind, []byte("/big"), big)
+	if entries[0].evicted != 1 || entries[1].evicted != 1 || entries[3].evicted != 0 || big.evicted != 0 {
+		t.Fatalf("unexpected evictions %d, %d, %d, %d. Expecting 1, 1, 0, 0",
+			entries[0].evicted, entries[1].evicted, entries[3].evicted, big.evicted)
+	}
+
+	stats := c.Stats()
+	expectedStats := FSLRUCacheStats{Hits: 1, Misses: 2, Evictions: 3, Files: 2, Bytes: 100}
+	if stats != expectedStats {
+		t.Fatalf("unexpected stats %+v. Expecting %+v", stats, expectedStats)
+	}
+
+	c.Delete(defaultCacheKind, []byte("/3"))
+	if entries[3].evicted != 1 {
+		t.Fatalf("unexpected evictions count %d. Expecting 1", entries[3].evicted)
+	}
+	if _, ok := c.Get(defaultCacheKind, []byte("/3")); ok {
+		t.Fatal("deleted file mustn't be cached")
+	}
+
+	c.Close()
+	if entries[3].evicted != 1 || big.evicted != 1 || entries[4].evicted != 0 {
+		t.Fatalf("unexpected evictions after Close %d, %d, %d. Expecting 1, 1, 0",
+			entries[3].evicted, big.evicted, entries[4].evicted)
+	}
+	// Files passed to Set after Close are evicted immediately.
+	c.Set(defaultCacheKind, []byte("/4"), entries[4])
+	if entries[4].evicted != 1 {
+		t.Fatalf("unexpected evictions count %d. Expecting 1", entries[4].evicted)
+	}
+}
+
+func TestFSLRUCacheExpiration(t *testing.T) {
+	t.Parallel()
+
+	c := &FSLRUCache{
+		CacheDuration: 50 * time.Millisecond,
+	}
+	e := &testFSCacheEntry{size: 1}
+	c.Set(defaultCacheKind, []byte("/foo"), e)
+	if _, ok := c.Get(defaultCacheKind, []byte("/foo")); !ok {
+		t.Fatal("missing cached file")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := c.Get(defaultCacheKind, []byte("/foo")); ok {
+		t.Fatal("expired file mustn't be cached")
+	}
+	if e.evicted != 1 {
+		t.Fatalf("unexpected evictions count %d. Expecting 1", e.evicted)
+	}
+}
+
+func TestFSCustomCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o600); err != nil {
+			t.Fatalf("cannot create test file: %v", err)
+		}
+	}
+
+	stop := make(chan struct{})
+	cache := &FSLRUCache{MaxFiles: 2}
+	fs := &FS{
+		Root:      dir,
+		Cache:     cache,
+		CleanStop: stop,
+	}
+	h := fs.NewRequestHandler()
+
+	for _, name := range []string{"a.txt", "b.txt", "a.txt", "c.txt", "b.txt"} {
+		var ctx RequestCtx
+		ctx.Init(&Request{}, nil, nil)
+		ctx.Request.SetRequestURI("/" + name)
+		h(&ctx)
+		resp := readResponseFromCtx(t, &ctx, false)
+		if resp.StatusCode() != StatusOK || string(resp.Body()) != name {
+			t.Fatalf("unexpected response %d %q. Expecting %d %q", resp.StatusCode(), resp.Body(), StatusOK, name)
+		}
+	}
+
+	stats := cache.Stats()
+	expectedStats := FSLRUCacheStats{Hits: 1, Misses: 4, Evictions: 2, Files: 2, Bytes: 10}
+	if stats != expectedStats {
+		t.Fatalf("unexpected stats %+v. Expecting %+v", stats, expectedStats)
+	}
+
+	// The cache is closed when CleanStop is closed.
+	close(stop)
+	deadline := time.Now().Add(time.Second)
+	for cache.Stats().Files > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the cache hasn't been closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFSInvalidateOnChange(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	stop := make(chan struct{})
+	defer close(stop)
+	fs := &FS{
+		Root:               dir,
+		GenerateIndexPages: true,
+		Compress:           true,
+		InvalidateOnChange: true,
+		CleanStop:          stop,
+	}
+	testFSInvalidateOnChange(t, fs, dir)
+}
+
+func TestFSInvalidateOnChangePolling(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	stop := make(chan struct{})
+	defer close(stop)
+	fs := &FS{
+		FS:                     os.DirFS(dir),
+		GenerateIndexPages:     true,
+		Compress:               true,
+		InvalidateOnChange:     true,
+		InvalidatePollInterval: 20 * time.Millisecond,
+		CleanStop:              stop,
+	}
+	testFSInvalidateOnChange(t, fs, dir)
+}
+
+func TestFSCacheInvalidatorChangeBeforeAdd(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(filePath, []byte("foo"), 0o600); err != nil {
+		t.Fatalf("cannot write test file: %v", err)
+	}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	st := newFSFileStat(fi)
+
+	// The file is changed after it has been opened, but before it is cached.
+	if err = os.WriteFile(filePath, []byte("foobar"), 0o600); err != nil {
+		t.Fatalf("cannot write test file: %v", err)
+	}
+
+	h := &fsHandler{
+		filesystem: os.DirFS(dir),
+		cache:      &FSLRUCache{MaxFiles: 10},
+	}
+	inv := newFSCacheInvalidator(h, 10*time.Millisecond, nil)
+	defer inv.Close()
+
+	ff := &fsFile{h: h}
+	ff.refs.Store(1)
+	h.cache.Set(defaultCacheKind, []byte("/a.txt"), ff)
+	inv.Add("a.txt", st, fsCacheKey{kind: defaultCacheKind, path: "/a.txt"})
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if _, ok := h.cache.Get(defaultCacheKind, []byte("/a.txt")); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the file changed before caching hasn't been evicted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testFSInvalidateOnChange(t *testing.T, fs *FS, dir string) {
+	t.Helper()
+
+	h := fs.NewRequestHandler()
+	filePath := filepath.Join(dir, "a.txt")
+	// Files are replaced atomically, since files truncated in place
+	// may be served with the stale size until the change is detected.
+	writeFile := func(body string) {
+		tmpPath := filepath.Join(t.TempDir(), "a.txt")
+		if err := os.WriteFile(tmpPath, []byte(body), 0o600); err != nil {
+			t.Fatalf("cannot write test file: %v", err)
+		}
+		if err := os.Rename(tmpPath, filePath); err != nil {
+			t.Fatalf("cannot replace test file: %v", err)
+		}
+	}
+	get := func(path string, gzip bool) string {
+		var ctx RequestCtx
+		ctx.Init(&Request{}, nil, nil)
+		ctx.Request.SetRequestURI(path)
+		if gzip {
+			ctx.Request.Header.Set(HeaderAcceptEncoding, "gzip")
+		}
+		h(&ctx)
+		resp := readResponseFromCtx(t, &ctx, false)
+		if resp.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+		}
+		body, err := resp.BodyUncompressed()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return string(body)
+	}
+	waitFor := func(path string, gzip bool, expected func(string) bool) {
+		deadline := time.Now().Add(3 * time.Second)
+		for {
+			body := get(path, gzip)
+			if expected(body) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("stale response %q for %q", body, path)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	oldBody := strings.Repeat("foo bar baz ", 100)
+	newBody := strings.Repeat("foo bar ", 100)
+	writeFile(oldBody)
+
+	if body := get("/a.txt", false); body != oldBody {
+		t.Fatalf("unexpected body %q. Expecting %q", body, oldBody)
+	}
+	if body := get("/a.txt", true); body != oldBody {
+		t.Fatalf("unexpected compressed body %q. Expecting %q", body, oldBody)
+	}
+	if body := get("/", false); strings.Contains(body, "b.txt") {
+		t.Fatalf("unexpected index page %q. It mustn't contain %q", body, "b.txt")
+	}
+
+	// Changed files are served instead of the cached ones.
+	writeFile(newBody)
+	waitFor("/a.txt", false, func(body string) bool { return body == newBody })
+	waitFor("/a.txt", true, func(body string) bool { return body == newBody })
+
+	// Directory index pages are re-generated after directory changes.
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("b"), 0o600); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	waitFor("/", false, func(body string) bool { return strings.Contains(body, "b.txt") })
+}
+
 func testFSByteRange(t *testing.T, h RequestHandler, filePath string) {
 	t.Helper()
 
@@ -712,6 +1202,267 @@ func testParseByteRangeError(t *testing.T, v string, contentLength int) {
 	}
 }
 
+func TestParseByteRanges(t *testing.T) {
+	t.Parallel()
+
+	testParseByteRanges(t, "bytes=0-0", 1, []ByteRange{{0, 0}})
+	testParseByteRanges(t, "bytes=1-2,4-6", 123, []ByteRange{{1, 2}, {4, 6}})
+	testParseByteRanges(t, "bytes=0-9, -5, 20-", 30, []ByteRange{{0, 9}, {25, 29}, {20, 29}})
+	testParseByteRanges(t, "bytes=1-2,,", 10, []ByteRange{{1, 2}})
+
+	// Unsatisfiable ranges are skipped.
+	testParseByteRanges(t, "bytes=100-200,1-2,-0", 10, []ByteRange{{1, 2}})
+
+	// End position exceeding content-length.
+	testParseByteRanges(t, "bytes=5-2345", 10, []ByteRange{{5, 9}})
+
+	testParseByteRangesError(t, "foobar=1-34", 600)
+	testParseByteRangesError(t, "bytes=1234", 1235)
+	testParseByteRangesError(t, "bytes=1-2,foobar", 123)
+	testParseByteRangesError(t, "bytes=1-2,123-34", 1234)
+	testParseByteRangesError(t, "bytes=100-200,300-", 12)
+	testParseByteRangesError(t, "bytes=-5", 0)
+	testParseByteRangesError(t, "bytes=", 10)
+}
+
+func testParseByteRanges(t *testing.T, v string, contentLength int, expectedRanges []ByteRange) {
+	t.Helper()
+
+	ranges, err := ParseByteRanges(nil, []byte(v), contentLength)
+	if err != nil {
+		t.Fatalf("unexpected error: %v. v=%q, contentLength=%d", err, v, contentLength)
+	}
+	if !reflect.DeepEqual(ranges, expectedRanges) {
+		t.Fatalf("unexpected ranges %v. Expecting %v. v=%q, contentLength=%d", ranges, expectedRanges, v, contentLength)
+	}
+}
+
+func testParseByteRangesError(t *testing.T, v string, contentLength int) {
+	t.Helper()
+
+	if _, err := ParseByteRanges(nil, []byte(v), contentLength); err == nil {
+		t.Fatalf("expecting error when parsing byte ranges %q", v)
+	}
+}
+
+func TestFSMultiByteRange(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	smallBody := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	bigBody := bytes.Repeat(smallBody, 1000)
+	if err := os.WriteFile(filepath.Join(dir, "small.txt"), smallBody, 0o600); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "big.txt"), bigBody, 0o600); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	fs := &FS{
+		Root:            dir,
+		AcceptByteRange: true,
+		GenerateETag:    true,
+		StrongETag:      true,
+		CleanStop:       stop,
+	}
+	h := fs.NewRequestHandler()
+
+	for _, tc := range []struct {
+		path string
+		body []byte
+	}{
+		{"/small.txt", smallBody},
+		{"/big.txt", bigBody},
+	} {
+		for range 2 {
+			var ctx RequestCtx
+			ctx.Init(&Request{}, nil, nil)
+			ctx.Request.SetRequestURI(tc.path)
+			ctx.Request.Header.Set(HeaderRange, "bytes=0-3, 10-12, -5")
+			h(&ctx)
+
+			resp := readResponseFromCtx(t, &ctx, false)
+			if resp.StatusCode() != StatusPartialContent {
+				t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusPartialContent)
+			}
+			mediaType, params, err := mime.ParseMediaType(string(resp.Header.ContentType()))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if mediaType != "multipart/byteranges" {
+				t.Fatalf("unexpected content-type %q. Expecting %q", mediaType, "multipart/byteranges")
+			}
+
+			size := len(tc.body)
+			expectedParts := []struct {
+				contentRange string
+				body         []byte
+			}{
+				{fmt.Sprintf("bytes 0-3/%d", size), tc.body[0:4]},
+				{fmt.Sprintf("bytes 10-12/%d", size), tc.body[10:13]},
+				{fmt.Sprintf("bytes %d-%d/%d", size-5, size-1, size), tc.body[size-5:]},
+			}
+			mr := multipart.NewReader(bytes.NewReader(resp.Body()), params["boundary"])
+			for i, expected := range expectedParts {
+				part, err := mr.NextPart()
+				if err != nil {
+					t.Fatalf("cannot read part #%d: %v", i, err)
+				}
+				if v := part.Header.Get(HeaderContentRange); v != expected.contentRange {
+					t.Fatalf("unexpected content-range %q. Expecting %q", v, expected.contentRange)
+				}
+				if v := part.Header.Get(HeaderContentType); v != "text/plain; charset=utf-8" {
+					t.Fatalf("unexpected content-type %q. Expecting %q", v, "text/plain; charset=utf-8")
+				}
+				body, err := io.ReadAll(part)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !bytes.Equal(body, expected.body) {
+					t.Fatalf("unexpected body %q. Expecting %q", body, expected.body)
+				}
+			}
+			if _, err := mr.NextPart(); err != io.EOF {
+				t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+			}
+		}
+	}
+}
+
+func TestFSOverlappingByteRanges(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	body := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), body, 0o600); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	fs := &FS{
+		Root:            dir,
+		AcceptByteRange: true,
+		CleanStop:       stop,
+	}
+	h := fs.NewRequestHandler()
+
+	serve := func(byteRange string) *Response {
+		var ctx RequestCtx
+		ctx.Init(&Request{}, nil, nil)
+		ctx.Request.SetRequestURI("/foo.txt")
+		ctx.Request.Header.Set(HeaderRange, byteRange)
+		h(&ctx)
+		return readResponseFromCtx(t, &ctx, false)
+	}
+
+	// The whole file is sent once instead of amplifying the response.
+	resp := serve("bytes=" + strings.Repeat("0-,", fsMaxByteRanges-1) + "0-")
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusOK)
+	}
+	if !bytes.Equal(resp.Body(), body) {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), body)
+	}
+
+	// Overlapping and adjacent ranges are merged.
+	resp = serve("bytes=10-12, 0-3, 2-5, 6-7, 11-14")
+	if resp.StatusCode() != StatusPartialContent {
+		t.Fatalf("unexpected status code: %d. Expecting %d", resp.StatusCode(), StatusPartialContent)
+	}
+	_, params, err := mime.ParseMediaType(string(resp.Header.ContentType()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mr := multipart.NewReader(bytes.NewReader(resp.Body()), params["boundary"])
+	for _, expected := range []string{"bytes 0-7/36", "bytes 10-14/36"} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v := part.Header.Get(HeaderContentRange); v != expected {
+			t.Fatalf("unexpected content-range %q. Expecting %q", v, expected)
+		}
+	}
+	if _, err = mr.NextPart(); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+
+	// Ranges merged into a single range are sent without multipart.
+	resp = serve("bytes=4-9, 0-4")
+	if resp.StatusCode() != StatusPartialContent || string(resp.Body()) != "0123456789" {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q", resp.StatusCode(), resp.Body(), StatusPartialContent, "0123456789")
+	}
+	if v := string(resp.Header.Peek(HeaderContentRange)); v != "bytes 0-9/36" {
+		t.Fatalf("unexpected content-range %q. Expecting %q", v, "bytes 0-9/36")
+	}
+}
+
+func TestFSIfRange(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	body := []byte("0123456789")
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), body, 0o600); err != nil {
+		t.Fatalf("cannot create test file: %v", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	fs := &FS{
+		Root:            dir,
+		AcceptByteRange: true,
+		GenerateETag:    true,
+		StrongETag:      true,
+		CleanStop:       stop,
+	}
+	h := fs.NewRequestHandler()
+
+	serve := func(ifRange string) *Response {
+		var ctx RequestCtx
+		ctx.Init(&Request{}, nil, nil)
+		ctx.Request.SetRequestURI("/foo.txt")
+		ctx.Request.Header.SetByteRange(2, 4)
+		if ifRange != "" {
+			ctx.Request.Header.Set(HeaderIfRange, ifRange)
+		}
+		h(&ctx)
+		return readResponseFromCtx(t, &ctx, false)
+	}
+
+	resp := serve("")
+	etag := string(resp.Header.Peek(HeaderETag))
+	lastModified := string(resp.Header.Peek(HeaderLastModified))
+	if resp.StatusCode() != StatusPartialContent || string(resp.Body()) != "234" {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q", resp.StatusCode(), resp.Body(), StatusPartialContent, "234")
+	}
+
+	for _, tc := range []struct {
+		ifRange    string
+		statusCode int
+	}{
+		{etag, StatusPartialContent},
+		{lastModified, StatusPartialContent},
+		{`"foo"`, StatusOK},
+		{"W/" + etag, StatusOK},
+		{"Mon, 02 Jan 2006 15:04:05 GMT", StatusOK},
+		{"invalid", StatusOK},
+	} {
+		resp := serve(tc.ifRange)
+		if resp.StatusCode() != tc.statusCode {
+			t.Fatalf("unexpected status code %d for If-Range %q. Expecting %d", resp.StatusCode(), tc.ifRange, tc.statusCode)
+		}
+		if tc.statusCode == StatusOK && !bytes.Equal(resp.Body(), body) {
+			t.Fatalf("unexpected body %q for If-Range %q. Expecting %q", resp.Body(), tc.ifRange, body)
+		}
+	}
+}
+
 func TestFSCompressConcurrent(t *testing.T) {
 	// Don't run this test on Windows, the Windows GitHub actions are too slow and timeout too often.
 	if runtime.GOOS == "windows" {
@@ -96,6 +96,8 @@ const (
 	HeaderTE                              = "TE"
 	HeaderTimingAllowOrigin               = "Timing-Allow-Origin"
 	HeaderTk                              = "Tk"
+	HeaderTraceParent                     = "Traceparent"
+	HeaderTraceState                      = "Tracestate"
 	HeaderTrailer                         = "Trailer"
 	HeaderTransferEncoding                = "Transfer-Encoding"
 	HeaderUpgrade                         = "Upgrade"
@@ -62,6 +62,9 @@ type Request struct {
 	// if <= 0, means not set
 	timeout time.Duration
 
+	// Collects request timings while HostClient with Tracer sends the request.
+	clientTrace *ClientTrace
+
 	secureErrorLogMessage bool
 
 	// Group bool members in order to reduce Request object size.
@@ -1738,6 +1741,23 @@ func (w *statsWriter) WriteString(s string) (int, error) {
 	return n, err
 }
 
+// ReadFrom passes r to the underlying writer, so files are sent via sendfile
+// if the underlying writer is a TCP connection.
+//
+// r is passed to the underlying writer as is, since copyZeroAlloc
+// sends only *os.File readers to *net.TCPConn, while range responses
+// are limited readers of files.
+func (w *statsWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := w.w.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(r)
+		w.bytesWritten += n
+		return n, err
+	}
+	n, err := copyZeroAlloc(w.w, r)
+	w.bytesWritten += n
+	return n, err
+}
+
 func acquireStatsWriter(w io.Writer) *statsWriter {
 	v := statsWriterPool.Get()
 	if v == nil {
@@ -1784,6 +1804,41 @@ func (req *Request) onlyMultipartForm() bool {
 //
 // See also WriteTo.
 func (req *Request) Write(w *bufio.Writer) error {
+	if err := req.prepareHeaderForWrite(); err != nil {
+		return err
+	}
+
+	if req.bodyStream != nil {
+		return req.writeBodyStream(w)
+	}
+
+	body, err := req.bodyForWrite()
+	if err != nil {
+		return err
+	}
+
+	hasBody := false
+	if len(body) != 0 || !req.Header.ignoreBody() {
+		hasBody = true
+		req.Header.SetContentLength(len(body))
+	}
+	if err = req.Header.Write(w); err != nil {
+		return err
+	}
+	if hasBody {
+		_, err = w.Write(body)
+	} else if len(body) > 0 {
+		if req.secureErrorLogMessage {
+			return errors.New("non-zero body for non-post request")
+		}
+		return fmt.Errorf("non-zero body for non-post request: body=%q", body)
+	}
+	return err
+}
+
+// prepareHeaderForWrite sets Host header, RequestURI and Authorization header
+// from the request URI before writing the request.
+func (req *Request) prepareHeaderForWrite() error {
 	if len(req.Header.Host()) == 0 || req.parsedURI {
 		uri := req.URI()
 		host := uri.Host()
@@ -1817,40 +1872,25 @@ func (req *Request) Write(w *bufio.Writer) error {
 		}
 	}
 
-	if req.bodyStream != nil {
-		return req.writeBodyStream(w)
-	}
+	return nil
+}
 
+// bodyForWrite returns the request body to be written,
+// marshaling multipart form or post args if needed.
+func (req *Request) bodyForWrite() ([]byte, error) {
 	body := req.bodyBytes()
-	var err error
 	if req.onlyMultipartForm() {
+		var err error
 		body, err = marshalMultipartForm(req.multipartForm, req.multipartFormBoundary)
 		if err != nil {
-			return fmt.Errorf("error when marshaling multipart form: %w", err)
+			return nil, fmt.Errorf("error when marshaling multipart form: %w", err)
 		}
 		req.Header.SetMultipartFormBoundary(req.multipartFormBoundary)
 	}
-
-	hasBody := false
 	if len(body) == 0 {
 		body = req.postArgs.QueryString()
 	}
-	if len(body) != 0 || !req.Header.ignoreBody() {
-		hasBody = true
-		req.Header.SetContentLength(len(body))
-	}
-	if err = req.Header.Write(w); err != nil {
-		return err
-	}
-	if hasBody {
-		_, err = w.Write(body)
-	} else if len(body) > 0 {
-		if req.secureErrorLogMessage {
-			return errors.New("non-zero body for non-post request")
-		}
-		return fmt.Errorf("non-zero body for non-post request: body=%q", body)
-	}
-	return err
+	return body, nil
 }
 
 // WriteGzip writes response with gzipped body to w.
@@ -2623,6 +2663,8 @@ func writeBodyFixedSize(w *bufio.Writer, r io.Reader, size int64) error {
 			earlyFlush = true
 		case *io.LimitedReader:
 			_, earlyFlush = r.R.(*os.File)
+		case *bigFileReader:
+			_, earlyFlush = r.f.(*os.File)
 		}
 		if earlyFlush {
 			// w buffer must be empty for triggering
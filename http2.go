@@ -0,0 +1,191 @@
+package fasthttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP2Config configures HTTP/2 support.
+//
+// Zero values mean default values for all the fields.
+type HTTP2Config struct {
+	// MaxConcurrentStreams is the maximum number of concurrently
+	// open streams per connection.
+	//
+	// 100 streams are allowed by default.
+	MaxConcurrentStreams uint32
+
+	// MaxReadFrameSize is the maximum frame payload size accepted from peers.
+	//
+	// The value must be in the range [16KB, 16MB]. 1MB is used by default.
+	MaxReadFrameSize uint32
+
+	// InitialStreamWindowSize is the flow control window size advertised
+	// to peers for each stream.
+	//
+	// 1MB is used by default.
+	InitialStreamWindowSize uint32
+
+	// InitialConnWindowSize is the flow control window size advertised
+	// to peers for the whole connection.
+	//
+	// 1MB is used by default.
+	InitialConnWindowSize uint32
+
+	// MaxHeaderListSize is the maximum size of uncompressed headers
+	// accepted from peers.
+	//
+	// 1MB is used by default.
+	MaxHeaderListSize uint32
+
+	// H2C enables cleartext HTTP/2 on non-TLS connections.
+	//
+	// Connections starting with the HTTP/2 connection preface (aka prior
+	// knowledge) and HTTP/1.1 requests with 'Upgrade: h2c' header
+	// are served over HTTP/2.
+	//
+	// Cleartext HTTP/2 is disabled by default.
+	H2C bool
+}
+
+const (
+	http2NextProto = "h2"
+	http2Protocol  = "HTTP/2.0"
+
+	defaultHTTP2MaxConcurrentStreams = 100
+	defaultHTTP2MaxReadFrameSize     = 1 << 20
+	defaultHTTP2WindowSize           = 1 << 20
+	defaultHTTP2MaxHeaderListSize    = 1 << 20
+
+	http2MinFrameSize         = 1 << 14
+	http2MaxFrameSize         = 1<<24 - 1
+	http2MaxWindowSize        = 1<<31 - 1
+	http2InitialWindowSize    = 65535
+	http2InitialHeaderTableSz = 4096
+)
+
+func (conf *HTTP2Config) setDefaults() error {
+	if conf.MaxConcurrentStreams == 0 {
+		conf.MaxConcurrentStreams = defaultHTTP2MaxConcurrentStreams
+	}
+	if conf.MaxReadFrameSize == 0 {
+		conf.MaxReadFrameSize = defaultHTTP2MaxReadFrameSize
+	}
+	if conf.MaxReadFrameSize < http2MinFrameSize || conf.MaxReadFrameSize > http2MaxFrameSize {
+		return fmt.Errorf("HTTP2Config.MaxReadFrameSize must be in the range [%d, %d]; got %d",
+			http2MinFrameSize, http2MaxFrameSize, conf.MaxReadFrameSize)
+	}
+	if conf.InitialStreamWindowSize == 0 {
+		conf.InitialStreamWindowSize = defaultHTTP2WindowSize
+	}
+	if conf.InitialConnWindowSize == 0 {
+		conf.InitialConnWindowSize = defaultHTTP2WindowSize
+	}
+	if conf.InitialStreamWindowSize > http2MaxWindowSize || conf.InitialConnWindowSize > http2MaxWindowSize {
+		return fmt.Errorf("HTTP2Config window sizes mustn't exceed %d", http2MaxWindowSize)
+	}
+	if conf.InitialConnWindowSize < http2InitialWindowSize {
+		return fmt.Errorf("HTTP2Config.InitialConnWindowSize mustn't be smaller than %d", http2InitialWindowSize)
+	}
+	if conf.MaxHeaderListSize == 0 {
+		conf.MaxHeaderListSize = defaultHTTP2MaxHeaderListSize
+	}
+	return nil
+}
+
+var errHTTP2StreamClosed = errors.New("http2: stream closed")
+
+var (
+	strH2C           = []byte("h2c")
+	strHTTP2Settings = []byte("HTTP2-Settings")
+	strTrailers      = []byte("trailers")
+)
+
+// isHTTP2ConnectionHeader returns true for connection-specific headers,
+// which mustn't be sent over HTTP/2.
+//
+// See https://www.rfc-editor.org/rfc/rfc9113#section-8.2.2 .
+func isHTTP2ConnectionHeader(key []byte) bool {
+	return caseInsensitiveCompare(key, strConnection) ||
+		caseInsensitiveCompare(key, strTransferEncoding) ||
+		caseInsensitiveCompare(key, strUpgrade) ||
+		caseInsensitiveCompare(key, s2b(HeaderKeepAlive)) ||
+		caseInsensitiveCompare(key, s2b(HeaderProxyConnection))
+}
+
+// appendHTTP2HeaderKey appends the lowercased key to dst,
+// since HTTP/2 header names must be lowercase.
+func appendHTTP2HeaderKey(dst, key []byte) []byte {
+	n := len(dst)
+	dst = append(dst, key...)
+	lowercaseBytes(dst[n:])
+	return dst
+}
+
+// http2HeaderEncoder encodes header blocks.
+//
+// It isn't safe for concurrent use.
+type http2HeaderEncoder struct {
+	enc  *hpack.Encoder
+	buf  bytes.Buffer
+	bufK []byte
+}
+
+func newHTTP2HeaderEncoder() *http2HeaderEncoder {
+	e := &http2HeaderEncoder{}
+	e.enc = hpack.NewEncoder(&e.buf)
+	return e
+}
+
+func (e *http2HeaderEncoder) reset() {
+	e.buf.Reset()
+}
+
+func (e *http2HeaderEncoder) writeField(key, value string) {
+	e.enc.WriteField(hpack.HeaderField{Name: key, Value: value}) //nolint:errcheck
+}
+
+// writeKV writes the given header after lowercasing its key.
+func (e *http2HeaderEncoder) writeKV(key, value []byte) {
+	e.bufK = appendHTTP2HeaderKey(e.bufK[:0], key)
+	e.writeField(string(e.bufK), string(value))
+}
+
+func (e *http2HeaderEncoder) bytes() []byte {
+	return e.buf.Bytes()
+}
+
+// writeHTTP2HeaderBlock writes the given header block as a HEADERS frame
+// followed by CONTINUATION frames if the block exceeds maxFrameSize.
+func writeHTTP2HeaderBlock(fr *http2.Framer, streamID uint32, endStream bool, block []byte, maxFrameSize uint32) error {
+	first := true
+	for first || len(block) > 0 {
+		chunk := block
+		if uint32(len(chunk)) > maxFrameSize { // #nosec G115
+			chunk = chunk[:maxFrameSize]
+		}
+		block = block[len(chunk):]
+		endHeaders := len(block) == 0
+
+		var err error
+		if first {
+			err = fr.WriteHeaders(http2.HeadersFrameParam{
+				StreamID:      streamID,
+				BlockFragment: chunk,
+				EndStream:     endStream,
+				EndHeaders:    endHeaders,
+			})
+			first = false
+		} else {
+			err = fr.WriteContinuation(streamID, endHeaders, chunk)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1334 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP2Transport is a RoundTripper sending requests over HTTP/2.
+//
+// The "h2" protocol is negotiated via ALPN on TLS connections. Concurrent
+// requests to the same HostClient are multiplexed over a single connection
+// until the server limit on concurrent streams is reached, so new
+// connections are dialed only when all the existing ones are busy.
+//
+// Requests to servers not supporting HTTP/2 are sent via Fallback.
+//
+// Per-request deadlines set via DoDeadline and DoTimeout are honored
+// for every stream separately without affecting other streams
+// on the same connection.
+//
+// Response bodies are always read into memory, so Response.StreamBody
+// and HostClient.StreamResponseBody have no effect.
+//
+// Usage:
+//
+//	c := &fasthttp.HostClient{
+//		Addr:      "example.com:443",
+//		IsTLS:     true,
+//		Transport: &fasthttp.HTTP2Transport{},
+//	}
+//
+// HTTP2Transport may be shared among multiple HostClient instances.
+// It is safe calling HTTP2Transport methods from concurrently running goroutines.
+type HTTP2Transport struct {
+	// Fallback is used for sending requests to servers,
+	// which don't support HTTP/2.
+	//
+	// DefaultTransport is used if not set.
+	Fallback RoundTripper
+
+	// MaxReadFrameSize is the maximum frame payload size accepted from servers.
+	//
+	// The value must be in the range [16KB, 16MB]. 1MB is used by default.
+	MaxReadFrameSize uint32
+
+	// InitialWindowSize is the flow control window size advertised
+	// to servers for each stream and for the whole connection.
+	//
+	// 1MB is used by default.
+	InitialWindowSize uint32
+
+	// MaxHeaderListSize is the maximum size of uncompressed response headers.
+	//
+	// 1MB is used by default.
+	MaxHeaderListSize uint32
+
+	// AllowHTTP enables cleartext HTTP/2 with prior knowledge
+	// for HostClient instances with IsTLS unset.
+	//
+	// Requests to such hosts are sent via Fallback by default.
+	AllowHTTP bool
+
+	mu      sync.Mutex
+	conns   map[*HostClient][]*http2ClientConn
+	dials   map[*HostClient]*http2Dial
+	noHTTP2 map[string]struct{}
+}
+
+var (
+	// ErrHTTP2StreamReset is returned when the server resets the request stream.
+	ErrHTTP2StreamReset = errors.New("http2: stream reset by server")
+
+	errHTTP2NotNegotiated  = errors.New("http2: server doesn't support h2 protocol")
+	errHTTP2ConnClosed     = errors.New("http2: client connection closed")
+	errHTTP2HeaderTooLarge = errors.New("http2: response header too large")
+)
+
+const http2MaxStreamID = 1<<31 - 1
+
+// http2Dial is an in-flight dial shared by concurrent requests.
+type http2Dial struct {
+	done chan struct{}
+	err  error
+}
+
+// RoundTrip implements RoundTripper.
+func (t *HTTP2Transport) RoundTrip(hc *HostClient, req *Request, resp *Response) (retry bool, err error) {
+	if !hc.IsTLS && !t.AllowHTTP {
+		return t.fallback().RoundTrip(hc, req, resp)
+	}
+
+	var deadline time.Time
+	if req.timeout > 0 {
+		deadline = time.Now().Add(req.timeout)
+	}
+
+	cc, err := t.acquireConn(hc, deadline)
+	if err == errHTTP2NotNegotiated {
+		return t.fallback().RoundTrip(hc, req, resp)
+	}
+	if err != nil {
+		return false, err
+	}
+	return cc.roundTrip(req, resp, deadline)
+}
+
+// CloseIdleConnections closes connections without active requests.
+func (t *HTTP2Transport) CloseIdleConnections() {
+	t.mu.Lock()
+	var idle []*http2ClientConn
+	for _, conns := range t.conns {
+		for _, cc := range conns {
+			if cc.closeIfIdle() {
+				idle = append(idle, cc)
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	for _, cc := range idle {
+		cc.c.Close()
+	}
+}
+
+func (t *HTTP2Transport) fallback() RoundTripper {
+	if t.Fallback == nil {
+		return DefaultTransport
+	}
+	return t.Fallback
+}
+
+func (t *HTTP2Transport) config() (HTTP2Config, error) {
+	conf := HTTP2Config{
+		MaxReadFrameSize:        t.MaxReadFrameSize,
+		InitialStreamWindowSize: t.InitialWindowSize,
+		InitialConnWindowSize:   t.InitialWindowSize,
+		MaxHeaderListSize:       t.MaxHeaderListSize,
+	}
+	err := conf.setDefaults()
+	return conf, err
+}
+
+// acquireConn returns a connection with a reserved stream.
+//
+// Concurrent requests share a single dial to the host.
+func (t *HTTP2Transport) acquireConn(hc *HostClient, deadline time.Time) (*http2ClientConn, error) {
+	for {
+		t.mu.Lock()
+		for _, cc := range t.conns[hc] {
+			if cc.reserveStream() {
+				t.mu.Unlock()
+				return cc, nil
+			}
+		}
+
+		d := t.dials[hc]
+		if d == nil {
+			addr := hc.nextAddr()
+			if _, ok := t.noHTTP2[addr]; ok {
+				t.mu.Unlock()
+				return nil, errHTTP2NotNegotiated
+			}
+			d = &http2Dial{
+				done: make(chan struct{}),
+			}
+			if t.dials == nil {
+				t.dials = make(map[*HostClient]*http2Dial)
+			}
+			t.dials[hc] = d
+			t.mu.Unlock()
+
+			var cc *http2ClientConn
+			cc, d.err = t.dialConn(hc, addr, deadline)
+
+			t.mu.Lock()
+			delete(t.dials, hc)
+			switch {
+			case d.err == nil:
+				if t.conns == nil {
+					t.conns = make(map[*HostClient][]*http2ClientConn)
+				}
+				t.conns[hc] = append(t.conns[hc], cc)
+			case d.err == errHTTP2NotNegotiated:
+				if t.noHTTP2 == nil {
+					t.noHTTP2 = make(map[string]struct{})
+				}
+				t.noHTTP2[addr] = struct{}{}
+			}
+			close(d.done)
+			t.mu.Unlock()
+
+			if d.err != nil {
+				return nil, d.err
+			}
+			go cc.readLoop()
+			continue
+		}
+		t.mu.Unlock()
+
+		if deadline.IsZero() {
+			<-d.done
+		} else {
+			tc := AcquireTimer(time.Until(deadline))
+			select {
+			case <-d.done:
+				ReleaseTimer(tc)
+			case <-tc.C:
+				ReleaseTimer(tc)
+				return nil, ErrTimeout
+			}
+		}
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+}
+
+func (t *HTTP2Transport) removeConn(cc *http2ClientConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conns := t.conns[cc.hc]
+	if i := slices.Index(conns, cc); i >= 0 {
+		conns = slices.Delete(conns, i, i+1)
+	}
+	if len(conns) == 0 {
+		delete(t.conns, cc.hc)
+	} else {
+		t.conns[cc.hc] = conns
+	}
+}
+
+func (t *HTTP2Transport) dialConn(hc *HostClient, addr string, deadline time.Time) (*http2ClientConn, error) {
+	conf, err := t.config()
+	if err != nil {
+		return nil, err
+	}
+
+	var dialTimeout time.Duration
+	if !deadline.IsZero() {
+		dialTimeout = time.Until(deadline)
+		if dialTimeout <= 0 {
+			return nil, ErrTimeout
+		}
+	}
+	c, err := callDialFunc(addr, hc.Dial, hc.DialTimeout, hc.DialDualStack, hc.IsTLS, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, errors.New("dialling unsuccessful: please report this bug")
+	}
+
+	if hc.IsTLS {
+		tlsConfig, err := hc.cachedTLSConfig(addr)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		handshakeDeadline := deadline
+		if hc.WriteTimeout > 0 {
+			d := time.Now().Add(hc.WriteTimeout)
+			if handshakeDeadline.IsZero() || d.Before(handshakeDeadline) {
+				handshakeDeadline = d
+			}
+		}
+		if c, err = http2ClientHandshake(c, tlsConfig, handshakeDeadline); err != nil {
+			return nil, err
+		}
+	}
+
+	cc := newHTTP2ClientConn(t, hc, c, &conf)
+	if err = cc.writePreface(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// http2TLSConn is implemented by TLS connections exposing the negotiated protocol.
+type http2TLSConn interface {
+	net.Conn
+	Handshake() error
+	ConnectionState() tls.ConnectionState
+}
+
+// http2ClientHandshake performs TLS handshake on c, offering h2 protocol
+// via ALPN.
+//
+// errHTTP2NotNegotiated is returned if the server doesn't support HTTP/2.
+func http2ClientHandshake(c net.Conn, tlsConfig *tls.Config, deadline time.Time) (_ net.Conn, retErr error) {
+	defer func() {
+		if retErr != nil {
+			c.Close()
+		}
+	}()
+
+	// We assume that any conn that has the Handshake() method is a TLS conn already.
+	if _, ok := c.(interface{ Handshake() error }); !ok {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{http2NextProto, "http/1.1"}
+		c = tls.Client(c, tlsConfig)
+	}
+	tc, ok := c.(http2TLSConn)
+	if !ok {
+		return nil, errHTTP2NotNegotiated
+	}
+
+	if err := tc.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	err := tc.Handshake()
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return nil, ErrTLSHandshakeTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = tc.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	if tc.ConnectionState().NegotiatedProtocol != http2NextProto {
+		return nil, errHTTP2NotNegotiated
+	}
+	return tc, nil
+}
+
+// http2ClientStream holds the state of a single request stream.
+type http2ClientStream struct {
+	resp *Response
+
+	// done is closed when the response is completely read or on error.
+	done  chan struct{}
+	err   error
+	retry bool
+
+	// The following fields are guarded by http2ClientConn.mu.
+	sendWindow    int32
+	recvWindow    int32
+	recvUnacked   int32
+	contentLength int
+	maxBodySize   int
+	bodySize      int
+	gotHeaders    bool
+	skipBody      bool
+
+	id uint32
+}
+
+// http2ClientConn is a client side HTTP/2 connection.
+//
+// Frames are read by a single reader goroutine, while requests are
+// written by the goroutines calling RoundTrip.
+type http2ClientConn struct {
+	connTime time.Time
+
+	t    *HTTP2Transport
+	hc   *HostClient
+	c    net.Conn
+	conf *HTTP2Config
+
+	// wmu guards the framer writes, the bw and the header encoder.
+	wmu      sync.Mutex
+	bw       *bufio.Writer
+	fr       *http2.Framer
+	enc      *http2HeaderEncoder
+	writeErr error
+
+	// cond is signaled on flow control window changes, stream completion
+	// and the connection close.
+	cond *sync.Cond
+
+	// mu guards the fields below.
+	mu                    sync.Mutex
+	streams               map[uint32]*http2ClientStream
+	reserved              int
+	nextStreamID          uint32
+	maxConcurrentStreams  uint32
+	sendWindow            int32
+	peerInitialWindowSize int32
+	goingAway             bool
+	closed                bool
+
+	peerMaxFrameSize atomic.Uint32
+
+	// The following fields are accessed only by the reader goroutine.
+	recvWindow  int32
+	recvUnacked int32
+}
+
+func newHTTP2ClientConn(t *HTTP2Transport, hc *HostClient, c net.Conn, conf *HTTP2Config) *http2ClientConn {
+	readBufferSize := hc.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	writeBufferSize := hc.WriteBufferSize
+	if writeBufferSize <= 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+
+	cc := &http2ClientConn{
+		connTime:              time.Now(),
+		t:                     t,
+		hc:                    hc,
+		c:                     c,
+		conf:                  conf,
+		bw:                    bufio.NewWriterSize(c, writeBufferSize),
+		enc:                   newHTTP2HeaderEncoder(),
+		streams:               make(map[uint32]*http2ClientStream),
+		nextStreamID:          1,
+		maxConcurrentStreams:  defaultHTTP2MaxConcurrentStreams,
+		sendWindow:            http2InitialWindowSize,
+		peerInitialWindowSize: http2InitialWindowSize,
+		recvWindow:            int32(conf.InitialConnWindowSize), // #nosec G115
+	}
+	cc.cond = sync.NewCond(&cc.mu)
+	cc.peerMaxFrameSize.Store(http2MinFrameSize)
+
+	cc.fr = http2.NewFramer(cc.bw, bufio.NewReaderSize(c, readBufferSize))
+	cc.fr.SetReuseFrames()
+	cc.fr.SetMaxReadFrameSize(conf.MaxReadFrameSize)
+	cc.fr.MaxHeaderListSize = conf.MaxHeaderListSize
+	cc.fr.ReadMetaHeaders = hpack.NewDecoder(http2InitialHeaderTableSz, nil)
+	return cc
+}
+
+func (cc *http2ClientConn) writePreface() error {
+	conf := cc.conf
+	return cc.write(func(fr *http2.Framer) error {
+		if _, err := cc.bw.WriteString(http2.ClientPreface); err != nil {
+			return err
+		}
+		err := fr.WriteSettings(
+			http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+			http2.Setting{ID: http2.SettingMaxFrameSize, Val: conf.MaxReadFrameSize},
+			http2.Setting{ID: http2.SettingInitialWindowSize, Val: conf.InitialStreamWindowSize},
+			http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: conf.MaxHeaderListSize},
+		)
+		if err != nil {
+			return err
+		}
+		if n := conf.InitialConnWindowSize - http2InitialWindowSize; n > 0 {
+			return fr.WriteWindowUpdate(0, n)
+		}
+		return nil
+	})
+}
+
+// write calls f under the write lock and flushes the written frames.
+//
+// The connection becomes unusable after the first error.
+func (cc *http2ClientConn) write(f func(fr *http2.Framer) error) error {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+
+	if cc.writeErr != nil {
+		return cc.writeErr
+	}
+	if d := cc.hc.WriteTimeout; d > 0 {
+		if err := cc.c.SetWriteDeadline(time.Now().Add(d)); err != nil {
+			cc.writeErr = err
+			return err
+		}
+	}
+	err := f(cc.fr)
+	if err == nil {
+		err = cc.bw.Flush()
+	}
+	if err != nil {
+		cc.writeErr = err
+		// Unblock the reader goroutine, so it fails the pending streams.
+		cc.c.Close()
+	}
+	return err
+}
+
+// reserveStream reserves a stream for the upcoming request.
+//
+// false is returned if the connection cannot accept new streams.
+func (cc *http2ClientConn) reserveStream() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.closed || cc.goingAway {
+		return false
+	}
+	if d := cc.hc.MaxConnDuration; d > 0 && time.Since(cc.connTime) > d {
+		cc.goingAway = true
+		cc.updateReadDeadlineLocked()
+		return false
+	}
+	if uint32(len(cc.streams)+cc.reserved) >= cc.maxConcurrentStreams { // #nosec G115
+		return false
+	}
+	cc.reserved++
+	cc.updateReadDeadlineLocked()
+	return true
+}
+
+// addStreamLocked assigns the next stream id to the reserved st.
+func (cc *http2ClientConn) addStreamLocked(st *http2ClientStream) bool {
+	cc.reserved--
+	if cc.closed || cc.nextStreamID > http2MaxStreamID {
+		// Stream ids are exhausted, so the connection must be replaced.
+		cc.goingAway = true
+		cc.updateReadDeadlineLocked()
+		return false
+	}
+	st.id = cc.nextStreamID
+	cc.nextStreamID += 2
+	st.sendWindow = cc.peerInitialWindowSize
+	cc.streams[st.id] = st
+	return true
+}
+
+// closeIfIdle marks the connection closed if it has no active streams.
+func (cc *http2ClientConn) closeIfIdle() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.closed || len(cc.streams) > 0 || cc.reserved > 0 {
+		return false
+	}
+	cc.closed = true
+	return true
+}
+
+// updateReadDeadlineLocked sets the idle timeout if the connection
+// has no active streams.
+//
+// The connection is closed immediately if it is idle and going away.
+func (cc *http2ClientConn) updateReadDeadlineLocked() {
+	var deadline time.Time
+	if len(cc.streams) == 0 && cc.reserved == 0 {
+		switch {
+		case cc.goingAway:
+			// Wake up the reader goroutine.
+			deadline = time.Now()
+		default:
+			d := cc.hc.MaxIdleConnDuration
+			if d <= 0 {
+				d = DefaultMaxIdleConnDuration
+			}
+			deadline = time.Now().Add(d)
+		}
+	}
+	cc.c.SetReadDeadline(deadline) //nolint:errcheck
+}
+
+func (cc *http2ClientConn) roundTrip(req *Request, resp *Response, deadline time.Time) (bool, error) {
+	hc := cc.hc
+
+	st := &http2ClientStream{
+		resp:          resp,
+		done:          make(chan struct{}),
+		contentLength: -1,
+		maxBodySize:   hc.MaxResponseBodySize,
+		skipBody:      resp.SkipBody || req.Header.IsHead(),
+		recvWindow:    int32(cc.conf.InitialStreamWindowSize), // #nosec G115
+	}
+	if hc.DisableHeaderNamesNormalizing {
+		resp.Header.DisableNormalizing()
+	}
+	resp.ParseNetConn(cc.c)
+
+	body, err := cc.prepareRequest(req)
+	if err != nil {
+		cc.releaseReservedStream()
+		return false, err
+	}
+	hasTrailer := len(req.Header.trailer) > 0
+	hasBody := req.bodyStream != nil || len(body) > 0
+	endStream := !hasBody && !hasTrailer
+
+	err = cc.write(func(fr *http2.Framer) error {
+		cc.mu.Lock()
+		ok := cc.addStreamLocked(st)
+		cc.mu.Unlock()
+		if !ok {
+			return errHTTP2ConnClosed
+		}
+		block := cc.encodeRequestHeader(&req.Header)
+		return writeHTTP2HeaderBlock(fr, st.id, endStream, block, cc.peerMaxFrameSize.Load())
+	})
+	if err != nil {
+		if st.id == 0 && err != errHTTP2ConnClosed {
+			// The stream wasn't reserved, since the connection is already broken.
+			cc.releaseReservedStream()
+		}
+		req.closeBodyStream() //nolint:errcheck
+		cc.abortStream(st)
+		return true, err
+	}
+
+	if !endStream {
+		w := &http2ClientStreamWriter{
+			deadline: deadline,
+			cc:       cc,
+			st:       st,
+		}
+		err = w.writeBody(req, body)
+		// errHTTP2StreamClosed means the server has already responded
+		// or reset the stream, so the outcome is awaited below.
+		if err != nil && err != errHTTP2StreamClosed && cc.abortStream(st) {
+			cc.writeRSTStream(st.id, http2.ErrCodeCancel)
+			return err != ErrTimeout, err
+		}
+	}
+
+	return cc.awaitResponse(st, deadline)
+}
+
+// prepareRequest prepares the request header for sending
+// and returns the request body.
+func (cc *http2ClientConn) prepareRequest(req *Request) ([]byte, error) {
+	if err := req.prepareHeaderForWrite(); err != nil {
+		return nil, err
+	}
+	if req.bodyStream != nil {
+		if req.Header.ContentLength() < 0 {
+			if n := limitedReaderSize(req.bodyStream); n >= 0 {
+				req.Header.SetContentLength(int(n))
+			}
+		}
+		return nil, nil
+	}
+
+	body, err := req.bodyForWrite()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) != 0 || !req.Header.ignoreBody() {
+		req.Header.SetContentLength(len(body))
+	}
+	return body, nil
+}
+
+func (cc *http2ClientConn) releaseReservedStream() {
+	cc.mu.Lock()
+	cc.reserved--
+	cc.updateReadDeadlineLocked()
+	cc.mu.Unlock()
+}
+
+// abortStream forgets st if it isn't completed yet.
+//
+// false is returned if st has been already completed by the reader goroutine.
+func (cc *http2ClientConn) abortStream(st *http2ClientStream) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if st.id == 0 || cc.streams[st.id] != st {
+		return false
+	}
+	cc.removeStreamLocked(st)
+	return true
+}
+
+func (cc *http2ClientConn) removeStreamLocked(st *http2ClientStream) {
+	delete(cc.streams, st.id)
+	cc.cond.Broadcast()
+	cc.updateReadDeadlineLocked()
+}
+
+// finishStreamLocked completes st with the given error.
+func (cc *http2ClientConn) finishStreamLocked(st *http2ClientStream, err error, retry bool) {
+	cc.removeStreamLocked(st)
+	st.err = err
+	st.retry = retry
+	close(st.done)
+}
+
+// awaitResponse waits until the response is read or the deadline is reached.
+func (cc *http2ClientConn) awaitResponse(st *http2ClientStream, deadline time.Time) (bool, error) {
+	if d := cc.hc.ReadTimeout; d > 0 {
+		readDeadline := time.Now().Add(d)
+		if deadline.IsZero() || readDeadline.Before(deadline) {
+			deadline = readDeadline
+		}
+	}
+	if deadline.IsZero() {
+		<-st.done
+		return st.retry, st.err
+	}
+
+	tc := AcquireTimer(time.Until(deadline))
+	defer ReleaseTimer(tc)
+	select {
+	case <-st.done:
+		return st.retry, st.err
+	case <-tc.C:
+	}
+
+	if !cc.abortStream(st) {
+		// The response has been completed concurrently.
+		<-st.done
+		return st.retry, st.err
+	}
+	cc.writeRSTStream(st.id, http2.ErrCodeCancel)
+	return false, ErrTimeout
+}
+
+func (cc *http2ClientConn) writeRSTStream(id uint32, code http2.ErrCode) {
+	cc.write(func(fr *http2.Framer) error { //nolint:errcheck
+		return fr.WriteRSTStream(id, code)
+	})
+}
+
+// encodeRequestHeader encodes h into a header block.
+//
+// The call must be made under the write lock.
+func (cc *http2ClientConn) encodeRequestHeader(h *RequestHeader) []byte {
+	e := cc.enc
+	e.reset()
+
+	e.writeField(":method", string(h.Method()))
+	if h.IsConnect() {
+		e.writeField(":authority", string(h.Host()))
+	} else {
+		scheme := "http"
+		if cc.hc.IsTLS {
+			scheme = "https"
+		}
+		path := h.RequestURI()
+		if len(path) == 0 {
+			path = strSlash
+		}
+		e.writeField(":scheme", scheme)
+		e.writeField(":authority", string(h.Host()))
+		e.writeField(":path", string(path))
+	}
+
+	if !h.disableSpecialHeader {
+		if userAgent := h.UserAgent(); len(userAgent) > 0 {
+			e.writeKV(strUserAgent, userAgent)
+		}
+		contentType := h.ContentType()
+		if !h.noDefaultContentType && len(contentType) == 0 && h.ContentLength() > 0 {
+			contentType = strDefaultContentType
+		}
+		if len(contentType) > 0 {
+			e.writeKV(strContentType, contentType)
+		}
+		if len(h.contentLengthBytes) > 0 {
+			e.writeKV(strContentLength, h.contentLengthBytes)
+		}
+	}
+	for i, n := 0, len(h.h); i < n; i++ {
+		kv := &h.h[i]
+		if isHTTP2ConnectionHeader(kv.key) || caseInsensitiveCompare(kv.key, strHost) {
+			continue
+		}
+		if caseInsensitiveCompare(kv.key, strTE) && !caseInsensitiveCompare(kv.value, strTrailers) {
+			// Only "trailers" value is allowed for TE header.
+			continue
+		}
+		if slices.ContainsFunc(h.trailer, func(t []byte) bool { return bytes.Equal(kv.key, t) }) {
+			continue
+		}
+		e.writeKV(kv.key, kv.value)
+	}
+	if len(h.trailer) > 0 {
+		e.writeKV(strTrailer, appendTrailerBytes(nil, h.trailer, strCommaSpace))
+	}
+	if len(h.cookies) > 0 && !h.disableSpecialHeader {
+		e.writeKV(strCookie, appendRequestCookieBytes(nil, h.cookies))
+	}
+	return e.bytes()
+}
+
+// encodeRequestTrailer encodes the trailer of h into a header block.
+//
+// The call must be made under the write lock.
+func (cc *http2ClientConn) encodeRequestTrailer(h *RequestHeader) []byte {
+	e := cc.enc
+	e.reset()
+	for _, t := range h.trailer {
+		e.writeKV(t, h.peek(t))
+	}
+	return e.bytes()
+}
+
+// http2ClientStreamWriter writes the request body as DATA frames
+// obeying the flow control.
+type http2ClientStreamWriter struct {
+	deadline time.Time
+	cc       *http2ClientConn
+	st       *http2ClientStream
+}
+
+func (w *http2ClientStreamWriter) Write(p []byte) (int, error) {
+	if err := w.writeData(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeBody writes the request body followed by the request trailer.
+func (w *http2ClientStreamWriter) writeBody(req *Request, body []byte) error {
+	hasTrailer := len(req.Header.trailer) > 0
+
+	var err error
+	if req.bodyStream != nil {
+		r := req.bodyStream
+		if n := req.Header.ContentLength(); n >= 0 {
+			r = io.LimitReader(r, int64(n))
+		}
+		_, err = copyZeroAlloc(w, r)
+		if errc := req.closeBodyStream(); err == nil {
+			err = errc
+		}
+		if err == nil && !hasTrailer {
+			err = w.writeData(nil, true)
+		}
+	} else {
+		err = w.writeData(body, !hasTrailer)
+	}
+	if err != nil || !hasTrailer {
+		return err
+	}
+
+	cc := w.cc
+	return cc.write(func(fr *http2.Framer) error {
+		block := cc.encodeRequestTrailer(&req.Header)
+		return writeHTTP2HeaderBlock(fr, w.st.id, true, block, cc.peerMaxFrameSize.Load())
+	})
+}
+
+// writeData writes p as DATA frames. The last frame ends the stream if endStream is set.
+func (w *http2ClientStreamWriter) writeData(p []byte, endStream bool) error {
+	for {
+		n, err := w.reserveWindow(len(p))
+		if err != nil {
+			return err
+		}
+		chunk := p[:n]
+		p = p[n:]
+		last := len(p) == 0
+		if last && !endStream && n == 0 {
+			return nil
+		}
+		err = w.cc.write(func(fr *http2.Framer) error {
+			return fr.WriteData(w.st.id, endStream && last, chunk)
+		})
+		if err != nil || last {
+			return err
+		}
+	}
+}
+
+// reserveWindow waits until the flow control allows sending up to n bytes
+// and returns the number of bytes, which may be sent.
+func (w *http2ClientStreamWriter) reserveWindow(n int) (int, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if maxFrameSize := int(w.cc.peerMaxFrameSize.Load()); n > maxFrameSize {
+		n = maxFrameSize
+	}
+
+	cc := w.cc
+	st := w.st
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	var t *time.Timer
+	defer func() {
+		if t != nil {
+			t.Stop()
+		}
+	}()
+	for {
+		if cc.closed || cc.streams[st.id] != st {
+			return 0, errHTTP2StreamClosed
+		}
+		if m := min(int32(n), cc.sendWindow, st.sendWindow); m > 0 { // #nosec G115
+			cc.sendWindow -= m
+			st.sendWindow -= m
+			return int(m), nil
+		}
+
+		if !w.deadline.IsZero() {
+			d := time.Until(w.deadline)
+			if d <= 0 {
+				return 0, ErrTimeout
+			}
+			if t == nil {
+				t = time.AfterFunc(d, func() {
+					cc.mu.Lock()
+					cc.cond.Broadcast()
+					cc.mu.Unlock()
+				})
+			}
+		}
+		cc.cond.Wait()
+	}
+}
+
+func (cc *http2ClientConn) readLoop() {
+	err := cc.serve()
+	cc.closeWithError(err)
+}
+
+// serve reads frames until the connection is closed.
+func (cc *http2ClientConn) serve() error {
+	for {
+		f, err := cc.fr.ReadFrame()
+		if err == nil {
+			err = cc.processFrame(f)
+		}
+		if err == nil {
+			continue
+		}
+
+		var se http2.StreamError
+		if errors.As(err, &se) {
+			cc.resetStream(se)
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			cc.mu.Lock()
+			idle := len(cc.streams) == 0 && cc.reserved == 0
+			if idle {
+				cc.closed = true
+			} else {
+				// New streams have been started after the idle timeout fired.
+				cc.updateReadDeadlineLocked()
+			}
+			cc.mu.Unlock()
+			if !idle {
+				continue
+			}
+			return nil
+		}
+
+		var ce http2.ConnectionError
+		if errors.As(err, &ce) {
+			cc.writeGoAway(http2.ErrCode(ce))
+		} else if errors.Is(err, http2.ErrFrameTooLarge) {
+			cc.writeGoAway(http2.ErrCodeFrameSize)
+		}
+		return err
+	}
+}
+
+func (cc *http2ClientConn) writeGoAway(code http2.ErrCode) {
+	cc.write(func(fr *http2.Framer) error { //nolint:errcheck
+		return fr.WriteGoAway(0, code, nil)
+	})
+}
+
+// closeWithError closes the connection and fails all the pending streams.
+func (cc *http2ClientConn) closeWithError(err error) {
+	if err == nil || err == io.EOF {
+		err = ErrConnectionClosed
+	}
+
+	cc.mu.Lock()
+	cc.closed = true
+	for _, st := range cc.streams {
+		cc.finishStreamLocked(st, err, true)
+	}
+	cc.cond.Broadcast()
+	cc.mu.Unlock()
+
+	cc.c.Close()
+	cc.t.removeConn(cc)
+}
+
+func (cc *http2ClientConn) processFrame(f http2.Frame) error {
+	switch f := f.(type) {
+	case *http2.SettingsFrame:
+		return cc.processSettings(f)
+	case *http2.MetaHeadersFrame:
+		return cc.processHeaders(f)
+	case *http2.DataFrame:
+		return cc.processData(f)
+	case *http2.WindowUpdateFrame:
+		return cc.processWindowUpdate(f)
+	case *http2.PingFrame:
+		if f.IsAck() {
+			return nil
+		}
+		return cc.write(func(fr *http2.Framer) error {
+			return fr.WritePing(true, f.Data)
+		})
+	case *http2.RSTStreamFrame:
+		return cc.processRSTStream(f)
+	case *http2.GoAwayFrame:
+		return cc.processGoAway(f)
+	case *http2.PushPromiseFrame:
+		// Server push is disabled in the client settings.
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	default:
+		// PRIORITY and unknown frames are ignored.
+		return nil
+	}
+}
+
+func (cc *http2ClientConn) processSettings(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+	if err := f.ForeachSetting(cc.applySetting); err != nil {
+		return err
+	}
+	return cc.write(func(fr *http2.Framer) error {
+		return fr.WriteSettingsAck()
+	})
+}
+
+func (cc *http2ClientConn) applySetting(s http2.Setting) error {
+	if err := s.Valid(); err != nil {
+		return err
+	}
+	switch s.ID {
+	case http2.SettingHeaderTableSize:
+		cc.wmu.Lock()
+		cc.enc.enc.SetMaxDynamicTableSizeLimit(s.Val)
+		cc.wmu.Unlock()
+	case http2.SettingMaxConcurrentStreams:
+		cc.mu.Lock()
+		cc.maxConcurrentStreams = s.Val
+		cc.mu.Unlock()
+	case http2.SettingInitialWindowSize:
+		cc.mu.Lock()
+		defer cc.mu.Unlock()
+		delta := int64(s.Val) - int64(cc.peerInitialWindowSize)
+		for _, st := range cc.streams {
+			n := int64(st.sendWindow) + delta
+			if n > http2MaxWindowSize {
+				return http2.ConnectionError(http2.ErrCodeFlowControl)
+			}
+			st.sendWindow = int32(n) // #nosec G115
+		}
+		cc.peerInitialWindowSize = int32(s.Val) // #nosec G115
+		cc.cond.Broadcast()
+	case http2.SettingMaxFrameSize:
+		cc.peerMaxFrameSize.Store(s.Val)
+	}
+	return nil
+}
+
+func (cc *http2ClientConn) processWindowUpdate(f *http2.WindowUpdateFrame) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if f.StreamID == 0 {
+		n := int64(cc.sendWindow) + int64(f.Increment)
+		if n > http2MaxWindowSize {
+			return http2.ConnectionError(http2.ErrCodeFlowControl)
+		}
+		cc.sendWindow = int32(n) // #nosec G115
+	} else {
+		st := cc.streams[f.StreamID]
+		if st == nil {
+			return nil
+		}
+		n := int64(st.sendWindow) + int64(f.Increment)
+		if n > http2MaxWindowSize {
+			return http2.StreamError{StreamID: f.StreamID, Code: http2.ErrCodeFlowControl}
+		}
+		st.sendWindow = int32(n) // #nosec G115
+	}
+	cc.cond.Broadcast()
+	return nil
+}
+
+func (cc *http2ClientConn) processRSTStream(f *http2.RSTStreamFrame) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	st := cc.streams[f.StreamID]
+	if st == nil {
+		if f.StreamID >= cc.nextStreamID {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		return nil
+	}
+	// The refused stream hasn't been processed by the server,
+	// so it is safe to retry it.
+	retry := f.ErrCode == http2.ErrCodeRefusedStream
+	cc.finishStreamLocked(st, fmt.Errorf("%w: %s", ErrHTTP2StreamReset, f.ErrCode), retry)
+	return nil
+}
+
+func (cc *http2ClientConn) processGoAway(f *http2.GoAwayFrame) error {
+	cc.mu.Lock()
+	cc.goingAway = true
+	for id, st := range cc.streams {
+		if id > f.LastStreamID {
+			// The stream hasn't been processed by the server.
+			cc.finishStreamLocked(st, ErrConnectionClosed, true)
+		}
+	}
+	cc.updateReadDeadlineLocked()
+	cc.mu.Unlock()
+
+	// Stop opening new streams on the connection.
+	cc.t.removeConn(cc)
+	return nil
+}
+
+// resetStream fails the stream, which caused the given error, and resets it.
+func (cc *http2ClientConn) resetStream(se http2.StreamError) {
+	cc.mu.Lock()
+	if st := cc.streams[se.StreamID]; st != nil {
+		cc.finishStreamLocked(st, se, false)
+	}
+	cc.mu.Unlock()
+
+	cc.writeRSTStream(se.StreamID, se.Code)
+}
+
+func (cc *http2ClientConn) processHeaders(f *http2.MetaHeadersFrame) error {
+	id := f.StreamID
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	st := cc.streams[id]
+	if st == nil {
+		if id%2 == 0 || id >= cc.nextStreamID {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		// The stream has been canceled.
+		return nil
+	}
+	protocolErr := http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol}
+	if f.Truncated {
+		cc.finishStreamLocked(st, errHTTP2HeaderTooLarge, false)
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeCancel}
+	}
+
+	if st.gotHeaders {
+		// Trailer.
+		if !f.StreamEnded() || len(f.PseudoFields()) > 0 {
+			return protocolErr
+		}
+		h := &st.resp.Header
+		for _, hf := range f.RegularFields() {
+			h.AddBytesKV(s2b(hf.Name), s2b(hf.Value))
+		}
+		return cc.endStreamLocked(st)
+	}
+
+	statusCode, err := strconv.Atoi(f.PseudoValue("status"))
+	if err != nil || statusCode < 100 || len(f.PseudoFields()) != 1 {
+		return protocolErr
+	}
+	if statusCode < 200 {
+		// Skip informational responses.
+		if f.StreamEnded() {
+			return protocolErr
+		}
+		return nil
+	}
+	st.gotHeaders = true
+
+	if err := st.readResponseHeader(f, statusCode); err != nil {
+		return err
+	}
+	if f.StreamEnded() {
+		return cc.endStreamLocked(st)
+	}
+	return nil
+}
+
+// readResponseHeader fills the response header from the HEADERS frame.
+func (st *http2ClientStream) readResponseHeader(f *http2.MetaHeadersFrame, statusCode int) error {
+	h := &st.resp.Header
+	protocolErr := http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+
+	h.SetStatusCode(statusCode)
+	h.SetProtocol(s2b(http2Protocol))
+	for _, hf := range f.RegularFields() {
+		key := s2b(hf.Name)
+		value := s2b(hf.Value)
+		if isHTTP2ConnectionHeader(key) {
+			return protocolErr
+		}
+		switch hf.Name {
+		case "content-length":
+			n, err := parseContentLength(value)
+			if err != nil || (st.contentLength >= 0 && n != st.contentLength) {
+				return protocolErr
+			}
+			st.contentLength = n
+		case "date":
+			// Date header is skipped by AddBytesKV, since it is managed
+			// automatically for outgoing responses.
+			h.h = appendArgBytes(h.h, strDate, value, argsHasValue)
+			continue
+		}
+		h.AddBytesKV(key, value)
+	}
+	return nil
+}
+
+func (cc *http2ClientConn) processData(f *http2.DataFrame) error {
+	id := f.StreamID
+	n := int32(f.Length) // #nosec G115
+
+	if n > cc.recvWindow {
+		return http2.ConnectionError(http2.ErrCodeFlowControl)
+	}
+	cc.recvWindow -= n
+
+	cc.mu.Lock()
+	st := cc.streams[id]
+	if st == nil {
+		idle := id%2 == 0 || id >= cc.nextStreamID
+		cc.mu.Unlock()
+		if idle {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		// The stream has been canceled.
+		return cc.consumeWindow(nil, n)
+	}
+	if !st.gotHeaders {
+		cc.mu.Unlock()
+		if err := cc.consumeWindow(nil, n); err != nil {
+			return err
+		}
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeProtocol}
+	}
+	if n > st.recvWindow {
+		cc.mu.Unlock()
+		if err := cc.consumeWindow(nil, n); err != nil {
+			return err
+		}
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeFlowControl}
+	}
+	st.recvWindow -= n
+
+	if data := f.Data(); len(data) > 0 {
+		st.bodySize += len(data)
+		if st.maxBodySize > 0 && st.bodySize > st.maxBodySize {
+			cc.finishStreamLocked(st, ErrBodyTooLarge, false)
+			cc.mu.Unlock()
+			if err := cc.consumeWindow(nil, n); err != nil {
+				return err
+			}
+			return http2.StreamError{StreamID: id, Code: http2.ErrCodeCancel}
+		}
+		if !st.skipBody {
+			st.resp.bodyBuffer().Write(data) //nolint:errcheck
+		}
+	}
+
+	if f.StreamEnded() {
+		err := cc.endStreamLocked(st)
+		cc.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return cc.consumeWindow(nil, n)
+	}
+	cc.mu.Unlock()
+	return cc.consumeWindow(st, n)
+}
+
+// consumeWindow returns n bytes to the connection receive window
+// and to the receive window of st if it isn't nil.
+//
+// The whole response body is buffered and its size is limited by
+// HostClient.MaxResponseBodySize, so received data doesn't need
+// to be throttled by the flow control.
+func (cc *http2ClientConn) consumeWindow(st *http2ClientStream, n int32) error {
+	if n == 0 {
+		return nil
+	}
+	var connIncr, streamIncr uint32
+	var id uint32
+
+	cc.recvUnacked += n
+	if cc.recvUnacked >= int32(cc.conf.InitialConnWindowSize/2) { // #nosec G115
+		connIncr = uint32(cc.recvUnacked) // #nosec G115
+		cc.recvWindow += cc.recvUnacked
+		cc.recvUnacked = 0
+	}
+	if st != nil {
+		cc.mu.Lock()
+		st.recvUnacked += n
+		if st.recvUnacked >= int32(cc.conf.InitialStreamWindowSize/2) { // #nosec G115
+			streamIncr = uint32(st.recvUnacked) // #nosec G115
+			st.recvWindow += st.recvUnacked
+			st.recvUnacked = 0
+			id = st.id
+		}
+		cc.mu.Unlock()
+	}
+	if connIncr == 0 && streamIncr == 0 {
+		return nil
+	}
+
+	return cc.write(func(fr *http2.Framer) error {
+		if connIncr > 0 {
+			if err := fr.WriteWindowUpdate(0, connIncr); err != nil {
+				return err
+			}
+		}
+		if streamIncr > 0 {
+			return fr.WriteWindowUpdate(id, streamIncr)
+		}
+		return nil
+	})
+}
+
+// endStreamLocked completes st after the response is completely read.
+func (cc *http2ClientConn) endStreamLocked(st *http2ClientStream) error {
+	if !st.skipBody {
+		if st.contentLength >= 0 && st.contentLength != st.bodySize {
+			return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+		}
+		st.resp.Header.SetContentLength(st.bodySize)
+	}
+	cc.finishStreamLocked(st, nil, false)
+	return nil
+}
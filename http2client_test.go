@@ -0,0 +1,325 @@
+package fasthttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func newHTTP2TestHostClient(t *testing.T, s *Server, enableHTTP2 bool) (*HostClient, *HTTP2Transport) {
+	t.Helper()
+
+	if enableHTTP2 {
+		if err := ConfigureHTTP2Server(s, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	certData, keyData, err := GenerateTestCertificate("localhost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() { ln.Close() })
+	go s.ServeTLSEmbed(ln, certData, keyData) //nolint:errcheck
+
+	tr := &HTTP2Transport{}
+	t.Cleanup(tr.CloseIdleConnections)
+	hc := &HostClient{
+		Addr:      "example.com",
+		IsTLS:     true,
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		Dial:      func(string) (net.Conn, error) { return ln.Dial() },
+		Transport: tr,
+	}
+	return hc, tr
+}
+
+func TestHTTP2TransportRequests(t *testing.T) {
+	t.Parallel()
+
+	var connIDs sync.Map
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			connIDs.Store(ctx.ConnID(), struct{}{})
+			ctx.Response.Header.Set("X-Method", string(ctx.Method()))
+			ctx.Response.Header.Set("X-Host", string(ctx.Host()))
+			ctx.Response.Header.Set("X-Foo", string(ctx.Request.Header.Peek("X-Foo")))
+			ctx.Response.Header.Set("X-Cookie", string(ctx.Request.Header.Cookie("a"))+string(ctx.Request.Header.Cookie("b")))
+			ctx.SetContentType("text/plain")
+			fmt.Fprintf(ctx, "%s %s %s %s", ctx.Request.Header.Protocol(), ctx.RequestURI(), ctx.QueryArgs().Peek("q"), ctx.PostBody())
+		},
+	}
+	hc, _ := newHTTP2TestHostClient(t, s, true)
+
+	testCases := []struct {
+		method string
+		body   string
+		stream bool
+	}{
+		{MethodGet, "", false},
+		{MethodPost, "hello", false},
+		{MethodPut, strings.Repeat("x", 100000), false},
+		{MethodPost, strings.Repeat("y", 3<<20), true},
+		{MethodHead, "", false},
+	}
+	for _, tc := range testCases {
+		var req Request
+		var resp Response
+		req.Header.SetMethod(tc.method)
+		req.SetRequestURI("https://example.com/foo?q=bar")
+		req.Header.Set("X-Foo", "baz")
+		req.Header.SetCookie("a", "1")
+		req.Header.SetCookie("b", "2")
+		if tc.stream {
+			req.SetBodyStream(strings.NewReader(tc.body), -1)
+		} else {
+			req.SetBodyString(tc.body)
+		}
+
+		if err := hc.Do(&req, &resp); err != nil {
+			t.Fatalf("unexpected error for %s: %v", tc.method, err)
+		}
+		if string(resp.Header.Protocol()) != "HTTP/2.0" {
+			t.Fatalf("unexpected protocol %q. Expecting %q", resp.Header.Protocol(), "HTTP/2.0")
+		}
+		if resp.StatusCode() != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d. Body: %q", resp.StatusCode(), StatusOK, resp.Body())
+		}
+		expectedBody := "HTTP/2.0 /foo?q=bar bar " + tc.body
+		if tc.method == MethodHead {
+			if len(resp.Body()) > 0 {
+				t.Fatalf("unexpected body for HEAD request: %q", resp.Body())
+			}
+		} else if string(resp.Body()) != expectedBody {
+			t.Fatalf("unexpected body for %s: len=%d. Expecting len=%d", tc.method, len(resp.Body()), len(expectedBody))
+		}
+		if resp.Header.ContentLength() != len(expectedBody) {
+			t.Fatalf("unexpected content length %d. Expecting %d", resp.Header.ContentLength(), len(expectedBody))
+		}
+		expectedHeaders := map[string]string{
+			"X-Method":     tc.method,
+			"X-Host":       "example.com",
+			"X-Foo":        "baz",
+			"X-Cookie":     "12",
+			"Content-Type": "text/plain",
+			"Server":       defaultServerName,
+		}
+		for k, v := range expectedHeaders {
+			if got := string(resp.Header.Peek(k)); got != v {
+				t.Fatalf("unexpected %s header %q. Expecting %q", k, got, v)
+			}
+		}
+		if len(resp.Header.Peek(HeaderDate)) == 0 {
+			t.Fatal("missing Date header")
+		}
+	}
+
+	n := 0
+	connIDs.Range(func(any, any) bool {
+		n++
+		return true
+	})
+	if n != 1 {
+		t.Fatalf("unexpected number of connections %d. Expecting 1", n)
+	}
+}
+
+func TestHTTP2TransportConcurrentRequests(t *testing.T) {
+	t.Parallel()
+
+	const n = 50
+	var started sync.WaitGroup
+	started.Add(n)
+	release := make(chan struct{})
+	var connIDs sync.Map
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			connIDs.Store(ctx.ConnID(), struct{}{})
+			started.Done()
+			<-release
+			ctx.WriteString(string(ctx.Path())) //nolint:errcheck
+		},
+	}
+	hc, _ := newHTTP2TestHostClient(t, s, true)
+
+	errCh := make(chan error, n)
+	for i := range n {
+		go func() {
+			path := fmt.Sprintf("/%d", i)
+			statusCode, body, err := hc.Get(nil, "https://example.com"+path)
+			if err == nil && (statusCode != StatusOK || string(body) != path) {
+				err = fmt.Errorf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, path)
+			}
+			errCh <- err
+		}()
+	}
+
+	// All the requests must be handled concurrently.
+	started.Wait()
+	close(release)
+	for range n {
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	connCount := 0
+	connIDs.Range(func(any, any) bool {
+		connCount++
+		return true
+	})
+	if connCount != 1 {
+		t.Fatalf("unexpected number of connections %d. Expecting 1", connCount)
+	}
+}
+
+func TestHTTP2TransportDoDeadline(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	var connIDs sync.Map
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			connIDs.Store(ctx.ConnID(), struct{}{})
+			if string(ctx.Path()) == "/slow" {
+				<-release
+			}
+			ctx.WriteString("ok") //nolint:errcheck
+		},
+	}
+	defer close(release)
+	hc, _ := newHTTP2TestHostClient(t, s, true)
+
+	var req Request
+	var resp Response
+	req.SetRequestURI("https://example.com/fast")
+	if err := hc.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req.SetRequestURI("https://example.com/slow")
+	if err := hc.DoDeadline(&req, &resp, time.Now().Add(50*time.Millisecond)); err != ErrTimeout {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTimeout)
+	}
+
+	// The timed out stream mustn't affect other streams on the connection.
+	req.SetRequestURI("https://example.com/fast")
+	if err := hc.DoTimeout(&req, &resp, time.Second); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), "ok")
+	}
+
+	n := 0
+	connIDs.Range(func(any, any) bool {
+		n++
+		return true
+	})
+	if n != 1 {
+		t.Fatalf("unexpected number of connections %d. Expecting 1", n)
+	}
+}
+
+func TestHTTP2TransportMaxResponseBodySize(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(bytes.Repeat([]byte("x"), 3<<20)) //nolint:errcheck
+		},
+	}
+	hc, _ := newHTTP2TestHostClient(t, s, true)
+
+	var req Request
+	var resp Response
+	req.SetRequestURI("https://example.com/")
+	if err := hc.Do(&req, &resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Body()) != 3<<20 {
+		t.Fatalf("unexpected body length %d. Expecting %d", len(resp.Body()), 3<<20)
+	}
+
+	hc.MaxResponseBodySize = 1 << 20
+	if err := hc.Do(&req, &resp); !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBodyTooLarge)
+	}
+}
+
+func TestHTTP2TransportFallback(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.Request.Header.Protocol()) //nolint:errcheck
+		},
+	}
+	hc, tr := newHTTP2TestHostClient(t, s, false)
+
+	for range 2 {
+		statusCode, body, err := hc.Get(nil, "https://example.com/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if statusCode != StatusOK || string(body) != "HTTP/1.1" {
+			t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, "HTTP/1.1")
+		}
+	}
+
+	tr.mu.Lock()
+	_, ok := tr.noHTTP2["example.com"]
+	tr.mu.Unlock()
+	if !ok {
+		t.Fatal("the host must be remembered as not supporting HTTP/2")
+	}
+}
+
+func TestHTTP2TransportAllowHTTP(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			fmt.Fprintf(ctx, "%s %v", ctx.Request.Header.Protocol(), ctx.IsTLS())
+		},
+	}
+	if err := ConfigureHTTP2Server(s, &HTTP2Config{H2C: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	testCases := []struct {
+		expected  string
+		allowHTTP bool
+	}{
+		{"HTTP/1.1 false", false},
+		{"HTTP/2.0 false", true},
+	}
+	for _, tc := range testCases {
+		tr := &HTTP2Transport{AllowHTTP: tc.allowHTTP}
+		hc := &HostClient{
+			Addr:      "example.com",
+			Dial:      func(string) (net.Conn, error) { return ln.Dial() },
+			Transport: tr,
+		}
+		statusCode, body, err := hc.Get(nil, "http://example.com/")
+		tr.CloseIdleConnections()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if statusCode != StatusOK || string(body) != tc.expected {
+			t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, tc.expected)
+		}
+	}
+}
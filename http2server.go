@@ -0,0 +1,1342 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// ConfigureHTTP2Server enables HTTP/2 support on s for TLS connections
+// negotiating the "h2" protocol via ALPN.
+//
+// Cleartext HTTP/2 connections are also served if HTTP2Config.H2C is set.
+//
+// Every HTTP/2 stream is served by s.Handler with a pooled RequestCtx,
+// so existing request handlers work without changes. Connection
+// hijacking isn't supported over HTTP/2 and is silently ignored.
+//
+// conf may be nil, in which case default settings are used.
+//
+// This function can only be called before the server is started.
+func ConfigureHTTP2Server(s *Server, conf *HTTP2Config) error {
+	var c HTTP2Config
+	if conf != nil {
+		c = *conf
+	}
+	if err := c.setDefaults(); err != nil {
+		return err
+	}
+
+	hs := &http2Server{
+		s:    s,
+		conf: c,
+	}
+	s.NextProto(http2NextProto, hs.serveConn)
+	s.http2 = hs
+
+	// Clients not supporting HTTP/2 must still be able to negotiate HTTP/1.1.
+	if !slices.Contains(s.TLSConfig.NextProtos, "http/1.1") {
+		s.TLSConfig.NextProtos = append(s.TLSConfig.NextProtos, "http/1.1")
+	}
+	return nil
+}
+
+// http2Server serves HTTP/2 connections for the Server.
+type http2Server struct {
+	s    *Server
+	conf HTTP2Config
+}
+
+func (hs *http2Server) serveConn(c net.Conn) error {
+	return hs.serveConnWithReader(c, nil)
+}
+
+// serveConnWithReader serves HTTP/2 connection, which may have
+// the already buffered data in br.
+func (hs *http2Server) serveConnWithReader(c net.Conn, br *bufio.Reader) error {
+	sc := newHTTP2ServerConn(hs, c, br)
+	return sc.serve(nil, nil)
+}
+
+// http2Stream holds the state of a single request stream.
+type http2Stream struct {
+	ctx *RequestCtx
+
+	// start is the time the request headers have been received.
+	start time.Time
+
+	// The following fields are accessed only by the reader goroutine.
+	contentLength int
+	maxBodySize   int
+	bodySize      int
+	recvWindow    int32
+	recvUnacked   int32
+
+	// The following fields are guarded by http2ServerConn.mu.
+	sendWindow int32
+	receiving  bool
+	reset      bool
+
+	writeTimeout time.Duration
+	requestNum   uint64
+	id           uint32
+}
+
+// http2ServerConn is a server side HTTP/2 connection.
+//
+// Frames are read by a single reader goroutine, while every request
+// is handled in its own goroutine.
+type http2ServerConn struct {
+	connTime time.Time
+
+	hs *http2Server
+	s  *Server
+	c  net.Conn
+	br *bufio.Reader
+
+	// wmu guards the framer writes, the bw and the header encoder.
+	wmu      sync.Mutex
+	bw       *bufio.Writer
+	fr       *http2.Framer
+	enc      *http2HeaderEncoder
+	writeErr error
+
+	// cond is signaled on flow control window changes, stream resets
+	// and the connection close.
+	cond *sync.Cond
+
+	// mu guards the fields below.
+	mu                    sync.Mutex
+	streams               map[uint32]*http2Stream
+	receiving             int
+	maxStreamID           uint32
+	sendWindow            int32
+	peerInitialWindowSize int32
+	goingAway             bool
+	closed                bool
+
+	handlers sync.WaitGroup
+
+	serverName string
+	connID     uint64
+	requestNum uint64
+
+	peerMaxFrameSize atomic.Uint32
+
+	// The following fields are accessed only by the reader goroutine.
+	recvWindow  int32
+	recvUnacked int32
+}
+
+func newHTTP2ServerConn(hs *http2Server, c net.Conn, br *bufio.Reader) *http2ServerConn {
+	s := hs.s
+
+	if br == nil {
+		readBufferSize := s.ReadBufferSize
+		if readBufferSize <= 0 {
+			readBufferSize = defaultReadBufferSize
+		}
+		br = bufio.NewReaderSize(c, readBufferSize)
+	}
+	writeBufferSize := s.WriteBufferSize
+	if writeBufferSize <= 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+
+	sc := &http2ServerConn{
+		connTime:              time.Now(),
+		hs:                    hs,
+		s:                     s,
+		c:                     c,
+		br:                    br,
+		bw:                    bufio.NewWriterSize(c, writeBufferSize),
+		enc:                   newHTTP2HeaderEncoder(),
+		streams:               make(map[uint32]*http2Stream),
+		sendWindow:            http2InitialWindowSize,
+		peerInitialWindowSize: http2InitialWindowSize,
+		serverName:            s.getServerName(),
+		connID:                nextConnID(),
+		recvWindow:            int32(hs.conf.InitialConnWindowSize), // #nosec G115
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	sc.peerMaxFrameSize.Store(http2MinFrameSize)
+
+	sc.fr = http2.NewFramer(sc.bw, sc.br)
+	sc.fr.SetReuseFrames()
+	sc.fr.SetMaxReadFrameSize(hs.conf.MaxReadFrameSize)
+	sc.fr.MaxHeaderListSize = hs.conf.MaxHeaderListSize
+	sc.fr.ReadMetaHeaders = hpack.NewDecoder(http2InitialHeaderTableSz, nil)
+	return sc
+}
+
+// serve serves the connection until it is closed.
+//
+// upgradeReq is served as stream 1 if the connection is upgraded
+// from HTTP/1.1 with 'Upgrade: h2c' header.
+func (sc *http2ServerConn) serve(upgradeReq *Request, upgradeSettings []http2.Setting) (err error) {
+	defer func() {
+		sc.cleanup(err != nil)
+	}()
+
+	if upgradeReq != nil {
+		// The server connection preface must follow 101 response
+		// before the client connection preface.
+		err = sc.write(func(*http2.Framer) error {
+			_, err := sc.bw.Write(strH2CSwitchingProtocols)
+			return err
+		})
+		if err == nil {
+			err = sc.writeInitialSettings()
+		}
+		if err == nil {
+			err = sc.startUpgradedStream(upgradeReq, upgradeSettings)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = sc.readPreface(); err != nil {
+		if err == io.EOF && upgradeReq == nil {
+			// The client closed the connection without sending a request.
+			err = nil
+		}
+		return err
+	}
+	if upgradeReq == nil {
+		if err = sc.writeInitialSettings(); err != nil {
+			return err
+		}
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if done := sc.s.done; done != nil {
+		go func() {
+			select {
+			case <-done:
+				sc.startGoingAway(http2.ErrCodeNo)
+			case <-stopCh:
+			}
+		}()
+	}
+
+	gotSettings := false
+	for {
+		if !sc.updateReadDeadline() {
+			// All the streams are done after GOAWAY.
+			return nil
+		}
+
+		var f http2.Frame
+		f, err = sc.fr.ReadFrame()
+		if err == nil {
+			if !gotSettings {
+				if sf, ok := f.(*http2.SettingsFrame); !ok || sf.IsAck() {
+					// The client connection preface must start with SETTINGS frame.
+					err = http2.ConnectionError(http2.ErrCodeProtocol)
+				}
+				gotSettings = true
+			}
+			if err == nil {
+				err = sc.processFrame(f)
+			}
+		}
+		if err == nil {
+			continue
+		}
+
+		var se http2.StreamError
+		if errors.As(err, &se) {
+			if err = sc.resetStream(se); err == nil {
+				continue
+			}
+			return err
+		}
+		return sc.handleConnError(err)
+	}
+}
+
+// handleConnError returns nil if err is caused by the graceful connection close.
+func (sc *http2ServerConn) handleConnError(err error) error {
+	var ce http2.ConnectionError
+	switch {
+	case errors.As(err, &ce):
+		sc.startGoingAway(http2.ErrCode(ce))
+		return err
+	case errors.Is(err, http2.ErrFrameTooLarge):
+		sc.startGoingAway(http2.ErrCodeFrameSize)
+		return err
+	case err == io.EOF:
+		return nil
+	}
+
+	sc.mu.Lock()
+	idle := len(sc.streams) == 0
+	goingAway := sc.goingAway
+	sc.mu.Unlock()
+	if idle {
+		if goingAway {
+			return nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// Idle timeout.
+			sc.startGoingAway(http2.ErrCodeNo)
+			return nil
+		}
+	}
+	return err
+}
+
+func (sc *http2ServerConn) cleanup(closeConn bool) {
+	sc.mu.Lock()
+	sc.closed = true
+	for id, st := range sc.streams {
+		if st.receiving {
+			delete(sc.streams, id)
+			sc.s.releaseCtx(st.ctx)
+		}
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	if closeConn {
+		// Unblock handlers writing to the connection.
+		sc.c.Close()
+	}
+	sc.handlers.Wait()
+}
+
+func (sc *http2ServerConn) readPreface() error {
+	if d := sc.s.ReadTimeout; d > 0 {
+		if err := sc.c.SetReadDeadline(time.Now().Add(d)); err != nil {
+			return err
+		}
+	}
+	preface, err := sc.br.Peek(len(http2.ClientPreface))
+	if err != nil {
+		return err
+	}
+	if string(preface) != http2.ClientPreface {
+		return errors.New("http2: invalid client connection preface")
+	}
+	_, err = sc.br.Discard(len(preface))
+	return err
+}
+
+func (sc *http2ServerConn) writeInitialSettings() error {
+	conf := &sc.hs.conf
+	return sc.write(func(fr *http2.Framer) error {
+		err := fr.WriteSettings(
+			http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: conf.MaxConcurrentStreams},
+			http2.Setting{ID: http2.SettingMaxFrameSize, Val: conf.MaxReadFrameSize},
+			http2.Setting{ID: http2.SettingInitialWindowSize, Val: conf.InitialStreamWindowSize},
+			http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: conf.MaxHeaderListSize},
+		)
+		if err != nil {
+			return err
+		}
+		if n := conf.InitialConnWindowSize - http2InitialWindowSize; n > 0 {
+			return fr.WriteWindowUpdate(0, n)
+		}
+		return nil
+	})
+}
+
+// write calls f under the write lock and flushes the written frames.
+//
+// The connection becomes unusable for writing after the first error.
+func (sc *http2ServerConn) write(f func(fr *http2.Framer) error) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	if sc.writeErr != nil {
+		return sc.writeErr
+	}
+	if d := sc.s.WriteTimeout; d > 0 {
+		if err := sc.c.SetWriteDeadline(time.Now().Add(d)); err != nil {
+			sc.writeErr = err
+			return err
+		}
+	}
+	err := f(sc.fr)
+	if err == nil {
+		err = sc.bw.Flush()
+	}
+	if err != nil {
+		sc.writeErr = err
+	}
+	return err
+}
+
+// updateReadDeadline sets the read deadline for the next frame.
+//
+// false is returned if the connection must be closed, since it is
+// going away and has no active streams.
+func (sc *http2ServerConn) updateReadDeadline() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.updateReadDeadlineLocked()
+}
+
+func (sc *http2ServerConn) updateReadDeadlineLocked() bool {
+	var deadline time.Time
+	ok := true
+	switch {
+	case len(sc.streams) == 0 && sc.goingAway:
+		// Wake up the reader goroutine.
+		deadline = time.Now()
+		ok = false
+	case len(sc.streams) == 0:
+		if d := sc.s.idleTimeout(); d > 0 {
+			deadline = time.Now().Add(d)
+		}
+	case sc.receiving > 0:
+		if d := sc.s.ReadTimeout; d > 0 {
+			deadline = time.Now().Add(d)
+		}
+	}
+	sc.c.SetReadDeadline(deadline) //nolint:errcheck
+	return ok
+}
+
+// startGoingAway sends GOAWAY frame, so the peer stops opening new streams.
+//
+// Already opened streams are served until completion if code is http2.ErrCodeNo.
+func (sc *http2ServerConn) startGoingAway(code http2.ErrCode) {
+	sc.mu.Lock()
+	if sc.goingAway && code == http2.ErrCodeNo {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goingAway = true
+	lastStreamID := sc.maxStreamID
+	sc.mu.Unlock()
+
+	sc.write(func(fr *http2.Framer) error { //nolint:errcheck
+		return fr.WriteGoAway(lastStreamID, code, nil)
+	})
+
+	sc.mu.Lock()
+	if len(sc.streams) == 0 {
+		sc.updateReadDeadlineLocked()
+	}
+	sc.mu.Unlock()
+}
+
+func (sc *http2ServerConn) processFrame(f http2.Frame) error {
+	switch f := f.(type) {
+	case *http2.SettingsFrame:
+		return sc.processSettings(f)
+	case *http2.MetaHeadersFrame:
+		return sc.processHeaders(f)
+	case *http2.DataFrame:
+		return sc.processData(f)
+	case *http2.WindowUpdateFrame:
+		return sc.processWindowUpdate(f)
+	case *http2.PingFrame:
+		if f.IsAck() {
+			return nil
+		}
+		return sc.write(func(fr *http2.Framer) error {
+			return fr.WritePing(true, f.Data)
+		})
+	case *http2.RSTStreamFrame:
+		return sc.processRSTStream(f)
+	case *http2.GoAwayFrame:
+		// The peer won't open new streams. Serve the remaining ones.
+		sc.mu.Lock()
+		sc.goingAway = true
+		sc.mu.Unlock()
+		return nil
+	case *http2.PushPromiseFrame:
+		// Clients cannot push.
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	default:
+		// PRIORITY and unknown frames are ignored.
+		return nil
+	}
+}
+
+func (sc *http2ServerConn) processSettings(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+	if err := f.ForeachSetting(sc.applySetting); err != nil {
+		return err
+	}
+	return sc.write(func(fr *http2.Framer) error {
+		return fr.WriteSettingsAck()
+	})
+}
+
+func (sc *http2ServerConn) applySetting(s http2.Setting) error {
+	if err := s.Valid(); err != nil {
+		return err
+	}
+	switch s.ID {
+	case http2.SettingHeaderTableSize:
+		sc.wmu.Lock()
+		sc.enc.enc.SetMaxDynamicTableSizeLimit(s.Val)
+		sc.wmu.Unlock()
+	case http2.SettingInitialWindowSize:
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		delta := int64(s.Val) - int64(sc.peerInitialWindowSize)
+		for _, st := range sc.streams {
+			n := int64(st.sendWindow) + delta
+			if n > http2MaxWindowSize {
+				return http2.ConnectionError(http2.ErrCodeFlowControl)
+			}
+			st.sendWindow = int32(n) // #nosec G115
+		}
+		sc.peerInitialWindowSize = int32(s.Val) // #nosec G115
+		sc.cond.Broadcast()
+	case http2.SettingMaxFrameSize:
+		sc.peerMaxFrameSize.Store(s.Val)
+	}
+	return nil
+}
+
+func (sc *http2ServerConn) processWindowUpdate(f *http2.WindowUpdateFrame) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.StreamID == 0 {
+		n := int64(sc.sendWindow) + int64(f.Increment)
+		if n > http2MaxWindowSize {
+			return http2.ConnectionError(http2.ErrCodeFlowControl)
+		}
+		sc.sendWindow = int32(n) // #nosec G115
+	} else {
+		st := sc.streams[f.StreamID]
+		if st == nil {
+			if f.StreamID > sc.maxStreamID {
+				return http2.ConnectionError(http2.ErrCodeProtocol)
+			}
+			return nil
+		}
+		n := int64(st.sendWindow) + int64(f.Increment)
+		if n > http2MaxWindowSize {
+			return http2.StreamError{StreamID: f.StreamID, Code: http2.ErrCodeFlowControl}
+		}
+		st.sendWindow = int32(n) // #nosec G115
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *http2ServerConn) processRSTStream(f *http2.RSTStreamFrame) error {
+	sc.mu.Lock()
+	st := sc.streams[f.StreamID]
+	if st == nil {
+		idle := f.StreamID > sc.maxStreamID
+		sc.mu.Unlock()
+		if idle {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		return nil
+	}
+	if st.receiving {
+		sc.removeReceivingStreamLocked(st)
+		sc.mu.Unlock()
+		sc.s.releaseCtx(st.ctx)
+		return nil
+	}
+	st.reset = true
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	return nil
+}
+
+// resetStream sends RST_STREAM frame for the stream error.
+func (sc *http2ServerConn) resetStream(se http2.StreamError) error {
+	sc.mu.Lock()
+	st := sc.streams[se.StreamID]
+	if st != nil {
+		if st.receiving {
+			sc.removeReceivingStreamLocked(st)
+			sc.s.releaseCtx(st.ctx)
+		} else {
+			// The handler mustn't write to the reset stream.
+			st.reset = true
+			sc.cond.Broadcast()
+		}
+	}
+	sc.mu.Unlock()
+
+	return sc.write(func(fr *http2.Framer) error {
+		return fr.WriteRSTStream(se.StreamID, se.Code)
+	})
+}
+
+func (sc *http2ServerConn) removeReceivingStreamLocked(st *http2Stream) {
+	delete(sc.streams, st.id)
+	st.receiving = false
+	sc.receiving--
+}
+
+func (sc *http2ServerConn) processHeaders(f *http2.MetaHeadersFrame) error {
+	id := f.StreamID
+
+	sc.mu.Lock()
+	st := sc.streams[id]
+	if st != nil {
+		receiving := st.receiving
+		sc.mu.Unlock()
+		if !receiving {
+			return http2.StreamError{StreamID: id, Code: http2.ErrCodeStreamClosed}
+		}
+		return sc.processTrailers(st, f)
+	}
+	if id%2 == 0 || id <= sc.maxStreamID {
+		sc.mu.Unlock()
+		return http2.ConnectionError(http2.ErrCodeProtocol)
+	}
+	sc.maxStreamID = id
+	if sc.goingAway || uint32(len(sc.streams)) >= sc.hs.conf.MaxConcurrentStreams { // #nosec G115
+		sc.mu.Unlock()
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeRefusedStream}
+	}
+	sc.mu.Unlock()
+
+	sc.requestNum++
+	st = &http2Stream{
+		ctx:           sc.s.acquireCtx(sc.c),
+		start:         time.Now(),
+		id:            id,
+		requestNum:    sc.requestNum,
+		contentLength: -1,
+		recvWindow:    int32(sc.hs.conf.InitialStreamWindowSize), // #nosec G115
+	}
+
+	if f.Truncated {
+		sc.s.releaseCtx(st.ctx)
+		return sc.writeErrorStatus(id, StatusRequestHeaderFieldsTooLarge, !f.StreamEnded())
+	}
+	if err := sc.readRequestHeader(st, f); err != nil {
+		sc.s.releaseCtx(st.ctx)
+		return err
+	}
+	if err := st.ctx.Request.parseURI(); err != nil {
+		sc.s.releaseCtx(st.ctx)
+		return sc.writeErrorStatus(id, StatusBadRequest, !f.StreamEnded())
+	}
+	if st.contentLength > st.maxBodySize {
+		sc.s.releaseCtx(st.ctx)
+		return sc.writeErrorStatus(id, StatusRequestEntityTooLarge, !f.StreamEnded())
+	}
+
+	sc.mu.Lock()
+	st.sendWindow = sc.peerInitialWindowSize
+	st.receiving = true
+	sc.receiving++
+	sc.streams[id] = st
+	sc.mu.Unlock()
+
+	if f.StreamEnded() {
+		return sc.endStream(st)
+	}
+	return nil
+}
+
+// readRequestHeader fills the request header from the HEADERS frame.
+func (sc *http2ServerConn) readRequestHeader(st *http2Stream, f *http2.MetaHeadersFrame) error {
+	s := sc.s
+	ctx := st.ctx
+	h := &ctx.Request.Header
+	protocolErr := http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+
+	sc.initCtx(ctx)
+
+	for _, hf := range f.PseudoFields() {
+		switch hf.Name {
+		case ":method", ":scheme", ":authority", ":path":
+		default:
+			return protocolErr
+		}
+	}
+	method := f.PseudoValue("method")
+	scheme := f.PseudoValue("scheme")
+	authority := f.PseudoValue("authority")
+	path := f.PseudoValue("path")
+	if method == MethodConnect {
+		if scheme != "" || path != "" || authority == "" {
+			return protocolErr
+		}
+		path = authority
+	} else if method == "" || scheme == "" || path == "" {
+		return protocolErr
+	}
+	h.SetMethod(method)
+	h.SetRequestURI(path)
+	h.SetProtocol(http2Protocol)
+
+	for _, hf := range f.RegularFields() {
+		key := s2b(hf.Name)
+		value := s2b(hf.Value)
+		if isHTTP2ConnectionHeader(key) {
+			return protocolErr
+		}
+		switch hf.Name {
+		case "te":
+			if hf.Value != "trailers" {
+				return protocolErr
+			}
+		case "content-length":
+			n, err := parseContentLength(value)
+			if err != nil || (st.contentLength >= 0 && n != st.contentLength) {
+				return protocolErr
+			}
+			st.contentLength = n
+		}
+		h.AddBytesKV(key, value)
+	}
+	if authority != "" {
+		h.SetHost(authority)
+	}
+
+	st.maxBodySize = s.MaxRequestBodySize
+	if st.maxBodySize <= 0 {
+		st.maxBodySize = DefaultMaxRequestBodySize
+	}
+	st.writeTimeout = s.WriteTimeout
+	if onHdrRecv := s.HeaderReceived; onHdrRecv != nil {
+		reqConf := onHdrRecv(h)
+		if reqConf.MaxRequestBodySize > 0 {
+			st.maxBodySize = reqConf.MaxRequestBodySize
+		}
+		if reqConf.WriteTimeout > 0 {
+			st.writeTimeout = reqConf.WriteTimeout
+		}
+	}
+	return nil
+}
+
+// initCtx applies the server settings to the newly acquired ctx.
+func (sc *http2ServerConn) initCtx(ctx *RequestCtx) {
+	s := sc.s
+	ctx.Request.isTLS = ctx.IsTLS()
+	ctx.Response.Header.noDefaultContentType = s.NoDefaultContentType
+	ctx.Response.Header.noDefaultDate = s.NoDefaultDate
+	ctx.Request.Header.secureErrorLogMessage = s.SecureErrorLogMessage
+	ctx.Response.Header.secureErrorLogMessage = s.SecureErrorLogMessage
+	ctx.Request.secureErrorLogMessage = s.SecureErrorLogMessage
+	ctx.Response.secureErrorLogMessage = s.SecureErrorLogMessage
+	if s.DisableHeaderNamesNormalizing {
+		ctx.Request.Header.DisableNormalizing()
+		ctx.Response.Header.DisableNormalizing()
+	}
+}
+
+func (sc *http2ServerConn) processTrailers(st *http2Stream, f *http2.MetaHeadersFrame) error {
+	if !f.StreamEnded() || len(f.PseudoFields()) > 0 {
+		return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+	}
+	if f.Truncated {
+		sc.abortReceivingStream(st)
+		return sc.writeErrorStatus(st.id, StatusRequestHeaderFieldsTooLarge, false)
+	}
+	h := &st.ctx.Request.Header
+	for _, hf := range f.RegularFields() {
+		h.AddBytesKV(s2b(hf.Name), s2b(hf.Value))
+	}
+	return sc.endStream(st)
+}
+
+func (sc *http2ServerConn) processData(f *http2.DataFrame) error {
+	id := f.StreamID
+	n := int32(f.Length) // #nosec G115
+
+	if n > sc.recvWindow {
+		return http2.ConnectionError(http2.ErrCodeFlowControl)
+	}
+	sc.recvWindow -= n
+
+	sc.mu.Lock()
+	st := sc.streams[id]
+	receiving := st != nil && st.receiving
+	idle := id > sc.maxStreamID
+	sc.mu.Unlock()
+
+	if !receiving {
+		if idle {
+			return http2.ConnectionError(http2.ErrCodeProtocol)
+		}
+		if err := sc.consumeConnWindow(n); err != nil {
+			return err
+		}
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeStreamClosed}
+	}
+	if n > st.recvWindow {
+		if err := sc.consumeConnWindow(n); err != nil {
+			return err
+		}
+		return http2.StreamError{StreamID: id, Code: http2.ErrCodeFlowControl}
+	}
+	st.recvWindow -= n
+
+	if data := f.Data(); len(data) > 0 {
+		st.bodySize += len(data)
+		if st.bodySize > st.maxBodySize {
+			sc.abortReceivingStream(st)
+			if err := sc.consumeConnWindow(n); err != nil {
+				return err
+			}
+			return sc.writeErrorStatus(id, StatusRequestEntityTooLarge, true)
+		}
+		st.ctx.Request.bodyBuffer().Write(data) //nolint:errcheck
+	}
+
+	if f.StreamEnded() {
+		if err := sc.consumeConnWindow(n); err != nil {
+			return err
+		}
+		return sc.endStream(st)
+	}
+	return sc.consumeWindow(st, n)
+}
+
+// consumeConnWindow returns n bytes to the connection receive window.
+//
+// The whole request body is buffered before calling the request handler
+// and its size is limited by Server.MaxRequestBodySize, so received data
+// doesn't need to be throttled by the flow control.
+func (sc *http2ServerConn) consumeConnWindow(n int32) error {
+	return sc.consumeWindow(nil, n)
+}
+
+// consumeWindow returns n bytes to the connection receive window
+// and to the receive window of st if it isn't nil.
+func (sc *http2ServerConn) consumeWindow(st *http2Stream, n int32) error {
+	if n == 0 {
+		return nil
+	}
+	var connIncr, streamIncr uint32
+
+	sc.recvUnacked += n
+	if sc.recvUnacked >= int32(sc.hs.conf.InitialConnWindowSize/2) { // #nosec G115
+		connIncr = uint32(sc.recvUnacked) // #nosec G115
+		sc.recvWindow += sc.recvUnacked
+		sc.recvUnacked = 0
+	}
+	if st != nil {
+		st.recvUnacked += n
+		if st.recvUnacked >= int32(sc.hs.conf.InitialStreamWindowSize/2) { // #nosec G115
+			streamIncr = uint32(st.recvUnacked) // #nosec G115
+			st.recvWindow += st.recvUnacked
+			st.recvUnacked = 0
+		}
+	}
+	if connIncr == 0 && streamIncr == 0 {
+		return nil
+	}
+
+	return sc.write(func(fr *http2.Framer) error {
+		if connIncr > 0 {
+			if err := fr.WriteWindowUpdate(0, connIncr); err != nil {
+				return err
+			}
+		}
+		if streamIncr > 0 {
+			return fr.WriteWindowUpdate(st.id, streamIncr)
+		}
+		return nil
+	})
+}
+
+// abortReceivingStream forgets the stream, which is still receiving the request.
+func (sc *http2ServerConn) abortReceivingStream(st *http2Stream) {
+	sc.mu.Lock()
+	sc.removeReceivingStreamLocked(st)
+	sc.mu.Unlock()
+	sc.s.releaseCtx(st.ctx)
+}
+
+// endStream starts the request handler after the request is completely read.
+func (sc *http2ServerConn) endStream(st *http2Stream) error {
+	req := &st.ctx.Request
+	bodyLen := len(req.Body())
+	if st.contentLength >= 0 && st.contentLength != bodyLen {
+		return http2.StreamError{StreamID: st.id, Code: http2.ErrCodeProtocol}
+	}
+	if bodyLen > 0 || st.contentLength >= 0 {
+		req.Header.SetContentLength(bodyLen)
+	}
+
+	sc.mu.Lock()
+	st.receiving = false
+	sc.receiving--
+	sc.mu.Unlock()
+
+	sc.handlers.Add(1)
+	go sc.runHandler(st)
+	return nil
+}
+
+// writeErrorStatus writes the response with the given status code and an empty body.
+//
+// The stream is reset with NO_ERROR code if the request isn't completely read,
+// so the client stops sending the request body.
+func (sc *http2ServerConn) writeErrorStatus(id uint32, statusCode int, reset bool) error {
+	maxFrameSize := sc.peerMaxFrameSize.Load()
+	return sc.write(func(fr *http2.Framer) error {
+		sc.enc.reset()
+		sc.enc.writeField(":status", strconv.Itoa(statusCode))
+		if err := writeHTTP2HeaderBlock(fr, id, true, sc.enc.bytes(), maxFrameSize); err != nil {
+			return err
+		}
+		if reset {
+			return fr.WriteRSTStream(id, http2.ErrCodeNo)
+		}
+		return nil
+	})
+}
+
+func (sc *http2ServerConn) runHandler(st *http2Stream) {
+	defer sc.handlers.Done()
+
+	s := sc.s
+	s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+
+	ctx := st.ctx
+	if sc.serverName != "" {
+		ctx.Response.Header.SetServer(sc.serverName)
+	}
+	ctx.connID = sc.connID
+	ctx.connRequestNum = st.requestNum
+	ctx.connTime = sc.connTime
+	ctx.time = time.Now()
+
+	var (
+		span       ServerSpan
+		trace      *ServerTrace
+		phaseStart time.Time
+	)
+	if tracer := s.Tracer; tracer != nil {
+		// Request headers are decoded by the frame reader,
+		// so the time before calling the handler is counted as BodyRead.
+		trace = &ServerTrace{
+			Start:    st.start,
+			BodyRead: ctx.time.Sub(st.start),
+		}
+		span = tracer.StartServerSpan(ctx)
+		phaseStart = time.Now()
+	}
+
+	s.Handler(ctx)
+
+	if span != nil {
+		trace.Handler = time.Since(phaseStart)
+		phaseStart = time.Now()
+	}
+
+	isHead := ctx.IsHead()
+	if timeoutResponse := ctx.timeoutResponse; timeoutResponse != nil {
+		accessLog := ctx.accessLog
+		ctx.accessLog = nil
+		// Acquire a new ctx because the old one will still be in use by the timeout out handler.
+		ctx = s.acquireCtx(sc.c)
+		timeoutResponse.CopyTo(&ctx.Response)
+		ctx.accessLog = accessLog
+	}
+	if isHead {
+		ctx.Response.SkipBody = true
+	}
+
+	// Connection hijacking isn't possible over HTTP/2.
+	ctx.hijackHandler = nil
+	ctx.hijackNoResponse = false
+
+	if sc.serverName != "" && len(ctx.Response.Header.Server()) == 0 {
+		ctx.Response.Header.SetServer(sc.serverName)
+	}
+
+	bytesWritten, err := sc.writeResponse(st, &ctx.Response)
+	if err != nil && !errors.Is(err, errHTTP2StreamClosed) {
+		sc.write(func(fr *http2.Framer) error { //nolint:errcheck
+			return fr.WriteRSTStream(st.id, http2.ErrCodeInternal)
+		})
+	}
+	if span != nil {
+		trace.Write = time.Since(phaseStart)
+		trace.BytesWritten = bytesWritten
+		trace.Err = err
+		span.End(ctx, trace)
+	}
+	if ctx.accessLog != nil {
+		ctx.finishAccessLog(bytesWritten)
+	}
+
+	s.releaseCtx(ctx)
+	sc.closeStream(st)
+}
+
+func (sc *http2ServerConn) closeStream(st *http2Stream) {
+	sc.mu.Lock()
+	delete(sc.streams, st.id)
+	if len(sc.streams) == 0 && !sc.closed {
+		sc.updateReadDeadlineLocked()
+	}
+	sc.mu.Unlock()
+}
+
+// writeResponse writes resp to the stream and returns the number of written body bytes.
+func (sc *http2ServerConn) writeResponse(st *http2Stream, resp *Response) (int64, error) {
+	h := &resp.Header
+	sendBody := !resp.mustSkipBody()
+
+	var body []byte
+	bodyStream := resp.bodyStream
+	if bodyStream == nil {
+		body = resp.bodyBytes()
+		if sendBody || len(body) > 0 {
+			h.SetContentLength(len(body))
+		}
+	} else if h.ContentLength() < 0 {
+		if n := limitedReaderSize(bodyStream); n >= 0 && int64(int(n)) == n {
+			h.SetContentLength(int(n))
+		}
+	}
+	hasTrailer := len(h.trailer) > 0
+	endStream := !sendBody || (bodyStream == nil && len(body) == 0 && !hasTrailer)
+
+	maxFrameSize := sc.peerMaxFrameSize.Load()
+	err := sc.write(func(fr *http2.Framer) error {
+		block := sc.encodeResponseHeader(h)
+		return writeHTTP2HeaderBlock(fr, st.id, endStream, block, maxFrameSize)
+	})
+	if err != nil || endStream {
+		return 0, resp.closeBodyStream(err)
+	}
+
+	var deadline time.Time
+	if st.writeTimeout > 0 {
+		deadline = time.Now().Add(st.writeTimeout)
+	}
+	w := &http2StreamWriter{
+		sc:       sc,
+		st:       st,
+		deadline: deadline,
+	}
+	if bodyStream != nil {
+		if n := h.ContentLength(); n >= 0 {
+			bodyStream = io.LimitReader(bodyStream, int64(n))
+		}
+		_, err = copyZeroAlloc(w, bodyStream)
+		if err == nil {
+			err = w.writeData(nil, !hasTrailer)
+		}
+		if errc := resp.closeBodyStream(err); err == nil {
+			err = errc
+		}
+	} else {
+		err = w.writeData(body, !hasTrailer)
+	}
+	if err != nil || !hasTrailer {
+		return w.written, err
+	}
+
+	maxFrameSize = sc.peerMaxFrameSize.Load()
+	err = sc.write(func(fr *http2.Framer) error {
+		block := sc.encodeResponseTrailer(h)
+		return writeHTTP2HeaderBlock(fr, st.id, true, block, maxFrameSize)
+	})
+	return w.written, err
+}
+
+// encodeResponseHeader encodes h into a header block.
+//
+// The call must be made under the write lock.
+func (sc *http2ServerConn) encodeResponseHeader(h *ResponseHeader) []byte {
+	e := sc.enc
+	e.reset()
+
+	statusCode := h.StatusCode()
+	if statusCode <= 0 {
+		statusCode = StatusOK
+	}
+	e.writeField(":status", strconv.Itoa(statusCode))
+
+	if server := h.Server(); len(server) > 0 {
+		e.writeKV(strServer, server)
+	}
+	if !h.noDefaultDate {
+		serverDateOnce.Do(updateServerDate)
+		e.writeKV(strDate, *serverDate.Load())
+	}
+	if h.ContentLength() != 0 || len(h.contentType) > 0 {
+		if contentType := h.ContentType(); len(contentType) > 0 {
+			e.writeKV(strContentType, contentType)
+		}
+	}
+	if contentEncoding := h.ContentEncoding(); len(contentEncoding) > 0 {
+		e.writeKV(strContentEncoding, contentEncoding)
+	}
+	if len(h.contentLengthBytes) > 0 {
+		e.writeKV(strContentLength, h.contentLengthBytes)
+	}
+	for i, n := 0, len(h.h); i < n; i++ {
+		kv := &h.h[i]
+		if isHTTP2ConnectionHeader(kv.key) || (!h.noDefaultDate && bytes.Equal(kv.key, strDate)) {
+			continue
+		}
+		if slices.ContainsFunc(h.trailer, func(t []byte) bool { return bytes.Equal(kv.key, t) }) {
+			continue
+		}
+		e.writeKV(kv.key, kv.value)
+	}
+	if len(h.trailer) > 0 {
+		e.writeKV(strTrailer, appendTrailerBytes(nil, h.trailer, strCommaSpace))
+	}
+	for i, n := 0, len(h.cookies); i < n; i++ {
+		e.writeKV(strSetCookie, h.cookies[i].value)
+	}
+	return e.bytes()
+}
+
+// encodeResponseTrailer encodes the trailer of h into a header block.
+//
+// The call must be made under the write lock.
+func (sc *http2ServerConn) encodeResponseTrailer(h *ResponseHeader) []byte {
+	e := sc.enc
+	e.reset()
+	for _, t := range h.trailer {
+		e.writeKV(t, h.peek(t))
+	}
+	return e.bytes()
+}
+
+// http2StreamWriter writes the response body as DATA frames
+// obeying the flow control.
+type http2StreamWriter struct {
+	deadline time.Time
+	sc       *http2ServerConn
+	st       *http2Stream
+
+	// written is the number of written DATA bytes.
+	written int64
+}
+
+func (w *http2StreamWriter) Write(p []byte) (int, error) {
+	if err := w.writeData(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeData writes p as DATA frames. The last frame ends the stream if endStream is set.
+func (w *http2StreamWriter) writeData(p []byte, endStream bool) error {
+	for {
+		n, err := w.reserveWindow(len(p))
+		if err != nil {
+			return err
+		}
+		chunk := p[:n]
+		p = p[n:]
+		last := len(p) == 0
+		err = w.sc.write(func(fr *http2.Framer) error {
+			return fr.WriteData(w.st.id, endStream && last, chunk)
+		})
+		if err == nil {
+			w.written += int64(len(chunk))
+		}
+		if err != nil || last {
+			return err
+		}
+	}
+}
+
+// reserveWindow waits until the flow control allows sending up to n bytes
+// and returns the number of bytes, which may be sent.
+func (w *http2StreamWriter) reserveWindow(n int) (int, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	if maxFrameSize := int(w.sc.peerMaxFrameSize.Load()); n > maxFrameSize {
+		n = maxFrameSize
+	}
+
+	sc := w.sc
+	st := w.st
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	var t *time.Timer
+	defer func() {
+		if t != nil {
+			t.Stop()
+		}
+	}()
+	for {
+		if sc.closed || st.reset {
+			return 0, errHTTP2StreamClosed
+		}
+		if m := min(int32(n), sc.sendWindow, st.sendWindow); m > 0 { // #nosec G115
+			sc.sendWindow -= m
+			st.sendWindow -= m
+			return int(m), nil
+		}
+
+		if !w.deadline.IsZero() {
+			d := time.Until(w.deadline)
+			if d <= 0 {
+				return 0, ErrTimeout
+			}
+			if t == nil {
+				t = time.AfterFunc(d, func() {
+					sc.mu.Lock()
+					sc.cond.Broadcast()
+					sc.mu.Unlock()
+				})
+			}
+		}
+		sc.cond.Wait()
+	}
+}
+
+var strH2CSwitchingProtocols = []byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+
+// peekPreface returns true if the connection starts with the HTTP/2
+// connection preface.
+//
+// The returned reader holds the peeked data and must be used for reading
+// from c afterwards.
+func (hs *http2Server) peekPreface(c net.Conn) (*bufio.Reader, bool, error) {
+	s := hs.s
+	// Apply ReadTimeout to the first bytes as for HTTP/1 requests.
+	// Fall back to IdleTimeout, so clients sending nothing
+	// don't hold the connection forever.
+	timeout := s.ReadTimeout
+	if timeout <= 0 {
+		timeout = s.IdleTimeout
+	}
+	if timeout > 0 {
+		if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, false, err
+		}
+		if s.ReadTimeout <= 0 {
+			// The deadline is applied only to the preface.
+			defer c.SetReadDeadline(zeroTime) //nolint:errcheck
+		}
+	}
+
+	br := s.acquireConnReader(c)
+
+	// Every HTTP/1 request is longer than the preface method,
+	// so it is safe to wait for it.
+	b, err := br.Peek(len("PRI "))
+	if err != nil {
+		if len(b) == 0 {
+			releaseReader(s, br)
+			return nil, false, err
+		}
+		// Let the HTTP/1 parser report the error.
+		return br, false, nil
+	}
+	if !strings.HasPrefix(http2.ClientPreface, string(b)) {
+		return br, false, nil
+	}
+
+	b, err = br.Peek(len(http2.ClientPreface))
+	if err != nil {
+		return br, false, nil
+	}
+	return br, string(b) == http2.ClientPreface, nil
+}
+
+// h2cUpgradeSettings returns the client settings if h contains
+// a valid 'Upgrade: h2c' request.
+//
+// See https://www.rfc-editor.org/rfc/rfc7540#section-3.2 .
+func (hs *http2Server) h2cUpgradeSettings(h *RequestHeader) ([]http2.Setting, bool) {
+	if !hs.conf.H2C || !h.ConnectionUpgrade() || !hasHeaderValue(h.Peek(HeaderUpgrade), strH2C) {
+		return nil, false
+	}
+	if !hasHeaderValue(h.Peek(HeaderConnection), strHTTP2Settings) {
+		return nil, false
+	}
+	values := h.PeekAll(b2s(strHTTP2Settings))
+	if len(values) != 1 {
+		return nil, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(b2s(values[0]), "="))
+	if err != nil || len(b)%6 != 0 {
+		return nil, false
+	}
+	settings := make([]http2.Setting, 0, len(b)/6)
+	for ; len(b) > 0; b = b[6:] {
+		st := http2.Setting{
+			ID:  http2.SettingID(binary.BigEndian.Uint16(b)),
+			Val: binary.BigEndian.Uint32(b[2:]),
+		}
+		if st.Valid() != nil {
+			return nil, false
+		}
+		settings = append(settings, st)
+	}
+	return settings, true
+}
+
+// serveUpgrade serves the connection upgraded from HTTP/1.1 with 'Upgrade: h2c' header.
+//
+// req is served as stream 1. br may contain already buffered data.
+func (hs *http2Server) serveUpgrade(c net.Conn, br *bufio.Reader, req *Request, settings []http2.Setting) error {
+	sc := newHTTP2ServerConn(hs, c, br)
+	return sc.serve(req, settings)
+}
+
+// startUpgradedStream applies the client settings from HTTP2-Settings header
+// and starts handling the upgraded request as stream 1.
+func (sc *http2ServerConn) startUpgradedStream(req *Request, settings []http2.Setting) error {
+	// The client settings are acknowledged implicitly by 101 response.
+	for _, s := range settings {
+		if err := sc.applySetting(s); err != nil {
+			return err
+		}
+	}
+
+	ctx := sc.s.acquireCtx(sc.c)
+	req.CopyTo(&ctx.Request)
+	sc.initCtx(ctx)
+	h := &ctx.Request.Header
+	h.Del(HeaderUpgrade)
+	h.Del(HeaderConnection)
+	h.DelBytes(strHTTP2Settings)
+	h.SetProtocol(http2Protocol)
+
+	sc.requestNum++
+	st := &http2Stream{
+		ctx:           ctx,
+		start:         time.Now(),
+		id:            1,
+		requestNum:    sc.requestNum,
+		contentLength: -1,
+		writeTimeout:  sc.s.WriteTimeout,
+	}
+
+	sc.mu.Lock()
+	sc.maxStreamID = st.id
+	st.sendWindow = sc.peerInitialWindowSize
+	sc.streams[st.id] = st
+	sc.mu.Unlock()
+
+	sc.handlers.Add(1)
+	go sc.runHandler(st)
+	return nil
+}
@@ -0,0 +1,602 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+func newHTTP2TestClient(t *testing.T, s *Server, conf *HTTP2Config) (*http.Client, *fasthttputil.InmemoryListener) {
+	t.Helper()
+
+	if err := ConfigureHTTP2Server(s, conf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certData, keyData, err := GenerateTestCertificate("localhost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ln := fasthttputil.NewInmemoryListener()
+	go s.ServeTLSEmbed(ln, certData, keyData) //nolint:errcheck
+
+	tr := &http2.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		DialTLSContext: func(ctx context.Context, _, _ string, cfg *tls.Config) (net.Conn, error) {
+			c, err := ln.Dial()
+			if err != nil {
+				return nil, err
+			}
+			tc := tls.Client(c, cfg)
+			if err := tc.HandshakeContext(ctx); err != nil {
+				return nil, err
+			}
+			if p := tc.ConnectionState().NegotiatedProtocol; p != http2NextProto {
+				return nil, fmt.Errorf("unexpected negotiated protocol %q", p)
+			}
+			return tc, nil
+		},
+	}
+	t.Cleanup(tr.CloseIdleConnections)
+	return &http.Client{Transport: tr}, ln
+}
+
+func TestConfigureHTTP2ServerInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{}
+	if err := ConfigureHTTP2Server(s, &HTTP2Config{MaxReadFrameSize: 100}); err == nil {
+		t.Fatal("expecting error for too small MaxReadFrameSize")
+	}
+	if err := ConfigureHTTP2Server(s, &HTTP2Config{InitialConnWindowSize: 100}); err == nil {
+		t.Fatal("expecting error for too small InitialConnWindowSize")
+	}
+
+	if err := ConfigureHTTP2Server(s, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := strings.Join(s.TLSConfig.NextProtos, ","); got != "h2,http/1.1" {
+		t.Fatalf("unexpected NextProtos %q. Expecting %q", got, "h2,http/1.1")
+	}
+}
+
+func TestHTTP2ServerRequests(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Request.Header.Protocol()) != "HTTP/2.0" {
+				ctx.Error("unexpected protocol", StatusInternalServerError)
+				return
+			}
+			ctx.Response.Header.Set("X-Method", string(ctx.Method()))
+			ctx.Response.Header.Set("X-Host", string(ctx.Host()))
+			ctx.Response.Header.Set("X-Foo", string(ctx.Request.Header.Peek("X-Foo")))
+			ctx.Response.Header.Set("X-Cookie", string(ctx.Request.Header.Cookie("a"))+string(ctx.Request.Header.Cookie("b")))
+			ctx.Response.Header.Set(HeaderConnection, "keep-alive")
+			ctx.SetContentType("text/plain")
+			fmt.Fprintf(ctx, "%s %s %s", ctx.RequestURI(), ctx.QueryArgs().Peek("q"), ctx.PostBody())
+		},
+	}
+	c, ln := newHTTP2TestClient(t, s, nil)
+	defer ln.Close()
+
+	testCases := []struct {
+		method string
+		body   string
+	}{
+		{MethodGet, ""},
+		{MethodPost, "hello"},
+		{MethodPut, strings.Repeat("x", 100000)},
+	}
+	for _, tc := range testCases {
+		var body io.Reader
+		if tc.body != "" {
+			body = strings.NewReader(tc.body)
+		}
+		req, err := http.NewRequest(tc.method, "https://example.com/foo?q=bar", body)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		req.Header.Set("X-Foo", "baz")
+		req.AddCookie(&http.Cookie{Name: "a", Value: "1"})
+		req.AddCookie(&http.Cookie{Name: "b", Value: "2"})
+
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		respBody, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if resp.ProtoMajor != 2 {
+			t.Fatalf("unexpected protocol %q. Expecting HTTP/2.0", resp.Proto)
+		}
+		if resp.StatusCode != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d. Body: %q", resp.StatusCode, StatusOK, respBody)
+		}
+		expectedBody := "/foo?q=bar bar " + tc.body
+		if string(respBody) != expectedBody {
+			t.Fatalf("unexpected body %q. Expecting %q", respBody, expectedBody)
+		}
+		if resp.ContentLength != int64(len(expectedBody)) {
+			t.Fatalf("unexpected content length %d. Expecting %d", resp.ContentLength, len(expectedBody))
+		}
+		expectedHeaders := map[string]string{
+			"X-Method":     tc.method,
+			"X-Host":       "example.com",
+			"X-Foo":        "baz",
+			"X-Cookie":     "12",
+			"Content-Type": "text/plain",
+			"Server":       defaultServerName,
+			"Connection":   "",
+		}
+		for k, v := range expectedHeaders {
+			if got := resp.Header.Get(k); got != v {
+				t.Fatalf("unexpected %s header %q. Expecting %q", k, got, v)
+			}
+		}
+		if resp.Header.Get("Date") == "" {
+			t.Fatal("missing Date header")
+		}
+	}
+}
+
+func TestHTTP2ServerConcurrentStreams(t *testing.T) {
+	t.Parallel()
+
+	const n = 50
+	var started sync.WaitGroup
+	started.Add(n)
+	release := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			started.Done()
+			<-release
+			ctx.WriteString(string(ctx.Path())) //nolint:errcheck
+		},
+	}
+	c, ln := newHTTP2TestClient(t, s, nil)
+	defer ln.Close()
+
+	errCh := make(chan error, n)
+	for i := range n {
+		go func() {
+			path := fmt.Sprintf("/%d", i)
+			resp, err := c.Get("https://example.com" + path)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err == nil && string(body) != path {
+				err = fmt.Errorf("unexpected body %q. Expecting %q", body, path)
+			}
+			errCh <- err
+		}()
+	}
+
+	// All the requests must be handled concurrently.
+	started.Wait()
+	close(release)
+	for range n {
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func TestHTTP2ServerFlowControl(t *testing.T) {
+	t.Parallel()
+
+	body := bytes.Repeat([]byte("0123456789"), 1<<18)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/stream" {
+				ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+					for i := 0; i < len(body); i += 1024 {
+						w.Write(body[i : i+1024]) //nolint:errcheck
+					}
+				})
+				return
+			}
+			ctx.SetBody(body)
+		},
+	}
+	c, ln := newHTTP2TestClient(t, s, &HTTP2Config{
+		InitialStreamWindowSize: http2InitialWindowSize,
+		InitialConnWindowSize:   http2InitialWindowSize,
+	})
+	defer ln.Close()
+
+	for _, path := range []string{"/", "/stream"} {
+		resp, err := c.Get("https://example.com" + path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, body) {
+			t.Fatalf("unexpected body for %q with len %d. Expecting len %d", path, len(got), len(body))
+		}
+	}
+
+	// Request bodies exceeding the advertised windows.
+	for range 3 {
+		resp, err := c.Post("https://example.com/", "text/plain", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body) //nolint:errcheck
+		resp.Body.Close()
+		if resp.StatusCode != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode, StatusOK)
+		}
+	}
+}
+
+func TestHTTP2ServerTrailer(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if err := ctx.Response.Header.SetTrailer("X-Checksum"); err != nil {
+				ctx.Error(err.Error(), StatusInternalServerError)
+				return
+			}
+			ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+				w.WriteString("body") //nolint:errcheck
+			})
+			ctx.Response.Header.Set("X-Checksum", "abc")
+		},
+	}
+	c, ln := newHTTP2TestClient(t, s, nil)
+	defer ln.Close()
+
+	resp, err := c.Get("https://example.com/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != "body" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "body")
+	}
+	if got := resp.Trailer.Get("X-Checksum"); got != "abc" {
+		t.Fatalf("unexpected trailer %q. Expecting %q", got, "abc")
+	}
+	if got := resp.Header.Get("X-Checksum"); got != "" {
+		t.Fatalf("unexpected X-Checksum header %q", got)
+	}
+}
+
+func TestHTTP2ServerMaxRequestBodySize(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		MaxRequestBodySize: 1000,
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.PostBody()) //nolint:errcheck
+		},
+	}
+	c, ln := newHTTP2TestClient(t, s, nil)
+	defer ln.Close()
+
+	body := strings.Repeat("x", 100000)
+	resp, err := c.Post("https://example.com/", "text/plain", io.MultiReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != StatusRequestEntityTooLarge {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode, StatusRequestEntityTooLarge)
+	}
+
+	// The connection must remain usable.
+	resp, err = c.Post("https://example.com/", "text/plain", strings.NewReader("small"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(got) != "small" {
+		t.Fatalf("unexpected body %q. Expecting %q", got, "small")
+	}
+}
+
+func TestHTTP2ServerShutdown(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			close(started)
+			<-release
+			ctx.WriteString("done") //nolint:errcheck
+		},
+	}
+	c, ln := newHTTP2TestClient(t, s, nil)
+
+	respCh := make(chan string, 1)
+	go func() {
+		resp, err := c.Get("https://example.com/")
+		if err != nil {
+			respCh <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		respCh <- string(body)
+	}()
+	<-started
+
+	shutdownCh := make(chan error, 1)
+	go func() {
+		shutdownCh <- s.Shutdown()
+	}()
+
+	select {
+	case err := <-shutdownCh:
+		t.Fatalf("Shutdown mustn't return before active streams are done: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if body := <-respCh; body != "done" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "done")
+	}
+	select {
+	case err := <-shutdownCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for Shutdown")
+	}
+	ln.Close()
+}
+
+func TestHTTP2ServerH2CPriorKnowledge(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			fmt.Fprintf(ctx, "%s %s %v", ctx.Request.Header.Protocol(), ctx.Path(), ctx.IsTLS())
+		},
+	}
+	if err := ConfigureHTTP2Server(s, &HTTP2Config{H2C: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	tr := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(context.Context, string, string, *tls.Config) (net.Conn, error) {
+			return ln.Dial()
+		},
+	}
+	defer tr.CloseIdleConnections()
+	c := &http.Client{Transport: tr}
+
+	resp, err := c.Get("http://example.com/foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != "HTTP/2.0 /foo false" {
+		t.Fatalf("unexpected body %q. Expecting %q", body, "HTTP/2.0 /foo false")
+	}
+
+	// HTTP/1 requests must be served as usual.
+	var req Request
+	var hresp Response
+	req.SetRequestURI("http://example.com/bar")
+	hc := &HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+	}
+	if err = hc.Do(&req, &hresp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(hresp.Body()) != "HTTP/1.1 /bar false" {
+		t.Fatalf("unexpected body %q. Expecting %q", hresp.Body(), "HTTP/1.1 /bar false")
+	}
+}
+
+func TestHTTP2ServerH2CShutdownSilentClient(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {},
+	}
+	if err := ConfigureHTTP2Server(s, &HTTP2Config{H2C: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	go s.Serve(ln) //nolint:errcheck
+
+	// The client sends nothing, so the server waits for the preface.
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	for s.GetOpenConnectionsCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown()
+	}()
+	select {
+	case err = <-shutdownErr:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for Shutdown")
+	}
+	if _, err = c.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection not closed")
+	}
+}
+
+func TestHTTP2ServerH2CIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler:     func(ctx *RequestCtx) {},
+		IdleTimeout: 100 * time.Millisecond,
+	}
+	if err := ConfigureHTTP2Server(s, &HTTP2Config{H2C: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	select {
+	case err = <-readErr:
+		if err == nil {
+			t.Fatal("expecting error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection without the preface isn't closed after IdleTimeout")
+	}
+}
+
+func TestHTTP2ServerH2CUpgrade(t *testing.T) {
+	t.Parallel()
+
+	for _, enabled := range []bool{true, false} {
+		s := &Server{
+			Handler: func(ctx *RequestCtx) {
+				fmt.Fprintf(ctx, "%s %s %s", ctx.Request.Header.Protocol(), ctx.Path(), ctx.PostBody())
+			},
+		}
+		if err := ConfigureHTTP2Server(s, &HTTP2Config{H2C: enabled}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ln := fasthttputil.NewInmemoryListener()
+		go s.Serve(ln) //nolint:errcheck
+
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err = c.Write([]byte("POST /foo HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\n" +
+			"Connection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAMAAABkAAQAAP__\r\n\r\nhello")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		br := bufio.NewReader(c)
+		var resp Response
+		if err = resp.Header.Read(br); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if !enabled {
+			if resp.StatusCode() != StatusOK {
+				t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusOK)
+			}
+			c.Close()
+			ln.Close()
+			continue
+		}
+
+		if resp.StatusCode() != StatusSwitchingProtocols {
+			t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusSwitchingProtocols)
+		}
+		if _, err = c.Write([]byte(http2.ClientPreface)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		fr := http2.NewFramer(c, br)
+		fr.ReadMetaHeaders = hpack.NewDecoder(http2InitialHeaderTableSz, nil)
+		if err = fr.WriteSettings(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var (
+			status string
+			body   []byte
+		)
+		for done := false; !done; {
+			f, err := fr.ReadFrame()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			switch f := f.(type) {
+			case *http2.MetaHeadersFrame:
+				if f.StreamID != 1 {
+					t.Fatalf("unexpected stream id %d. Expecting 1", f.StreamID)
+				}
+				status = f.PseudoValue("status")
+				done = f.StreamEnded()
+			case *http2.DataFrame:
+				body = append(body, f.Data()...)
+				done = f.StreamEnded()
+			}
+		}
+		if status != "200" {
+			t.Fatalf("unexpected status %q. Expecting %q", status, "200")
+		}
+		if string(body) != "HTTP/2.0 /foo hello" {
+			t.Fatalf("unexpected body %q. Expecting %q", body, "HTTP/2.0 /foo hello")
+		}
+
+		// The idle upgraded connection mustn't be counted as in-flight request.
+		// The stream 1 is un-counted right after its response is sent.
+		deadline := time.Now().Add(5 * time.Second)
+		for s.InFlightRequests() > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := s.InFlightRequests(); n != 0 {
+			t.Fatalf("unexpected number of in-flight requests %d. Expecting 0", n)
+		}
+		c.Close()
+		ln.Close()
+	}
+}
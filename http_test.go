@@ -9,6 +9,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -17,6 +18,7 @@ import (
 	"time"
 
 	"github.com/valyala/bytebufferpool"
+	"github.com/valyala/fasthttp/fasthttputil"
 )
 
 func TestInvalidTrailers(t *testing.T) {
@@ -4194,3 +4196,36 @@ func TestWriteBodyChunkedConcreteTypes(t *testing.T) {
 		}
 	}
 }
+
+func TestResponseWriteToSendFileReadFrom(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	body := bytes.Repeat([]byte("0123456789abcdef"), 4*1024)
+	if err := os.WriteFile(dir+"/big.txt", body, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var resp Response
+	if err := resp.SendFile(dir + "/big.txt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pc := fasthttputil.NewPipeConns()
+	defer pc.Close()
+	go func() {
+		io.Copy(io.Discard, pc.Conn2()) //nolint:errcheck
+	}()
+
+	w := &readFromRecorderConn{Conn: pc.Conn1(), fileBytes: &byteCounter{}}
+	n, err := resp.WriteTo(w)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n <= int64(len(body)) {
+		t.Fatalf("unexpected number of bytes written %d. Expecting more than %d", n, len(body))
+	}
+	if fileBytes := w.fileBytes.Load(); fileBytes != int64(len(body)) {
+		t.Fatalf("unexpected number of bytes sent via ReadFrom %d. Expecting %d", fileBytes, len(body))
+	}
+}
@@ -0,0 +1,109 @@
+// Package compresspool contains the compression level normalization
+// and the raw DEFLATE writer and reader pools shared by fasthttp packages.
+package compresspool
+
+import (
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/valyala/fasthttp/stackless"
+)
+
+// NewPoolMap returns pools for all the compression levels normalized
+// with NormalizeLevel.
+func NewPoolMap() []*sync.Pool {
+	// Initialize pools for all the compression levels defined
+	// in https://pkg.go.dev/compress/flate#pkg-constants .
+	// Compression levels are normalized with NormalizeLevel,
+	// so they fit [0..11].
+	m := make([]*sync.Pool, 0, 12)
+	for range 12 {
+		m = append(m, &sync.Pool{})
+	}
+	return m
+}
+
+// NormalizeLevel normalizes compression level into [0..11], so it could be
+// used as an index in the pool map returned by NewPoolMap.
+func NormalizeLevel(level int) int {
+	// -2 is the lowest compression level - flate.HuffmanOnly
+	// 9 is the highest compression level - flate.BestCompression
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		level = 6 // flate.DefaultCompression
+	}
+	return level + 2
+}
+
+// AcquireStacklessFlateWriter returns a raw DEFLATE writer
+// for the given compression level, which writes to w.
+//
+// Return the writer to the pool via ReleaseStacklessFlateWriter.
+func AcquireStacklessFlateWriter(w io.Writer, level int) stackless.Writer {
+	nLevel := NormalizeLevel(level)
+	p := stacklessFlateWriterPoolMap[nLevel]
+	v := p.Get()
+	if v == nil {
+		return stackless.NewWriter(w, func(w io.Writer) stackless.Writer {
+			return newRealFlateWriter(w, level)
+		})
+	}
+	sw := v.(stackless.Writer) //nolint:forcetypeassert
+	sw.Reset(w)
+	return sw
+}
+
+// ReleaseStacklessFlateWriter returns sw acquired via
+// AcquireStacklessFlateWriter to the pool.
+//
+// sw isn't closed, so the final block isn't written. Flush sw before
+// releasing it if the written data is needed.
+func ReleaseStacklessFlateWriter(sw stackless.Writer, level int) {
+	// The writer is reset on the next acquire, so there is no need
+	// in closing it and writing the final block to the destination.
+	nLevel := NormalizeLevel(level)
+	p := stacklessFlateWriterPoolMap[nLevel]
+	p.Put(sw)
+}
+
+func newRealFlateWriter(w io.Writer, level int) *flate.Writer {
+	zw, err := flate.NewWriter(w, level)
+	if err != nil {
+		// flate.NewWriter only errors for invalid
+		// compression levels. Clamp it to be min or max.
+		if level < flate.HuffmanOnly {
+			level = flate.HuffmanOnly
+		} else {
+			level = flate.BestCompression
+		}
+		zw, _ = flate.NewWriter(w, level)
+	}
+	return zw
+}
+
+var stacklessFlateWriterPoolMap = NewPoolMap()
+
+// AcquireFlateReader returns a raw DEFLATE reader, which reads from r
+// using the given preset dictionary.
+//
+// Return the reader to the pool via ReleaseFlateReader.
+func AcquireFlateReader(r io.Reader, dict []byte) (io.ReadCloser, error) {
+	v := flateReaderPool.Get()
+	if v == nil {
+		return flate.NewReaderDict(r, dict), nil
+	}
+	zr := v.(io.ReadCloser)                                    //nolint:forcetypeassert
+	if err := zr.(flate.Resetter).Reset(r, dict); err != nil { //nolint:forcetypeassert
+		return nil, err
+	}
+	return zr, nil
+}
+
+// ReleaseFlateReader closes zr acquired via AcquireFlateReader
+// and returns it to the pool.
+func ReleaseFlateReader(zr io.ReadCloser) {
+	zr.Close()
+	flateReaderPool.Put(zr)
+}
+
+var flateReaderPool sync.Pool
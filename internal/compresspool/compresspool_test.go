@@ -0,0 +1,84 @@
+package compresspool
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+)
+
+func TestNormalizeLevel(t *testing.T) {
+	t.Parallel()
+
+	for level, expected := range map[int]int{
+		flate.HuffmanOnly:     0,
+		flate.NoCompression:   2,
+		flate.BestCompression: 11,
+		-3:                    8,
+		10:                    8,
+	} {
+		if n := NormalizeLevel(level); n != expected {
+			t.Fatalf("unexpected normalized level %d for %d. Expecting %d", n, level, expected)
+		}
+	}
+}
+
+func TestStacklessFlateWriter(t *testing.T) {
+	t.Parallel()
+
+	for _, level := range []int{flate.HuffmanOnly, flate.BestSpeed, flate.BestCompression, 100} {
+		for range 3 {
+			var buf bytes.Buffer
+			zw := AcquireStacklessFlateWriter(&buf, level)
+			if _, err := zw.Write([]byte("foobar")); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := zw.Flush(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ReleaseStacklessFlateWriter(zw, level)
+
+			// The writer isn't closed, so the stream ends after the flushed block.
+			b, err := io.ReadAll(flate.NewReader(&buf))
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("unexpected error: %v. Expecting %v", err, io.ErrUnexpectedEOF)
+			}
+			if string(b) != "foobar" {
+				t.Fatalf("unexpected data %q. Expecting %q", b, "foobar")
+			}
+		}
+	}
+}
+
+func TestFlateReader(t *testing.T) {
+	t.Parallel()
+
+	dict := []byte("foobar")
+	var buf bytes.Buffer
+	zw, err := flate.NewWriterDict(&buf, flate.BestCompression, dict)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = zw.Write([]byte("foobarbaz")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for range 3 {
+		zr, err := AcquireFlateReader(bytes.NewReader(buf.Bytes()), dict)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(b) != "foobarbaz" {
+			t.Fatalf("unexpected data %q. Expecting %q", b, "foobarbaz")
+		}
+		ReleaseFlateReader(zr)
+	}
+}
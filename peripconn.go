@@ -2,6 +2,7 @@ package fasthttp
 
 import (
 	"crypto/tls"
+	"io"
 	"net"
 	"sync"
 )
@@ -87,6 +88,15 @@ func acquirePerIPConn(conn net.Conn, ip uint32, counter *perIPConnCounter) net.C
 	return c
 }
 
+// ReadFrom passes r to the underlying connection if it implements io.ReaderFrom,
+// so files are sent via sendfile over TCP connections.
+func (c *perIPConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return copyZeroAlloc(c.Conn, r)
+}
+
 func (c *perIPConn) Close() error {
 	c.lock.Lock()
 	cc := c.Conn
@@ -0,0 +1,580 @@
+package fasthttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultProxyProtocolReadHeaderTimeout is the default timeout for reading
+// PROXY protocol headers used by ProxyProtocolListener.
+const DefaultProxyProtocolReadHeaderTimeout = 10 * time.Second
+
+// PROXY protocol v2 TLV types.
+//
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt .
+const (
+	ProxyProtocolTLVTypeALPN      = 0x01
+	ProxyProtocolTLVTypeAuthority = 0x02
+	ProxyProtocolTLVTypeCRC32C    = 0x03
+	ProxyProtocolTLVTypeNoop      = 0x04
+	ProxyProtocolTLVTypeUniqueID  = 0x05
+	ProxyProtocolTLVTypeSSL       = 0x20
+	ProxyProtocolTLVTypeNetNS     = 0x30
+)
+
+// ProxyProtocolTLV is a type-length-value field of PROXY protocol v2 header.
+type ProxyProtocolTLV struct {
+	Value []byte
+	Type  byte
+}
+
+// ProxyProtocolHeader is the PROXY protocol header received
+// at the beginning of the connection.
+type ProxyProtocolHeader struct {
+	// SourceAddr is the address of the client connected to the proxy.
+	//
+	// It is nil if Local is true or if the proxy doesn't know the address.
+	SourceAddr net.Addr
+
+	// DestinationAddr is the address the client connected to.
+	//
+	// It is nil if Local is true or if the proxy doesn't know the address.
+	DestinationAddr net.Addr
+
+	// TLVs contains type-length-value fields of v2 header.
+	TLVs []ProxyProtocolTLV
+
+	// Version is the PROXY protocol version, 1 or 2.
+	Version int
+
+	// Local is true if the connection has been established by the proxy
+	// itself, e.g. for health checks.
+	Local bool
+}
+
+// TLV returns the value of the first TLV with the given type.
+func (h *ProxyProtocolHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Authority returns the host name sent by the client, i.e. TLS SNI.
+//
+// An empty string is returned if the proxy didn't send it.
+func (h *ProxyProtocolHeader) Authority() string {
+	v, _ := h.TLV(ProxyProtocolTLVTypeAuthority)
+	return string(v)
+}
+
+// ProxyProtocol returns the PROXY protocol header received at the beginning
+// of the connection accepted by ProxyProtocolListener.
+//
+// nil is returned if the connection hasn't been accepted by ProxyProtocolListener
+// or if it has been accepted from the source not listed in TrustedSources.
+func (ctx *RequestCtx) ProxyProtocol() *ProxyProtocolHeader {
+	c := ctx.c
+	for {
+		switch cc := c.(type) {
+		case *proxyProtocolConn:
+			return cc.header
+		case *perIPConn:
+			c = cc.Conn
+		case *perIPTLSConn:
+			c = cc.Conn
+		case interface{ NetConn() net.Conn }:
+			// *tls.Conn
+			c = cc.NetConn()
+		default:
+			return nil
+		}
+	}
+}
+
+// ProxyProtocolListener accepts connections from proxies and load balancers
+// prepending PROXY protocol v1 or v2 headers to connections,
+// such as HAProxy and AWS Network Load Balancer.
+//
+// RemoteAddr and LocalAddr of the accepted connections return the addresses
+// from the header, so RequestCtx.RemoteAddr, RequestCtx.RemoteIP and
+// Server.MaxConnsPerIP use the client address instead of the proxy address.
+// The header is returned by RequestCtx.ProxyProtocol.
+//
+// Headers are read in background goroutines, so connections with slow
+// headers don't delay accepting other connections. Connections
+// with invalid headers are closed.
+//
+// Wrap the listener passed to Server.ServeTLS and Server.ServeTLSEmbed
+// for serving TLS connections, since the header precedes the TLS handshake.
+//
+// Usage:
+//
+//	ln := &fasthttp.ProxyProtocolListener{
+//		Listener:       ln,
+//		TrustedSources: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+//	}
+//	s.Serve(ln)
+type ProxyProtocolListener struct {
+	// Listener is the wrapped listener.
+	Listener net.Listener
+
+	// Logger is used for logging invalid headers.
+	//
+	// The default logger is used if nil.
+	Logger Logger
+
+	// TrustedSources contains networks of the proxies allowed to send
+	// PROXY protocol headers.
+	//
+	// Connections from other sources are accepted as is, without reading
+	// the header, so clients connecting directly cannot spoof their address.
+	// Headers aren't read at all if TrustedSources is empty
+	// and TrustAllSources isn't set.
+	TrustedSources []netip.Prefix
+
+	// ReadHeaderTimeout is the maximum duration for reading the header.
+	//
+	// DefaultProxyProtocolReadHeaderTimeout is used if not set.
+	ReadHeaderTimeout time.Duration
+
+	// TrustAllSources enables reading headers from all the connections
+	// regardless of TrustedSources.
+	//
+	// Set it only if the listener is reachable exclusively by the proxies,
+	// e.g. via a unix socket or a private network.
+	TrustAllSources bool
+
+	accepted chan proxyProtocolAcceptResult
+
+	// done is closed when the listener is closed.
+	done chan struct{}
+	err  error
+
+	initOnce sync.Once
+	stopOnce sync.Once
+}
+
+type proxyProtocolAcceptResult struct {
+	c   net.Conn
+	err error
+}
+
+// Accept returns the next connection with the PROXY protocol header read.
+func (ln *ProxyProtocolListener) Accept() (net.Conn, error) {
+	ln.initOnce.Do(ln.init)
+
+	select {
+	case r := <-ln.accepted:
+		return r.c, r.err
+	case <-ln.done:
+		return nil, ln.err
+	}
+}
+
+// Close closes the wrapped listener and the connections,
+// which haven't been accepted yet.
+func (ln *ProxyProtocolListener) Close() error {
+	ln.initOnce.Do(ln.init)
+
+	err := ln.Listener.Close()
+	ln.stop(net.ErrClosed)
+	return err
+}
+
+// Addr returns the address of the wrapped listener.
+func (ln *ProxyProtocolListener) Addr() net.Addr {
+	return ln.Listener.Addr()
+}
+
+func (ln *ProxyProtocolListener) init() {
+	ln.accepted = make(chan proxyProtocolAcceptResult)
+	ln.done = make(chan struct{})
+	go ln.acceptLoop()
+}
+
+func (ln *ProxyProtocolListener) stop(err error) {
+	ln.stopOnce.Do(func() {
+		ln.err = err
+		close(ln.done)
+	})
+}
+
+func (ln *ProxyProtocolListener) acceptLoop() {
+	for {
+		c, err := ln.Listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				ln.deliver(proxyProtocolAcceptResult{err: err})
+				continue
+			}
+			ln.stop(err)
+			return
+		}
+
+		if !ln.isTrusted(c.RemoteAddr()) {
+			ln.deliver(proxyProtocolAcceptResult{c: c})
+			continue
+		}
+		go ln.handshake(c)
+	}
+}
+
+func (ln *ProxyProtocolListener) handshake(c net.Conn) {
+	pc, err := newProxyProtocolConn(c, ln.readHeaderTimeout())
+	if err != nil {
+		_ = c.Close()
+		ln.logger().Printf("cannot read PROXY protocol header from %s: %v", c.RemoteAddr(), err)
+		return
+	}
+	ln.deliver(proxyProtocolAcceptResult{c: pc})
+}
+
+func (ln *ProxyProtocolListener) deliver(r proxyProtocolAcceptResult) {
+	select {
+	case ln.accepted <- r:
+	case <-ln.done:
+		if r.c != nil {
+			_ = r.c.Close()
+		}
+	}
+}
+
+func (ln *ProxyProtocolListener) isTrusted(addr net.Addr) bool {
+	if ln.TrustAllSources {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	return ipInPrefixes(tcpAddr.IP, ln.TrustedSources)
+}
+
+func (ln *ProxyProtocolListener) readHeaderTimeout() time.Duration {
+	if ln.ReadHeaderTimeout > 0 {
+		return ln.ReadHeaderTimeout
+	}
+	return DefaultProxyProtocolReadHeaderTimeout
+}
+
+func (ln *ProxyProtocolListener) logger() Logger {
+	if ln.Logger != nil {
+		return ln.Logger
+	}
+	return defaultLogger
+}
+
+// proxyProtocolConn is a connection with the PROXY protocol header read.
+type proxyProtocolConn struct {
+	net.Conn
+
+	header *ProxyProtocolHeader
+
+	// buf contains the data read after v1 header.
+	buf []byte
+}
+
+func newProxyProtocolConn(c net.Conn, timeout time.Duration) (*proxyProtocolConn, error) {
+	if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	header, buf, err := readProxyProtocolHeader(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SetReadDeadline(zeroTime); err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{
+		Conn:   c,
+		header: header,
+		buf:    buf,
+	}, nil
+}
+
+func (c *proxyProtocolConn) Read(p []byte) (int, error) {
+	if len(c.buf) > 0 {
+		n := copy(p, c.buf)
+		c.buf = c.buf[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// ReadFrom passes r to the underlying connection if it implements io.ReaderFrom,
+// so files are sent via sendfile over TCP connections.
+func (c *proxyProtocolConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return copyZeroAlloc(c.Conn, r)
+}
+
+// RemoteAddr returns the client address from the PROXY protocol header.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if c.header.SourceAddr != nil {
+		return c.header.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to
+// from the PROXY protocol header.
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	if c.header.DestinationAddr != nil {
+		return c.header.DestinationAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+var (
+	proxyProtocolV1Prefix    = []byte("PROXY ")
+	proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyProtocolNoHeader   = errors.New("missing PROXY protocol header")
+	errProxyProtocolV1Header   = errors.New("invalid PROXY protocol v1 header")
+	errProxyProtocolV2Header   = errors.New("invalid PROXY protocol v2 header")
+	errProxyProtocolV2Checksum = errors.New("PROXY protocol v2 header checksum mismatch")
+)
+
+const (
+	// proxyProtocolV1MaxLen is the maximum length of v1 header including CRLF.
+	proxyProtocolV1MaxLen = 107
+
+	// proxyProtocolV2HeaderLen is the length of v2 header before addresses.
+	proxyProtocolV2HeaderLen = 16
+)
+
+// readProxyProtocolHeader reads PROXY protocol header from r.
+//
+// It returns the data read after the header.
+func readProxyProtocolHeader(r io.Reader) (*ProxyProtocolHeader, []byte, error) {
+	// Both v1 and v2 headers are longer than v2 signature.
+	buf := make([]byte, proxyProtocolV1MaxLen)
+	if _, err := io.ReadFull(r, buf[:len(proxyProtocolV2Signature)]); err != nil {
+		return nil, nil, err
+	}
+
+	if bytes.Equal(buf[:len(proxyProtocolV2Signature)], proxyProtocolV2Signature) {
+		h, err := readProxyProtocolV2Header(r, buf[:len(proxyProtocolV2Signature)])
+		return h, nil, err
+	}
+	if !bytes.HasPrefix(buf, proxyProtocolV1Prefix) {
+		return nil, nil, errProxyProtocolNoHeader
+	}
+
+	// The data following the header may be read together with the header,
+	// since v1 header length is unknown.
+	n := len(proxyProtocolV2Signature)
+	for {
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			h, err := parseProxyProtocolV1Header(buf[:i+1])
+			return h, buf[i+1 : n], err
+		}
+		if n == len(buf) {
+			return nil, nil, errProxyProtocolV1Header
+		}
+		m, err := r.Read(buf[n:])
+		n += m
+		if err != nil && m == 0 {
+			return nil, nil, err
+		}
+	}
+}
+
+func parseProxyProtocolV1Header(b []byte) (*ProxyProtocolHeader, error) {
+	if !bytes.HasSuffix(b, strCRLF) {
+		return nil, errProxyProtocolV1Header
+	}
+	fields := bytes.Split(b[len(proxyProtocolV1Prefix):len(b)-len(strCRLF)], []byte(" "))
+
+	h := &ProxyProtocolHeader{
+		Version: 1,
+	}
+	switch string(fields[0]) {
+	case "UNKNOWN":
+		// The proxy doesn't know the client address.
+		// The rest of the header must be ignored.
+		h.Local = true
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errProxyProtocolV1Header
+	}
+	if len(fields) != 5 {
+		return nil, errProxyProtocolV1Header
+	}
+
+	is4 := string(fields[0]) == "TCP4"
+	src, err := parseProxyProtocolV1Addr(fields[1], fields[3], is4)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyProtocolV1Addr(fields[2], fields[4], is4)
+	if err != nil {
+		return nil, err
+	}
+	h.SourceAddr = src
+	h.DestinationAddr = dst
+	return h, nil
+}
+
+func parseProxyProtocolV1Addr(ipStr, portStr []byte, is4 bool) (*net.TCPAddr, error) {
+	ip, err := netip.ParseAddr(string(ipStr))
+	if err != nil || ip.Is4() != is4 || ip.Zone() != "" {
+		return nil, errProxyProtocolV1Header
+	}
+	port, err := strconv.ParseUint(string(portStr), 10, 16)
+	if err != nil {
+		return nil, errProxyProtocolV1Header
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyProtocolV2Header reads v2 header following the signature.
+func readProxyProtocolV2Header(r io.Reader, signature []byte) (*ProxyProtocolHeader, error) {
+	var prefix [proxyProtocolV2HeaderLen]byte
+	copy(prefix[:], signature)
+	if _, err := io.ReadFull(r, prefix[len(signature):]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, proxyProtocolV2HeaderLen+int(binary.BigEndian.Uint16(prefix[14:])))
+	copy(b, prefix[:])
+	if _, err := io.ReadFull(r, b[proxyProtocolV2HeaderLen:]); err != nil {
+		return nil, err
+	}
+	return parseProxyProtocolV2Header(b)
+}
+
+func parseProxyProtocolV2Header(b []byte) (*ProxyProtocolHeader, error) {
+	h := &ProxyProtocolHeader{
+		Version: 2,
+	}
+
+	verCmd := b[12]
+	if verCmd>>4 != 2 {
+		return nil, errProxyProtocolV2Header
+	}
+	switch verCmd & 0x0f {
+	case 0x00:
+		h.Local = true
+	case 0x01:
+	default:
+		return nil, errProxyProtocolV2Header
+	}
+
+	payload := b[proxyProtocolV2HeaderLen:]
+	var addrLen int
+	switch famProto := b[13]; famProto >> 4 {
+	case 0x00:
+		// AF_UNSPEC
+	case 0x01:
+		// AF_INET
+		addrLen = 2*net.IPv4len + 4
+	case 0x02:
+		// AF_INET6
+		addrLen = 2*net.IPv6len + 4
+	case 0x03:
+		// AF_UNIX
+		addrLen = 2 * 108
+	default:
+		return nil, fmt.Errorf("%w: unsupported address family %d", errProxyProtocolV2Header, famProto>>4)
+	}
+	if len(payload) < addrLen {
+		return nil, errProxyProtocolV2Header
+	}
+	if !h.Local {
+		if proto := b[13] & 0x0f; proto > 0x01 {
+			// Only SOCK_STREAM connections may be proxied to TCP listeners.
+			return nil, fmt.Errorf("%w: unsupported transport protocol %d", errProxyProtocolV2Header, proto)
+		}
+		h.SourceAddr, h.DestinationAddr = parseProxyProtocolV2Addrs(b[13], payload[:addrLen])
+	}
+
+	tlvs := payload[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, errProxyProtocolV2Header
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < 3+n {
+			return nil, errProxyProtocolV2Header
+		}
+		tlv := ProxyProtocolTLV{
+			Type:  tlvs[0],
+			Value: tlvs[3 : 3+n],
+		}
+		if tlv.Type == ProxyProtocolTLVTypeCRC32C {
+			if err := checkProxyProtocolV2Checksum(b, tlv.Value); err != nil {
+				return nil, err
+			}
+		}
+		h.TLVs = append(h.TLVs, tlv)
+		tlvs = tlvs[3+n:]
+	}
+	return h, nil
+}
+
+func parseProxyProtocolV2Addrs(famProto byte, b []byte) (src, dst net.Addr) {
+	switch famProto >> 4 {
+	case 0x01:
+		srcIP := netip.AddrFrom4([4]byte(b[:4]))
+		dstIP := netip.AddrFrom4([4]byte(b[4:8]))
+		return proxyProtocolV2TCPAddr(srcIP, b[8:]), proxyProtocolV2TCPAddr(dstIP, b[10:])
+	case 0x02:
+		srcIP := netip.AddrFrom16([16]byte(b[:16]))
+		dstIP := netip.AddrFrom16([16]byte(b[16:32]))
+		return proxyProtocolV2TCPAddr(srcIP, b[32:]), proxyProtocolV2TCPAddr(dstIP, b[34:])
+	case 0x03:
+		return proxyProtocolV2UnixAddr(b[:108]), proxyProtocolV2UnixAddr(b[108:])
+	default:
+		return nil, nil
+	}
+}
+
+func proxyProtocolV2TCPAddr(ip netip.Addr, port []byte) *net.TCPAddr {
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, binary.BigEndian.Uint16(port)))
+}
+
+func proxyProtocolV2UnixAddr(b []byte) *net.UnixAddr {
+	if n := bytes.IndexByte(b, 0); n >= 0 {
+		b = b[:n]
+	}
+	return &net.UnixAddr{
+		Name: string(b),
+		Net:  "unix",
+	}
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checkProxyProtocolV2Checksum verifies the checksum of the header b.
+//
+// The checksum is calculated over the header with the checksum value zeroed.
+// sum must point into b.
+func checkProxyProtocolV2Checksum(b, sum []byte) error {
+	if len(sum) != 4 {
+		return errProxyProtocolV2Header
+	}
+	expected := binary.BigEndian.Uint32(sum)
+	var zero [4]byte
+	copy(sum, zero[:])
+	actual := crc32.Checksum(b, crc32cTable)
+	binary.BigEndian.PutUint32(sum, expected)
+	if actual != expected {
+		return errProxyProtocolV2Checksum
+	}
+	return nil
+}
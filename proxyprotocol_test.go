@@ -0,0 +1,375 @@
+package fasthttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func appendProxyProtocolV2Header(dst []byte, verCmd, famProto byte, addrs []byte, tlvs []ProxyProtocolTLV, checksum bool) []byte {
+	start := len(dst)
+	dst = append(dst, proxyProtocolV2Signature...)
+	dst = append(dst, verCmd, famProto, 0, 0)
+	dst = append(dst, addrs...)
+	for _, tlv := range tlvs {
+		dst = append(dst, tlv.Type)
+		dst = binary.BigEndian.AppendUint16(dst, uint16(len(tlv.Value)))
+		dst = append(dst, tlv.Value...)
+	}
+	if checksum {
+		dst = append(dst, ProxyProtocolTLVTypeCRC32C, 0, 4, 0, 0, 0, 0)
+	}
+	binary.BigEndian.PutUint16(dst[start+14:], uint16(len(dst)-start-proxyProtocolV2HeaderLen))
+	if checksum {
+		sum := crc32.Checksum(dst[start:], crc32.MakeTable(crc32.Castagnoli))
+		binary.BigEndian.PutUint32(dst[len(dst)-4:], sum)
+	}
+	return dst
+}
+
+func proxyProtocolV2TCP4Addrs(src, dst string, srcPort, dstPort uint16) []byte {
+	srcIP := netip.MustParseAddr(src).As4()
+	dstIP := netip.MustParseAddr(dst).As4()
+	b := append(srcIP[:], dstIP[:]...)
+	b = binary.BigEndian.AppendUint16(b, srcPort)
+	return binary.BigEndian.AppendUint16(b, dstPort)
+}
+
+func startProxyProtocolServer(t *testing.T, s *Server, readHeaderTimeout time.Duration) *fasthttputil.InmemoryListener {
+	t.Helper()
+
+	ln := fasthttputil.NewInmemoryListener()
+	pln := &ProxyProtocolListener{
+		Listener:          ln,
+		Logger:            &testLogger{},
+		TrustedSources:    []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	go s.Serve(pln) //nolint:errcheck
+	t.Cleanup(func() {
+		pln.Close()
+	})
+	return ln
+}
+
+func dialProxyProtocol(t *testing.T, ln *fasthttputil.InmemoryListener, srcIP string) net.Conn {
+	t.Helper()
+
+	c, err := ln.DialWithLocalAddr(&net.TCPAddr{IP: net.ParseIP(srcIP), Port: 1234})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+func doProxyProtocolRequest(c net.Conn, br *bufio.Reader, header []byte) (*Response, error) {
+	req := append(header, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"...)
+	if _, err := c.Write(req); err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := resp.Read(br); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			h := ctx.ProxyProtocol()
+			if h == nil {
+				fmt.Fprintf(ctx, "%s|none", ctx.RemoteAddr())
+				return
+			}
+			fmt.Fprintf(ctx, "%s|%s|v%d|%q", ctx.RemoteAddr(), ctx.LocalAddr(), h.Version, h.Authority())
+		},
+	}
+	ln := startProxyProtocolServer(t, s, 0)
+
+	tlvs := []ProxyProtocolTLV{
+		{Type: ProxyProtocolTLVTypeALPN, Value: []byte("http/1.1")},
+		{Type: ProxyProtocolTLVTypeAuthority, Value: []byte("example.com")},
+	}
+	for _, tc := range []struct {
+		name     string
+		srcIP    string
+		header   []byte
+		expected string
+	}{
+		{
+			name:     "v1 tcp4",
+			srcIP:    "10.0.0.1",
+			header:   []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 443\r\n"),
+			expected: `1.2.3.4:1111|5.6.7.8:443|v1|""`,
+		},
+		{
+			name:     "v1 tcp6",
+			srcIP:    "10.0.0.1",
+			header:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1111 443\r\n"),
+			expected: `[2001:db8::1]:1111|[2001:db8::2]:443|v1|""`,
+		},
+		{
+			name:     "v1 unknown",
+			srcIP:    "10.0.0.1",
+			header:   []byte("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"),
+			expected: `10.0.0.1:1234|InmemoryListener|v1|""`,
+		},
+		{
+			name:     "v2 tcp4",
+			srcIP:    "10.0.0.1",
+			header:   appendProxyProtocolV2Header(nil, 0x21, 0x11, proxyProtocolV2TCP4Addrs("1.2.3.4", "5.6.7.8", 1111, 443), tlvs, true),
+			expected: `1.2.3.4:1111|5.6.7.8:443|v2|"example.com"`,
+		},
+		{
+			name:     "v2 local",
+			srcIP:    "10.0.0.1",
+			header:   appendProxyProtocolV2Header(nil, 0x20, 0x00, nil, nil, false),
+			expected: `10.0.0.1:1234|InmemoryListener|v2|""`,
+		},
+		{
+			name:     "untrusted source",
+			srcIP:    "192.168.0.1",
+			expected: "192.168.0.1:1234|none",
+		},
+	} {
+		c := dialProxyProtocol(t, ln, tc.srcIP)
+		br := bufio.NewReader(c)
+		// Send two requests for making sure the header is read only once.
+		for range 2 {
+			resp, err := doProxyProtocolRequest(c, br, tc.header)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", tc.name, err)
+			}
+			if string(resp.Body()) != tc.expected {
+				t.Fatalf("%s: unexpected response %q. Expecting %q", tc.name, resp.Body(), tc.expected)
+			}
+			tc.header = nil
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		header []byte
+	}{
+		{
+			name: "missing header",
+		},
+		{
+			name:   "invalid v1 header",
+			header: []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111\r\n"),
+		},
+		{
+			name: "invalid v2 checksum",
+			header: func() []byte {
+				b := appendProxyProtocolV2Header(nil, 0x21, 0x11, proxyProtocolV2TCP4Addrs("1.2.3.4", "5.6.7.8", 1111, 443), nil, true)
+				b[len(b)-1]++
+				return b
+			}(),
+		},
+	} {
+		c := dialProxyProtocol(t, ln, "10.0.0.1")
+		if _, err := doProxyProtocolRequest(c, bufio.NewReader(c), tc.header); err == nil {
+			t.Fatalf("%s: expecting error", tc.name)
+		}
+	}
+}
+
+func TestProxyProtocolListenerReadHeaderTimeout(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString(ctx.RemoteIP().String()) //nolint:errcheck
+		},
+	}
+	ln := startProxyProtocolServer(t, s, 100*time.Millisecond)
+
+	// The connection without the header mustn't block other connections.
+	slow := dialProxyProtocol(t, ln, "10.0.0.1")
+
+	c := dialProxyProtocol(t, ln, "10.0.0.2")
+	resp, err := doProxyProtocolRequest(c, bufio.NewReader(c), []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 443\r\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(resp.Body()) != "1.2.3.4" {
+		t.Fatalf("unexpected response %q. Expecting %q", resp.Body(), "1.2.3.4")
+	}
+
+	// The connection is closed after the timeout.
+	if _, err := slow.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.EOF)
+	}
+}
+
+func TestProxyProtocolListenerMaxConnsPerIP(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString(ctx.RemoteIP().String()) //nolint:errcheck
+		},
+		MaxConnsPerIP: 1,
+		Logger:        &testLogger{},
+	}
+	ln := startProxyProtocolServer(t, s, 0)
+
+	// The connections come from the same proxy, while the limit
+	// is applied to client addresses.
+	for _, tc := range []struct {
+		clientIP           string
+		expectedStatusCode int
+	}{
+		{clientIP: "1.2.3.4", expectedStatusCode: StatusOK},
+		{clientIP: "1.2.3.5", expectedStatusCode: StatusOK},
+		{clientIP: "1.2.3.4", expectedStatusCode: StatusTooManyRequests},
+	} {
+		c := dialProxyProtocol(t, ln, "10.0.0.1")
+		header := []byte("PROXY TCP4 " + tc.clientIP + " 5.6.7.8 1111 443\r\n")
+		resp, err := doProxyProtocolRequest(c, bufio.NewReader(c), header)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.StatusCode() != tc.expectedStatusCode {
+			t.Fatalf("unexpected status code %d for %s. Expecting %d", resp.StatusCode(), tc.clientIP, tc.expectedStatusCode)
+		}
+	}
+}
+
+func TestProxyProtocolListenerTrustedSources(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			fmt.Fprintf(ctx, "%s|%v", ctx.RemoteIP(), ctx.ProxyProtocol() != nil)
+		},
+		Logger: &testLogger{},
+	}
+	for _, tc := range []struct {
+		name     string
+		ln       *ProxyProtocolListener
+		header   []byte
+		expected string
+	}{
+		{
+			// Headers aren't trusted by default.
+			name:     "empty trusted sources",
+			ln:       &ProxyProtocolListener{},
+			expected: "10.0.0.1|false",
+		},
+		{
+			name: "trust all sources",
+			ln: &ProxyProtocolListener{
+				TrustAllSources: true,
+			},
+			header:   []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 443\r\n"),
+			expected: "1.2.3.4|true",
+		},
+	} {
+		ln := fasthttputil.NewInmemoryListener()
+		tc.ln.Listener = ln
+		tc.ln.Logger = &testLogger{}
+		go s.Serve(tc.ln) //nolint:errcheck
+
+		c := dialProxyProtocol(t, ln, "10.0.0.1")
+		resp, err := doProxyProtocolRequest(c, bufio.NewReader(c), tc.header)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if string(resp.Body()) != tc.expected {
+			t.Fatalf("%s: unexpected response %q. Expecting %q", tc.name, resp.Body(), tc.expected)
+		}
+		tc.ln.Close()
+	}
+
+	// The spoofed header from untrusted client isn't parsed.
+	ln := fasthttputil.NewInmemoryListener()
+	pln := &ProxyProtocolListener{
+		Listener: ln,
+	}
+	go s.Serve(pln) //nolint:errcheck
+	defer pln.Close()
+	c := dialProxyProtocol(t, ln, "10.0.0.1")
+	resp, err := doProxyProtocolRequest(c, bufio.NewReader(c), []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 443\r\n"))
+	if err == nil && bytes.HasPrefix(resp.Body(), []byte("1.2.3.4|")) {
+		t.Fatalf("unexpected spoofed client address in response %q", resp.Body())
+	}
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	t.Parallel()
+
+	// The data following v1 header is returned.
+	h, buf, err := readProxyProtocolHeader(bytes.NewReader([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 443\r\nGET / HTTP/1.1\r\n")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.SourceAddr.String() != "1.2.3.4:1111" {
+		t.Fatalf("unexpected source address %q. Expecting %q", h.SourceAddr, "1.2.3.4:1111")
+	}
+	if string(buf) != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("unexpected data after header %q. Expecting %q", buf, "GET / HTTP/1.1\r\n")
+	}
+
+	ipv6Addrs := make([]byte, 36)
+	ipv6Addrs[15] = 1
+	ipv6Addrs[31] = 2
+	h, _, err = readProxyProtocolHeader(bytes.NewReader(appendProxyProtocolV2Header(nil, 0x21, 0x21, ipv6Addrs, []ProxyProtocolTLV{
+		{Type: ProxyProtocolTLVTypeUniqueID, Value: []byte("foo")},
+		{Type: ProxyProtocolTLVTypeNoop},
+	}, false)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if h.SourceAddr.String() != "[::1]:0" || h.DestinationAddr.String() != "[::2]:0" {
+		t.Fatalf("unexpected addresses %q, %q. Expecting %q, %q", h.SourceAddr, h.DestinationAddr, "[::1]:0", "[::2]:0")
+	}
+	if v, ok := h.TLV(ProxyProtocolTLVTypeUniqueID); !ok || string(v) != "foo" {
+		t.Fatalf("unexpected unique id %q. Expecting %q", v, "foo")
+	}
+	if _, ok := h.TLV(ProxyProtocolTLVTypeAuthority); ok {
+		t.Fatal("unexpected authority")
+	}
+
+	tcp4Addrs := proxyProtocolV2TCP4Addrs("1.2.3.4", "5.6.7.8", 1111, 443)
+	for _, header := range [][]byte{
+		[]byte("GET / HTTP/1.1\r\n\r\n"),
+		[]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 443\n"),
+		[]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 443 1\r\n"),
+		[]byte("PROXY TCP4 2001:db8::1 5.6.7.8 1111 443\r\n"),
+		[]byte("PROXY TCP6 1.2.3.4 2001:db8::2 1111 443\r\n"),
+		[]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1111 65536\r\n"),
+		[]byte("PROXY UDP4 1.2.3.4 5.6.7.8 1111 443\r\n"),
+		[]byte("PROXY TCP4 " + string(bytes.Repeat([]byte("1"), 200)) + "\r\n"),
+		[]byte("PROXY TCP4 1.2.3.4"),
+		appendProxyProtocolV2Header(nil, 0x11, 0x11, tcp4Addrs, nil, false),
+		appendProxyProtocolV2Header(nil, 0x22, 0x11, tcp4Addrs, nil, false),
+		appendProxyProtocolV2Header(nil, 0x21, 0x41, tcp4Addrs, nil, false),
+		appendProxyProtocolV2Header(nil, 0x21, 0x12, tcp4Addrs, nil, false),
+		appendProxyProtocolV2Header(nil, 0x21, 0x21, tcp4Addrs, nil, false),
+		append(appendProxyProtocolV2Header(nil, 0x21, 0x11, tcp4Addrs, nil, false)[:15], 20),
+		func() []byte {
+			b := appendProxyProtocolV2Header(nil, 0x21, 0x11, tcp4Addrs, []ProxyProtocolTLV{{Type: 0xea, Value: []byte("foo")}}, false)
+			return b[:len(b)-1]
+		}(),
+	} {
+		if _, _, err := readProxyProtocolHeader(bytes.NewReader(header)); err == nil {
+			t.Fatalf("expecting error for header %q", header)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package fasthttp
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -10,6 +11,7 @@ import (
 	"log"
 	"mime/multipart"
 	"net"
+	"net/netip"
 	"os"
 	"strings"
 	"sync"
@@ -219,6 +221,21 @@ type Server struct {
 	// ConnState type and associated constants for details.
 	ConnState func(net.Conn, ConnState)
 
+	// OnDrainStart is called when Drain starts draining the server.
+	OnDrainStart func()
+
+	// OnDrainEnd is called when Drain finishes draining the server.
+	//
+	// err is the error returned by Drain. It is nil if all the connections
+	// have been closed.
+	OnDrainEnd func(err error)
+
+	// Tracer is notified about every served request with the request timings.
+	//
+	// Tracer is used for both HTTP/1.x and HTTP/2 requests.
+	// See ServerTrace for the differences in HTTP/2 timings.
+	Tracer ServerTracer
+
 	// TLSConfig optionally provides a TLS configuration for use
 	// by ServeTLS, ServeTLSEmbed, ListenAndServeTLS, ListenAndServeTLSEmbed,
 	// AppendCert, AppendCertEmbed and NextProto.
@@ -240,8 +257,21 @@ type Server struct {
 	// consistent with net/http.
 	FormValueFunc FormValueFunc
 
+	// TrustedProxies contains networks of the reverse proxies and load balancers
+	// allowed to pass the client address, protocol and host in Forwarded
+	// and X-Forwarded-* request headers.
+	//
+	// The headers are used by RequestCtx.ClientIP, RequestCtx.ClientProto
+	// and RequestCtx.ClientHost only for requests from the trusted proxies.
+	//
+	// The headers are ignored by default.
+	TrustedProxies []netip.Prefix
+
 	nextProtos map[string]ServeHandler
 
+	// http2 is set by ConfigureHTTP2Server.
+	http2 *http2Server
+
 	concurrencyCh chan struct{}
 
 	idleConns map[net.Conn]*atomic.Int64
@@ -350,6 +380,8 @@ type Server struct {
 	concurrency atomic.Uint32
 	open        atomic.Int32
 	stop        atomic.Int32
+	inFlight    atomic.Int32
+	draining    atomic.Bool
 
 	rejectedRequestsCount atomic.Uint32
 
@@ -472,6 +504,18 @@ type Server struct {
 	// bounded. Calling PostBody or Request.Body reads the entire remaining body
 	// into memory.
 	StreamRequestBody bool
+
+	// TrustedProxyOverwritesXForwarded must be set if the nearest trusted
+	// proxy overwrites X-Forwarded-Proto and X-Forwarded-Host request headers
+	// instead of appending the values to them like to X-Forwarded-For,
+	// e.g. nginx with 'proxy_set_header X-Forwarded-Proto $scheme'.
+	//
+	// The last X-Forwarded-Proto and X-Forwarded-Host values are used
+	// by RequestCtx.ClientProto and RequestCtx.ClientHost then.
+	//
+	// By default the values are used only if their number matches
+	// the number of X-Forwarded-For values.
+	TrustedProxyOverwritesXForwarded bool
 }
 
 // TimeoutHandler creates RequestHandler, which returns StatusRequestTimeout
@@ -647,6 +691,10 @@ type RequestCtx struct {
 	formValueFunc FormValueFunc
 	fbr           firstByteReader
 
+	// accessLog contains entries set by AccessLog,
+	// which are written after writing the response.
+	accessLog *accessLogEntry
+
 	// Incoming request.
 	//
 	// Copying Request by value is forbidden. Use pointer to Request instead.
@@ -855,6 +903,10 @@ type tlsConn interface {
 //
 // tls.Conn is an encrypted connection (aka SSL, HTTPS).
 func (ctx *RequestCtx) IsTLS() bool {
+	return isTLSConn(ctx.c)
+}
+
+func isTLSConn(c net.Conn) bool {
 	// cast to (tlsConn) instead of (*tls.Conn), since it catches
 	// cases with overridden tls.Conn such as:
 	//
@@ -865,12 +917,12 @@ func (ctx *RequestCtx) IsTLS() bool {
 	// }
 
 	// perIPConn wraps the net.Conn in the Conn field
-	if pic, ok := ctx.c.(*perIPConn); ok {
+	if pic, ok := c.(*perIPConn); ok {
 		_, ok := pic.Conn.(tlsConn)
 		return ok
 	}
 
-	_, ok := ctx.c.(tlsConn)
+	_, ok := c.(tlsConn)
 	return ok
 }
 
@@ -909,6 +961,7 @@ func (ctx *RequestCtx) reset() {
 	ctx.remoteAddr = nil
 	ctx.time = zeroTime
 	ctx.c = nil
+	ctx.accessLog = nil
 
 	// Don't reset ctx.s!
 	// We have a pool per server so the next time this ctx is used it
@@ -1583,6 +1636,88 @@ func (ctx *RequestCtx) IfModifiedSince(lastModified time.Time) bool {
 	return ifMod.Before(lastModified)
 }
 
+// IfNoneMatch returns true if etag doesn't match any entity tag
+// from 'If-None-Match' request header.
+//
+// Entity tags are compared using the weak comparison, so W/"foo" matches "foo".
+// '*' matches any etag.
+//
+// The function returns true also if 'If-None-Match' request header is missing.
+func (ctx *RequestCtx) IfNoneMatch(etag []byte) bool {
+	ifNoneMatch := ctx.Request.Header.peek(strIfNoneMatch)
+	if len(ifNoneMatch) == 0 {
+		return true
+	}
+	return !eTagListMatch(ifNoneMatch, etag)
+}
+
+// IfRange returns true if byte ranges from 'Range' request header
+// must be sent according to 'If-Range' request header.
+//
+// 'If-Range' header may contain either an entity tag, which must strongly
+// match etag, or a date, which must be equal to lastModified.
+// Weak entity tags never match.
+//
+// The function returns true also if 'If-Range' request header is missing.
+func (ctx *RequestCtx) IfRange(etag []byte, lastModified time.Time) bool {
+	ifRange := ctx.Request.Header.peek(strIfRange)
+	if len(ifRange) == 0 {
+		return true
+	}
+	if ifRange[0] == '"' || bytes.HasPrefix(ifRange, strWeakETagPrefix) {
+		return ifRange[0] == '"' && len(etag) > 0 && etag[0] == '"' && bytes.Equal(ifRange, etag)
+	}
+	date, err := ParseHTTPDate(ifRange)
+	if err != nil {
+		return false
+	}
+	return date.Equal(lastModified.Truncate(time.Second))
+}
+
+// eTagListMatch returns true if etag matches any entity tag from
+// the comma-separated list using the weak comparison.
+func eTagListMatch(list, etag []byte) bool {
+	etag = trimWeakETagPrefix(etag)
+	for {
+		for len(list) > 0 && (list[0] == ',' || list[0] == ' ' || list[0] == '\t') {
+			list = list[1:]
+		}
+		if len(list) == 0 {
+			return false
+		}
+		if list[0] == '*' {
+			return true
+		}
+		list = trimWeakETagPrefix(list)
+		if list[0] != '"' {
+			// Invalid entity tag. Skip it.
+			n := bytes.IndexByte(list, ',')
+			if n < 0 {
+				return false
+			}
+			list = list[n:]
+			continue
+		}
+		// Entity tags may contain commas, so the closing quote is searched for.
+		n := bytes.IndexByte(list[1:], '"')
+		if n < 0 {
+			return false
+		}
+		tag := list[:n+2]
+		if bytes.Equal(tag, etag) {
+			return true
+		}
+		list = list[n+2:]
+	}
+}
+
+func trimWeakETagPrefix(etag []byte) []byte {
+	if len(etag) > len(strWeakETagPrefix) && bytes.HasPrefix(etag, strWeakETagPrefix) {
+		return etag[len(strWeakETagPrefix):]
+	}
+	return etag
+}
+
 // NotModified resets response and sets '304 Not Modified' response status code.
 func (ctx *RequestCtx) NotModified() {
 	ctx.Response.Reset()
@@ -1867,9 +2002,10 @@ func (s *Server) ServeTLS(ln net.Listener, certFile, keyFile string) error {
 
 	s.mu.Unlock()
 
-	return s.Serve(
-		tls.NewListener(ln, tlsConfig),
-	)
+	return s.Serve(&tlsListener{
+		Listener: tls.NewListener(ln, tlsConfig),
+		ln:       ln,
+	})
 }
 
 // ServeTLSEmbed serves HTTPS requests from the given listener.
@@ -1894,9 +2030,10 @@ func (s *Server) ServeTLSEmbed(ln net.Listener, certData, keyData []byte) error
 
 	s.mu.Unlock()
 
-	return s.Serve(
-		tls.NewListener(ln, tlsConfig),
-	)
+	return s.Serve(&tlsListener{
+		Listener: tls.NewListener(ln, tlsConfig),
+		ln:       ln,
+	})
 }
 
 // AppendCert appends certificate and keyfile to TLS Configuration.
@@ -2368,6 +2505,30 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 	idleConnTime.Store(connTime.Add(time.Second * 5).Unix())
 	s.idleConnsMu.Unlock()
 
+	// Detect cleartext HTTP/2 connections with prior knowledge
+	// before the HTTP/1 parser takes over.
+	// The connection is tracked as idle while waiting for the preface,
+	// so silent clients are closed on shutdown.
+	var h2cReader *bufio.Reader
+	if hs := s.http2; hs != nil && hs.conf.H2C && proto == "" && !isTLSConn(c) {
+		var isHTTP2 bool
+		h2cReader, isHTTP2, err = hs.peekPreface(c)
+		if err != nil || isHTTP2 {
+			s.untrackIdleConn(c)
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+		if isHTTP2 {
+			err = hs.serveConnWithReader(c, h2cReader)
+			releaseReader(s, h2cReader)
+			return err
+		}
+	}
+
 	serverName := s.getServerName()
 	connRequestNum := uint64(0)
 	connID := nextConnID()
@@ -2391,8 +2552,26 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 
 		connectionClose bool
 
+		// inFlight is true while the request is counted in Server.InFlightRequests.
+		inFlight bool
+
 		continueReadingRequest = true
+
+		tracer     = s.Tracer
+		span       ServerSpan
+		trace      *ServerTrace
+		phaseStart time.Time
+
+		// connWriter is c wrapped by statsWriter for traced servers
+		// and access logs, so the number of written bytes may be calculated.
+		connWriter io.Writer = c
+		sw         *statsWriter
 	)
+	if tracer != nil {
+		sw = &statsWriter{w: c}
+		connWriter = sw
+	}
+	br = h2cReader
 	for {
 		connRequestNum++
 
@@ -2449,6 +2628,18 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 		if err == nil {
 			idleConnTime.Store(0)
 			s.setState(c, StateActive)
+			if !inFlight {
+				s.inFlight.Add(1)
+				inFlight = true
+			}
+			if tracer != nil {
+				// trace is allocated only for traced servers,
+				// since it escapes to the heap.
+				if trace == nil {
+					trace = &ServerTrace{}
+				}
+				*trace = ServerTrace{Start: time.Now()}
+			}
 
 			if s.ReadTimeout > 0 {
 				if err = c.SetReadDeadline(time.Now().Add(s.ReadTimeout)); err != nil {
@@ -2487,6 +2678,9 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 			}
 
 			if err == nil {
+				if tracer != nil {
+					trace.HeaderRead = time.Since(trace.Start)
+				}
 				if onHdrRecv := s.HeaderReceived; onHdrRecv != nil {
 					reqConf := onHdrRecv(&ctx.Request.Header)
 					if reqConf.ReadTimeout > 0 {
@@ -2519,11 +2713,17 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 
 				if err == nil {
 					// read body
+					if tracer != nil {
+						phaseStart = time.Now()
+					}
 					if s.StreamRequestBody {
 						err = ctx.Request.readBodyStream(br, maxRequestBodySize, s.GetOnly, !s.DisablePreParseMultipartForm)
 					} else {
 						err = ctx.Request.readLimitBody(br, maxRequestBodySize, s.GetOnly, !s.DisablePreParseMultipartForm)
 					}
+					if tracer != nil {
+						trace.BodyRead = time.Since(phaseStart)
+					}
 				}
 			}
 			// When StreamRequestBody is set to true, we cannot safely release br.
@@ -2556,6 +2756,24 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 			break
 		}
 
+		// 'Upgrade: h2c' request handling.
+		if hs := s.http2; hs != nil && !isTLS {
+			if settings, ok := hs.h2cUpgradeSettings(&ctx.Request.Header); ok {
+				if bw != nil && bw.Buffered() > 0 {
+					if err = bw.Flush(); err != nil {
+						break
+					}
+				}
+				// The upgraded request and the following streams are counted
+				// by the HTTP/2 connection while they are handled.
+				s.setState(c, StateIdle)
+				s.inFlight.Add(-1)
+				inFlight = false
+				err = hs.serveUpgrade(c, br, &ctx.Request, settings)
+				break
+			}
+		}
+
 		// 'Expect: 100-continue' request handling.
 		// See https://www.rfc-editor.org/rfc/rfc9110.html#field.expect for details.
 		if ctx.Request.MayContinue() {
@@ -2582,7 +2800,7 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 
 			if continueReadingRequest {
 				if bw == nil {
-					bw = acquireWriter(ctx)
+					bw = s.acquireConnWriter(connWriter)
 				}
 
 				// Send 'HTTP/1.1 100 Continue' response.
@@ -2604,11 +2822,17 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 					br = acquireReader(ctx)
 				}
 
+				if tracer != nil {
+					phaseStart = time.Now()
+				}
 				if s.StreamRequestBody {
 					err = ctx.Request.ContinueReadBodyStream(br, maxRequestBodySize, !s.DisablePreParseMultipartForm)
 				} else {
 					err = ctx.Request.ContinueReadBody(br, maxRequestBodySize, !s.DisablePreParseMultipartForm)
 				}
+				if tracer != nil {
+					trace.BodyRead += time.Since(phaseStart)
+				}
 				if (!s.StreamRequestBody && s.ReduceMemoryUsage && br.Buffered() == 0) || err != nil {
 					releaseReader(s, br)
 					br = nil
@@ -2631,16 +2855,29 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 		ctx.connRequestNum = connRequestNum
 		ctx.time = time.Now()
 
+		if tracer != nil {
+			span = tracer.StartServerSpan(ctx)
+			phaseStart = time.Now()
+		}
+
 		// If a client denies a request the handler should not be called
 		if continueReadingRequest {
 			s.Handler(ctx)
 		}
 
+		if span != nil {
+			trace.Handler = time.Since(phaseStart)
+			phaseStart = time.Now()
+		}
+
 		timeoutResponse = ctx.timeoutResponse
 		if timeoutResponse != nil {
+			accessLog := ctx.accessLog
+			ctx.accessLog = nil
 			// Acquire a new ctx because the old one will still be in use by the timeout out handler.
 			ctx = s.acquireCtx(c)
 			timeoutResponse.CopyTo(&ctx.Response)
+			ctx.accessLog = accessLog
 		}
 
 		if ctx.IsHead() {
@@ -2668,7 +2905,8 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 		connectionClose = connectionClose ||
 			(s.MaxRequestsPerConn > 0 && connRequestNum >= uint64(s.MaxRequestsPerConn)) || // #nosec G115
 			ctx.Response.Header.ConnectionClose() ||
-			(s.CloseOnShutdown && s.stop.Load() == 1)
+			(s.CloseOnShutdown && s.stop.Load() == 1) ||
+			s.draining.Load()
 		if connectionClose {
 			ctx.Response.Header.SetConnectionClose()
 		} else if !ctx.Request.Header.IsHTTP11() {
@@ -2684,7 +2922,23 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 
 		if !hijackNoResponse {
 			if bw == nil {
-				bw = acquireWriter(ctx)
+				bw = s.acquireConnWriter(connWriter)
+			}
+			if sw == nil && ctx.accessLog != nil {
+				// Start counting written bytes for access logs.
+				if err = bw.Flush(); err != nil {
+					break
+				}
+				sw = &statsWriter{w: c}
+				connWriter = sw
+				bw.Reset(sw)
+			}
+			var writtenStart int64
+			if sw != nil {
+				writtenStart = sw.bytesWritten + int64(bw.Buffered())
+			}
+			if span != nil {
+				phaseStart = time.Now()
 			}
 			if err = writeResponse(ctx, bw); err != nil {
 				break
@@ -2701,6 +2955,19 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 					break
 				}
 			}
+			var bytesWritten int64
+			if sw != nil {
+				bytesWritten = sw.bytesWritten + int64(bw.Buffered()) - writtenStart
+			}
+			if span != nil {
+				trace.Write = time.Since(phaseStart)
+				trace.BytesWritten = bytesWritten
+				span.End(ctx, trace)
+				span = nil
+			}
+			if ctx.accessLog != nil {
+				ctx.finishAccessLog(bytesWritten)
+			}
 			if connectionClose {
 				break
 			}
@@ -2710,6 +2977,15 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 			}
 		}
 
+		if span != nil {
+			span.End(ctx, trace)
+			span = nil
+		}
+		if ctx.accessLog != nil {
+			// The response isn't written for hijacked connections.
+			ctx.finishAccessLog(0)
+		}
+
 		if hijackHandler != nil {
 			var hjr io.Reader = c
 			if br != nil {
@@ -2742,6 +3018,8 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 
 		idleConnTime.Store(ctx.time.Unix())
 		s.setState(c, StateIdle)
+		s.inFlight.Add(-1)
+		inFlight = false
 		ctx.Request.Reset()
 		ctx.Response.Reset()
 
@@ -2751,6 +3029,24 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 		}
 	}
 
+	if inFlight {
+		s.inFlight.Add(-1)
+	}
+
+	if span != nil {
+		// The response couldn't be written.
+		trace.Write = time.Since(phaseStart)
+		trace.Err = err
+		span.End(ctx, trace)
+	}
+	// ctx mustn't be accessed after the connection has been hijacked,
+	// since it is released by hijackConnHandler.
+	// The access log has been already finished before the hijacking.
+	if hijackHandler == nil && ctx.accessLog != nil {
+		// The response couldn't be written.
+		ctx.finishAccessLog(0)
+	}
+
 	if br != nil {
 		releaseReader(s, br)
 	}
@@ -2761,15 +3057,19 @@ func (s *Server) serveConnCounted(c net.Conn, countConcurrency bool) error {
 		s.releaseCtx(ctx)
 	}
 
+	s.untrackIdleConn(c)
+
+	return err
+}
+
+// untrackIdleConn removes c from the connections closed by closeIdleConns.
+func (s *Server) untrackIdleConn(c net.Conn) {
 	s.idleConnsMu.Lock()
-	ic, ok := s.idleConns[c]
-	if ok {
+	if ic, ok := s.idleConns[c]; ok {
 		idleConnTimePool.Put(ic)
 		delete(s.idleConns, c)
 	}
 	s.idleConnsMu.Unlock()
-
-	return err
 }
 
 func (s *Server) setState(nc net.Conn, state ConnState) {
@@ -2898,16 +3198,20 @@ func acquireByteReader(ctxP **RequestCtx) (*bufio.Reader, error) {
 }
 
 func acquireReader(ctx *RequestCtx) *bufio.Reader {
-	v := ctx.s.readerPool.Get()
+	return ctx.s.acquireConnReader(ctx.c)
+}
+
+func (s *Server) acquireConnReader(c net.Conn) *bufio.Reader {
+	v := s.readerPool.Get()
 	if v == nil {
-		n := ctx.s.ReadBufferSize
+		n := s.ReadBufferSize
 		if n <= 0 {
 			n = defaultReadBufferSize
 		}
-		return bufio.NewReaderSize(ctx.c, n)
+		return bufio.NewReaderSize(c, n)
 	}
 	r := v.(*bufio.Reader) //nolint:forcetypeassert
-	r.Reset(ctx.c)
+	r.Reset(c)
 	return r
 }
 
@@ -2916,17 +3220,21 @@ func releaseReader(s *Server, r *bufio.Reader) {
 }
 
 func acquireWriter(ctx *RequestCtx) *bufio.Writer {
-	v := ctx.s.writerPool.Get()
+	return ctx.s.acquireConnWriter(ctx.c)
+}
+
+func (s *Server) acquireConnWriter(w io.Writer) *bufio.Writer {
+	v := s.writerPool.Get()
 	if v == nil {
-		n := ctx.s.WriteBufferSize
+		n := s.WriteBufferSize
 		if n <= 0 {
 			n = defaultWriteBufferSize
 		}
-		return bufio.NewWriterSize(ctx.c, n)
+		return bufio.NewWriterSize(w, n)
 	}
-	w := v.(*bufio.Writer) //nolint:forcetypeassert
-	w.Reset(ctx.c)
-	return w
+	bw := v.(*bufio.Writer) //nolint:forcetypeassert
+	bw.Reset(w)
+	return bw
 }
 
 func releaseWriter(s *Server, w *bufio.Writer) {
@@ -3059,6 +3059,49 @@ func TestRequestCtxIfModifiedSince(t *testing.T) {
 	}
 }
 
+func TestRequestCtxIfNoneMatch(t *testing.T) {
+	t.Parallel()
+
+	var ctx RequestCtx
+	var req Request
+	ctx.Init(&req, nil, defaultLogger)
+
+	etag := []byte(`"foo"`)
+	if !ctx.IfNoneMatch(etag) {
+		t.Fatal("IfNoneMatch must return true for non-existing If-None-Match header")
+	}
+
+	for _, v := range []string{
+		`"foo"`,
+		`W/"foo"`,
+		`*`,
+		`"bar", "foo"`,
+		`"a,b",W/"foo"`,
+		`invalid, "foo"`,
+	} {
+		ctx.Request.Header.Set(HeaderIfNoneMatch, v)
+		if ctx.IfNoneMatch(etag) {
+			t.Fatalf("If-None-Match %q must match %q", v, etag)
+		}
+		if ctx.IfNoneMatch([]byte(`W/"foo"`)) {
+			t.Fatalf("If-None-Match %q must match weak %q", v, etag)
+		}
+	}
+
+	for _, v := range []string{
+		`"bar"`,
+		`"foo`,
+		`foo`,
+		`"a,"foo"`,
+		`"bar", W/"baz"`,
+	} {
+		ctx.Request.Header.Set(HeaderIfNoneMatch, v)
+		if !ctx.IfNoneMatch(etag) {
+			t.Fatalf("If-None-Match %q mustn't match %q", v, etag)
+		}
+	}
+}
+
 func TestRequestCtxSendFileNotModified(t *testing.T) {
 	t.Parallel()
 
@@ -5238,3 +5281,131 @@ func TestRequestCtxInitShouldNotBeCanceledIssue1879(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// byteCounter counts the bytes written to it.
+type byteCounter struct {
+	atomic.Int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// readFromRecorderConn records the bytes sent via ReadFrom from files,
+// i.e. the bytes *net.TCPConn would send via sendfile.
+type readFromRecorderConn struct {
+	net.Conn
+	fileBytes *byteCounter
+}
+
+func (c *readFromRecorderConn) ReadFrom(r io.Reader) (int64, error) {
+	isFile := false
+	switch r := r.(type) {
+	case *os.File:
+		isFile = true
+	case *io.LimitedReader:
+		_, isFile = r.R.(*os.File)
+	}
+	if isFile {
+		// Count the bytes before they are sent, so they are counted
+		// when the peer receives them.
+		r = io.TeeReader(r, c.fileBytes)
+	}
+	return copyZeroAlloc(c.Conn, r)
+}
+
+type readFromRecorderListener struct {
+	net.Listener
+	fileBytes byteCounter
+}
+
+func (ln *readFromRecorderListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &readFromRecorderConn{Conn: c, fileBytes: &ln.fileBytes}, nil
+}
+
+func TestServerSendFileReadFrom(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	body := bytes.Repeat([]byte("0123456789abcdef"), 4*1024)
+	if err := os.WriteFile(dir+"/big.txt", body, 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Traced servers write responses via statsWriter.
+	for _, traced := range []bool{false, true} {
+		testServerSendFileReadFrom(t, dir, body, traced)
+	}
+}
+
+func testServerSendFileReadFrom(t *testing.T, dir string, body []byte, traced bool) {
+	t.Helper()
+
+	fsHandler := (&FS{Root: dir, AcceptByteRange: true}).NewRequestHandler()
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			if string(ctx.Path()) == "/sendfile" {
+				if err := ctx.Response.SendFile(dir + "/big.txt"); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			fsHandler(ctx)
+		},
+		// Connections are wrapped into perIPConn.
+		MaxConnsPerIP: 10,
+	}
+	if traced {
+		s.Tracer = &testServerTracer{}
+	}
+	ln := &readFromRecorderListener{Listener: fasthttputil.NewInmemoryListener()}
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	c := &HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) {
+			return ln.Listener.(*fasthttputil.InmemoryListener).DialWithLocalAddr(&net.TCPAddr{
+				IP:   net.IPv4(1, 2, 3, 4),
+				Port: 1234,
+			})
+		},
+	}
+
+	expectedFileBytes := int64(0)
+	for _, tc := range []struct {
+		path      string
+		byteRange string
+		body      []byte
+	}{
+		{path: "/sendfile", body: body},
+		{path: "/big.txt", body: body},
+		{path: "/big.txt", byteRange: "bytes=10-20009", body: body[10:20010]},
+	} {
+		req := AcquireRequest()
+		resp := AcquireResponse()
+		req.SetRequestURI("http://example.com" + tc.path)
+		if tc.byteRange != "" {
+			req.Header.Set(HeaderRange, tc.byteRange)
+		}
+		if err := c.Do(req, resp); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(resp.Body(), tc.body) {
+			t.Fatalf("unexpected body for %q with length %d. Expecting length %d", tc.path, len(resp.Body()), len(tc.body))
+		}
+		ReleaseRequest(req)
+		ReleaseResponse(resp)
+
+		expectedFileBytes += int64(len(tc.body))
+		if n := ln.fileBytes.Load(); n != expectedFileBytes {
+			t.Fatalf("unexpected number of bytes sent via ReadFrom for %q (traced=%v): %d. Expecting %d",
+				tc.path, traced, n, expectedFileBytes)
+		}
+	}
+}
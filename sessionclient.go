@@ -0,0 +1,273 @@
+package fasthttp
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// SessionClient is a stateful client emulating browser sessions.
+//
+// SessionClient sends requests via Client and additionally:
+//
+//   - stores cookies from responses into CookieJar and sends them
+//     with subsequent requests, including redirect hops;
+//   - sets Referer header to the url of the previous request;
+//   - sets default headers configured via SetHeader and SetBasicAuth;
+//   - records the redirect chain followed by the last request.
+//
+// Usage:
+//
+//	s := &fasthttp.SessionClient{}
+//	s.SetHeader("Accept-Language", "en")
+//	statusCode, body, err := s.Get(nil, "https://example.com/login")
+//	...
+//	for _, hop := range s.Redirects() {
+//		fmt.Printf("%d %s -> %s\n", hop.StatusCode, hop.URL, hop.Location)
+//	}
+//
+// SessionClient instance MUST NOT be used from concurrently running goroutines.
+// Create a SessionClient per session instead. Multiple sessions may share
+// the same Client.
+type SessionClient struct {
+	noCopy noCopy
+
+	// Client sends session requests.
+	//
+	// Cookies are stored both in Client.CookieJar and in CookieJar
+	// if Client.CookieJar is set, so it is better to leave it empty.
+	//
+	// The default client is used if not set.
+	Client *Client
+
+	// CookieJar stores session cookies.
+	//
+	// InMemoryCookieJar is used if not set.
+	CookieJar CookieJar
+
+	jar InMemoryCookieJar
+
+	lastURL string
+
+	header []argsKV
+
+	redirects []*RedirectHop
+}
+
+// RedirectHop describes a redirect response followed by SessionClient.
+type RedirectHop struct {
+	// Header contains the redirect response headers.
+	Header ResponseHeader
+
+	// URL is the requested url.
+	URL string
+
+	// Location is the url the request was redirected to.
+	Location string
+
+	// StatusCode is the redirect response status code.
+	StatusCode int
+}
+
+// SetHeader sets the default header sent with every session request
+// unless the request already contains the header.
+func (s *SessionClient) SetHeader(key, value string) {
+	s.header = setArg(s.header, key, value, argsHasValue)
+}
+
+// DelHeader deletes the default header set via SetHeader.
+func (s *SessionClient) DelHeader(key string) {
+	s.header = delAllArgs(s.header, key)
+}
+
+// SetBasicAuth sets the default Authorization header sent with every
+// session request to the basic auth with the given username and password.
+//
+// The Authorization header isn't sent to other hosts while following redirects.
+func (s *SessionClient) SetBasicAuth(username, password string) {
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	s.SetHeader(HeaderAuthorization, "Basic "+auth)
+}
+
+// LastURL returns the url of the last successful request,
+// including redirects. This url is sent in Referer header
+// with the next request.
+func (s *SessionClient) LastURL() string {
+	return s.lastURL
+}
+
+// SetLastURL sets the url sent in Referer header with the next request.
+//
+// Referer header isn't sent if url is empty.
+func (s *SessionClient) SetLastURL(url string) {
+	s.lastURL = url
+}
+
+// Redirects returns the redirect chain followed by the last request
+// sent via Get, Post or DoRedirects.
+func (s *SessionClient) Redirects() []*RedirectHop {
+	return s.redirects
+}
+
+// Get returns the status code and body of url.
+//
+// The contents of dst will be replaced by the body and returned, if the dst
+// is too small a new slice will be allocated.
+//
+// The function follows redirects and records them in Redirects.
+func (s *SessionClient) Get(dst []byte, url string) (statusCode int, body []byte, err error) {
+	d := s.newDoer()
+	statusCode, body, err = clientGetURL(dst, url, d)
+	s.finish(d)
+	return statusCode, body, err
+}
+
+// Post sends POST request to the given url with the given POST arguments.
+//
+// The contents of dst will be replaced by the body and returned, if the dst
+// is too small a new slice will be allocated.
+//
+// The function follows redirects and records them in Redirects.
+//
+// Empty POST body is sent if postArgs is nil.
+func (s *SessionClient) Post(dst []byte, url string, postArgs *Args) (statusCode int, body []byte, err error) {
+	d := s.newDoer()
+	statusCode, body, err = clientPostURL(dst, url, postArgs, d)
+	s.finish(d)
+	return statusCode, body, err
+}
+
+// Do performs the given http request and fills the given http response.
+//
+// The function doesn't follow redirects. Use DoRedirects for following redirects.
+//
+// Response is ignored if resp is nil.
+func (s *SessionClient) Do(req *Request, resp *Response) error {
+	if resp == nil {
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	d := s.newDoer()
+	err := d.Do(req, resp)
+	s.finish(d)
+	return err
+}
+
+// DoRedirects performs the given http request and fills the given http response,
+// following up to maxRedirectsCount redirects. When the redirect count exceeds
+// maxRedirectsCount, ErrTooManyRedirects is returned.
+//
+// The followed redirects are recorded in Redirects.
+//
+// Response is ignored if resp is nil.
+func (s *SessionClient) DoRedirects(req *Request, resp *Response, maxRedirectsCount int) error {
+	if resp == nil {
+		resp = AcquireResponse()
+		defer ReleaseResponse(resp)
+	}
+	if s.client().DisablePathNormalizing {
+		req.URI().DisablePathNormalizing = true
+	}
+	d := s.newDoer()
+	_, _, err := doRequestFollowRedirects(req, resp, req.URI().String(), maxRedirectsCount, d)
+	s.finish(d)
+	return err
+}
+
+func (s *SessionClient) client() *Client {
+	if s.Client == nil {
+		return &defaultClient
+	}
+	return s.Client
+}
+
+func (s *SessionClient) cookieJar() CookieJar {
+	if s.CookieJar == nil {
+		return &s.jar
+	}
+	return s.CookieJar
+}
+
+func (s *SessionClient) newDoer() *sessionDoer {
+	// Hops of the previous request may be still used by the caller,
+	// so the slice isn't reused.
+	s.redirects = nil
+	return &sessionDoer{
+		s:       s,
+		referer: s.lastURL,
+	}
+}
+
+// finish updates the last url after sending the request via d.
+func (s *SessionClient) finish(d *sessionDoer) {
+	if d.lastURL != "" {
+		s.lastURL = d.lastURL
+	}
+}
+
+// sessionDoer sends a request and each redirect hop on behalf of SessionClient.
+type sessionDoer struct {
+	s *SessionClient
+
+	// referer is set by sessionDoer and is removed
+	// on redirects from https to http.
+	referer string
+
+	// lastURL is the url of the last successfully sent hop.
+	lastURL string
+
+	hops int
+}
+
+func (d *sessionDoer) Do(req *Request, resp *Response) error {
+	if d.hops == 0 {
+		// Default headers are set only once, so sensitive headers
+		// removed on redirects to other hosts aren't restored.
+		for i := range d.s.header {
+			kv := &d.s.header[i]
+			if len(req.Header.PeekBytes(kv.key)) == 0 {
+				req.Header.SetBytesKV(kv.key, kv.value)
+			}
+		}
+		if len(req.Header.Referer()) > 0 {
+			// Referer set by the caller takes precedence.
+			d.referer = ""
+		}
+	}
+	d.hops++
+
+	if d.referer != "" {
+		if !req.URI().isHTTPS() && isHTTPSURL(d.referer) {
+			// Do not leak https urls to http hosts.
+			req.Header.Del(HeaderReferer)
+			d.referer = ""
+		} else {
+			req.Header.SetReferer(d.referer)
+		}
+	}
+
+	jar := d.s.cookieJar()
+	n := addCookieJarCookies(jar, req)
+	err := d.s.client().Do(req, resp)
+	removeCookieJarCookies(req, n)
+	if err != nil {
+		return err
+	}
+	d.lastURL = req.URI().String()
+	storeCookieJarCookies(jar, req.URI(), resp)
+
+	statusCode := resp.StatusCode()
+	if StatusCodeIsRedirect(statusCode) {
+		hop := &RedirectHop{
+			URL:        d.lastURL,
+			StatusCode: statusCode,
+			Location:   string(resp.Header.Peek(HeaderLocation)),
+		}
+		resp.Header.CopyTo(&hop.Header)
+		d.s.redirects = append(d.s.redirects, hop)
+	}
+	return nil
+}
+
+func isHTTPSURL(url string) bool {
+	return len(url) > len(strHTTPS) && url[len(strHTTPS)] == ':' && strings.EqualFold(url[:len(strHTTPS)], string(strHTTPS))
+}
@@ -0,0 +1,158 @@
+package fasthttp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func newSessionTestClient(t *testing.T) *SessionClient {
+	t.Helper()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			switch string(ctx.Path()) {
+			case "/login":
+				ctx.Response.Header.Add(HeaderSetCookie, "session=123; path=/")
+				ctx.Redirect("/step", StatusFound)
+			case "/step":
+				ctx.Response.Header.Set("X-Step", "1")
+				ctx.Redirect("/home", StatusSeeOther)
+			case "/external":
+				ctx.Redirect("http://other.com/home", StatusFound)
+			default:
+				fmt.Fprintf(ctx, "%s %s referer=%s session=%s auth=%s lang=%s",
+					ctx.Method(), ctx.Host(), ctx.Referer(), ctx.Request.Header.Cookie("session"),
+					ctx.Request.Header.Peek(HeaderAuthorization), ctx.Request.Header.Peek("Accept-Language"))
+			}
+		},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	t.Cleanup(func() { ln.Close() })
+	go s.Serve(ln) //nolint:errcheck
+
+	return &SessionClient{
+		Client: &Client{
+			Dial: func(string) (net.Conn, error) { return ln.Dial() },
+		},
+	}
+}
+
+func TestSessionClient(t *testing.T) {
+	t.Parallel()
+
+	sc := newSessionTestClient(t)
+	sc.SetHeader("Accept-Language", "en")
+	sc.SetBasicAuth("foo", "bar")
+
+	statusCode, body, err := sc.Post(nil, "http://example.com/login", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedBody := "GET example.com referer= session=123 auth=Basic Zm9vOmJhcg== lang=en"
+	if statusCode != StatusOK || string(body) != expectedBody {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, expectedBody)
+	}
+	if sc.LastURL() != "http://example.com/home" {
+		t.Fatalf("unexpected last url %q. Expecting %q", sc.LastURL(), "http://example.com/home")
+	}
+
+	redirects := sc.Redirects()
+	expectedRedirects := []struct {
+		url        string
+		location   string
+		statusCode int
+	}{
+		{"http://example.com/login", "http://example.com/step", StatusFound},
+		{"http://example.com/step", "http://example.com/home", StatusSeeOther},
+	}
+	if len(redirects) != len(expectedRedirects) {
+		t.Fatalf("unexpected number of redirects %d. Expecting %d", len(redirects), len(expectedRedirects))
+	}
+	for i, hop := range redirects {
+		expected := expectedRedirects[i]
+		if hop.URL != expected.url || hop.Location != expected.location || hop.StatusCode != expected.statusCode {
+			t.Fatalf("unexpected redirect #%d %s %q %d. Expecting %s %q %d", i,
+				hop.URL, hop.Location, hop.StatusCode, expected.url, expected.location, expected.statusCode)
+		}
+	}
+	if v := string(redirects[0].Header.Peek(HeaderSetCookie)); v != "session=123; path=/" {
+		t.Fatalf("unexpected Set-Cookie header %q. Expecting %q", v, "session=123; path=/")
+	}
+	if v := string(redirects[1].Header.Peek("X-Step")); v != "1" {
+		t.Fatalf("unexpected X-Step header %q. Expecting %q", v, "1")
+	}
+
+	// The session cookie and Referer are sent with the next request.
+	statusCode, body, err = sc.Get(nil, "http://example.com/profile")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedBody = "GET example.com referer=http://example.com/home session=123 auth=Basic Zm9vOmJhcg== lang=en"
+	if statusCode != StatusOK || string(body) != expectedBody {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, expectedBody)
+	}
+	if len(sc.Redirects()) != 0 {
+		t.Fatalf("unexpected number of redirects %d. Expecting 0", len(sc.Redirects()))
+	}
+
+	// Authorization and cookies aren't sent to other hosts.
+	statusCode, body, err = sc.Get(nil, "http://example.com/external")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedBody = "GET other.com referer=http://example.com/profile session= auth= lang=en"
+	if statusCode != StatusOK || string(body) != expectedBody {
+		t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, expectedBody)
+	}
+}
+
+func TestSessionClientDo(t *testing.T) {
+	t.Parallel()
+
+	sc := newSessionTestClient(t)
+	sc.SetLastURL("https://example.com/secret")
+
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+
+	// Do doesn't follow redirects.
+	req.SetRequestURI("http://example.com/login")
+	if err := sc.Do(req, resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode() != StatusFound {
+		t.Fatalf("unexpected status code %d. Expecting %d", resp.StatusCode(), StatusFound)
+	}
+	if len(req.Header.Referer()) > 0 {
+		t.Fatalf("https referer %q mustn't be sent to http hosts", req.Header.Referer())
+	}
+	if len(sc.Redirects()) != 1 {
+		t.Fatalf("unexpected number of redirects %d. Expecting 1", len(sc.Redirects()))
+	}
+
+	// Referer set by the caller takes precedence.
+	req.Reset()
+	req.SetRequestURI("http://example.com/")
+	req.Header.SetReferer("http://example.com/custom")
+	if err := sc.DoRedirects(req, resp, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedBody := "GET example.com referer=http://example.com/custom session=123 auth= lang="
+	if string(resp.Body()) != expectedBody {
+		t.Fatalf("unexpected body %q. Expecting %q", resp.Body(), expectedBody)
+	}
+
+	req.Reset()
+	req.SetRequestURI("http://example.com/login")
+	if err := sc.DoRedirects(req, resp, 1); err != ErrTooManyRedirects {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrTooManyRedirects)
+	}
+	if len(sc.Redirects()) != 2 {
+		t.Fatalf("unexpected number of redirects %d. Expecting 2", len(sc.Redirects()))
+	}
+}
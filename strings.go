@@ -41,12 +41,16 @@ var (
 	strServer             = []byte(HeaderServer)
 	strTransferEncoding   = []byte(HeaderTransferEncoding)
 	strContentEncoding    = []byte(HeaderContentEncoding)
+	strAccept             = []byte(HeaderAccept)
 	strAcceptEncoding     = []byte(HeaderAcceptEncoding)
 	strUserAgent          = []byte(HeaderUserAgent)
 	strCookie             = []byte(HeaderCookie)
 	strSetCookie          = []byte(HeaderSetCookie)
 	strLocation           = []byte(HeaderLocation)
 	strIfModifiedSince    = []byte(HeaderIfModifiedSince)
+	strIfNoneMatch        = []byte(HeaderIfNoneMatch)
+	strIfRange            = []byte(HeaderIfRange)
+	strETag               = []byte(HeaderETag)
 	strLastModified       = []byte(HeaderLastModified)
 	strAcceptRanges       = []byte(HeaderAcceptRanges)
 	strRange              = []byte(HeaderRange)
@@ -60,6 +64,12 @@ var (
 	strProxyAuthorization = []byte(HeaderProxyAuthorization)
 	strWWWAuthenticate    = []byte(HeaderWWWAuthenticate)
 	strVary               = []byte(HeaderVary)
+	strCacheControl       = []byte(HeaderCacheControl)
+	strExpires            = []byte(HeaderExpires)
+
+	strForwardedFor   = []byte("for")
+	strForwardedProto = []byte("proto")
+	strForwardedHost  = []byte("host")
 
 	strCookieExpires        = []byte("expires")
 	strCookieDomain         = []byte("domain")
@@ -88,6 +98,7 @@ var (
 	strMultipartFormData   = []byte("multipart/form-data")
 	strBoundary            = []byte("boundary")
 	strBytes               = []byte("bytes")
+	strWeakETagPrefix      = []byte("W/")
 	strBasicSpace          = []byte("Basic ")
 	strLink                = []byte("Link")
 	strConnect             = []byte("CONNECT")
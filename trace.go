@@ -0,0 +1,238 @@
+package fasthttp
+
+import (
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// ErrInvalidTraceParent is returned when traceparent header value is invalid.
+var ErrInvalidTraceParent = errors.New("fasthttp: invalid traceparent header value")
+
+// TraceFlagsSampled is set in TraceContext.Flags for sampled traces.
+const TraceFlagsSampled = 0x01
+
+// TraceContext is W3C trace context propagated via traceparent
+// and tracestate headers.
+//
+// See https://www.w3.org/TR/trace-context/ .
+type TraceContext struct {
+	// TraceState is the tracestate header value. It is propagated as is.
+	TraceState string
+
+	// TraceID identifies the whole trace.
+	TraceID [16]byte
+
+	// SpanID identifies the span, which is the parent
+	// for spans created by the request receiver.
+	SpanID [8]byte
+
+	// Flags contains trace flags such as TraceFlagsSampled.
+	Flags byte
+}
+
+// IsValid returns true if both TraceID and SpanID are non-zero.
+func (tc *TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// IsSampled returns true if TraceFlagsSampled is set.
+func (tc *TraceContext) IsSampled() bool {
+	return tc.Flags&TraceFlagsSampled != 0
+}
+
+const (
+	traceParentVersion = "00"
+	traceParentLen     = 55
+)
+
+// ParseTraceParent parses traceparent header value into tc.
+//
+// TraceState isn't modified.
+func (tc *TraceContext) ParseTraceParent(b []byte) error {
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(b) < traceParentLen || b[2] != '-' || b[35] != '-' || b[52] != '-' {
+		return ErrInvalidTraceParent
+	}
+	var version [1]byte
+	if !decodeTraceHex(version[:], b[:2]) || version[0] == 0xff {
+		return ErrInvalidTraceParent
+	}
+	// Future versions may append fields after trace-flags.
+	if len(b) > traceParentLen && (version[0] == 0 || b[traceParentLen] != '-') {
+		return ErrInvalidTraceParent
+	}
+
+	var t TraceContext
+	var flags [1]byte
+	if !decodeTraceHex(t.TraceID[:], b[3:35]) ||
+		!decodeTraceHex(t.SpanID[:], b[36:52]) ||
+		!decodeTraceHex(flags[:], b[53:55]) ||
+		!t.IsValid() {
+		return ErrInvalidTraceParent
+	}
+	tc.TraceID = t.TraceID
+	tc.SpanID = t.SpanID
+	tc.Flags = flags[0]
+	return nil
+}
+
+// decodeTraceHex decodes lowercase hex src into dst.
+func decodeTraceHex(dst, src []byte) bool {
+	for _, c := range src {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	_, err := hex.Decode(dst, src)
+	return err == nil
+}
+
+// AppendTraceParent appends traceparent header value for tc to dst
+// and returns the extended dst.
+func (tc *TraceContext) AppendTraceParent(dst []byte) []byte {
+	dst = append(dst, traceParentVersion...)
+	dst = append(dst, '-')
+	dst = hex.AppendEncode(dst, tc.TraceID[:])
+	dst = append(dst, '-')
+	dst = hex.AppendEncode(dst, tc.SpanID[:])
+	dst = append(dst, '-')
+	return hex.AppendEncode(dst, []byte{tc.Flags})
+}
+
+// Extract reads tc from traceparent and tracestate request headers.
+//
+// false is returned if traceparent header is missing or invalid.
+func (tc *TraceContext) Extract(h *RequestHeader) bool {
+	traceParent := h.Peek(HeaderTraceParent)
+	if len(traceParent) == 0 || tc.ParseTraceParent(traceParent) != nil {
+		return false
+	}
+	tc.TraceState = string(h.Peek(HeaderTraceState))
+	return true
+}
+
+// Inject sets traceparent and tracestate request headers from tc.
+//
+// tracestate header is deleted if TraceState is empty.
+func (tc *TraceContext) Inject(h *RequestHeader) {
+	var buf [traceParentLen]byte
+	h.SetBytesV(HeaderTraceParent, tc.AppendTraceParent(buf[:0]))
+	if tc.TraceState != "" {
+		h.Set(HeaderTraceState, tc.TraceState)
+	} else {
+		h.Del(HeaderTraceState)
+	}
+}
+
+// ServerTracer traces requests served by Server.
+//
+// See Server.Tracer for details.
+type ServerTracer interface {
+	// StartServerSpan is called after reading the request
+	// and before calling the request handler.
+	//
+	// The incoming trace context may be obtained via TraceContext.Extract.
+	StartServerSpan(ctx *RequestCtx) ServerSpan
+}
+
+// ServerSpan is a span started by ServerTracer.
+type ServerSpan interface {
+	// End is called after writing the response or on failed response write.
+	//
+	// ctx is a copy of the original RequestCtx with empty Request
+	// if the request handler timed out. See TimeoutHandler.
+	//
+	// ctx and trace mustn't be retained after returning from End.
+	End(ctx *RequestCtx, trace *ServerTrace)
+}
+
+// ServerTrace contains timings of a request served by Server.
+//
+// HeaderRead is zero for HTTP/2 requests, since request headers are decoded
+// by the connection reader, and BytesWritten contains only the response body.
+type ServerTrace struct {
+	// Start is the time the server started reading request headers.
+	Start time.Time
+
+	// Err is the error occurred while writing the response.
+	Err error
+
+	// HeaderRead is the duration of reading request headers.
+	HeaderRead time.Duration
+
+	// BodyRead is the duration of reading the request body.
+	//
+	// It is close to zero if Server.StreamRequestBody is set,
+	// since the body is read by the request handler then.
+	BodyRead time.Duration
+
+	// Handler is the duration of the request handler call.
+	Handler time.Duration
+
+	// Write is the duration of writing the response.
+	Write time.Duration
+
+	// BytesWritten is the number of response bytes written
+	// to the connection including response headers.
+	BytesWritten int64
+}
+
+// ClientTracer traces requests sent by HostClient.
+//
+// See HostClient.Tracer for details.
+type ClientTracer interface {
+	// StartClientSpan is called when HostClient.Do is called.
+	//
+	// The trace context may be propagated to the server via TraceContext.Inject.
+	StartClientSpan(req *Request) ClientSpan
+}
+
+// ClientSpan is a span started by ClientTracer.
+type ClientSpan interface {
+	// End is called before returning from HostClient.Do.
+	//
+	// resp contains the response if err is nil.
+	//
+	// req, resp and trace mustn't be retained after returning from End.
+	End(req *Request, resp *Response, trace *ClientTrace, err error)
+}
+
+// ClientTrace contains timings of a request sent by HostClient.
+//
+// Timings are collected for the last attempt if the request was retried.
+// Only Start and Attempts are collected if custom HostClient.Transport is used.
+type ClientTrace struct {
+	// Start is the time HostClient.Do has been called.
+	Start time.Time
+
+	// ConnWait is the duration of waiting for a free connection
+	// if all the MaxConns connections are busy. See MaxConnWaitTimeout.
+	ConnWait time.Duration
+
+	// Dial is the duration of establishing the connection.
+	//
+	// It is zero if an existing connection has been reused.
+	Dial time.Duration
+
+	// TLSHandshake is the duration of TLS handshake.
+	//
+	// It is zero for reused connections and non-TLS connections.
+	TLSHandshake time.Duration
+
+	// FirstByte is the duration between writing the request
+	// and receiving the first response byte.
+	FirstByte time.Duration
+
+	// BodyRead is the duration of reading the response after the first byte.
+	//
+	// The response body isn't read by HostClient when it is streamed,
+	// so only the response headers reading is included then.
+	BodyRead time.Duration
+
+	// Attempts is the number of attempts made for sending the request.
+	Attempts int
+
+	// ConnReused is set if an existing connection has been reused.
+	ConnReused bool
+}
@@ -0,0 +1,380 @@
+package fasthttp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestTraceContextParseTraceParent(t *testing.T) {
+	t.Parallel()
+
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var tc TraceContext
+	if err := tc.ParseTraceParent([]byte(valid)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !tc.IsValid() || !tc.IsSampled() {
+		t.Fatalf("unexpected trace context %+v. Expecting valid sampled context", tc)
+	}
+	if s := string(tc.AppendTraceParent(nil)); s != valid {
+		t.Fatalf("unexpected traceparent %q. Expecting %q", s, valid)
+	}
+
+	for _, s := range []string{
+		"",
+		valid[:54],
+		valid + "-",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if err := tc.ParseTraceParent([]byte(s)); err != ErrInvalidTraceParent {
+			t.Fatalf("unexpected error for %q: %v. Expecting %v", s, err, ErrInvalidTraceParent)
+		}
+	}
+
+	// Future versions may contain additional fields.
+	if err := tc.ParseTraceParent([]byte("01" + valid[2:] + "-foo")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTraceContextInjectExtract(t *testing.T) {
+	t.Parallel()
+
+	tc := TraceContext{
+		TraceID:    [16]byte{1, 2, 3},
+		SpanID:     [8]byte{4, 5, 6},
+		Flags:      TraceFlagsSampled,
+		TraceState: "foo=bar",
+	}
+	var h RequestHeader
+	tc.Inject(&h)
+	if v := string(h.Peek(HeaderTraceParent)); v != "00-01020300000000000000000000000000-0405060000000000-01" {
+		t.Fatalf("unexpected traceparent %q", v)
+	}
+
+	var tc1 TraceContext
+	if !tc1.Extract(&h) {
+		t.Fatal("cannot extract trace context")
+	}
+	if tc1 != tc {
+		t.Fatalf("unexpected trace context %+v. Expecting %+v", tc1, tc)
+	}
+
+	tc.TraceState = ""
+	tc.Inject(&h)
+	if len(h.Peek(HeaderTraceState)) > 0 {
+		t.Fatalf("unexpected tracestate %q", h.Peek(HeaderTraceState))
+	}
+
+	h.Set(HeaderTraceParent, "invalid")
+	if tc1.Extract(&h) {
+		t.Fatal("invalid traceparent mustn't be extracted")
+	}
+}
+
+type testServerTracer struct {
+	traces []ServerTrace
+	paths  []string
+	mu     sync.Mutex
+}
+
+func (t *testServerTracer) StartServerSpan(ctx *RequestCtx) ServerSpan {
+	t.mu.Lock()
+	t.paths = append(t.paths, string(ctx.Path()))
+	t.mu.Unlock()
+	return t
+}
+
+func (t *testServerTracer) End(_ *RequestCtx, trace *ServerTrace) {
+	t.mu.Lock()
+	t.traces = append(t.traces, *trace)
+	t.mu.Unlock()
+}
+
+func TestServerTracer(t *testing.T) {
+	t.Parallel()
+
+	tracer := &testServerTracer{}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			time.Sleep(20 * time.Millisecond)
+			ctx.WriteString("ok") //nolint:errcheck
+		},
+		Tracer: tracer,
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	c := &HostClient{
+		Addr: "example.com",
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+	}
+	for _, path := range []string{"/foo", "/bar"} {
+		statusCode, _, err := c.Post(nil, "http://example.com"+path, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if statusCode != StatusOK {
+			t.Fatalf("unexpected status code %d. Expecting %d", statusCode, StatusOK)
+		}
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if len(tracer.traces) != 2 {
+		t.Fatalf("unexpected number of traces %d. Expecting 2", len(tracer.traces))
+	}
+	if tracer.paths[0] != "/foo" || tracer.paths[1] != "/bar" {
+		t.Fatalf("unexpected paths %q. Expecting %q", tracer.paths, []string{"/foo", "/bar"})
+	}
+	for _, trace := range tracer.traces {
+		if trace.Start.IsZero() || trace.Err != nil {
+			t.Fatalf("unexpected trace %+v", trace)
+		}
+		if trace.Handler < 20*time.Millisecond {
+			t.Fatalf("unexpected handler duration %s. Expecting at least %s", trace.Handler, 20*time.Millisecond)
+		}
+		if trace.HeaderRead <= 0 || trace.Write <= 0 {
+			t.Fatalf("unexpected header read %s or write %s durations", trace.HeaderRead, trace.Write)
+		}
+	}
+}
+
+func TestServerTracerBytesWritten(t *testing.T) {
+	t.Parallel()
+
+	tracer := &testServerTracer{}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.Path()) //nolint:errcheck
+		},
+		Tracer: tracer,
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	// Pipelined responses are flushed together.
+	requests := "GET /foo HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+		"GET /foobar HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n"
+	if _, err = c.Write([]byte(requests)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	responses, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if len(tracer.traces) != 2 {
+		t.Fatalf("unexpected number of traces %d. Expecting 2", len(tracer.traces))
+	}
+	first, second := tracer.traces[0].BytesWritten, tracer.traces[1].BytesWritten
+	if first+second != int64(len(responses)) {
+		t.Fatalf("unexpected number of bytes written %d+%d. Expecting %d", first, second, len(responses))
+	}
+	// The second response differs from the first one by the body
+	// and 'Connection: close' header.
+	if d := second - first; d != int64(len("bar")+len("Connection: close\r\n")) {
+		t.Fatalf("unexpected difference between bytes written %d", d)
+	}
+}
+
+func TestServerTracerHTTP2(t *testing.T) {
+	t.Parallel()
+
+	tracer := &testServerTracer{}
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			time.Sleep(20 * time.Millisecond)
+			ctx.Write(ctx.Path()) //nolint:errcheck
+		},
+		Tracer: tracer,
+	}
+	c, ln := newHTTP2TestClient(t, s, nil)
+	defer ln.Close()
+
+	resp, err := c.Get("https://localhost/foo")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	resp.Body.Close()
+	c.CloseIdleConnections()
+
+	// The span is ended after the response is sent to the client.
+	deadline := time.Now().Add(5 * time.Second)
+	for s.InFlightRequests() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if len(tracer.traces) != 1 {
+		t.Fatalf("unexpected number of traces %d. Expecting 1", len(tracer.traces))
+	}
+	if tracer.paths[0] != "/foo" {
+		t.Fatalf("unexpected path %q. Expecting %q", tracer.paths[0], "/foo")
+	}
+	trace := tracer.traces[0]
+	if trace.Start.IsZero() || trace.Err != nil || trace.Write <= 0 {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+	if trace.Handler < 20*time.Millisecond {
+		t.Fatalf("unexpected handler duration %s. Expecting at least %s", trace.Handler, 20*time.Millisecond)
+	}
+	if trace.BytesWritten != int64(len("/foo")) {
+		t.Fatalf("unexpected number of bytes written %d. Expecting %d", trace.BytesWritten, len("/foo"))
+	}
+}
+
+type testClientTracer struct {
+	traces []ClientTrace
+	errs   []error
+	mu     sync.Mutex
+}
+
+func (t *testClientTracer) StartClientSpan(req *Request) ClientSpan {
+	req.Header.Set("X-Traced", "1")
+	return t
+}
+
+func (t *testClientTracer) End(_ *Request, _ *Response, trace *ClientTrace, err error) {
+	t.mu.Lock()
+	t.traces = append(t.traces, *trace)
+	t.errs = append(t.errs, err)
+	t.mu.Unlock()
+}
+
+func TestClientTracer(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.Write(ctx.Request.Header.Peek("X-Traced")) //nolint:errcheck
+		},
+	}
+	certData, keyData, err := GenerateTestCertificate("localhost")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.ServeTLSEmbed(ln, certData, keyData) //nolint:errcheck
+
+	for _, writeTimeout := range []time.Duration{0, time.Second} {
+		tracer := &testClientTracer{}
+		c := &HostClient{
+			Addr:         "example.com",
+			IsTLS:        true,
+			TLSConfig:    &tls.Config{InsecureSkipVerify: true},
+			Dial:         func(string) (net.Conn, error) { return ln.Dial() },
+			WriteTimeout: writeTimeout,
+			Tracer:       tracer,
+		}
+		for range 2 {
+			statusCode, body, err := c.Get(nil, "https://example.com/")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if statusCode != StatusOK || !bytes.Equal(body, []byte("1")) {
+				t.Fatalf("unexpected response %d %q. Expecting %d %q", statusCode, body, StatusOK, "1")
+			}
+		}
+
+		if len(tracer.traces) != 2 {
+			t.Fatalf("unexpected number of traces %d. Expecting 2", len(tracer.traces))
+		}
+		first, second := tracer.traces[0], tracer.traces[1]
+		if first.ConnReused || first.Dial <= 0 || first.TLSHandshake <= 0 {
+			t.Fatalf("unexpected trace for new connection with write timeout %s: %+v", writeTimeout, first)
+		}
+		if !second.ConnReused || second.Dial != 0 || second.TLSHandshake != 0 {
+			t.Fatalf("unexpected trace for reused connection with write timeout %s: %+v", writeTimeout, second)
+		}
+		for _, trace := range tracer.traces {
+			if trace.Start.IsZero() || trace.Attempts != 1 || trace.FirstByte <= 0 || trace.BodyRead <= 0 {
+				t.Fatalf("unexpected trace %+v", trace)
+			}
+		}
+		c.CloseIdleConnections()
+	}
+
+	// The span is ended on errors too.
+	errDialFailed := errors.New("dial failed")
+	tracer := &testClientTracer{}
+	c := &HostClient{
+		Addr:   "example.com",
+		Dial:   func(string) (net.Conn, error) { return nil, errDialFailed },
+		Tracer: tracer,
+	}
+	if _, _, err := c.Get(nil, "http://example.com/"); err != errDialFailed {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, errDialFailed)
+	}
+	if len(tracer.errs) != 1 || tracer.errs[0] != errDialFailed {
+		t.Fatalf("unexpected errors %v. Expecting %v", tracer.errs, []error{errDialFailed})
+	}
+}
+
+func TestClientTracerConnWait(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			time.Sleep(50 * time.Millisecond)
+		},
+	}
+	ln := fasthttputil.NewInmemoryListener()
+	defer ln.Close()
+	go s.Serve(ln) //nolint:errcheck
+
+	tracer := &testClientTracer{}
+	c := &HostClient{
+		Addr:               "example.com",
+		Dial:               func(string) (net.Conn, error) { return ln.Dial() },
+		MaxConns:           1,
+		MaxConnWaitTimeout: 5 * time.Second,
+		Tracer:             tracer,
+	}
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.Get(nil, "http://example.com/"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(tracer.traces) != 2 {
+		t.Fatalf("unexpected number of traces %d. Expecting 2", len(tracer.traces))
+	}
+	// The second request waits for the connection used by the first one.
+	waited := max(tracer.traces[0].ConnWait, tracer.traces[1].ConnWait)
+	if waited < 40*time.Millisecond {
+		t.Fatalf("unexpected connection wait duration %s. Expecting at least %s", waited, 40*time.Millisecond)
+	}
+}
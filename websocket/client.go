@@ -0,0 +1,262 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultHandshakeTimeout is the default timeout for the opening handshake
+// including the time needed for establishing the connection.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// Dialer establishes client WebSocket connections.
+//
+// It is safe to call Dialer methods from concurrently running goroutines.
+type Dialer struct {
+	// NetDial is used for establishing TCP connections.
+	//
+	// fasthttp.DialTimeout is used if not set.
+	NetDial fasthttp.DialFuncWithTimeout
+
+	// TLSConfig is used for wss:// connections.
+	//
+	// ServerName is set to the URL host if not set in TLSConfig.
+	TLSConfig *tls.Config
+
+	// Subprotocols lists the subprotocols requested from the server.
+	Subprotocols []string
+
+	// HandshakeTimeout limits the duration of the opening handshake.
+	//
+	// DefaultHandshakeTimeout is used if not set.
+	HandshakeTimeout time.Duration
+
+	// ReadBufferSize is the size of the read buffer for the connection.
+	//
+	// Default buffer size is used if not set.
+	ReadBufferSize int
+
+	// WriteBufferSize is the size of the write buffer for the connection.
+	//
+	// Default buffer size is used if not set.
+	WriteBufferSize int
+
+	// ReadLimit is the maximum message size in bytes accepted from the server.
+	//
+	// DefaultReadLimit is used if not set. The message size is unlimited
+	// if ReadLimit is negative. See Conn.SetReadLimit for details.
+	ReadLimit int64
+
+	// CompressionLevel is the level used for compressing messages
+	// if permessage-deflate extension is negotiated.
+	//
+	// The level may be one of the fasthttp.Compress* constants.
+	// fasthttp.CompressDefaultCompression is used if not set.
+	CompressionLevel int
+
+	// EnableCompression enables offering permessage-deflate
+	// extension (RFC 7692) to the server.
+	EnableCompression bool
+
+	// ServerNoContextTakeover asks the server not to reuse
+	// the compression context across messages.
+	//
+	// The handshake fails if the server doesn't agree.
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover prevents the client from reusing
+	// the compression context across messages.
+	ClientNoContextTakeover bool
+}
+
+// DefaultDialer is used by Dial.
+var DefaultDialer = &Dialer{}
+
+// NewDialer returns a Dialer using the dial function, TLS config
+// and buffer sizes of the given HostClient.
+func NewDialer(hc *fasthttp.HostClient) *Dialer {
+	d := &Dialer{
+		TLSConfig:       hc.TLSConfig,
+		ReadBufferSize:  hc.ReadBufferSize,
+		WriteBufferSize: hc.WriteBufferSize,
+	}
+	switch {
+	case hc.DialTimeout != nil:
+		d.NetDial = hc.DialTimeout
+	case hc.Dial != nil:
+		dial := hc.Dial
+		d.NetDial = func(addr string, _ time.Duration) (net.Conn, error) {
+			return dial(addr)
+		}
+	}
+	return d
+}
+
+// Dial establishes a WebSocket connection to the given ws:// or wss:// url
+// using DefaultDialer.
+//
+// See Dialer.Dial for details.
+func Dial(url string, header *fasthttp.RequestHeader) (*Conn, error) {
+	return DefaultDialer.Dial(url, header)
+}
+
+// Dial establishes a WebSocket connection to the given ws:// or wss:// url.
+//
+// Headers from the optional header are sent with the handshake request,
+// so cookies, authorization or Origin may be set there.
+//
+// An error wrapping ErrBadHandshake is returned if the server
+// rejects the handshake.
+func (d *Dialer) Dial(url string, header *fasthttp.RequestHeader) (*Conn, error) {
+	var uri fasthttp.URI
+	if err := uri.Parse(nil, []byte(url)); err != nil {
+		return nil, err
+	}
+	var isTLS bool
+	switch string(uri.Scheme()) {
+	case "ws", "http":
+	case "wss", "https":
+		isTLS = true
+	default:
+		return nil, fmt.Errorf("websocket: unsupported url scheme %q", uri.Scheme())
+	}
+	host := string(uri.Host())
+	addr := fasthttp.AddMissingPort(host, isTLS)
+
+	timeout := d.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	dial := d.NetDial
+	if dial == nil {
+		dial = fasthttp.DialTimeout
+	}
+	nc, err := dial(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err = nc.SetDeadline(deadline); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if isTLS {
+		var cfg *tls.Config
+		if d.TLSConfig == nil {
+			cfg = &tls.Config{}
+		} else {
+			cfg = d.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			serverName, _, splitErr := net.SplitHostPort(addr)
+			if splitErr != nil {
+				serverName = host
+			}
+			cfg.ServerName = serverName
+		}
+		tlsConn := tls.Client(nc, cfg)
+		if err = tlsConn.Handshake(); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tlsConn
+	}
+
+	c, err := d.handshake(nc, &uri, header)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if err = nc.SetDeadline(time.Time{}); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (d *Dialer) handshake(nc net.Conn, uri *fasthttp.URI, header *fasthttp.RequestHeader) (*Conn, error) {
+	key, err := generateChallengeKey()
+	if err != nil {
+		return nil, err
+	}
+
+	var h fasthttp.RequestHeader
+	if header != nil {
+		header.CopyTo(&h)
+	}
+	h.SetMethod(fasthttp.MethodGet)
+	h.SetRequestURIBytes(uri.RequestURI())
+	h.SetHostBytes(uri.Host())
+	h.Set(fasthttp.HeaderUpgrade, "websocket")
+	h.Set(fasthttp.HeaderConnection, "Upgrade")
+	h.Set(fasthttp.HeaderSecWebSocketKey, key)
+	h.Set(fasthttp.HeaderSecWebSocketVersion, "13")
+	if len(d.Subprotocols) > 0 {
+		h.Set(fasthttp.HeaderSecWebSocketProtocol, strings.Join(d.Subprotocols, ", "))
+	}
+	if d.EnableCompression {
+		h.Set(fasthttp.HeaderSecWebSocketExtensions, d.deflateOffer())
+	}
+
+	bw := bufio.NewWriter(nc)
+	if err = h.Write(bw); err != nil {
+		return nil, err
+	}
+	if err = bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	readBufferSize := d.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	br := bufio.NewReaderSize(nc, readBufferSize)
+	var rh fasthttp.ResponseHeader
+	if err = rh.Read(br); err != nil {
+		return nil, err
+	}
+
+	if rh.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrBadHandshake, rh.StatusCode())
+	}
+	if !rh.ConnectionUpgrade() || !headerHasToken(rh.Peek(fasthttp.HeaderUpgrade), "websocket") {
+		return nil, fmt.Errorf("%w: missing upgrade headers in response", ErrBadHandshake)
+	}
+	if string(rh.Peek(fasthttp.HeaderSecWebSocketAccept)) != computeAcceptKey([]byte(key)) {
+		return nil, fmt.Errorf("%w: invalid 'Sec-WebSocket-Accept' header", ErrBadHandshake)
+	}
+	subprotocol := string(rh.Peek(fasthttp.HeaderSecWebSocketProtocol))
+	if subprotocol != "" && !slices.Contains(d.Subprotocols, subprotocol) {
+		return nil, fmt.Errorf("%w: unexpected subprotocol %q", ErrBadHandshake, subprotocol)
+	}
+
+	deflate, compress, ok := d.acceptDeflate(rh.Peek(fasthttp.HeaderSecWebSocketExtensions))
+	if !ok {
+		return nil, fmt.Errorf("%w: unexpected extensions %q", ErrBadHandshake, rh.Peek(fasthttp.HeaderSecWebSocketExtensions))
+	}
+
+	c := newConn(nc, br, false, subprotocol, d.WriteBufferSize)
+	c.SetReadLimit(d.ReadLimit)
+	if compress {
+		c.enableCompression(deflate, d.CompressionLevel)
+	}
+	return c, nil
+}
+
+func generateChallengeKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,307 @@
+package websocket
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp/internal/compresspool"
+	"github.com/valyala/fasthttp/stackless"
+)
+
+// permessage-deflate extension name and parameters.
+//
+// See https://www.rfc-editor.org/rfc/rfc7692 .
+const (
+	extPermessageDeflate         = "permessage-deflate"
+	paramServerNoContextTakeover = "server_no_context_takeover"
+	paramClientNoContextTakeover = "client_no_context_takeover"
+	paramServerMaxWindowBits     = "server_max_window_bits"
+	paramClientMaxWindowBits     = "client_max_window_bits"
+)
+
+// maxWindowBits is the only LZ77 window size supported by flate writers.
+const maxWindowBits = 15
+
+// maxWindowSize is the size of the sliding window kept for context takeover.
+const maxWindowSize = 1 << maxWindowBits
+
+// deflateTail is stripped from every compressed message by the sender
+// and appended back by the receiver. It is followed by an empty final
+// stored block, so flate readers stop with io.EOF at the message end.
+var deflateTail = []byte("\x00\x00\xff\xff\x01\x00\x00\xff\xff")
+
+// deflateParams holds negotiated permessage-deflate parameters.
+type deflateParams struct {
+	serverMaxWindowBits     int
+	clientMaxWindowBits     int
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+}
+
+// parseDeflateParams parses parameters of a single permessage-deflate
+// offer or response.
+//
+// false is returned for unknown, duplicate or malformed parameters.
+func parseDeflateParams(params string) (deflateParams, bool) {
+	var p deflateParams
+	seen := make(map[string]struct{}, 4)
+	for params != "" {
+		var param string
+		param, params, _ = strings.Cut(params, ";")
+		name, value, hasValue := strings.Cut(strings.TrimSpace(param), "=")
+		name = strings.TrimSpace(name)
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		if name == "" {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			return p, false
+		}
+		seen[name] = struct{}{}
+
+		switch name {
+		case paramServerNoContextTakeover:
+			if hasValue {
+				return p, false
+			}
+			p.serverNoContextTakeover = true
+		case paramClientNoContextTakeover:
+			if hasValue {
+				return p, false
+			}
+			p.clientNoContextTakeover = true
+		case paramServerMaxWindowBits:
+			bits, ok := parseWindowBits(value)
+			if !ok {
+				return p, false
+			}
+			p.serverMaxWindowBits = bits
+		case paramClientMaxWindowBits:
+			if !hasValue {
+				// The client supports the parameter, but doesn't limit the window.
+				p.clientMaxWindowBits = maxWindowBits
+				continue
+			}
+			bits, ok := parseWindowBits(value)
+			if !ok {
+				return p, false
+			}
+			p.clientMaxWindowBits = bits
+		default:
+			return p, false
+		}
+	}
+	return p, true
+}
+
+func parseWindowBits(s string) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 8 || n > maxWindowBits {
+		return 0, false
+	}
+	return n, true
+}
+
+// splitExtension splits a single Sec-WebSocket-Extensions element
+// into the extension name and its parameters.
+func splitExtension(ext string) (name, params string) {
+	name, params, _ = strings.Cut(ext, ";")
+	return strings.TrimSpace(name), params
+}
+
+// negotiateDeflate selects the first acceptable permessage-deflate offer
+// from the client's Sec-WebSocket-Extensions header.
+//
+// The returned response must be sent in the server's
+// Sec-WebSocket-Extensions header. false is returned if no offer
+// is acceptable.
+func (u *Upgrader) negotiateDeflate(offers []byte) (deflateParams, string, bool) {
+	for _, offer := range strings.Split(string(offers), ",") {
+		name, params := splitExtension(offer)
+		if !strings.EqualFold(name, extPermessageDeflate) {
+			continue
+		}
+		p, ok := parseDeflateParams(params)
+		if !ok {
+			continue
+		}
+		if p.serverMaxWindowBits != 0 && p.serverMaxWindowBits != maxWindowBits {
+			// The server's flate writer cannot limit its window.
+			continue
+		}
+		p.serverNoContextTakeover = p.serverNoContextTakeover || u.ServerNoContextTakeover
+		p.clientNoContextTakeover = p.clientNoContextTakeover || u.ClientNoContextTakeover
+
+		var sb strings.Builder
+		sb.WriteString(extPermessageDeflate)
+		if p.serverNoContextTakeover {
+			sb.WriteString("; " + paramServerNoContextTakeover)
+		}
+		if p.clientNoContextTakeover {
+			sb.WriteString("; " + paramClientNoContextTakeover)
+		}
+		if p.serverMaxWindowBits != 0 {
+			sb.WriteString("; " + paramServerMaxWindowBits + "=" + strconv.Itoa(maxWindowBits))
+		}
+		return p, sb.String(), true
+	}
+	return deflateParams{}, "", false
+}
+
+// deflateOffer returns the client's permessage-deflate offer.
+func (d *Dialer) deflateOffer() string {
+	s := extPermessageDeflate
+	if d.ServerNoContextTakeover {
+		s += "; " + paramServerNoContextTakeover
+	}
+	if d.ClientNoContextTakeover {
+		s += "; " + paramClientNoContextTakeover
+	}
+	return s
+}
+
+// acceptDeflate validates the server's Sec-WebSocket-Extensions header.
+//
+// enabled is false if the server didn't accept the permessage-deflate offer.
+func (d *Dialer) acceptDeflate(exts []byte) (p deflateParams, enabled, ok bool) {
+	if len(exts) == 0 {
+		return p, false, true
+	}
+	if !d.EnableCompression || bytes.IndexByte(exts, ',') >= 0 {
+		// Only a single permessage-deflate extension could be offered.
+		return p, false, false
+	}
+	name, params := splitExtension(string(exts))
+	if !strings.EqualFold(name, extPermessageDeflate) {
+		return p, false, false
+	}
+	p, ok = parseDeflateParams(params)
+	if !ok {
+		return p, false, false
+	}
+	if p.clientMaxWindowBits != 0 && p.clientMaxWindowBits != maxWindowBits {
+		// The client's flate writer cannot limit its window.
+		return p, false, false
+	}
+	if d.ServerNoContextTakeover && !p.serverNoContextTakeover {
+		return p, false, false
+	}
+	return p, true, true
+}
+
+// compressor holds per-connection permessage-deflate state.
+type compressor struct {
+	// w is kept across messages when the sender uses context takeover.
+	w stackless.Writer
+
+	// dict holds the tail of previously received uncompressed data
+	// when the peer uses context takeover.
+	dict []byte
+
+	wbuf bytes.Buffer
+	rbuf []byte
+
+	level int
+
+	writeNoContextTakeover bool
+	readNoContextTakeover  bool
+}
+
+func newCompressor(p deflateParams, isServer bool, level int) *compressor {
+	c := &compressor{
+		level:                  level,
+		writeNoContextTakeover: p.clientNoContextTakeover,
+		readNoContextTakeover:  p.serverNoContextTakeover,
+	}
+	if isServer {
+		c.writeNoContextTakeover, c.readNoContextTakeover = c.readNoContextTakeover, c.writeNoContextTakeover
+	}
+	return c
+}
+
+// compress returns the compressed payload for the given message
+// with the deflate tail stripped.
+//
+// The returned slice is valid until the next compress call.
+func (c *compressor) compress(p []byte) ([]byte, error) {
+	c.wbuf.Reset()
+	w := c.w
+	if w == nil {
+		w = compresspool.AcquireStacklessFlateWriter(&c.wbuf, c.level)
+	}
+	_, err := w.Write(p)
+	if err == nil {
+		err = w.Flush()
+	}
+	if c.writeNoContextTakeover || err != nil {
+		compresspool.ReleaseStacklessFlateWriter(w, c.level)
+		c.w = nil
+	} else {
+		c.w = w
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(c.wbuf.Bytes(), deflateTail[:4]), nil
+}
+
+// appendCompressed appends a compressed frame payload to the current message.
+func (c *compressor) appendCompressed(p []byte) {
+	c.rbuf = append(c.rbuf, p...)
+}
+
+// compressedLen returns the length of the current compressed message.
+func (c *compressor) compressedLen() int {
+	return len(c.rbuf)
+}
+
+// decompress appends the current compressed message to dst
+// in uncompressed form.
+//
+// ErrReadLimit is returned if the uncompressed message exceeds limit.
+func (c *compressor) decompress(dst []byte, limit int64) ([]byte, error) {
+	c.rbuf = append(c.rbuf, deflateTail...)
+	r := bytes.NewReader(c.rbuf)
+	c.rbuf = c.rbuf[:0]
+	zr, err := compresspool.AcquireFlateReader(r, c.dict)
+	if err != nil {
+		return dst, err
+	}
+	defer compresspool.ReleaseFlateReader(zr)
+
+	start := len(dst)
+	for {
+		if len(dst) == cap(dst) {
+			dst = append(dst, 0)[:len(dst)]
+		}
+		n, err := zr.Read(dst[len(dst):cap(dst)])
+		dst = dst[:len(dst)+n]
+		if limit > 0 && int64(len(dst)-start) > limit {
+			return dst, ErrReadLimit
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return dst, err
+		}
+	}
+
+	if !c.readNoContextTakeover {
+		c.dict = append(c.dict, dst[start:]...)
+		if n := len(c.dict) - maxWindowSize; n > 0 {
+			c.dict = append(c.dict[:0], c.dict[n:]...)
+		}
+	}
+	return dst, nil
+}
+
+func (c *compressor) release() {
+	if c.w != nil {
+		compresspool.ReleaseStacklessFlateWriter(c.w, c.level)
+		c.w = nil
+	}
+}
@@ -0,0 +1,277 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestParseDeflateParams(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		params   string
+		expected deflateParams
+		ok       bool
+	}{
+		{"", deflateParams{}, true},
+		{"; server_no_context_takeover", deflateParams{serverNoContextTakeover: true}, true},
+		{"; client_no_context_takeover; server_no_context_takeover", deflateParams{
+			serverNoContextTakeover: true,
+			clientNoContextTakeover: true,
+		}, true},
+		{"; client_max_window_bits", deflateParams{clientMaxWindowBits: 15}, true},
+		{`; client_max_window_bits="10"; server_max_window_bits=12`, deflateParams{
+			clientMaxWindowBits: 10,
+			serverMaxWindowBits: 12,
+		}, true},
+		{"; server_max_window_bits", deflateParams{}, false},
+		{"; server_max_window_bits=7", deflateParams{}, false},
+		{"; server_no_context_takeover=1", deflateParams{}, false},
+		{"; server_no_context_takeover; server_no_context_takeover", deflateParams{}, false},
+		{"; foo", deflateParams{}, false},
+	}
+	for _, tc := range testCases {
+		p, ok := parseDeflateParams(tc.params)
+		if ok != tc.ok {
+			t.Fatalf("unexpected ok=%v for %q. Expecting %v", ok, tc.params, tc.ok)
+		}
+		if ok && p != tc.expected {
+			t.Fatalf("unexpected params for %q: %+v. Expecting %+v", tc.params, p, tc.expected)
+		}
+	}
+}
+
+func TestUpgraderNegotiateDeflate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		upgrader Upgrader
+		offers   string
+		expected string
+	}{
+		{Upgrader{}, "", ""},
+		{Upgrader{}, "x-webkit-deflate-frame", ""},
+		{Upgrader{}, "permessage-deflate; client_max_window_bits", "permessage-deflate"},
+		{Upgrader{}, "permessage-deflate; server_max_window_bits=10, permessage-deflate", "permessage-deflate"},
+		{
+			Upgrader{},
+			"permessage-deflate; server_max_window_bits=15",
+			"permessage-deflate; server_max_window_bits=15",
+		},
+		{
+			Upgrader{ServerNoContextTakeover: true, ClientNoContextTakeover: true},
+			"permessage-deflate",
+			"permessage-deflate; server_no_context_takeover; client_no_context_takeover",
+		},
+		{
+			Upgrader{},
+			"permessage-deflate; client_no_context_takeover",
+			"permessage-deflate; client_no_context_takeover",
+		},
+	}
+	for _, tc := range testCases {
+		_, resp, ok := tc.upgrader.negotiateDeflate([]byte(tc.offers))
+		if ok != (tc.expected != "") {
+			t.Fatalf("unexpected ok=%v for %q", ok, tc.offers)
+		}
+		if resp != tc.expected {
+			t.Fatalf("unexpected response for %q: %q. Expecting %q", tc.offers, resp, tc.expected)
+		}
+	}
+}
+
+func TestCompressorRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, noContextTakeover := range []bool{false, true} {
+		p := deflateParams{
+			serverNoContextTakeover: noContextTakeover,
+			clientNoContextTakeover: noContextTakeover,
+		}
+		w := newCompressor(p, true, fasthttp.CompressDefaultCompression)
+		r := newCompressor(p, false, fasthttp.CompressDefaultCompression)
+
+		msgs := []string{
+			"",
+			"hello",
+			strings.Repeat("hello, world! ", 1000),
+			strings.Repeat("hello, world! ", 1000),
+			"bye",
+		}
+		var sizes []int
+		for _, msg := range msgs {
+			b, err := w.compress([]byte(msg))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if bytes.HasSuffix(b, deflateTail[:4]) {
+				t.Fatalf("the deflate tail must be stripped")
+			}
+			sizes = append(sizes, len(b))
+			r.appendCompressed(b)
+			got, err := r.decompress(nil, 0)
+			if err != nil {
+				t.Fatalf("unexpected error for noContextTakeover=%v: %v", noContextTakeover, err)
+			}
+			if string(got) != msg {
+				t.Fatalf("unexpected message for noContextTakeover=%v: %q. Expecting %q", noContextTakeover, got, msg)
+			}
+		}
+		if !noContextTakeover && sizes[3] >= sizes[2] {
+			t.Fatalf("context takeover must improve compression of the repeated message: %d vs %d", sizes[3], sizes[2])
+		}
+		if noContextTakeover && sizes[3] != sizes[2] {
+			t.Fatalf("repeated message must be compressed independently: %d vs %d", sizes[3], sizes[2])
+		}
+		w.release()
+	}
+}
+
+func TestCompressorReadLimit(t *testing.T) {
+	t.Parallel()
+
+	w := newCompressor(deflateParams{}, true, fasthttp.CompressBestCompression)
+	r := newCompressor(deflateParams{}, false, fasthttp.CompressBestCompression)
+	b, err := w.compress(make([]byte, 1<<20))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.appendCompressed(b)
+	if _, err = r.decompress(nil, 1000); err != ErrReadLimit {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrReadLimit)
+	}
+}
+
+func TestServerClientCompression(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		upgrader Upgrader
+		dialer   Dialer
+		enabled  bool
+	}{
+		{Upgrader{}, Dialer{EnableCompression: true}, false},
+		{Upgrader{EnableCompression: true}, Dialer{}, false},
+		{Upgrader{EnableCompression: true}, Dialer{EnableCompression: true}, true},
+		{
+			Upgrader{EnableCompression: true, ServerNoContextTakeover: true},
+			Dialer{EnableCompression: true, ClientNoContextTakeover: true},
+			true,
+		},
+		{
+			Upgrader{EnableCompression: true, ClientNoContextTakeover: true, CompressionLevel: fasthttp.CompressBestSpeed},
+			Dialer{EnableCompression: true, ServerNoContextTakeover: true, CompressionLevel: fasthttp.CompressBestCompression},
+			true,
+		},
+	}
+	for i := range testCases {
+		tc := &testCases[i]
+		ln := fasthttputil.NewInmemoryListener()
+		s := &fasthttp.Server{
+			Handler: func(ctx *fasthttp.RequestCtx) {
+				tc.upgrader.Upgrade(ctx, func(c *Conn) { //nolint:errcheck
+					if c.CompressionEnabled() != tc.enabled {
+						c.WriteClose(CloseInternalServerErr, "unexpected compression state") //nolint:errcheck
+						return
+					}
+					for {
+						op, msg, err := c.ReadMessage(nil)
+						if err != nil {
+							return
+						}
+						if err = c.WriteMessage(op, msg); err != nil {
+							return
+						}
+					}
+				})
+			},
+		}
+		go s.Serve(ln) //nolint:errcheck
+
+		tc.dialer.NetDial = func(string, time.Duration) (net.Conn, error) { return ln.Dial() }
+		c, err := tc.dialer.Dial("ws://example.com/", nil)
+		if err != nil {
+			t.Fatalf("case %d: unexpected error: %v", i, err)
+		}
+		if c.CompressionEnabled() != tc.enabled {
+			t.Fatalf("case %d: unexpected compression state %v", i, c.CompressionEnabled())
+		}
+		for j := 0; j < 5; j++ {
+			msg := strings.Repeat("compressible text ", 100*j)
+			if err = c.WriteMessage(OpText, []byte(msg)); err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+			op, got, err := c.ReadMessage(nil)
+			if err != nil {
+				t.Fatalf("case %d: unexpected error: %v", i, err)
+			}
+			if op != OpText || string(got) != msg {
+				t.Fatalf("case %d: unexpected echo: opcode=%d, len=%d. Expecting len=%d", i, op, len(got), len(msg))
+			}
+		}
+		c.Close()
+		ln.Close()
+	}
+}
+
+func TestDialerRejectsUnexpectedExtension(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		dialer Dialer
+		exts   string
+	}{
+		{Dialer{}, "permessage-deflate"},
+		{Dialer{EnableCompression: true}, "x-foo"},
+		{Dialer{EnableCompression: true}, "permessage-deflate; client_max_window_bits=10"},
+		{Dialer{EnableCompression: true, ServerNoContextTakeover: true}, "permessage-deflate"},
+	}
+	for _, tc := range testCases {
+		_, _, ok := tc.dialer.acceptDeflate([]byte(tc.exts))
+		if ok {
+			t.Fatalf("expecting %q to be rejected", tc.exts)
+		}
+	}
+
+	if _, enabled, ok := (&Dialer{EnableCompression: true}).acceptDeflate(nil); !ok || enabled {
+		t.Fatalf("unexpected result for missing extensions: enabled=%v, ok=%v", enabled, ok)
+	}
+}
+
+func TestConnRejectsUnexpectedRSV1(t *testing.T) {
+	t.Parallel()
+
+	// RSV1 on a connection without negotiated compression.
+	c, _ := newBufferConn(true, []byte{0xc1, 0x80, 0, 0, 0, 0})
+	_, _, err := c.ReadMessage(nil)
+	if err == nil {
+		t.Fatal("expecting error")
+	}
+
+	// RSV1 on a control frame.
+	c, _ = newBufferConn(true, []byte{0xc9, 0x80, 0, 0, 0, 0})
+	c.enableCompression(deflateParams{}, 0)
+	if _, _, err = c.ReadMessage(nil); err == nil {
+		t.Fatal("expecting error")
+	}
+
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		t.Fatalf("unexpected close error %v", err)
+	}
+
+	if err = c.WriteFrame(&Frame{Opcode: OpPing, Fin: true, Compressed: true}); err != ErrInvalidOpcode {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrInvalidOpcode)
+	}
+	c, _ = newBufferConn(true, nil)
+	if err = c.WriteFrame(&Frame{Opcode: OpText, Fin: true, Compressed: true}); err != ErrCompressionNotNegotiated {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrCompressionNotNegotiated)
+	}
+}
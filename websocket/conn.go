@@ -0,0 +1,705 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Opcode is a WebSocket frame opcode.
+//
+// See https://www.rfc-editor.org/rfc/rfc6455#section-5.2 .
+type Opcode byte
+
+// Frame opcodes defined by RFC 6455.
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xa
+)
+
+// IsControl returns true for close, ping and pong opcodes.
+func (op Opcode) IsControl() bool {
+	return op&0x8 != 0
+}
+
+func (op Opcode) isValid() bool {
+	switch op {
+	case OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong:
+		return true
+	}
+	return false
+}
+
+// Close status codes.
+//
+// See https://www.rfc-editor.org/rfc/rfc6455#section-7.4.1 .
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+	CloseTLSHandshake            = 1015
+)
+
+// CloseError is returned from ReadMessage when the peer sends a close frame.
+type CloseError struct {
+	// Text is the close reason sent by the peer.
+	Text string
+
+	// Code is the close status code sent by the peer.
+	//
+	// CloseNoStatusReceived is used if the close frame had no payload.
+	Code int
+}
+
+func (e *CloseError) Error() string {
+	if e.Text == "" {
+		return fmt.Sprintf("websocket: close %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: close %d: %s", e.Code, e.Text)
+}
+
+var (
+	// ErrReadLimit is returned when a message exceeds the limit set via SetReadLimit.
+	ErrReadLimit = errors.New("websocket: read limit exceeded")
+
+	// ErrCloseSent is returned when writing to the connection after a close frame has been sent.
+	ErrCloseSent = errors.New("websocket: close frame has been sent")
+
+	// ErrInvalidOpcode is returned when writing a frame with an opcode
+	// that isn't allowed for the called method.
+	ErrInvalidOpcode = errors.New("websocket: invalid opcode")
+
+	// ErrInvalidControlFrame is returned when writing a control frame
+	// that is fragmented or carries more than 125 bytes of payload.
+	ErrInvalidControlFrame = errors.New("websocket: control frames must not be fragmented " +
+		"and must not exceed 125 bytes of payload")
+
+	// ErrCompressionNotNegotiated is returned when writing a compressed frame
+	// over a connection without negotiated permessage-deflate extension.
+	ErrCompressionNotNegotiated = errors.New("websocket: permessage-deflate extension hasn't been negotiated")
+)
+
+const maxControlFramePayloadSize = 125
+
+// DefaultReadLimit is the default maximum size in bytes of a message
+// read from the peer. See Conn.SetReadLimit.
+const DefaultReadLimit = 32 << 20
+
+// maxPreallocatedPayloadSize is the maximum size of the payload buffer
+// allocated before the payload is read.
+const maxPreallocatedPayloadSize = 64 << 10
+
+// Frame is a single WebSocket frame.
+type Frame struct {
+	// Payload is the unmasked frame payload.
+	Payload []byte
+
+	// Opcode is the frame opcode.
+	Opcode Opcode
+
+	// Fin is set on the last frame of a message.
+	Fin bool
+
+	// Compressed is set on the first frame of a message compressed
+	// with permessage-deflate extension (the RSV1 bit).
+	//
+	// The payload of compressed frames is neither decompressed by ReadFrame
+	// nor compressed by WriteFrame. Use ReadMessage and WriteMessage
+	// for transparent compression.
+	Compressed bool
+}
+
+// Conn is a WebSocket connection.
+//
+// Only a single goroutine may read from Conn at a time. Writes are
+// serialized internally, so they may be issued from concurrently running
+// goroutines, including the one answering control frames in ReadMessage.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	pingHandler func(appData []byte) error
+	pongHandler func(appData []byte) error
+
+	readErr error
+	rf      Frame
+
+	compressor *compressor
+
+	subprotocol string
+
+	wbuf []byte
+
+	readLimit int64
+
+	wmu sync.Mutex
+
+	isServer       bool
+	readFragmented bool
+	closeSent      bool
+	writeCompress  bool
+}
+
+func newConn(c net.Conn, br *bufio.Reader, isServer bool, subprotocol string, writeBufferSize int) *Conn {
+	if writeBufferSize <= 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+	return &Conn{
+		conn:        c,
+		br:          br,
+		isServer:    isServer,
+		subprotocol: subprotocol,
+		wbuf:        make([]byte, 0, writeBufferSize),
+		readLimit:   DefaultReadLimit,
+	}
+}
+
+const (
+	defaultReadBufferSize  = 4096
+	defaultWriteBufferSize = 4096
+)
+
+// Subprotocol returns the subprotocol negotiated during the handshake.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the read deadline on the underlying connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline on the underlying connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetReadLimit sets the maximum size in bytes of a message read from the peer.
+//
+// The connection is closed with CloseMessageTooBig and ErrReadLimit is
+// returned if a message exceeds the limit.
+//
+// DefaultReadLimit is used if limit is zero. The message size is unlimited
+// if limit is negative. Frame payloads are read in chunks in this case,
+// so the peer cannot make the connection allocating the memory
+// for the announced payload without sending it.
+func (c *Conn) SetReadLimit(limit int64) {
+	if limit == 0 {
+		limit = DefaultReadLimit
+	}
+	c.readLimit = limit
+}
+
+// SetPingHandler sets the handler called by ReadMessage for ping frames.
+//
+// The default handler replies with a pong frame carrying the same payload.
+func (c *Conn) SetPingHandler(h func(appData []byte) error) {
+	c.pingHandler = h
+}
+
+// SetPongHandler sets the handler called by ReadMessage for pong frames.
+//
+// Pong frames are ignored by default.
+func (c *Conn) SetPongHandler(h func(appData []byte) error) {
+	c.pongHandler = h
+}
+
+// CompressionEnabled returns true if permessage-deflate extension
+// has been negotiated during the handshake.
+func (c *Conn) CompressionEnabled() bool {
+	return c.compressor != nil
+}
+
+// EnableWriteCompression enables or disables compression of messages
+// written by WriteMessage.
+//
+// Write compression is enabled by default if permessage-deflate extension
+// has been negotiated. Disabling it may be useful for messages
+// that are already compressed, such as images.
+func (c *Conn) EnableWriteCompression(enable bool) {
+	c.wmu.Lock()
+	c.writeCompress = enable
+	c.wmu.Unlock()
+}
+
+func (c *Conn) enableCompression(p deflateParams, level int) {
+	if level == 0 {
+		level = fasthttp.CompressDefaultCompression
+	}
+	c.compressor = newCompressor(p, c.isServer, level)
+	c.writeCompress = true
+}
+
+// releaseCompressor returns the compression context held
+// by the connection to the pool.
+func (c *Conn) releaseCompressor() {
+	if c.compressor != nil {
+		c.wmu.Lock()
+		c.compressor.release()
+		c.wmu.Unlock()
+	}
+}
+
+// Close closes the underlying connection without sending a close frame.
+//
+// Use WriteClose for the closing handshake.
+func (c *Conn) Close() error {
+	c.releaseCompressor()
+	return c.conn.Close()
+}
+
+// ReadFrame reads the next frame into f.
+//
+// f.Payload is reused if it has enough capacity. Control frames aren't
+// handled by ReadFrame; the caller is responsible for answering them.
+//
+// Protocol violations are answered with a close frame and make all
+// subsequent reads fail.
+func (c *Conn) ReadFrame(f *Frame) error {
+	if c.readErr != nil {
+		return c.readErr
+	}
+	if err := c.readFrame(f); err != nil {
+		c.readErr = err
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) readFrame(f *Frame) error {
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.br, hdr[:2]); err != nil {
+		return err
+	}
+
+	fin := hdr[0]&finBit != 0
+	op := Opcode(hdr[0] & 0x0f)
+	if !op.isValid() {
+		return c.failf(CloseProtocolError, "unknown opcode 0x%x", byte(op))
+	}
+	compressed := hdr[0]&rsv1Bit != 0
+	if compressed && (c.compressor == nil || op.IsControl() || op == OpContinuation) {
+		return c.failf(CloseProtocolError, "unexpected RSV1 bit")
+	}
+	if rsv := hdr[0] & (rsv2Bit | rsv3Bit); rsv != 0 {
+		return c.failf(CloseProtocolError, "unexpected reserved bits 0x%x", rsv)
+	}
+
+	masked := hdr[1]&0x80 != 0
+	if masked != c.isServer {
+		if c.isServer {
+			return c.failf(CloseProtocolError, "client frames must be masked")
+		}
+		return c.failf(CloseProtocolError, "server frames must not be masked")
+	}
+
+	n := int64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(c.br, hdr[:2]); err != nil {
+			return err
+		}
+		n = int64(binary.BigEndian.Uint16(hdr[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, hdr[:8]); err != nil {
+			return err
+		}
+		v := binary.BigEndian.Uint64(hdr[:8])
+		if v>>63 != 0 {
+			return c.failf(CloseProtocolError, "invalid payload length")
+		}
+		n = int64(v)
+	}
+
+	if op.IsControl() {
+		if n > maxControlFramePayloadSize || !fin {
+			return c.failf(CloseProtocolError, "invalid control frame")
+		}
+	} else {
+		if op == OpContinuation && !c.readFragmented {
+			return c.failf(CloseProtocolError, "unexpected continuation frame")
+		}
+		if op != OpContinuation && c.readFragmented {
+			return c.failf(CloseProtocolError, "expecting continuation frame")
+		}
+		c.readFragmented = !fin
+	}
+
+	if (c.readLimit > 0 && n > c.readLimit) || uint64(n) > math.MaxInt {
+		c.writeCloseFrame(CloseMessageTooBig, "") //nolint:errcheck
+		return ErrReadLimit
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, maskKey[:]); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if f.Payload, err = c.readPayload(f.Payload[:0], int(n)); err != nil {
+		return err
+	}
+	if masked {
+		maskBytes(maskKey, f.Payload)
+	}
+	f.Opcode = op
+	f.Fin = fin
+	f.Compressed = compressed
+	return nil
+}
+
+// readPayload reads n bytes of the frame payload into b.
+//
+// Large payloads are read in chunks, so the memory is allocated
+// only for the payload actually sent by the peer.
+func (c *Conn) readPayload(b []byte, n int) ([]byte, error) {
+	if n <= maxPreallocatedPayloadSize || n <= cap(b) {
+		b = growBytes(b, n)
+		_, err := io.ReadFull(c.br, b)
+		return b, err
+	}
+	for len(b) < n {
+		chunk := min(n-len(b), maxPreallocatedPayloadSize)
+		b = slices.Grow(b, chunk)
+		m, err := io.ReadFull(c.br, b[len(b):len(b)+chunk])
+		b = b[:len(b)+m]
+		if err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// ReadMessage reads the next text or binary message and appends it to dst.
+//
+// Compressed messages are decompressed transparently. Ping frames are
+// answered via the ping handler, and pong frames are passed to the pong
+// handler. A *CloseError is returned when the peer sends a close frame;
+// the close frame is echoed back if no close frame has been sent yet.
+func (c *Conn) ReadMessage(dst []byte) (Opcode, []byte, error) {
+	var msgOp Opcode
+	var compressed bool
+	dst = dst[:0]
+	for {
+		f := &c.rf
+		if err := c.ReadFrame(f); err != nil {
+			return 0, dst, err
+		}
+
+		switch f.Opcode {
+		case OpPing:
+			if err := c.handlePing(f.Payload); err != nil {
+				return 0, dst, err
+			}
+			continue
+		case OpPong:
+			if c.pongHandler != nil {
+				if err := c.pongHandler(f.Payload); err != nil {
+					return 0, dst, err
+				}
+			}
+			continue
+		case OpClose:
+			err := c.handleClose(f.Payload)
+			c.readErr = err
+			return 0, dst, err
+		case OpText, OpBinary:
+			msgOp = f.Opcode
+			compressed = f.Compressed
+		}
+
+		var n int
+		if compressed {
+			c.compressor.appendCompressed(f.Payload)
+			n = c.compressor.compressedLen()
+		} else {
+			dst = append(dst, f.Payload...)
+			n = len(dst)
+		}
+		if c.readLimit > 0 && int64(n) > c.readLimit {
+			return 0, dst, c.readLimitExceeded()
+		}
+		if !f.Fin {
+			continue
+		}
+		if compressed {
+			var err error
+			if dst, err = c.compressor.decompress(dst, c.readLimit); err != nil {
+				if err == ErrReadLimit {
+					return 0, dst, c.readLimitExceeded()
+				}
+				err = c.failf(CloseInvalidFramePayloadData, "cannot decompress message: %v", err)
+				c.readErr = err
+				return 0, dst, err
+			}
+		}
+		if msgOp == OpText && !utf8.Valid(dst) {
+			err := c.failf(CloseInvalidFramePayloadData, "invalid UTF-8 in text message")
+			c.readErr = err
+			return 0, dst, err
+		}
+		return msgOp, dst, nil
+	}
+}
+
+func (c *Conn) readLimitExceeded() error {
+	c.writeCloseFrame(CloseMessageTooBig, "") //nolint:errcheck
+	c.readErr = ErrReadLimit
+	return ErrReadLimit
+}
+
+func (c *Conn) handlePing(appData []byte) error {
+	if c.pingHandler != nil {
+		return c.pingHandler(appData)
+	}
+	err := c.WriteControl(OpPong, appData)
+	if err == ErrCloseSent {
+		return nil
+	}
+	return err
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{
+		Code: CloseNoStatusReceived,
+	}
+	switch {
+	case len(payload) == 1:
+		return c.failf(CloseProtocolError, "invalid close frame payload")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		if !isValidReceivedCloseCode(closeErr.Code) {
+			return c.failf(CloseProtocolError, "invalid close code %d", closeErr.Code)
+		}
+		reason := payload[2:]
+		if !utf8.Valid(reason) {
+			return c.failf(CloseInvalidFramePayloadData, "invalid UTF-8 in close reason")
+		}
+		closeErr.Text = string(reason)
+	}
+
+	code := closeErr.Code
+	if code == CloseNoStatusReceived {
+		code = CloseNormalClosure
+	}
+	if err := c.writeCloseFrame(code, ""); err != nil && err != ErrCloseSent {
+		return err
+	}
+	return closeErr
+}
+
+func isValidReceivedCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// failf sends a close frame with the given code and returns an error
+// describing the protocol violation.
+func (c *Conn) failf(code int, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	c.writeCloseFrame(code, msg) //nolint:errcheck
+	return fmt.Errorf("websocket: %s", msg)
+}
+
+// WriteFrame writes f to the connection.
+//
+// WriteFrame doesn't verify message fragmentation, so the caller
+// must follow every non-final text or binary frame with continuation frames.
+func (c *Conn) WriteFrame(f *Frame) error {
+	if !f.Opcode.isValid() {
+		return ErrInvalidOpcode
+	}
+	if f.Compressed {
+		if c.compressor == nil {
+			return ErrCompressionNotNegotiated
+		}
+		if f.Opcode != OpText && f.Opcode != OpBinary {
+			return ErrInvalidOpcode
+		}
+	}
+	return c.writeFrame(f.Fin, f.Compressed, f.Opcode, f.Payload)
+}
+
+// WriteMessage writes data as a single frame message.
+//
+// The message is compressed if permessage-deflate extension
+// has been negotiated and write compression isn't disabled
+// via EnableWriteCompression.
+//
+// op must be either OpText or OpBinary.
+func (c *Conn) WriteMessage(op Opcode, data []byte) error {
+	if op != OpText && op != OpBinary {
+		return ErrInvalidOpcode
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.compressor == nil || !c.writeCompress || c.closeSent {
+		return c.writeFrameLocked(true, false, op, data)
+	}
+	payload, err := c.compressor.compress(data)
+	if err != nil {
+		return err
+	}
+	return c.writeFrameLocked(true, true, op, payload)
+}
+
+// WriteControl writes a ping or pong frame.
+//
+// Use WriteClose for sending close frames.
+func (c *Conn) WriteControl(op Opcode, data []byte) error {
+	if op != OpPing && op != OpPong {
+		return ErrInvalidOpcode
+	}
+	return c.writeFrame(true, false, op, data)
+}
+
+// WriteClose sends a close frame with the given code and reason.
+//
+// No frames may be written after WriteClose. The connection isn't closed,
+// so the caller may wait for the peer's close frame via ReadMessage.
+func (c *Conn) WriteClose(code int, reason string) error {
+	return c.writeCloseFrame(code, reason)
+}
+
+func (c *Conn) writeCloseFrame(code int, reason string) error {
+	var buf [maxControlFramePayloadSize]byte
+	payload := buf[:0]
+	if code != CloseNoStatusReceived {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(code)) // #nosec G115
+		if len(reason) > maxControlFramePayloadSize-2 {
+			reason = reason[:maxControlFramePayloadSize-2]
+		}
+		payload = append(payload, reason...)
+	}
+	return c.writeFrame(true, false, OpClose, payload)
+}
+
+// Frame header bits.
+const (
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsv2Bit = 0x20
+	rsv3Bit = 0x10
+)
+
+func (c *Conn) writeFrame(fin, compressed bool, op Opcode, payload []byte) error {
+	if op.IsControl() && (!fin || len(payload) > maxControlFramePayloadSize) {
+		return ErrInvalidControlFrame
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	return c.writeFrameLocked(fin, compressed, op, payload)
+}
+
+func (c *Conn) writeFrameLocked(fin, compressed bool, op Opcode, payload []byte) error {
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if op == OpClose {
+		c.closeSent = true
+	}
+
+	b := c.wbuf[:0]
+	b0 := byte(op)
+	if fin {
+		b0 |= finBit
+	}
+	if compressed {
+		b0 |= rsv1Bit
+	}
+	b = append(b, b0)
+
+	var b1 byte
+	if !c.isServer {
+		b1 = 0x80
+	}
+	n := len(payload)
+	switch {
+	case n <= 125:
+		b = append(b, b1|byte(n))
+	case n <= 0xffff:
+		b = append(b, b1|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(n)) // #nosec G115
+	default:
+		b = append(b, b1|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(n)) // #nosec G115
+	}
+
+	if c.isServer {
+		b = append(b, payload...)
+	} else {
+		var maskKey [4]byte
+		binary.LittleEndian.PutUint32(maskKey[:], rand.Uint32()) // #nosec G404
+		b = append(b, maskKey[:]...)
+		start := len(b)
+		b = append(b, payload...)
+		maskBytes(maskKey, b[start:])
+	}
+
+	// c.wbuf isn't replaced with the grown b, so a single big frame
+	// doesn't pin a huge buffer for the connection lifetime.
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+func growBytes(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
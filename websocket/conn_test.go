@@ -0,0 +1,260 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// bufferConn is a net.Conn reading from r and writing to w.
+type bufferConn struct {
+	r *bytes.Reader
+	w bytes.Buffer
+}
+
+func (c *bufferConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *bufferConn) Write(p []byte) (int, error)        { return c.w.Write(p) }
+func (c *bufferConn) Close() error                       { return nil }
+func (c *bufferConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *bufferConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *bufferConn) SetDeadline(_ time.Time) error      { return nil }
+func (c *bufferConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (c *bufferConn) SetWriteDeadline(_ time.Time) error { return nil }
+
+func newBufferConn(isServer bool, input []byte) (*Conn, *bufferConn) {
+	bc := &bufferConn{r: bytes.NewReader(input)}
+	return newConn(bc, bufio.NewReader(bc), isServer, "", 0), bc
+}
+
+// writeFrames returns the wire representation of the given frames
+// written by a client (masked) or a server (unmasked).
+func writeFrames(t *testing.T, fromServer bool, frames ...Frame) []byte {
+	t.Helper()
+	c, bc := newBufferConn(fromServer, nil)
+	for i := range frames {
+		if err := c.WriteFrame(&frames[i]); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return bc.w.Bytes()
+}
+
+func TestConnFrameRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, fromServer := range []bool{true, false} {
+		for _, n := range []int{0, 1, 125, 126, 127, 0xffff, 0x10000} {
+			payload := bytes.Repeat([]byte("x"), n)
+			wire := writeFrames(t, fromServer, Frame{Opcode: OpBinary, Fin: true, Payload: payload})
+
+			c, _ := newBufferConn(!fromServer, wire)
+			var f Frame
+			if err := c.ReadFrame(&f); err != nil {
+				t.Fatalf("unexpected error for fromServer=%v, n=%d: %v", fromServer, n, err)
+			}
+			if f.Opcode != OpBinary || !f.Fin {
+				t.Fatalf("unexpected frame header: opcode=%d, fin=%v", f.Opcode, f.Fin)
+			}
+			if !bytes.Equal(f.Payload, payload) {
+				t.Fatalf("unexpected payload for fromServer=%v, n=%d", fromServer, n)
+			}
+		}
+	}
+}
+
+func TestConnClientFramesMasked(t *testing.T) {
+	t.Parallel()
+
+	wire := writeFrames(t, false, Frame{Opcode: OpText, Fin: true, Payload: []byte("hello")})
+	if wire[1]&0x80 == 0 {
+		t.Fatal("client frame must be masked")
+	}
+	payload := append([]byte(nil), wire[6:]...)
+	maskBytes([4]byte(wire[2:6]), payload)
+	if string(payload) != "hello" {
+		t.Fatalf("unexpected unmasked payload %q. Expecting %q", payload, "hello")
+	}
+
+	wire = writeFrames(t, true, Frame{Opcode: OpText, Fin: true, Payload: []byte("hello")})
+	if wire[1]&0x80 != 0 {
+		t.Fatal("server frame must not be masked")
+	}
+}
+
+func TestConnReadMessageFragmented(t *testing.T) {
+	t.Parallel()
+
+	wire := writeFrames(t, false,
+		Frame{Opcode: OpText, Payload: []byte("foo")},
+		Frame{Opcode: OpPing, Fin: true, Payload: []byte("ping")},
+		Frame{Opcode: OpContinuation, Payload: []byte("bar")},
+		Frame{Opcode: OpContinuation, Fin: true, Payload: []byte("baz")},
+	)
+	c, bc := newBufferConn(true, wire)
+	op, msg, err := c.ReadMessage(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if op != OpText || string(msg) != "foobarbaz" {
+		t.Fatalf("unexpected message: opcode=%d, %q", op, msg)
+	}
+
+	// The ping must be answered with a pong.
+	r, _ := newBufferConn(false, bc.w.Bytes())
+	var f Frame
+	if err := r.ReadFrame(&f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Opcode != OpPong || string(f.Payload) != "ping" {
+		t.Fatalf("unexpected reply: opcode=%d, %q", f.Opcode, f.Payload)
+	}
+}
+
+func TestConnReadMessageClose(t *testing.T) {
+	t.Parallel()
+
+	client, bc := newBufferConn(false, nil)
+	if err := client.WriteClose(CloseGoingAway, "bye"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := client.WriteMessage(OpText, []byte("late")); err != ErrCloseSent {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrCloseSent)
+	}
+
+	server, sbc := newBufferConn(true, bc.w.Bytes())
+	_, _, err := server.ReadMessage(nil)
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("unexpected error: %v. Expecting *CloseError", err)
+	}
+	if closeErr.Code != CloseGoingAway || closeErr.Text != "bye" {
+		t.Fatalf("unexpected close error: %+v", closeErr)
+	}
+
+	// The close frame must be echoed.
+	r, _ := newBufferConn(false, sbc.w.Bytes())
+	var f Frame
+	if err := r.ReadFrame(&f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Opcode != OpClose {
+		t.Fatalf("unexpected opcode %d. Expecting %d", f.Opcode, OpClose)
+	}
+}
+
+func TestConnProtocolErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string][]byte{
+		"unmasked":           writeFrames(t, true, Frame{Opcode: OpText, Fin: true, Payload: []byte("x")}),
+		"reserved bits":      {0xc1, 0x80, 0, 0, 0, 0},
+		"unknown opcode":     {0x83, 0x80, 0, 0, 0, 0},
+		"fragmented ping":    {0x09, 0x80, 0, 0, 0, 0},
+		"big ping":           {0x89, 0xfe, 0x00, 0x7e, 0, 0, 0, 0},
+		"continuation":       writeFrames(t, false, Frame{Opcode: OpContinuation, Fin: true}),
+		"missing cont":       writeFrames(t, false, Frame{Opcode: OpText}, Frame{Opcode: OpText, Fin: true}),
+		"invalid utf8":       writeFrames(t, false, Frame{Opcode: OpText, Fin: true, Payload: []byte{0xff, 0xfe}}),
+		"invalid close":      writeFrames(t, false, Frame{Opcode: OpClose, Fin: true, Payload: []byte{0x03}}),
+		"invalid close code": writeFrames(t, false, Frame{Opcode: OpClose, Fin: true, Payload: []byte{0x03, 0xed}}),
+	}
+	for name, wire := range testCases {
+		c, bc := newBufferConn(true, wire)
+		_, _, err := c.ReadMessage(nil)
+		if err == nil {
+			t.Fatalf("%s: expecting error", name)
+		}
+		var closeErr *CloseError
+		if errors.As(err, &closeErr) {
+			t.Fatalf("%s: unexpected close error %v", name, err)
+		}
+		if _, _, err2 := c.ReadMessage(nil); err2 != err {
+			t.Fatalf("%s: read error must be sticky, got %v", name, err2)
+		}
+		if bc.w.Len() == 0 || bc.w.Bytes()[0] != 0x88 {
+			t.Fatalf("%s: expecting close frame to be sent", name)
+		}
+	}
+}
+
+func TestConnReadLimit(t *testing.T) {
+	t.Parallel()
+
+	wire := writeFrames(t, false,
+		Frame{Opcode: OpBinary, Payload: []byte("12345")},
+		Frame{Opcode: OpContinuation, Fin: true, Payload: []byte("67890")},
+	)
+	c, bc := newBufferConn(true, wire)
+	c.SetReadLimit(8)
+	if _, _, err := c.ReadMessage(nil); err != ErrReadLimit {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrReadLimit)
+	}
+
+	r, _ := newBufferConn(false, bc.w.Bytes())
+	var f Frame
+	if err := r.ReadFrame(&f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Opcode != OpClose || len(f.Payload) < 2 || int(f.Payload[0])<<8|int(f.Payload[1]) != CloseMessageTooBig {
+		t.Fatalf("unexpected close frame: opcode=%d, payload=%v", f.Opcode, f.Payload)
+	}
+}
+
+func TestConnReadOversizedFrame(t *testing.T) {
+	t.Parallel()
+
+	// Masked binary frame announcing 2^62 bytes of payload,
+	// which exceeds the default read limit.
+	wire := []byte{0x82, 0xff, 0x40, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4}
+	c, bc := newBufferConn(true, wire)
+	if _, _, err := c.ReadMessage(nil); err != ErrReadLimit {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrReadLimit)
+	}
+
+	r, _ := newBufferConn(false, bc.w.Bytes())
+	var f Frame
+	if err := r.ReadFrame(&f); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.Opcode != OpClose || len(f.Payload) < 2 || int(f.Payload[0])<<8|int(f.Payload[1]) != CloseMessageTooBig {
+		t.Fatalf("unexpected close frame: opcode=%d, payload=%v", f.Opcode, f.Payload)
+	}
+}
+
+func TestConnReadUnlimitedTruncatedFrame(t *testing.T) {
+	t.Parallel()
+
+	// The payload of 2^62 bytes is announced, but only a few bytes are sent.
+	wire := []byte{0x82, 0xff, 0x40, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 'f', 'o', 'o'}
+	c, _ := newBufferConn(true, wire)
+	c.SetReadLimit(-1)
+	var f Frame
+	err := c.ReadFrame(&f)
+	if err != io.ErrUnexpectedEOF && err != ErrReadLimit {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, io.ErrUnexpectedEOF)
+	}
+	if cap(f.Payload) > maxPreallocatedPayloadSize {
+		t.Fatalf("unexpected payload buffer size %d. Expecting up to %d", cap(f.Payload), maxPreallocatedPayloadSize)
+	}
+}
+
+func TestConnWriteInvalid(t *testing.T) {
+	t.Parallel()
+
+	c, _ := newBufferConn(true, nil)
+	if err := c.WriteMessage(OpPing, nil); err != ErrInvalidOpcode {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrInvalidOpcode)
+	}
+	if err := c.WriteControl(OpPing, make([]byte, 126)); err != ErrInvalidControlFrame {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrInvalidControlFrame)
+	}
+	if err := c.WriteFrame(&Frame{Opcode: OpPong}); err != ErrInvalidControlFrame {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrInvalidControlFrame)
+	}
+	if err := c.WriteFrame(&Frame{Opcode: 0x3, Fin: true}); err != ErrInvalidOpcode {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrInvalidOpcode)
+	}
+}
@@ -0,0 +1,17 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) for fasthttp.
+//
+// Server side connections are obtained by Upgrader.Upgrade, which performs
+// the opening handshake straight from fasthttp.RequestHeader and hands
+// the connection over to a Handler via fasthttp.RequestCtx.Hijack.
+//
+// Client side connections are obtained by Dialer.Dial, which reuses
+// the Dial and TLSConfig settings of fasthttp.HostClient.
+//
+// Conn exposes both a frame-level API (ReadFrame, WriteFrame) and
+// a message-level API (ReadMessage, WriteMessage). Control frames are
+// answered automatically by the message-level API.
+//
+// The permessage-deflate extension (RFC 7692) is negotiated if enabled
+// via Upgrader.EnableCompression and Dialer.EnableCompression.
+// ReadMessage and WriteMessage compress and decompress messages transparently.
+package websocket
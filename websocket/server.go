@@ -0,0 +1,224 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1" //nolint:gosec // SHA-1 is mandated by RFC 6455.
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Handler handles a WebSocket connection.
+//
+// The connection is closed after returning from the handler.
+type Handler func(c *Conn)
+
+// ErrBadHandshake is returned when the opening handshake fails.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Upgrader upgrades fasthttp requests to WebSocket connections.
+//
+// It is safe to call Upgrader methods from concurrently running goroutines.
+type Upgrader struct {
+	// CheckOrigin returns true if the request Origin header is acceptable.
+	//
+	// By default requests with an Origin header are accepted only
+	// if the Origin host matches the request Host header.
+	CheckOrigin func(ctx *fasthttp.RequestCtx) bool
+
+	// Subprotocols lists the server's supported subprotocols in order
+	// of preference.
+	//
+	// The first subprotocol requested by the client from this list
+	// is selected. No subprotocol is negotiated if the list is empty.
+	Subprotocols []string
+
+	// ReadBufferSize is the size of the read buffer for the upgraded connection.
+	//
+	// Default buffer size is used if not set.
+	ReadBufferSize int
+
+	// WriteBufferSize is the size of the write buffer for the upgraded connection.
+	//
+	// Default buffer size is used if not set.
+	WriteBufferSize int
+
+	// ReadLimit is the maximum message size in bytes accepted from the client.
+	//
+	// DefaultReadLimit is used if not set. The message size is unlimited
+	// if ReadLimit is negative. See Conn.SetReadLimit for details.
+	ReadLimit int64
+
+	// CompressionLevel is the level used for compressing messages
+	// if permessage-deflate extension is negotiated.
+	//
+	// The level may be one of the fasthttp.Compress* constants.
+	// fasthttp.CompressDefaultCompression is used if not set.
+	CompressionLevel int
+
+	// EnableCompression enables negotiation of permessage-deflate
+	// extension (RFC 7692) offered by clients.
+	EnableCompression bool
+
+	// ServerNoContextTakeover prevents the server from reusing
+	// the compression context across messages.
+	//
+	// This reduces per-connection memory usage at the cost
+	// of compression ratio.
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover asks clients not to reuse
+	// the compression context across messages.
+	//
+	// This reduces per-connection memory usage at the cost
+	// of compression ratio.
+	ClientNoContextTakeover bool
+}
+
+// IsWebSocketUpgrade returns true if the request asks for a WebSocket upgrade.
+func IsWebSocketUpgrade(ctx *fasthttp.RequestCtx) bool {
+	h := &ctx.Request.Header
+	return h.IsGet() && h.ConnectionUpgrade() && headerHasToken(h.Peek(fasthttp.HeaderUpgrade), "websocket")
+}
+
+// Upgrade performs the opening handshake and calls handler with
+// the upgraded connection after the server writes the handshake response.
+//
+// An error response is set on ctx and ErrBadHandshake is returned
+// if the request isn't a valid WebSocket handshake.
+// The request handler must return immediately after Upgrade.
+func (u *Upgrader) Upgrade(ctx *fasthttp.RequestCtx, handler Handler) error {
+	h := &ctx.Request.Header
+	if !h.IsGet() {
+		return u.fail(ctx, fasthttp.StatusMethodNotAllowed, "request method is not GET")
+	}
+	if !h.ConnectionUpgrade() {
+		return u.fail(ctx, fasthttp.StatusBadRequest, "'upgrade' token not found in 'Connection' header")
+	}
+	if !headerHasToken(h.Peek(fasthttp.HeaderUpgrade), "websocket") {
+		return u.fail(ctx, fasthttp.StatusBadRequest, "'websocket' token not found in 'Upgrade' header")
+	}
+	if string(h.Peek(fasthttp.HeaderSecWebSocketVersion)) != "13" {
+		ctx.Response.Header.Set(fasthttp.HeaderSecWebSocketVersion, "13")
+		return u.fail(ctx, fasthttp.StatusUpgradeRequired, "unsupported version")
+	}
+	key := h.Peek(fasthttp.HeaderSecWebSocketKey)
+	if !isValidChallengeKey(key) {
+		return u.fail(ctx, fasthttp.StatusBadRequest, "invalid 'Sec-WebSocket-Key' header")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = checkSameOrigin
+	}
+	if !checkOrigin(ctx) {
+		return u.fail(ctx, fasthttp.StatusForbidden, "origin not allowed")
+	}
+
+	subprotocol := u.selectSubprotocol(h.Peek(fasthttp.HeaderSecWebSocketProtocol))
+
+	var (
+		deflate     deflateParams
+		deflateResp string
+		compress    bool
+	)
+	if u.EnableCompression {
+		deflate, deflateResp, compress = u.negotiateDeflate(h.Peek(fasthttp.HeaderSecWebSocketExtensions))
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusSwitchingProtocols)
+	rh := &ctx.Response.Header
+	rh.SetNoDefaultContentType(true)
+	rh.Set(fasthttp.HeaderUpgrade, "websocket")
+	rh.Set(fasthttp.HeaderConnection, "Upgrade")
+	rh.Set(fasthttp.HeaderSecWebSocketAccept, computeAcceptKey(key))
+	if subprotocol != "" {
+		rh.Set(fasthttp.HeaderSecWebSocketProtocol, subprotocol)
+	}
+	if compress {
+		rh.Set(fasthttp.HeaderSecWebSocketExtensions, deflateResp)
+	}
+
+	readBufferSize := u.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	writeBufferSize := u.WriteBufferSize
+	readLimit := u.ReadLimit
+	compressionLevel := u.CompressionLevel
+	ctx.Hijack(func(nc net.Conn) {
+		c := newConn(nc, bufio.NewReaderSize(nc, readBufferSize), true, subprotocol, writeBufferSize)
+		c.SetReadLimit(readLimit)
+		if compress {
+			c.enableCompression(deflate, compressionLevel)
+		}
+		handler(c)
+		c.releaseCompressor()
+	})
+	return nil
+}
+
+func (u *Upgrader) fail(ctx *fasthttp.RequestCtx, statusCode int, reason string) error {
+	ctx.Error(fasthttp.StatusMessage(statusCode), statusCode)
+	return fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+}
+
+func (u *Upgrader) selectSubprotocol(requested []byte) string {
+	for _, p := range u.Subprotocols {
+		if headerHasToken(requested, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func checkSameOrigin(ctx *fasthttp.RequestCtx) bool {
+	origin := ctx.Request.Header.Peek(fasthttp.HeaderOrigin)
+	if len(origin) == 0 {
+		return true
+	}
+	var u fasthttp.URI
+	if err := u.Parse(nil, origin); err != nil {
+		return false
+	}
+	return bytes.EqualFold(u.Host(), ctx.Host())
+}
+
+// websocketGUID is appended to Sec-WebSocket-Key when computing Sec-WebSocket-Accept.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func computeAcceptKey(key []byte) string {
+	h := sha1.New() //nolint:gosec // SHA-1 is mandated by RFC 6455.
+	h.Write(key)
+	h.Write([]byte(websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func isValidChallengeKey(key []byte) bool {
+	if len(key) != 24 {
+		return false
+	}
+	var buf [18]byte
+	n, err := base64.StdEncoding.Decode(buf[:], key)
+	return err == nil && n == 16
+}
+
+// headerHasToken returns true if the comma-separated header value
+// contains the given token. Tokens are compared case-insensitively.
+func headerHasToken(value []byte, token string) bool {
+	for len(value) > 0 {
+		var v []byte
+		if n := bytes.IndexByte(value, ','); n >= 0 {
+			v, value = value[:n], value[n+1:]
+		} else {
+			v, value = value, nil
+		}
+		if bytes.EqualFold(bytes.TrimSpace(v), []byte(token)) {
+			return true
+		}
+	}
+	return false
+}
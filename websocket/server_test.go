@@ -0,0 +1,264 @@
+package websocket
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestComputeAcceptKey(t *testing.T) {
+	t.Parallel()
+
+	// The example from RFC 6455, section 1.3.
+	if s := computeAcceptKey([]byte("dGhlIHNhbXBsZSBub25jZQ==")); s != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", s)
+	}
+}
+
+func TestHeaderHasToken(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		value    string
+		token    string
+		expected bool
+	}{
+		{"websocket", "websocket", true},
+		{"WebSocket", "websocket", true},
+		{"keep-alive, Upgrade", "upgrade", true},
+		{"chat,  superchat ", "superchat", true},
+		{"", "websocket", false},
+		{"websockets", "websocket", false},
+	}
+	for _, tc := range testCases {
+		if got := headerHasToken([]byte(tc.value), tc.token); got != tc.expected {
+			t.Fatalf("unexpected result for %q, %q: %v. Expecting %v", tc.value, tc.token, got, tc.expected)
+		}
+	}
+}
+
+func newUpgradeCtx() *fasthttp.RequestCtx {
+	var ctx fasthttp.RequestCtx
+	h := &ctx.Request.Header
+	h.SetMethod(fasthttp.MethodGet)
+	h.SetRequestURI("/ws")
+	h.SetHost("example.com")
+	h.Set(fasthttp.HeaderConnection, "keep-alive, Upgrade")
+	h.Set(fasthttp.HeaderUpgrade, "websocket")
+	h.Set(fasthttp.HeaderSecWebSocketVersion, "13")
+	h.Set(fasthttp.HeaderSecWebSocketKey, "dGhlIHNhbXBsZSBub25jZQ==")
+	return &ctx
+}
+
+func TestUpgraderUpgrade(t *testing.T) {
+	t.Parallel()
+
+	ctx := newUpgradeCtx()
+	ctx.Request.Header.Set(fasthttp.HeaderSecWebSocketProtocol, "foo, chat")
+	ctx.Request.Header.Set(fasthttp.HeaderOrigin, "https://example.com")
+	if !IsWebSocketUpgrade(ctx) {
+		t.Fatal("expecting websocket upgrade request")
+	}
+
+	u := &Upgrader{Subprotocols: []string{"chat", "foo"}}
+	if err := u.Upgrade(ctx, func(*Conn) {}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ctx.Response.StatusCode() != fasthttp.StatusSwitchingProtocols {
+		t.Fatalf("unexpected status code %d", ctx.Response.StatusCode())
+	}
+	if !ctx.Hijacked() {
+		t.Fatal("expecting hijacked connection")
+	}
+	rh := &ctx.Response.Header
+	if s := string(rh.Peek(fasthttp.HeaderSecWebSocketAccept)); s != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", s)
+	}
+	if s := string(rh.Peek(fasthttp.HeaderSecWebSocketProtocol)); s != "chat" {
+		t.Fatalf("unexpected subprotocol %q. Expecting %q", s, "chat")
+	}
+}
+
+func TestUpgraderUpgradeBadHandshake(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		modify     func(h *fasthttp.RequestHeader)
+		name       string
+		statusCode int
+	}{
+		{
+			name:       "method",
+			modify:     func(h *fasthttp.RequestHeader) { h.SetMethod(fasthttp.MethodPost) },
+			statusCode: fasthttp.StatusMethodNotAllowed,
+		},
+		{
+			name:       "connection",
+			modify:     func(h *fasthttp.RequestHeader) { h.Set(fasthttp.HeaderConnection, "keep-alive") },
+			statusCode: fasthttp.StatusBadRequest,
+		},
+		{
+			name:       "upgrade",
+			modify:     func(h *fasthttp.RequestHeader) { h.Set(fasthttp.HeaderUpgrade, "h2c") },
+			statusCode: fasthttp.StatusBadRequest,
+		},
+		{
+			name:       "version",
+			modify:     func(h *fasthttp.RequestHeader) { h.Set(fasthttp.HeaderSecWebSocketVersion, "8") },
+			statusCode: fasthttp.StatusUpgradeRequired,
+		},
+		{
+			name:       "key",
+			modify:     func(h *fasthttp.RequestHeader) { h.Set(fasthttp.HeaderSecWebSocketKey, "Zm9v") },
+			statusCode: fasthttp.StatusBadRequest,
+		},
+		{
+			name:       "origin",
+			modify:     func(h *fasthttp.RequestHeader) { h.Set(fasthttp.HeaderOrigin, "https://evil.com") },
+			statusCode: fasthttp.StatusForbidden,
+		},
+	}
+	for _, tc := range testCases {
+		ctx := newUpgradeCtx()
+		tc.modify(&ctx.Request.Header)
+		var u Upgrader
+		err := u.Upgrade(ctx, func(*Conn) {})
+		if !errors.Is(err, ErrBadHandshake) {
+			t.Fatalf("%s: unexpected error: %v. Expecting %v", tc.name, err, ErrBadHandshake)
+		}
+		if ctx.Response.StatusCode() != tc.statusCode {
+			t.Fatalf("%s: unexpected status code %d. Expecting %d", tc.name, ctx.Response.StatusCode(), tc.statusCode)
+		}
+		if ctx.Hijacked() {
+			t.Fatalf("%s: connection must not be hijacked", tc.name)
+		}
+	}
+}
+
+func TestServerClientEcho(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	u := &Upgrader{Subprotocols: []string{"echo"}}
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			if !IsWebSocketUpgrade(ctx) {
+				ctx.Error("not a websocket request", fasthttp.StatusBadRequest)
+				return
+			}
+			u.Upgrade(ctx, func(c *Conn) { //nolint:errcheck
+				var buf []byte
+				for {
+					op, msg, err := c.ReadMessage(buf)
+					if err != nil {
+						return
+					}
+					if err = c.WriteMessage(op, msg); err != nil {
+						return
+					}
+					buf = msg
+				}
+			})
+		},
+	}
+	serverCh := make(chan error, 1)
+	go func() {
+		serverCh <- s.Serve(ln)
+	}()
+
+	d := NewDialer(&fasthttp.HostClient{
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+	})
+	d.Subprotocols = []string{"echo"}
+
+	var h fasthttp.RequestHeader
+	h.Set(fasthttp.HeaderOrigin, "http://example.com")
+	c, err := d.Dial("ws://example.com/echo", &h)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Subprotocol() != "echo" {
+		t.Fatalf("unexpected subprotocol %q", c.Subprotocol())
+	}
+
+	pongCh := make(chan string, 1)
+	c.SetPongHandler(func(appData []byte) error {
+		pongCh <- string(appData)
+		return nil
+	})
+	if err = c.WriteControl(OpPing, []byte("are you there?")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, m := range []string{"hello", "", string(make([]byte, 70000))} {
+		if err = c.WriteMessage(OpText, []byte(m)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		op, msg, err := c.ReadMessage(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if op != OpText || string(msg) != m {
+			t.Fatalf("unexpected echo: opcode=%d, len=%d. Expecting len=%d", op, len(msg), len(m))
+		}
+	}
+	select {
+	case s := <-pongCh:
+		if s != "are you there?" {
+			t.Fatalf("unexpected pong payload %q", s)
+		}
+	default:
+		t.Fatal("expecting pong")
+	}
+
+	if err = c.WriteClose(CloseNormalClosure, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, _, err = c.ReadMessage(nil)
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != CloseNormalClosure {
+		t.Fatalf("unexpected error: %v. Expecting close %d", err, CloseNormalClosure)
+	}
+	c.Close()
+
+	if err = ln.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case err = <-serverCh:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+}
+
+func TestDialBadHandshake(t *testing.T) {
+	t.Parallel()
+
+	ln := fasthttputil.NewInmemoryListener()
+	s := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			ctx.Error("forbidden", fasthttp.StatusForbidden)
+		},
+	}
+	go s.Serve(ln) //nolint:errcheck
+	defer ln.Close()
+
+	d := &Dialer{
+		NetDial: func(string, time.Duration) (net.Conn, error) { return ln.Dial() },
+	}
+	_, err := d.Dial("ws://example.com/", nil)
+	if !errors.Is(err, ErrBadHandshake) {
+		t.Fatalf("unexpected error: %v. Expecting %v", err, ErrBadHandshake)
+	}
+
+	if _, err = d.Dial("ftp://example.com/", nil); err == nil {
+		t.Fatal("expecting error for unsupported scheme")
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/klauspost/compress/zstd"
 	"github.com/valyala/bytebufferpool"
+	"github.com/valyala/fasthttp/internal/compresspool"
 	"github.com/valyala/fasthttp/stackless"
 )
 
@@ -21,8 +22,8 @@ const (
 
 var (
 	zstdDecoderPool            sync.Pool
-	realZstdWriterPoolMap      = newCompressWriterPoolMap()
-	stacklessZstdWriterPoolMap = newCompressWriterPoolMap()
+	realZstdWriterPoolMap      = compresspool.NewPoolMap()
+	stacklessZstdWriterPoolMap = compresspool.NewPoolMap()
 )
 
 func acquireZstdReader(r io.Reader) (*zstd.Decoder, error) {